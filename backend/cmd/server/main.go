@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,6 +11,10 @@ import (
 	"voice-ai-backend/internal/api"
 	"voice-ai-backend/internal/config"
 	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/envelope"
+	"voice-ai-backend/internal/redact"
+	"voice-ai-backend/internal/scheduler"
+	"voice-ai-backend/internal/services"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -37,6 +42,28 @@ func main() {
 	}
 	log.SetLevel(level)
 
+	// Mask personal data in stored texts and logs
+	piiPolicy, err := redact.ParsePolicy(config.AppConfig.PIIRedaction)
+	if err != nil {
+		log.Fatalf("❌ Invalid PII_REDACTION: %v", err)
+	}
+	piiKey, err := base64.StdEncoding.DecodeString(config.AppConfig.PIIEncryptionKey)
+	if err != nil {
+		log.Fatalf("❌ Invalid PII_ENCRYPTION_KEY: %v", err)
+	}
+	if redact.Default, err = redact.New(piiPolicy, piiKey); err != nil {
+		log.Fatalf("❌ Failed to configure PII redaction: %v", err)
+	}
+	log.AddHook(redact.NewLogHook(redact.Default))
+
+	// Master keys for encryption of conversation content at rest
+	if envelope.Default, err = envelope.LoadKeyring(config.AppConfig.EncryptionKeys, config.AppConfig.EncryptionKeysFile); err != nil {
+		log.Fatalf("❌ Failed to load encryption keys: %v", err)
+	}
+	if envelope.Default != nil {
+		log.Infof("🔐 Content encryption enabled (master key: %s)", envelope.Default.Primary())
+	}
+
 	// Connect to database
 	if err := database.Connect(config.AppConfig.DatabaseURL); err != nil {
 		log.Fatalf("❌ Failed to connect to database: %v", err)
@@ -51,6 +78,26 @@ func main() {
 		log.Fatalf("❌ Failed to ping database: %v", err)
 	}
 
+	// Apply backend-managed schema
+	if err := database.Database.Migrate(ctx); err != nil {
+		log.Fatalf("❌ Failed to migrate database: %v", err)
+	}
+
+	if config.AppConfig.TrialEnabled {
+		if _, err := services.NewPlanService().EnsureTrialPlan(ctx); err != nil {
+			log.Fatalf("❌ Failed to configure trial plan: %v", err)
+		}
+	}
+
+	// Start background jobs
+	jobScheduler := scheduler.New()
+	if config.AppConfig.SchedulerEnabled {
+		if err := scheduler.RegisterDefaultJobs(jobScheduler); err != nil {
+			log.Fatalf("❌ Failed to register background jobs: %v", err)
+		}
+		jobScheduler.Start()
+	}
+
 	// Setup router
 	router := api.SetupRouter()
 
@@ -86,5 +133,9 @@ func main() {
 		log.Errorf("❌ Server forced to shutdown: %v", err)
 	}
 
+	if err := jobScheduler.Stop(ctx); err != nil {
+		log.Errorf("❌ Scheduler forced to stop: %v", err)
+	}
+
 	log.Info("✅ Server stopped gracefully")
 }
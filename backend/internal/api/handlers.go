@@ -1,12 +1,20 @@
 package api
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/llm"
 	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/payments"
 	"voice-ai-backend/internal/services"
+	"voice-ai-backend/internal/telegram"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -18,23 +26,62 @@ type Handlers struct {
 	planService         *services.PlanService
 	conversationService *services.ConversationService
 	promptService       *services.PromptService
+	basePromptService   *services.BasePromptService
 	openaiService       *services.OpenAIService
 	adminService        *services.AdminService
 	activityService     *services.ActivityService
 	sessionService      *services.SessionService
+	paymentService      *services.PaymentService
+	promoService        *services.PromoService
+	referralService     *services.ReferralService
+	orgService          *services.OrganizationService
+	transferService     *services.TransferService
+	usageService        *services.UsageService
+	notificationService *services.NotificationService
+	memoryService       *services.MemoryService
+	exportService       *services.ExportService
+	privacyService      *services.PrivacyService
+	retentionService    *services.RetentionService
+	encryptionService   *services.EncryptionService
 }
 
 func NewHandlers() *Handlers {
+	bot := telegram.NewClient(config.AppConfig.TelegramBotToken, config.AppConfig.TelegramAPIURL)
+	completer := llm.NewOpenAIClient(config.AppConfig.OpenAIAPIURL, config.AppConfig.OpenAIAPIKey)
+
+	var gateways []payments.Gateway
+	if config.AppConfig.YooKassaShopID != "" {
+		gateways = append(gateways, payments.NewYooKassaGateway(
+			config.AppConfig.YooKassaAPIURL,
+			config.AppConfig.YooKassaShopID,
+			config.AppConfig.YooKassaSecretKey,
+			config.AppConfig.YooKassaWebhookSecret,
+		))
+	}
+
 	return &Handlers{
 		userService:         services.NewUserService(),
 		tokenService:        services.NewTokenService(),
 		planService:         services.NewPlanService(),
 		conversationService: services.NewConversationService(),
 		promptService:       services.NewPromptService(),
+		basePromptService:   services.NewBasePromptService(),
 		openaiService:       services.NewOpenAIService(),
 		adminService:        services.NewAdminService(),
 		activityService:     services.NewActivityService(),
 		sessionService:      services.NewSessionService(),
+		paymentService:      services.NewPaymentService(bot, gateways...),
+		promoService:        services.NewPromoService(),
+		referralService:     services.NewReferralService(),
+		orgService:          services.NewOrganizationService(),
+		transferService:     services.NewTransferService(bot),
+		usageService:        services.NewUsageService(),
+		notificationService: services.NewNotificationService(bot),
+		memoryService:       services.NewMemoryService(services.NewLLMFactExtractor(completer)),
+		exportService:       services.NewExportService(bot),
+		privacyService:      services.NewPrivacyService(bot),
+		retentionService:    services.NewRetentionService(),
+		encryptionService:   services.NewEncryptionService(),
 	}
 }
 
@@ -150,10 +197,16 @@ func (h *Handlers) GetTokenBalance(c *gin.Context) {
 		return
 	}
 
+	expirations, err := h.tokenService.GetTokenExpirations(c.Request.Context(), userID)
+	if err != nil {
+		log.Warnf("Failed to get token expirations for user %d: %v", userID, err)
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: models.TokenBalanceResponse{
 			TokenBalance: balance,
+			Expirations:  expirations,
 		},
 	})
 }
@@ -175,6 +228,16 @@ func (h *Handlers) DeductTokens(c *gin.Context) {
 				Success: false,
 				Error:   "Insufficient tokens",
 			})
+		} else if err.Error() == "member spending cap exceeded" {
+			c.JSON(http.StatusPaymentRequired, models.APIResponse{
+				Success: false,
+				Error:   "Monthly organization spending cap exceeded",
+			})
+		} else if err.Error() == "daily spending limit exceeded" || err.Error() == "monthly spending limit exceeded" {
+			c.JSON(http.StatusPaymentRequired, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
 		} else {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
@@ -200,11 +263,2188 @@ func (h *Handlers) AddTokens(c *gin.Context) {
 		return
 	}
 
-	newBalance, err := h.tokenService.AddTokens(c.Request.Context(), req.UserID, req.TokensToAdd)
+	var expiresAt *time.Time
+	if req.ExpiresInDays != nil {
+		if *req.ExpiresInDays <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "expires_in_days must be positive",
+			})
+			return
+		}
+		expiresAt = services.TokenTTL(*req.ExpiresInDays)
+	}
+
+	newBalance, err := h.tokenService.AddTokens(c.Request.Context(), req.UserID, req.TokensToAdd, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"tokens_added": req.TokensToAdd,
+			"new_balance":  newBalance,
+		},
+	})
+}
+
+func (h *Handlers) GetTokenUsage(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	usage, err := h.usageService.GetUsage(c.Request.Context(), userID, from, to, c.DefaultQuery("bucket", "day"))
+	if err != nil {
+		if err.Error() == "invalid bucket" || err.Error() == "date range too large for bucket" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to get token usage: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get token usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    usage,
+	})
+}
+
+func (h *Handlers) GetSpendingLimits(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	limits, err := h.tokenService.GetSpendingLimits(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get spending limits",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    limits,
+	})
+}
+
+func (h *Handlers) UpdateSpendingLimits(c *gin.Context) {
+	var req models.UpdateSpendingLimitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	limits, err := h.tokenService.UpdateSpendingLimits(c.Request.Context(), &req)
+	if err != nil {
+		if err.Error() == "invalid limit" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to update spending limits: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to update spending limits",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    limits,
+		Message: "Spending limits updated",
+	})
+}
+
+// Notification Handlers
+
+func (h *Handlers) GetNotifications(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+	unreadOnly := c.Query("unread_only") == "true"
+
+	notifications, unread, err := h.notificationService.GetNotifications(c.Request.Context(), userID, limit, unreadOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get notifications",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"notifications": notifications,
+			"unread_count":  unread,
+		},
+	})
+}
+
+func (h *Handlers) MarkNotificationsRead(c *gin.Context) {
+	var req models.MarkNotificationsReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	marked, err := h.notificationService.MarkNotificationsRead(c.Request.Context(), req.UserID, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to mark notifications read",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"marked": marked,
+		},
+	})
+}
+
+func transferErrorStatus(err error) int {
+	switch err.Error() {
+	case "recipient not found", "user not found":
+		return http.StatusNotFound
+	case "invalid amount", "cannot transfer to yourself", "note is too long":
+		return http.StatusBadRequest
+	case "insufficient tokens", "minimum remaining balance not met":
+		return http.StatusPaymentRequired
+	case "daily transfer limit exceeded":
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) TransferTokens(c *gin.Context) {
+	var req models.TransferTokensRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	transfer, err := h.transferService.TransferTokens(c.Request.Context(), &req)
+	if err != nil {
+		status := transferErrorStatus(err)
+		message := err.Error()
+		if status == http.StatusInternalServerError {
+			log.Errorf("Failed to transfer tokens: %v", err)
+			message = "Failed to transfer tokens"
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    transfer,
+	})
+}
+
+func (h *Handlers) GetTokenTransfers(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	transfers, err := h.transferService.GetUserTransfers(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get transfers",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"transfers": transfers,
+		},
+	})
+}
+
+func (h *Handlers) GetTokenLedger(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "50")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	entries, err := h.transferService.GetLedger(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get ledger",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"entries": entries,
+		},
+	})
+}
+
+// Plan Handlers
+
+func (h *Handlers) GetPlans(c *gin.Context) {
+	plans, err := h.planService.GetAllPlans(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get plans",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plans": plans,
+		},
+	})
+}
+
+func (h *Handlers) GetUserPlans(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	userPlans, err := h.planService.GetUserPlans(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get user plans",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    userPlans,
+	})
+}
+
+func (h *Handlers) CreateSubscription(c *gin.Context) {
+	var req models.CreateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	result, err := h.planService.CreateSubscription(c.Request.Context(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "payment required":
+			c.JSON(http.StatusPaymentRequired, models.APIResponse{
+				Success: false,
+				Error:   "Paid plans are activated after payment",
+			})
+		case "paid plan change pending":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case "plan not found", "user not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Plan not found",
+			})
+		default:
+			log.Errorf("Failed to create subscription: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to create subscription",
+			})
+		}
+		return
+	}
+
+	message := fmt.Sprintf("Subscription activated. Token balance set to %d", result.NewTokenBalance)
+	if result.Scheduled {
+		message = "Plan change scheduled for the end of the current period"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"subscription_id":   result.SubscriptionID,
+			"new_token_balance": result.NewTokenBalance,
+			"plan_change":       result,
+			"message":           message,
+		},
+	})
+}
+
+func (h *Handlers) PreviewPlanChange(c *gin.Context) {
+	var req models.PlanChangePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	preview, err := h.planService.PreviewPlanChange(c.Request.Context(), req.UserID, req.PlanID, req.ScheduleDowngrade)
+	if err != nil {
+		switch err.Error() {
+		case "plan not found", "user not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case "paid plan change pending":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to preview plan change: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to preview plan change",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    preview,
+	})
+}
+
+func (h *Handlers) CancelScheduledPlanChange(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	if err := h.planService.CancelScheduledPlanChange(c.Request.Context(), userID); err != nil {
+		switch err.Error() {
+		case "scheduled plan change not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case "paid plan change cannot be canceled":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to cancel scheduled plan change: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to cancel scheduled plan change",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Scheduled plan change canceled",
+	})
+}
+
+// Payment Handlers
+
+func (h *Handlers) CreateStarsInvoice(c *gin.Context) {
+	var req models.CreateStarsInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	invoice, err := h.paymentService.CreateStarsInvoice(c.Request.Context(), req.UserID, req.PlanID)
+	if err != nil {
+		switch err.Error() {
+		case "plan not found", "user not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case "plan is not available for stars payment":
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Plan is not available for Telegram Stars payment",
+			})
+		case "paid plan change pending":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to create stars invoice: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to create invoice",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"invoice_id":   invoice.ID,
+			"invoice_link": invoice.InvoiceLink,
+			"amount":       invoice.Amount,
+			"currency":     telegram.StarsCurrency,
+		},
+	})
+}
+
+func (h *Handlers) TelegramWebhook(c *gin.Context) {
+	secret := config.AppConfig.TelegramWebhookSecret
+	if secret == "" {
+		c.JSON(http.StatusServiceUnavailable, models.APIResponse{
+			Success: false,
+			Error:   "Telegram webhook is not configured",
+		})
+		return
+	}
+
+	header := c.GetHeader("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(header), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Invalid secret token",
+		})
+		return
+	}
+
+	var update telegram.Update
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid update: " + err.Error(),
+		})
+		return
+	}
+
+	// При ошибке отвечаем 500, чтобы Telegram повторил доставку update
+	if err := h.paymentService.HandleTelegramUpdate(c.Request.Context(), &update); err != nil {
+		log.Errorf("Failed to handle telegram update %d: %v", update.UpdateID, err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to handle update",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+	})
+}
+
+func (h *Handlers) CreateCheckout(c *gin.Context) {
+	var req models.CreateCheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if req.Provider == "" {
+		req.Provider = "yookassa"
+	}
+	if req.ReturnURL == "" {
+		req.ReturnURL = config.AppConfig.PaymentReturnURL
+	}
+
+	payment, err := h.paymentService.CreateCheckout(c.Request.Context(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "plan not found", "user not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Plan not found",
+			})
+		case "plan is free", "payment provider not available":
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case "paid plan change pending":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to create checkout: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to create checkout",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"payment": payment,
+		},
+	})
+}
+
+func (h *Handlers) PaymentWebhook(c *gin.Context) {
+	provider := c.Param("provider")
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Failed to read body",
+		})
+		return
+	}
+
+	err = h.paymentService.HandleGatewayWebhook(c.Request.Context(), provider, c.Request.Header, body)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrInvalidSignature):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Error:   "Invalid signature",
+			})
+		case err.Error() == "payment provider not available":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Unknown payment provider",
+			})
+		default:
+			log.Errorf("Failed to handle %s webhook: %v", provider, err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to handle webhook",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+	})
+}
+
+func (h *Handlers) GetUserPayments(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	userPayments, err := h.paymentService.GetUserPayments(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get payments",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"payments": userPayments,
+		},
+	})
+}
+
+// Promo Handlers
+
+func (h *Handlers) RedeemPromo(c *gin.Context) {
+	var req models.RedeemPromoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	clientIP := c.ClientIP()
+	redemption, err := h.promoService.RedeemPromoCode(c.Request.Context(), req.UserID, req.Code, &clientIP)
+	if err != nil {
+		switch err.Error() {
+		case "promo code not found":
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Promo code not found",
+			})
+		case "promo code expired", "promo code usage limit reached", "promo code already redeemed",
+			"promo code not applicable to current plan", "promo code not available with paid subscription":
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to redeem promo code: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to redeem promo code",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"redemption": redemption,
+		},
+	})
+}
+
+// Referral Handlers
+
+func (h *Handlers) GetReferralInfo(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	info, err := h.referralService.GetUserReferralInfo(c.Request.Context(), userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		log.Errorf("Failed to get referral info: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get referral info",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    info,
+	})
+}
+
+// Organization Handlers
+
+// orgErrorStatus сопоставляет ошибки OrganizationService с HTTP-статусами
+func orgErrorStatus(err error) int {
+	switch err.Error() {
+	case "organization not found", "member not found", "user not found":
+		return http.StatusNotFound
+	case "user already in organization", "organization is full", "owner cannot leave organization":
+		return http.StatusConflict
+	case "invalid organization name", "invalid monthly cap":
+		return http.StatusBadRequest
+	case "forbidden":
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) respondOrgError(c *gin.Context, err error, fallback string) {
+	status := orgErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Errorf("%s: %v", fallback, err)
+		message = fallback
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func (h *Handlers) CreateOrganization(c *gin.Context) {
+	var req models.CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	org, err := h.orgService.CreateOrganization(c.Request.Context(), req.UserID, req.Name)
+	if err != nil {
+		h.respondOrgError(c, err, "Failed to create organization")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"organization": org,
+		},
+	})
+}
+
+func (h *Handlers) GetOrganization(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	org, err := h.orgService.GetUserOrganization(c.Request.Context(), userID)
+	if err != nil {
+		h.respondOrgError(c, err, "Failed to get organization")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    org,
+	})
+}
+
+func (h *Handlers) JoinOrganization(c *gin.Context) {
+	var req models.JoinOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	org, err := h.orgService.JoinOrganization(c.Request.Context(), req.UserID, req.InviteCode)
+	if err != nil {
+		h.respondOrgError(c, err, "Failed to join organization")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"organization": org,
+		},
+	})
+}
+
+func (h *Handlers) RegenerateOrganizationInvite(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	code, err := h.orgService.RegenerateInviteCode(c.Request.Context(), userID)
+	if err != nil {
+		h.respondOrgError(c, err, "Failed to regenerate invite code")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"invite_code": code,
+			"start_param": "org_" + code,
+		},
+	})
+}
+
+func (h *Handlers) UpdateOrganizationMemberCap(c *gin.Context) {
+	var req models.UpdateMemberCapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.orgService.SetMemberCap(c.Request.Context(), req.UserID, req.MemberID, req.MonthlyCap); err != nil {
+		h.respondOrgError(c, err, "Failed to update member cap")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Member cap updated",
+	})
+}
+
+func (h *Handlers) RemoveOrganizationMember(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	memberIDStr := c.Query("member_id")
+
+	if userIDStr == "" || memberIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id and member_id are required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	memberID, _ := strconv.Atoi(memberIDStr)
+
+	if err := h.orgService.RemoveMember(c.Request.Context(), userID, memberID); err != nil {
+		h.respondOrgError(c, err, "Failed to remove member")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Member removed",
+	})
+}
+
+// parseDateRange разбирает параметры from/to (YYYY-MM-DD, to включительно).
+// По умолчанию - с начала текущего месяца до текущего момента.
+func parseDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromStr, now.Location())
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from date")
+		}
+		from = parsed
+	}
+
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toStr, now.Location())
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+
+	return from, to, nil
+}
+
+func (h *Handlers) GetOrganizationUsage(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	usage, err := h.orgService.GetUsageBreakdown(c.Request.Context(), userID, from, to)
+	if err != nil {
+		h.respondOrgError(c, err, "Failed to get organization usage")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    usage,
+	})
+}
+
+// Conversation Handlers
+
+func (h *Handlers) GetConversation(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "6")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	messages, err := h.conversationService.GetUserConversation(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get conversation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"messages": messages,
+		},
+	})
+}
+
+// parseOptionalDateRange разбирает необязательные параметры from/to (YYYY-MM-DD, to включительно)
+func parseOptionalDateRange(c *gin.Context) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid from date")
+		}
+		from = &parsed
+	}
+
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid to date")
+		}
+		parsed = parsed.AddDate(0, 0, 1)
+		to = &parsed
+	}
+
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("from must be before to")
+	}
+
+	return from, to, nil
+}
+
+func (h *Handlers) GetConversationSessions(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	from, to, err := parseOptionalDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	page, err := h.conversationService.GetConversationSessions(c.Request.Context(), userID, from, to, c.Query("cursor"), limit)
+	if err != nil {
+		if err.Error() == "invalid cursor" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to get conversation sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get conversation sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    page,
+	})
+}
+
+func (h *Handlers) GetConversationMessages(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "50")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	from, to, err := parseOptionalDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	filter := &services.MessageHistoryFilter{
+		UserID:    userID,
+		From:      from,
+		To:        to,
+		Cursor:    c.Query("cursor"),
+		Limit:     limit,
+		Ascending: c.Query("order") == "asc",
+	}
+	if sessionIDStr := c.Query("session_id"); sessionIDStr != "" {
+		sessionID, err := strconv.Atoi(sessionIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "invalid session_id",
+			})
+			return
+		}
+		filter.SessionID = &sessionID
+	}
+	if role := c.Query("role"); role != "" {
+		filter.Role = &role
+	}
+
+	page, err := h.conversationService.GetMessageHistory(c.Request.Context(), filter)
+	if err != nil {
+		if err.Error() == "invalid cursor" || err.Error() == "invalid role" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to get conversation messages: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get conversation messages",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    page,
+	})
+}
+
+func (h *Handlers) SearchConversation(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+	offsetStr := c.DefaultQuery("offset", "0")
+
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+	offset, _ := strconv.Atoi(offsetStr)
+
+	from, to, err := parseOptionalDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	var sessionID *int
+	if sessionIDStr := c.Query("session_id"); sessionIDStr != "" {
+		id, err := strconv.Atoi(sessionIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "invalid session_id",
+			})
+			return
+		}
+		sessionID = &id
+	}
+
+	results, hasMore, err := h.conversationService.SearchMessages(c.Request.Context(), userID, c.Query("q"), sessionID, from, to, limit, offset)
+	if err != nil {
+		if err.Error() == "query is required" || err.Error() == "query is too long" {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to search conversation: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to search conversation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"results":  results,
+			"has_more": hasMore,
+		},
+	})
+}
+
+func exportErrorStatus(err error) int {
+	switch err.Error() {
+	case "invalid format":
+		return http.StatusBadRequest
+	case "session not found", "no messages to export", "user not found":
+		return http.StatusNotFound
+	case "telegram bot is not configured":
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) respondExportError(c *gin.Context, err error, fallback string) {
+	status := exportErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Errorf("%s: %v", fallback, err)
+		message = fallback
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// parseExportFilter разбирает параметры выгрузки; при ошибке отвечает 400 и возвращает nil
+func parseExportFilter(c *gin.Context) *services.TranscriptExportFilter {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return nil
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	from, to, err := parseOptionalDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return nil
+	}
+
+	filter := &services.TranscriptExportFilter{
+		UserID: userID,
+		From:   from,
+		To:     to,
+		Format: c.DefaultQuery("format", "md"),
+	}
+	if sessionIDStr := c.Query("session_id"); sessionIDStr != "" {
+		sessionID, err := strconv.Atoi(sessionIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "invalid session_id",
+			})
+			return nil
+		}
+		filter.SessionID = &sessionID
+	}
+
+	return filter
+}
+
+func (h *Handlers) ExportConversation(c *gin.Context) {
+	filter := parseExportFilter(c)
+	if filter == nil {
+		return
+	}
+
+	if err := h.exportService.PrepareExport(c.Request.Context(), filter); err != nil {
+		h.respondExportError(c, err, "Failed to export conversation")
+		return
+	}
+
+	c.Header("Content-Type", services.ExportContentType(filter.Format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, services.ExportFilename(filter)))
+	c.Status(http.StatusOK)
+
+	// Ответ уже начат, поэтому ошибку посреди выгрузки можно только залогировать
+	if err := h.exportService.WriteTranscript(c.Request.Context(), filter, c.Writer); err != nil {
+		log.Errorf("Failed to stream conversation export for user %d: %v", filter.UserID, err)
+	}
+}
+
+func (h *Handlers) SendConversationExport(c *gin.Context) {
+	filter := parseExportFilter(c)
+	if filter == nil {
+		return
+	}
+
+	if err := h.exportService.SendTranscript(c.Request.Context(), filter); err != nil {
+		h.respondExportError(c, err, "Failed to send conversation export")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Export sent to Telegram",
+	})
+}
+
+func (h *Handlers) SaveMessage(c *gin.Context) {
+	var req models.SaveConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	messageID, err := h.conversationService.SaveMessage(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to save message",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"message_id": messageID,
+		},
+	})
+}
+
+// Memory Handlers
+
+func memoryErrorStatus(err error) int {
+	switch err.Error() {
+	case "fact not found":
+		return http.StatusNotFound
+	case "invalid fact", "fact is too long", "invalid category", "memory is full":
+		return http.StatusBadRequest
+	case "fact already exists":
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) respondMemoryError(c *gin.Context, err error, fallback string) {
+	status := memoryErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Errorf("%s: %v", fallback, err)
+		message = fallback
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func (h *Handlers) GetMemoryFacts(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	facts, err := h.memoryService.GetFacts(c.Request.Context(), userID)
+	if err != nil {
+		h.respondMemoryError(c, err, "Failed to get memory")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"facts": facts,
+		},
+	})
+}
+
+func (h *Handlers) CreateMemoryFact(c *gin.Context) {
+	var req models.CreateMemoryFactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	fact, err := h.memoryService.CreateFact(c.Request.Context(), &req)
+	if err != nil {
+		h.respondMemoryError(c, err, "Failed to create memory fact")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    fact,
+	})
+}
+
+func (h *Handlers) UpdateMemoryFact(c *gin.Context) {
+	var req models.UpdateMemoryFactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	fact, err := h.memoryService.UpdateFact(c.Request.Context(), &req)
+	if err != nil {
+		h.respondMemoryError(c, err, "Failed to update memory fact")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    fact,
+	})
+}
+
+func (h *Handlers) DeleteMemoryFact(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	factIDStr := c.Query("fact_id")
+
+	if userIDStr == "" || factIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id and fact_id are required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	factID, _ := strconv.Atoi(factIDStr)
+
+	if err := h.memoryService.DeleteFact(c.Request.Context(), userID, factID); err != nil {
+		h.respondMemoryError(c, err, "Failed to delete memory fact")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Memory fact deleted",
+	})
+}
+
+// Privacy Handlers
+
+func privacyErrorStatus(err error) int {
+	switch err.Error() {
+	case "user not found", "export not found", "deletion not found":
+		return http.StatusNotFound
+	case "export is not ready":
+		return http.StatusConflict
+	case "export expired":
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) respondPrivacyError(c *gin.Context, err error, fallback string) {
+	status := privacyErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Errorf("%s: %v", fallback, err)
+		message = fallback
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func (h *Handlers) RequestDataExport(c *gin.Context) {
+	var req models.PrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	export, err := h.privacyService.RequestExport(c.Request.Context(), req.UserID)
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to request data export")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    export,
+		Message: "Data export is being prepared",
+	})
+}
+
+func (h *Handlers) GetDataExports(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	exports, err := h.privacyService.GetExports(c.Request.Context(), userID)
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to get data exports")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"exports": exports,
+		},
+	})
+}
+
+func (h *Handlers) DownloadDataExport(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "token is required",
+		})
+		return
+	}
+
+	file, exportID, err := h.privacyService.OpenExport(c.Request.Context(), token)
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to download data export")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to download data export")
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, info.Size(), "application/zip", file, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, services.DataExportFilename(exportID)),
+	})
+}
+
+func (h *Handlers) DeleteAccount(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	deletion, err := h.privacyService.RequestDeletion(c.Request.Context(), userID)
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to request account deletion")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data:    deletion,
+		Message: "Account deletion scheduled",
+	})
+}
+
+func (h *Handlers) GetAccountDeletion(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	deletion, err := h.privacyService.GetDeletion(c.Request.Context(), userID)
+	if err != nil {
+		h.respondPrivacyError(c, err, "Failed to get account deletion")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    deletion,
+	})
+}
+
+func (h *Handlers) CancelAccountDeletion(c *gin.Context) {
+	var req models.PrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.privacyService.CancelDeletion(c.Request.Context(), req.UserID); err != nil {
+		h.respondPrivacyError(c, err, "Failed to cancel account deletion")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Account deletion canceled",
+	})
+}
+
+// Prompt Handlers
+
+func (h *Handlers) GetPrompts(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	if userIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	prompts, err := h.promptService.GetUserPrompts(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get prompts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    prompts,
+	})
+}
+
+func (h *Handlers) CreatePrompt(c *gin.Context) {
+	var req models.CreatePromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	prompt, err := h.promptService.CreatePrompt(c.Request.Context(), &req)
+	if err != nil {
+		if err.Error() == "prompt limit reached" {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Error:   "Prompt limit reached for your plan",
+			})
+		} else {
+			h.respondPromptError(c, err, "Failed to create prompt")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"prompt": prompt,
+		},
+	})
+}
+
+func promptErrorStatus(err error) int {
+	switch err.Error() {
+	case "prompt not found", "prompt not found or not accessible", "prompt version not found":
+		return http.StatusNotFound
+	case "nothing to update", "title and content cannot be empty",
+		"invalid slug", "invalid titles", "invalid voice gender", "invalid plan tier", "invalid prompt order":
+		return http.StatusBadRequest
+	case "version is already current", "slug already exists":
+		return http.StatusConflict
+	}
+	if strings.HasPrefix(err.Error(), "invalid template: ") || strings.HasPrefix(err.Error(), "invalid prompt ") {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handlers) respondPromptError(c *gin.Context, err error, fallback string) {
+	status := promptErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Errorf("%s: %v", fallback, err)
+		message = fallback
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func (h *Handlers) UpdatePrompt(c *gin.Context) {
+	var req models.UpdatePromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	prompt, err := h.promptService.UpdatePrompt(c.Request.Context(), &req)
+	if err != nil {
+		h.respondPromptError(c, err, "Failed to update prompt")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"prompt": prompt,
+		},
+	})
+}
+
+// PreviewPrompt показывает итоговые инструкции сессии пользователя с подставленными переменными
+func (h *Handlers) PreviewPrompt(c *gin.Context) {
+	var req models.PreviewPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	preview, err := h.openaiService.PreviewInstructions(c.Request.Context(), &req)
+	if err != nil {
+		h.respondPromptError(c, err, "Failed to preview prompt")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    preview,
+	})
+}
+
+func (h *Handlers) GetPromptVersions(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+	promptIDStr := c.Query("prompt_id")
+	if userIDStr == "" || promptIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "user_id and prompt_id are required",
+		})
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+	promptID, _ := strconv.Atoi(promptIDStr)
+
+	versions, err := h.promptService.GetPromptVersions(c.Request.Context(), promptID, &userID)
+	if err != nil {
+		h.respondPromptError(c, err, "Failed to get prompt versions")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"versions": versions,
+		},
+	})
+}
+
+// OpenAI Handler
+
+func (h *Handlers) GetOpenAIToken(c *gin.Context) {
+	userIDStr := c.Query("user_id")
+
+	var userID *int
+	if userIDStr != "" {
+		id, _ := strconv.Atoi(userIDStr)
+		userID = &id
+	}
+
+	token, err := h.openaiService.GetEphemeralToken(c.Request.Context(), userID)
+	if err != nil {
+		log.Errorf("Failed to get OpenAI token: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get OpenAI token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    token,
+	})
+}
+
+// Health check
+func (h *Handlers) HealthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"service": "voice-ai-backend",
+	})
+}
+
+// Admin Handlers
+
+func (h *Handlers) GetAllPlansForAdmin(c *gin.Context) {
+	plans, err := h.adminService.GetAllPlansForAdmin(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get plans",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plans": plans,
+		},
+	})
+}
+
+func (h *Handlers) CreatePlanAdmin(c *gin.Context) {
+	var req models.CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	// Установка дефолтных значений
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+	if req.Features == nil {
+		req.Features = []string{}
+	}
+
+	plan, err := h.adminService.CreatePlan(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to create plan",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plan": plan,
+		},
+	})
+}
+
+func (h *Handlers) UpdatePlanAdmin(c *gin.Context) {
+	var req models.UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.adminService.UpdatePlan(c.Request.Context(), &req)
+	if err != nil {
+		if err.Error() == "plan not found" {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Plan not found",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to update plan",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plan": plan,
+		},
+	})
+}
+
+func (h *Handlers) DeletePlanAdmin(c *gin.Context) {
+	planIDStr := c.Query("plan_id")
+	if planIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "plan_id parameter is required",
+		})
+		return
+	}
+
+	planID, _ := strconv.Atoi(planIDStr)
+
+	err := h.adminService.DeletePlan(c.Request.Context(), planID)
+	if err != nil {
+		if err.Error() == "plan not found" {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Plan not found",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to delete plan",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Plan successfully deleted",
+	})
+}
+
+func (h *Handlers) GetJobRunsAdmin(c *gin.Context) {
+	jobName := c.Query("job_name")
+	limitStr := c.DefaultQuery("limit", "50")
+
+	limit, _ := strconv.Atoi(limitStr)
+
+	runs, err := h.adminService.GetJobRuns(c.Request.Context(), jobName, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get job runs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"runs": runs,
+		},
+	})
+}
+
+func (h *Handlers) GetRetentionPoliciesAdmin(c *gin.Context) {
+	policies, err := h.retentionService.GetPolicies(c.Request.Context())
+	if err != nil {
+		log.Errorf("Failed to get retention policies: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get retention policies",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"policies": policies,
+		},
+	})
+}
+
+func (h *Handlers) UpdateRetentionPolicyAdmin(c *gin.Context) {
+	var req models.UpdateRetentionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	policy, err := h.retentionService.UpdatePolicy(c.Request.Context(), &req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid table", "invalid tier", "invalid retention days":
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			log.Errorf("Failed to update retention policy: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "Failed to update retention policy",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    policy,
+	})
+}
+
+func (h *Handlers) PreviewRetentionAdmin(c *gin.Context) {
+	entries, err := h.retentionService.Preview(c.Request.Context())
+	if err != nil {
+		log.Errorf("Failed to preview retention: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to preview retention",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"policies": entries,
+		},
+	})
+}
+
+func (h *Handlers) GetRetentionStatsAdmin(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days <= 0 {
+		days = 30
+	}
+
+	stats, err := h.retentionService.GetPurgeStats(c.Request.Context(), days)
+	if err != nil {
+		log.Errorf("Failed to get retention stats: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get retention stats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"days":  days,
+			"stats": stats,
+		},
+	})
+}
+
+func (h *Handlers) GetEncryptionStatusAdmin(c *gin.Context) {
+	status, err := h.encryptionService.GetStatus(c.Request.Context())
+	if err != nil {
+		log.Errorf("Failed to get encryption status: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get encryption status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    status,
+	})
+}
+
+// RotateDataKeysAdmin выводит из работы ключи данных; пустое тело - ключи всех пользователей
+func (h *Handlers) RotateDataKeysAdmin(c *gin.Context) {
+	var req models.RotateDataKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	retired, err := h.encryptionService.RotateDataKeys(c.Request.Context(), req.UserID)
 	if err != nil {
+		if err.Error() == "encryption is not configured" {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to rotate data keys: %v", err)
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
-			Error:   err.Error(),
+			Error:   "Failed to rotate data keys",
 		})
 		return
 	}
@@ -212,60 +2452,63 @@ func (h *Handlers) AddTokens(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"tokens_added": req.TokensToAdd,
-			"new_balance":  newBalance,
+			"retired_keys": retired,
 		},
+		Message: "Data keys retired, content will be re-encrypted by the reencrypt_content job",
 	})
 }
 
-// Plan Handlers
-
-func (h *Handlers) GetPlans(c *gin.Context) {
-	plans, err := h.planService.GetAllPlans(c.Request.Context())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+func (h *Handlers) GetPromptVersionsAdmin(c *gin.Context) {
+	promptID, _ := strconv.Atoi(c.Query("prompt_id"))
+	if promptID <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "Failed to get plans",
+			Error:   "prompt_id is required",
 		})
 		return
 	}
 
+	versions, err := h.promptService.GetPromptVersions(c.Request.Context(), promptID, nil)
+	if err != nil {
+		h.respondPromptError(c, err, "Failed to get prompt versions")
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"plans": plans,
+			"versions": versions,
 		},
 	})
 }
 
-func (h *Handlers) GetUserPlans(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
+// DiffPromptVersionsAdmin сравнивает версии from и to (по умолчанию - текущая)
+func (h *Handlers) DiffPromptVersionsAdmin(c *gin.Context) {
+	promptID, _ := strconv.Atoi(c.Query("prompt_id"))
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, _ := strconv.Atoi(c.Query("to"))
+	if promptID <= 0 || from <= 0 || to < 0 {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "user_id is required",
+			Error:   "prompt_id and from are required",
 		})
 		return
 	}
 
-	userID, _ := strconv.Atoi(userIDStr)
-	userPlans, err := h.planService.GetUserPlans(c.Request.Context(), userID)
+	diff, err := h.promptService.DiffVersions(c.Request.Context(), promptID, from, to)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get user plans",
-		})
+		h.respondPromptError(c, err, "Failed to diff prompt versions")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    userPlans,
+		Data:    diff,
 	})
 }
 
-func (h *Handlers) CreateSubscription(c *gin.Context) {
-	var req models.CreateSubscriptionRequest
+func (h *Handlers) RollbackPromptAdmin(c *gin.Context) {
+	var req models.RollbackPromptRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -274,61 +2517,41 @@ func (h *Handlers) CreateSubscription(c *gin.Context) {
 		return
 	}
 
-	subscriptionID, newBalance, err := h.planService.CreateSubscription(c.Request.Context(), &req)
+	version, err := h.promptService.RollbackPrompt(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to create subscription",
-		})
+		h.respondPromptError(c, err, "Failed to roll back prompt")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"subscription_id":   subscriptionID,
-			"new_token_balance": newBalance,
-			"message":           fmt.Sprintf("Subscription activated. Token balance set to %d", newBalance),
+			"version": version,
 		},
+		Message: fmt.Sprintf("Prompt rolled back to version %d", req.Version),
 	})
 }
 
-// Conversation Handlers
-
-func (h *Handlers) GetConversation(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	limitStr := c.DefaultQuery("limit", "6")
-
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "user_id is required",
-		})
-		return
-	}
-
-	userID, _ := strconv.Atoi(userIDStr)
-	limit, _ := strconv.Atoi(limitStr)
+// Base prompts
 
-	messages, err := h.conversationService.GetUserConversation(c.Request.Context(), userID, limit)
+func (h *Handlers) GetBasePromptsAdmin(c *gin.Context) {
+	prompts, err := h.basePromptService.ListBasePrompts(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get conversation",
-		})
+		h.respondPromptError(c, err, "Failed to get base prompts")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"messages": messages,
+			"prompts":    prompts,
+			"plan_tiers": services.PromptPlanTiers(),
 		},
 	})
 }
 
-func (h *Handlers) SaveMessage(c *gin.Context) {
-	var req models.SaveConversationRequest
+func (h *Handlers) CreateBasePromptAdmin(c *gin.Context) {
+	var req models.CreateBasePromptRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -337,53 +2560,48 @@ func (h *Handlers) SaveMessage(c *gin.Context) {
 		return
 	}
 
-	messageID, err := h.conversationService.SaveMessage(c.Request.Context(), &req)
+	prompt, err := h.basePromptService.CreateBasePrompt(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to save message",
-		})
+		h.respondPromptError(c, err, "Failed to create base prompt")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"message_id": messageID,
+			"prompt": prompt,
 		},
+		Message: "Base prompt created successfully",
 	})
 }
 
-// Prompt Handlers
-
-func (h *Handlers) GetPrompts(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
+func (h *Handlers) UpdateBasePromptAdmin(c *gin.Context) {
+	var req models.UpdateBasePromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "user_id is required",
+			Error:   "Invalid request: " + err.Error(),
 		})
 		return
 	}
 
-	userID, _ := strconv.Atoi(userIDStr)
-	prompts, err := h.promptService.GetUserPrompts(c.Request.Context(), userID)
+	prompt, err := h.basePromptService.UpdateBasePrompt(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get prompts",
-		})
+		h.respondPromptError(c, err, "Failed to update base prompt")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    prompts,
+		Data: map[string]interface{}{
+			"prompt": prompt,
+		},
+		Message: "Base prompt updated successfully",
 	})
 }
 
-func (h *Handlers) CreatePrompt(c *gin.Context) {
-	var req models.CreatePromptRequest
+func (h *Handlers) ReorderBasePromptsAdmin(c *gin.Context) {
+	var req models.ReorderBasePromptsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -392,87 +2610,71 @@ func (h *Handlers) CreatePrompt(c *gin.Context) {
 		return
 	}
 
-	prompt, err := h.promptService.CreatePrompt(c.Request.Context(), &req)
+	prompts, err := h.basePromptService.ReorderBasePrompts(c.Request.Context(), req.PromptIDs)
 	if err != nil {
-		if err.Error() == "prompt limit reached" {
-			c.JSON(http.StatusForbidden, models.APIResponse{
-				Success: false,
-				Error:   "Prompt limit reached for your plan",
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Error:   "Failed to create prompt",
-			})
-		}
+		h.respondPromptError(c, err, "Failed to reorder base prompts")
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"prompt": prompt,
+			"prompts": prompts,
 		},
 	})
 }
 
-// OpenAI Handler
+func (h *Handlers) ActivateBasePromptAdmin(c *gin.Context) {
+	h.setBasePromptActive(c, true)
+}
 
-func (h *Handlers) GetOpenAIToken(c *gin.Context) {
-	userIDStr := c.Query("user_id")
+func (h *Handlers) DeactivateBasePromptAdmin(c *gin.Context) {
+	h.setBasePromptActive(c, false)
+}
 
-	var userID *int
-	if userIDStr != "" {
-		id, _ := strconv.Atoi(userIDStr)
-		userID = &id
+func (h *Handlers) setBasePromptActive(c *gin.Context, active bool) {
+	var req models.BasePromptStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
 	}
 
-	token, err := h.openaiService.GetEphemeralToken(c.Request.Context(), userID)
+	prompt, err := h.basePromptService.SetBasePromptActive(c.Request.Context(), req.PromptID, active)
 	if err != nil {
-		log.Errorf("Failed to get OpenAI token: %v", err)
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get OpenAI token",
-		})
+		h.respondPromptError(c, err, "Failed to update base prompt")
 		return
 	}
 
+	message := "Base prompt activated"
+	if !active {
+		message = "Base prompt deactivated"
+	}
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Data:    token,
-	})
-}
-
-// Health check
-func (h *Handlers) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
-		"service": "voice-ai-backend",
+		Data: map[string]interface{}{
+			"prompt": prompt,
+		},
+		Message: message,
 	})
 }
 
-// Admin Handlers
-
-func (h *Handlers) GetAllPlansForAdmin(c *gin.Context) {
-	plans, err := h.adminService.GetAllPlansForAdmin(c.Request.Context())
+// ExportBasePromptsAdmin отдает все базовые промпты JSON-файлом, который принимает импорт
+func (h *Handlers) ExportBasePromptsAdmin(c *gin.Context) {
+	export, err := h.basePromptService.ExportBasePrompts(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get plans",
-		})
+		h.respondPromptError(c, err, "Failed to export base prompts")
 		return
 	}
 
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"plans": plans,
-		},
-	})
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="base_prompts_%s.json"`, export.ExportedAt.Format("20060102_150405")))
+	c.JSON(http.StatusOK, export)
 }
 
-func (h *Handlers) CreatePlanAdmin(c *gin.Context) {
-	var req models.CreatePlanRequest
+func (h *Handlers) ImportBasePromptsAdmin(c *gin.Context) {
+	var req models.ImportBasePromptsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -481,19 +2683,25 @@ func (h *Handlers) CreatePlanAdmin(c *gin.Context) {
 		return
 	}
 
-	// Установка дефолтных значений
-	if req.Currency == "" {
-		req.Currency = "USD"
-	}
-	if req.Features == nil {
-		req.Features = []string{}
+	result, err := h.basePromptService.ImportBasePrompts(c.Request.Context(), &req)
+	if err != nil {
+		h.respondPromptError(c, err, "Failed to import base prompts")
+		return
 	}
 
-	plan, err := h.adminService.CreatePlan(c.Request.Context(), &req)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    result,
+		Message: fmt.Sprintf("%d created, %d updated, %d unchanged", result.Created, result.Updated, result.Unchanged),
+	})
+}
+
+func (h *Handlers) GetPromoCodesAdmin(c *gin.Context) {
+	promos, err := h.promoService.GetPromoCodes(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
-			Error:   "Failed to create plan",
+			Error:   "Failed to get promo codes",
 		})
 		return
 	}
@@ -501,13 +2709,13 @@ func (h *Handlers) CreatePlanAdmin(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"plan": plan,
+			"promo_codes": promos,
 		},
 	})
 }
 
-func (h *Handlers) UpdatePlanAdmin(c *gin.Context) {
-	var req models.UpdatePlanRequest
+func (h *Handlers) CreatePromoCodeAdmin(c *gin.Context) {
+	var req models.CreatePromoCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -516,17 +2724,22 @@ func (h *Handlers) UpdatePlanAdmin(c *gin.Context) {
 		return
 	}
 
-	plan, err := h.adminService.UpdatePlan(c.Request.Context(), &req)
+	promo, err := h.promoService.CreatePromoCode(c.Request.Context(), &req)
 	if err != nil {
-		if err.Error() == "plan not found" {
-			c.JSON(http.StatusNotFound, models.APIResponse{
+		if err.Error() == "promo code already exists" {
+			c.JSON(http.StatusConflict, models.APIResponse{
 				Success: false,
-				Error:   "Plan not found",
+				Error:   "Promo code already exists",
+			})
+		} else if strings.HasPrefix(err.Error(), "invalid promo code") {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
-				Error:   "Failed to update plan",
+				Error:   "Failed to create promo code",
 			})
 		}
 		return
@@ -535,34 +2748,33 @@ func (h *Handlers) UpdatePlanAdmin(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"plan": plan,
+			"promo_code": promo,
 		},
 	})
 }
 
-func (h *Handlers) DeletePlanAdmin(c *gin.Context) {
-	planIDStr := c.Query("plan_id")
-	if planIDStr == "" {
+func (h *Handlers) DeactivatePromoCodeAdmin(c *gin.Context) {
+	promoIDStr := c.Query("promo_id")
+	if promoIDStr == "" {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "plan_id parameter is required",
+			Error:   "promo_id parameter is required",
 		})
 		return
 	}
 
-	planID, _ := strconv.Atoi(planIDStr)
+	promoID, _ := strconv.Atoi(promoIDStr)
 
-	err := h.adminService.DeletePlan(c.Request.Context(), planID)
-	if err != nil {
-		if err.Error() == "plan not found" {
+	if err := h.promoService.DeactivatePromoCode(c.Request.Context(), promoID); err != nil {
+		if err.Error() == "promo code not found" {
 			c.JSON(http.StatusNotFound, models.APIResponse{
 				Success: false,
-				Error:   "Plan not found",
+				Error:   "Promo code not found",
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
-				Error:   "Failed to delete plan",
+				Error:   "Failed to deactivate promo code",
 			})
 		}
 		return
@@ -570,7 +2782,59 @@ func (h *Handlers) DeletePlanAdmin(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "Plan successfully deleted",
+		Message: "Promo code deactivated",
+	})
+}
+
+func (h *Handlers) GetPromoRedemptionsAdmin(c *gin.Context) {
+	promoIDStr := c.Query("promo_id")
+	limitStr := c.DefaultQuery("limit", "100")
+
+	if promoIDStr == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "promo_id parameter is required",
+		})
+		return
+	}
+
+	promoID, _ := strconv.Atoi(promoIDStr)
+	limit, _ := strconv.Atoi(limitStr)
+
+	redemptions, err := h.promoService.GetRedemptions(c.Request.Context(), promoID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get redemptions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"redemptions": redemptions,
+		},
+	})
+}
+
+func (h *Handlers) GetReferralStatsAdmin(c *gin.Context) {
+	limitStr := c.DefaultQuery("limit", "20")
+	limit, _ := strconv.Atoi(limitStr)
+
+	stats, err := h.referralService.GetReferralStats(c.Request.Context(), limit)
+	if err != nil {
+		log.Errorf("Failed to get referral stats: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get referral stats",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    stats,
 	})
 }
 
@@ -612,13 +2876,18 @@ func (h *Handlers) SelectPrompt(c *gin.Context) {
 		return
 	}
 
-	err := h.promptService.SelectPrompt(c.Request.Context(), req.UserID, req.PromptID)
+	err := h.promptService.SelectPrompt(c.Request.Context(), req.UserID, req.PromptID, req.Version)
 	if err != nil {
 		if err.Error() == "prompt not found or not accessible" {
 			c.JSON(http.StatusNotFound, models.APIResponse{
 				Success: false,
 				Error:   "Prompt not found or not accessible",
 			})
+		} else if err.Error() == "prompt version not found" {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Prompt version not found",
+			})
 		} else {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
@@ -830,6 +3099,38 @@ func (h *Handlers) CreateVoiceSession(c *gin.Context) {
 	})
 }
 
+func (h *Handlers) EndVoiceSession(c *gin.Context) {
+	var req models.EndVoiceSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.sessionService.EndVoiceSession(c.Request.Context(), req.UserID, req.SessionID); err != nil {
+		if err.Error() == "session not found" {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Errorf("Failed to end voice session: %v", err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to end session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Session ended",
+	})
+}
+
 func (h *Handlers) GetUserVoiceSessions(c *gin.Context) {
 	userIDStr := c.Query("user_id")
 	limitStr := c.DefaultQuery("limit", "20")
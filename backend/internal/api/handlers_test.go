@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTelegramWebhookSecret(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+
+	tests := []struct {
+		name       string
+		configured string
+		header     string
+		wantStatus int
+	}{
+		{"not configured", "", "secret", http.StatusServiceUnavailable},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "wrong", http.StatusUnauthorized},
+		{"token prefix", "secret", "secre", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig = &config.Config{TelegramWebhookSecret: tt.configured}
+
+			// Сервисы не заданы: отклоненный запрос не должен до них доходить
+			router := gin.New()
+			router.POST("/api/payments/telegram/webhook", (&Handlers{}).TelegramWebhook)
+
+			body := `{"update_id":1,"pre_checkout_query":{"id":"pcq","from":{"id":1},"currency":"XTR","total_amount":1,"invoice_payload":"p"}}`
+			req := httptest.NewRequest(http.MethodPost, "/api/payments/telegram/webhook", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set("X-Telegram-Bot-Api-Secret-Token", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var resp models.APIResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Success {
+				t.Error("success = true for rejected webhook")
+			}
+		})
+	}
+}
@@ -47,19 +47,75 @@ func SetupRouter() *gin.Engine {
 		api.GET("/tokens", handlers.GetTokenBalance)
 		api.PATCH("/tokens", handlers.DeductTokens)
 		api.PUT("/tokens", handlers.AddTokens)
+		api.GET("/tokens/usage", handlers.GetTokenUsage)
+		api.GET("/tokens/limits", handlers.GetSpendingLimits)
+		api.PUT("/tokens/limits", handlers.UpdateSpendingLimits)
+		api.POST("/tokens/transfer", handlers.TransferTokens)
+		api.GET("/tokens/transfers", handlers.GetTokenTransfers)
+		api.GET("/tokens/ledger", handlers.GetTokenLedger)
 
 		// Plans
 		api.GET("/plans", handlers.GetPlans)
 		api.GET("/user-plans", handlers.GetUserPlans)
 		api.POST("/user-plans", handlers.CreateSubscription)
+		api.POST("/user-plans/preview", handlers.PreviewPlanChange)
+		api.DELETE("/user-plans/scheduled", handlers.CancelScheduledPlanChange)
+
+		// Payments
+		api.POST("/payments/stars/invoice", handlers.CreateStarsInvoice)
+		api.POST("/payments/telegram/webhook", handlers.TelegramWebhook)
+		api.POST("/payments/checkout", handlers.CreateCheckout)
+		api.POST("/payments/webhook/:provider", handlers.PaymentWebhook)
+		api.GET("/payments", handlers.GetUserPayments)
+
+		// Promo codes
+		api.POST("/promo/redeem", handlers.RedeemPromo)
+
+		// Referrals
+		api.GET("/referrals", handlers.GetReferralInfo)
+
+		// Notifications
+		api.GET("/notifications", handlers.GetNotifications)
+		api.POST("/notifications/read", handlers.MarkNotificationsRead)
+
+		// Organizations
+		api.GET("/organizations", handlers.GetOrganization)
+		api.POST("/organizations", handlers.CreateOrganization)
+		api.POST("/organizations/join", handlers.JoinOrganization)
+		api.POST("/organizations/invite", handlers.RegenerateOrganizationInvite)
+		api.PATCH("/organizations/members", handlers.UpdateOrganizationMemberCap)
+		api.DELETE("/organizations/members", handlers.RemoveOrganizationMember)
+		api.GET("/organizations/usage", handlers.GetOrganizationUsage)
 
 		// Conversation
 		api.GET("/conversation", handlers.GetConversation)
 		api.POST("/conversation", handlers.SaveMessage)
+		api.GET("/conversation/sessions", handlers.GetConversationSessions)
+		api.GET("/conversation/messages", handlers.GetConversationMessages)
+		api.GET("/conversation/search", handlers.SearchConversation)
+		api.GET("/conversation/export", handlers.ExportConversation)
+		api.POST("/conversation/export/telegram", handlers.SendConversationExport)
+
+		// Memory
+		api.GET("/memory", handlers.GetMemoryFacts)
+		api.POST("/memory", handlers.CreateMemoryFact)
+		api.PATCH("/memory", handlers.UpdateMemoryFact)
+		api.DELETE("/memory", handlers.DeleteMemoryFact)
+
+		// Personal data
+		api.POST("/me/export", handlers.RequestDataExport)
+		api.GET("/me/export", handlers.GetDataExports)
+		api.GET("/me/export/download", handlers.DownloadDataExport)
+		api.DELETE("/me", handlers.DeleteAccount)
+		api.GET("/me/deletion", handlers.GetAccountDeletion)
+		api.POST("/me/deletion/cancel", handlers.CancelAccountDeletion)
 
 		// Prompts
 		api.GET("/prompts", handlers.GetPrompts)
 		api.POST("/prompts", handlers.CreatePrompt)
+		api.PUT("/prompts", handlers.UpdatePrompt)
+		api.POST("/prompts/preview", handlers.PreviewPrompt)
+		api.GET("/prompts/versions", handlers.GetPromptVersions)
 
 		// OpenAI Token
 		api.GET("/token", handlers.GetOpenAIToken)
@@ -71,6 +127,43 @@ func SetupRouter() *gin.Engine {
 			admin.POST("/plans", handlers.CreatePlanAdmin)
 			admin.PUT("/plans", handlers.UpdatePlanAdmin)
 			admin.DELETE("/plans", handlers.DeletePlanAdmin)
+
+			// Background jobs
+			admin.GET("/jobs", handlers.GetJobRunsAdmin)
+
+			// Data retention
+			admin.GET("/retention", handlers.GetRetentionPoliciesAdmin)
+			admin.PUT("/retention", handlers.UpdateRetentionPolicyAdmin)
+			admin.GET("/retention/preview", handlers.PreviewRetentionAdmin)
+			admin.GET("/retention/stats", handlers.GetRetentionStatsAdmin)
+
+			// Base prompts
+			admin.GET("/prompts", handlers.GetBasePromptsAdmin)
+			admin.POST("/prompts", handlers.CreateBasePromptAdmin)
+			admin.PUT("/prompts", handlers.UpdateBasePromptAdmin)
+			admin.POST("/prompts/reorder", handlers.ReorderBasePromptsAdmin)
+			admin.POST("/prompts/activate", handlers.ActivateBasePromptAdmin)
+			admin.POST("/prompts/deactivate", handlers.DeactivateBasePromptAdmin)
+			admin.GET("/prompts/export", handlers.ExportBasePromptsAdmin)
+			admin.POST("/prompts/import", handlers.ImportBasePromptsAdmin)
+
+			// Prompt versions
+			admin.GET("/prompts/versions", handlers.GetPromptVersionsAdmin)
+			admin.GET("/prompts/diff", handlers.DiffPromptVersionsAdmin)
+			admin.POST("/prompts/rollback", handlers.RollbackPromptAdmin)
+
+			// Encryption at rest
+			admin.GET("/encryption", handlers.GetEncryptionStatusAdmin)
+			admin.POST("/encryption/rotate", handlers.RotateDataKeysAdmin)
+
+			// Promo codes
+			admin.GET("/promo-codes", handlers.GetPromoCodesAdmin)
+			admin.POST("/promo-codes", handlers.CreatePromoCodeAdmin)
+			admin.DELETE("/promo-codes", handlers.DeactivatePromoCodeAdmin)
+			admin.GET("/promo-codes/redemptions", handlers.GetPromoRedemptionsAdmin)
+
+			// Referrals
+			admin.GET("/referrals/stats", handlers.GetReferralStatsAdmin)
 		}
 
 		// User Current Plan
@@ -90,6 +183,7 @@ func SetupRouter() *gin.Engine {
 
 		// Voice Sessions
 		api.POST("/voice-sessions", handlers.CreateVoiceSession)
+		api.POST("/voice-sessions/end", handlers.EndVoiceSession)
 		api.GET("/voice-sessions", handlers.GetUserVoiceSessions)
 		api.GET("/voice-sessions/stats", handlers.GetSessionStats)
 	}
@@ -22,6 +22,76 @@ type Config struct {
 	OpenAIAPIKey       string
 	OpenAIRealtimeURL  string
 
+	// Telegram Bot API
+	TelegramBotToken      string
+	TelegramAPIURL        string
+	TelegramWebhookSecret string
+
+	// Payment gateway
+	YooKassaAPIURL        string
+	YooKassaShopID        string
+	YooKassaSecretKey     string
+	YooKassaWebhookSecret string
+	PaymentReturnURL      string
+
+	// Trial
+	TrialEnabled         bool
+	TrialPlanName        string
+	TrialTokenAmount     int
+	TrialDurationDays    int
+	FreeTierTokenBalance int
+
+	// Plan changes ("tokens" or "time")
+	PlanProrationMode string
+
+	// Token grant expiry (0 days - tokens do not expire)
+	PlanTokensExpire     bool
+	PromoTokenTTLDays    int
+	ReferralTokenTTLDays int
+
+	// Token alerts: percents of plan tokens left and spending cap usage warning
+	TokenAlertPercents        []int
+	SpendingCapWarningPercent int
+
+	// Session summaries and conversation context
+	OpenAIAPIURL            string
+	SummaryModel            string
+	SessionIdleMinutes      int
+	InstructionsTokenBudget int
+	ContextTokenBudget      int
+	MemoryTokenBudget       int
+	MemoryMaxFacts          int
+	ContextDebug            bool
+	PromptTimezone          string
+
+	// PII redaction
+	PIIRedaction     string
+	PIIEncryptionKey string
+
+	// Encryption of conversation content at rest
+	EncryptionKeys     string
+	EncryptionKeysFile string
+
+	// Personal data export and account deletion
+	DataExportDir            string
+	DataExportTTLHours       int
+	AccountDeletionGraceDays int
+	DeletedAccountSalt       string
+
+	// Token transfers
+	TransferMinAmount           int
+	TransferDailyLimit          int
+	TransferMinRemainingBalance int
+
+	// Organizations
+	OrganizationMaxMembers int
+
+	// Referral program
+	ReferralReferrerBonus int
+	ReferralRefereeBonus  int
+	ReferralDailyLimit    int
+	ReferralMaxRewards    int
+
 	// CORS
 	AllowedOrigins []string
 
@@ -31,6 +101,9 @@ type Config struct {
 
 	// Logging
 	LogLevel string
+
+	// Scheduler
+	SchedulerEnabled bool
 }
 
 var AppConfig *Config
@@ -50,17 +123,85 @@ func Load() error {
 		DefaultTokenBalance: getEnvAsInt("DEFAULT_TOKEN_BALANCE", 1000),
 		MinTokenThreshold:   getEnvAsInt("MIN_TOKEN_THRESHOLD", 2000),
 		LogLevel:            getEnv("LOG_LEVEL", "info"),
+
+		TelegramBotToken:      getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramAPIURL:        getEnv("TELEGRAM_API_URL", "https://api.telegram.org"),
+		TelegramWebhookSecret: getEnv("TELEGRAM_WEBHOOK_SECRET", ""),
+
+		YooKassaAPIURL:        getEnv("YOOKASSA_API_URL", "https://api.yookassa.ru/v3"),
+		YooKassaShopID:        getEnv("YOOKASSA_SHOP_ID", ""),
+		YooKassaSecretKey:     getEnv("YOOKASSA_SECRET_KEY", ""),
+		YooKassaWebhookSecret: getEnv("YOOKASSA_WEBHOOK_SECRET", ""),
+		PaymentReturnURL:      getEnv("PAYMENT_RETURN_URL", getEnv("FRONTEND_URL", "http://localhost:3000")),
+
+		TrialEnabled:         getEnvAsBool("TRIAL_ENABLED", true),
+		TrialPlanName:        getEnv("TRIAL_PLAN_NAME", "Пробный период"),
+		TrialTokenAmount:     getEnvAsInt("TRIAL_TOKENS", 1000),
+		TrialDurationDays:    getEnvAsInt("TRIAL_DURATION_DAYS", 7),
+		FreeTierTokenBalance: getEnvAsInt("FREE_TIER_TOKENS", 0),
+
+		PlanProrationMode: getEnv("PLAN_PRORATION_MODE", "tokens"),
+
+		PlanTokensExpire:     getEnvAsBool("PLAN_TOKENS_EXPIRE", true),
+		PromoTokenTTLDays:    getEnvAsInt("PROMO_TOKENS_TTL_DAYS", 30),
+		ReferralTokenTTLDays: getEnvAsInt("REFERRAL_TOKENS_TTL_DAYS", 90),
+
+		TokenAlertPercents:        getEnvAsIntList("TOKEN_ALERT_PERCENTS", []int{20, 5}),
+		SpendingCapWarningPercent: getEnvAsInt("SPENDING_CAP_WARNING_PERCENT", 80),
+
+		OpenAIAPIURL:            getEnv("OPENAI_API_URL", "https://api.openai.com/v1"),
+		SummaryModel:            getEnv("SUMMARY_MODEL", "gpt-4o-mini"),
+		SessionIdleMinutes:      getEnvAsInt("SESSION_IDLE_MINUTES", 30),
+		InstructionsTokenBudget: getEnvAsInt("INSTRUCTIONS_TOKEN_BUDGET", 4000),
+		ContextTokenBudget:      getEnvAsInt("CONTEXT_TOKEN_BUDGET", 1500),
+		MemoryTokenBudget:       getEnvAsInt("MEMORY_TOKEN_BUDGET", 300),
+		MemoryMaxFacts:          getEnvAsInt("MEMORY_MAX_FACTS", 200),
+		ContextDebug:            getEnvAsBool("CONTEXT_DEBUG", false),
+		PromptTimezone:          getEnv("PROMPT_TIMEZONE", "Europe/Moscow"),
+
+		PIIRedaction:     getEnv("PII_REDACTION", ""),
+		PIIEncryptionKey: getEnv("PII_ENCRYPTION_KEY", ""),
+
+		EncryptionKeys:     getEnv("ENCRYPTION_KEYS", ""),
+		EncryptionKeysFile: getEnv("ENCRYPTION_KEYS_FILE", ""),
+
+		DataExportDir:            getEnv("DATA_EXPORT_DIR", "./data/exports"),
+		DataExportTTLHours:       getEnvAsInt("DATA_EXPORT_TTL_HOURS", 72),
+		AccountDeletionGraceDays: getEnvAsInt("ACCOUNT_DELETION_GRACE_DAYS", 14),
+		DeletedAccountSalt:       getEnv("DELETED_ACCOUNT_SALT", ""),
+
+		TransferMinAmount:           getEnvAsInt("TRANSFER_MIN_AMOUNT", 10),
+		TransferDailyLimit:          getEnvAsInt("TRANSFER_DAILY_LIMIT", 10000),
+		TransferMinRemainingBalance: getEnvAsInt("TRANSFER_MIN_REMAINING_BALANCE", 100),
+
+		OrganizationMaxMembers: getEnvAsInt("ORGANIZATION_MAX_MEMBERS", 10),
+
+		ReferralReferrerBonus: getEnvAsInt("REFERRAL_REFERRER_BONUS", 500),
+		ReferralRefereeBonus:  getEnvAsInt("REFERRAL_REFEREE_BONUS", 500),
+		ReferralDailyLimit:    getEnvAsInt("REFERRAL_DAILY_LIMIT", 20),
+		ReferralMaxRewards:    getEnvAsInt("REFERRAL_MAX_REWARDS", 100),
+
+		SchedulerEnabled: getEnvAsBool("SCHEDULER_ENABLED", true),
 	}
 
-	// Валидация критичных параметров
-	if AppConfig.DatabaseURL == "" {
+	return AppConfig.Validate()
+}
+
+// Validate проверяет критичные параметры
+func (c *Config) Validate() error {
+	if c.DatabaseURL == "" {
 		return fmt.Errorf("DATABASE_URL is required")
 	}
 
-	if AppConfig.OpenAIAPIKey == "" {
+	if c.OpenAIAPIKey == "" {
 		return fmt.Errorf("OPENAI_API_KEY is required")
 	}
 
+	// Пустой ключ HMAC сделал бы хеши удаленных аккаунтов обратимыми перебором telegram_id
+	if c.DeletedAccountSalt == "" {
+		return fmt.Errorf("DELETED_ACCOUNT_SALT is required")
+	}
+
 	return nil
 }
 
@@ -78,3 +219,28 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsIntList(key string, defaultValue []int) []int {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	var values []int
+	for _, part := range strings.Split(valueStr, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return defaultValue
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			DatabaseURL:        "postgres://localhost/test",
+			OpenAIAPIKey:       "test",
+			DeletedAccountSalt: "salt",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"missing database url", func(c *Config) { c.DatabaseURL = "" }, "DATABASE_URL is required"},
+		{"missing openai key", func(c *Config) { c.OpenAIAPIKey = "" }, "OPENAI_API_KEY is required"},
+		{"missing deleted account salt", func(c *Config) { c.DeletedAccountSalt = "" }, "DELETED_ACCOUNT_SALT is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
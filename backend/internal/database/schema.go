@@ -0,0 +1,489 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// schemaStatements содержит идемпотентные DDL-выражения для таблиц,
+// которыми управляет сам backend. Базовая схема (users, user_subscriptions,
+// token_usage и т.д.) создается вне приложения.
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS scheduler_job_runs (
+		id SERIAL PRIMARY KEY,
+		job_name VARCHAR(100) NOT NULL,
+		instance_id VARCHAR(100) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		error TEXT,
+		started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_scheduler_job_runs_job_started
+		ON scheduler_job_runs (job_name, started_at DESC)`,
+
+	`ALTER TABLE subscription_plans ADD COLUMN IF NOT EXISTS price_stars INTEGER`,
+	`CREATE TABLE IF NOT EXISTS star_invoices (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+		payload VARCHAR(64) NOT NULL UNIQUE,
+		amount INTEGER NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		invoice_link TEXT,
+		telegram_payment_charge_id VARCHAR(255) UNIQUE,
+		subscription_id INTEGER,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		paid_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_star_invoices_user ON star_invoices (user_id, created_at DESC)`,
+
+	`CREATE TABLE IF NOT EXISTS payments (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+		provider VARCHAR(50) NOT NULL,
+		provider_payment_id VARCHAR(255),
+		idempotency_key VARCHAR(64) NOT NULL UNIQUE,
+		amount NUMERIC(12, 2) NOT NULL,
+		currency VARCHAR(10) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		confirmation_url TEXT,
+		subscription_id INTEGER REFERENCES user_subscriptions(id),
+		tokens_granted INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		paid_at TIMESTAMP,
+		refunded_at TIMESTAMP,
+		UNIQUE (provider, provider_payment_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_payments_user ON payments (user_id, created_at DESC)`,
+
+	`CREATE TABLE IF NOT EXISTS promo_codes (
+		id SERIAL PRIMARY KEY,
+		code VARCHAR(64) NOT NULL UNIQUE,
+		kind VARCHAR(20) NOT NULL,
+		token_amount INTEGER NOT NULL DEFAULT 0,
+		discount_percent INTEGER NOT NULL DEFAULT 0,
+		trial_days INTEGER NOT NULL DEFAULT 0,
+		plan_id INTEGER REFERENCES subscription_plans(id),
+		max_uses INTEGER,
+		uses_count INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP,
+		is_active BOOLEAN NOT NULL DEFAULT true,
+		created_by VARCHAR(100),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS promo_redemptions (
+		id SERIAL PRIMARY KEY,
+		promo_code_id INTEGER NOT NULL REFERENCES promo_codes(id),
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status VARCHAR(20) NOT NULL,
+		tokens_granted INTEGER NOT NULL DEFAULT 0,
+		subscription_id INTEGER REFERENCES user_subscriptions(id),
+		payment_id INTEGER REFERENCES payments(id),
+		ip_address VARCHAR(64),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (promo_code_id, user_id)
+	)`,
+	`ALTER TABLE payments ADD COLUMN IF NOT EXISTS promo_redemption_id INTEGER REFERENCES promo_redemptions(id)`,
+
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_referral_code ON users (referral_code)`,
+	`CREATE TABLE IF NOT EXISTS referrals (
+		id SERIAL PRIMARY KEY,
+		referrer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		referee_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+		start_param VARCHAR(128),
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		reject_reason VARCHAR(50),
+		reward_trigger VARCHAR(30),
+		referrer_reward INTEGER NOT NULL DEFAULT 0,
+		referee_reward INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		rewarded_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals (referrer_id, created_at DESC)`,
+
+	`ALTER TABLE subscription_plans ADD COLUMN IF NOT EXISTS is_trial BOOLEAN NOT NULL DEFAULT false`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_subscription_plans_trial ON subscription_plans (is_trial) WHERE is_trial`,
+	`CREATE TABLE IF NOT EXISTS user_trials (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		subscription_id INTEGER REFERENCES user_subscriptions(id),
+		plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+		token_amount INTEGER NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'active',
+		granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		ends_at TIMESTAMP NOT NULL,
+		converted_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_trials_active ON user_trials (ends_at) WHERE status = 'active'`,
+
+	`ALTER TABLE payments ADD COLUMN IF NOT EXISTS schedule_downgrade BOOLEAN NOT NULL DEFAULT false`,
+	`CREATE TABLE IF NOT EXISTS scheduled_plan_changes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		from_subscription_id INTEGER REFERENCES user_subscriptions(id),
+		to_plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+		payment_id INTEGER REFERENCES payments(id),
+		reference VARCHAR(255),
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		effective_at TIMESTAMP,
+		subscription_id INTEGER REFERENCES user_subscriptions(id),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		applied_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_scheduled_plan_changes_pending ON scheduled_plan_changes (user_id) WHERE status = 'pending'`,
+
+	`CREATE TABLE IF NOT EXISTS organizations (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		owner_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+		invite_code VARCHAR(16) NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS organization_members (
+		id SERIAL PRIMARY KEY,
+		organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role VARCHAR(20) NOT NULL DEFAULT 'member',
+		monthly_cap INTEGER,
+		status VARCHAR(20) NOT NULL DEFAULT 'active',
+		joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		removed_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_members_active ON organization_members (user_id) WHERE status = 'active'`,
+	`CREATE INDEX IF NOT EXISTS idx_organization_members_org ON organization_members (organization_id)`,
+	`ALTER TABLE token_usage ADD COLUMN IF NOT EXISTS organization_id INTEGER REFERENCES organizations(id) ON DELETE SET NULL`,
+	`ALTER TABLE token_usage ADD COLUMN IF NOT EXISTS actor_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_token_usage_organization ON token_usage (organization_id, created_at) WHERE organization_id IS NOT NULL`,
+
+	`CREATE TABLE IF NOT EXISTS token_transfers (
+		id SERIAL PRIMARY KEY,
+		sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		amount INTEGER NOT NULL CHECK (amount > 0),
+		note VARCHAR(200),
+		idempotency_key VARCHAR(64),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (sender_id, idempotency_key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_token_transfers_sender ON token_transfers (sender_id, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_token_transfers_recipient ON token_transfers (recipient_id, created_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS token_ledger (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		delta INTEGER NOT NULL,
+		balance_after INTEGER NOT NULL,
+		reason VARCHAR(30) NOT NULL,
+		reference_id INTEGER,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_token_ledger_user ON token_ledger (user_id, created_at DESC)`,
+
+	`CREATE TABLE IF NOT EXISTS token_grants (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		source VARCHAR(30) NOT NULL,
+		reference_id INTEGER,
+		amount INTEGER NOT NULL CHECK (amount > 0),
+		remaining INTEGER NOT NULL CHECK (remaining >= 0),
+		status VARCHAR(20) NOT NULL DEFAULT 'active',
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		closed_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_token_grants_user_active ON token_grants (user_id, expires_at) WHERE status = 'active'`,
+	`CREATE INDEX IF NOT EXISTS idx_token_grants_expires ON token_grants (expires_at) WHERE status = 'active' AND expires_at IS NOT NULL`,
+
+	`ALTER TABLE token_usage ADD COLUMN IF NOT EXISTS model VARCHAR(100)`,
+	`CREATE TABLE IF NOT EXISTS token_usage_hourly (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		bucket_start TIMESTAMP NOT NULL,
+		model VARCHAR(100) NOT NULL DEFAULT '',
+		session_id VARCHAR(255) NOT NULL DEFAULT '',
+		requests INTEGER NOT NULL DEFAULT 0,
+		input_tokens BIGINT NOT NULL DEFAULT 0,
+		output_tokens BIGINT NOT NULL DEFAULT 0,
+		total_tokens BIGINT NOT NULL DEFAULT 0,
+		input_text_tokens BIGINT NOT NULL DEFAULT 0,
+		input_audio_tokens BIGINT NOT NULL DEFAULT 0,
+		input_image_tokens BIGINT NOT NULL DEFAULT 0,
+		cached_tokens BIGINT NOT NULL DEFAULT 0,
+		output_text_tokens BIGINT NOT NULL DEFAULT 0,
+		output_audio_tokens BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, bucket_start, model, session_id)
+	)`,
+	// Первичное заполнение агрегатов по истории: выполняется, только пока таблица пуста
+	`INSERT INTO token_usage_hourly (
+		user_id, bucket_start, model, session_id, requests, input_tokens, output_tokens, total_tokens,
+		input_text_tokens, input_audio_tokens, input_image_tokens, cached_tokens,
+		output_text_tokens, output_audio_tokens
+	)
+	SELECT COALESCE(actor_user_id, user_id), date_trunc('hour', created_at),
+	       COALESCE(model, ''), COALESCE(session_id, ''), COUNT(*),
+	       SUM(COALESCE(input_tokens, 0)), SUM(COALESCE(output_tokens, 0)), SUM(COALESCE(total_tokens, 0)),
+	       SUM(COALESCE(input_text_tokens, 0)), SUM(COALESCE(input_audio_tokens, 0)),
+	       SUM(COALESCE(input_image_tokens, 0)), SUM(COALESCE(cached_tokens, 0)),
+	       SUM(COALESCE(output_text_tokens, 0)), SUM(COALESCE(output_audio_tokens, 0))
+	FROM token_usage
+	WHERE NOT EXISTS (SELECT 1 FROM token_usage_hourly)
+	GROUP BY 1, 2, 3, 4
+	ON CONFLICT DO NOTHING`,
+
+	`CREATE TABLE IF NOT EXISTS user_spending_limits (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		daily_limit INTEGER CHECK (daily_limit > 0),
+		monthly_limit INTEGER CHECK (monthly_limit > 0),
+		alerts_enabled BOOLEAN NOT NULL DEFAULT true,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS token_alerts (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		alert_key VARCHAR(50) NOT NULL,
+		period_key VARCHAR(50) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, alert_key, period_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind VARCHAR(50) NOT NULL,
+		message TEXT NOT NULL,
+		read_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL`,
+	// Доставка ботом идет фоновой задачей. Уведомления, созданные до этого, уже были
+	// отправлены: для них колонка заполняется значением 'sent', для новых - 'pending'.
+	`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivery_status VARCHAR(20) NOT NULL DEFAULT 'sent'`,
+	`ALTER TABLE notifications ALTER COLUMN delivery_status SET DEFAULT 'pending'`,
+	`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivery_attempts INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS idx_notifications_pending ON notifications (id) WHERE delivery_status = 'pending'`,
+
+	// Индексы для постраничной истории разговоров
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_user_created ON conversation_messages (user_id, created_at DESC, id DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_session ON conversation_messages (session_id, created_at) WHERE session_id IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_sessions_user_created ON voice_sessions (user_id, created_at DESC, id DESC)`,
+
+	// Полнотекстовый поиск по истории: конфигурация выбирается по языку пользователя
+	`CREATE OR REPLACE FUNCTION conversation_search_config(lang TEXT) RETURNS regconfig AS $$
+		SELECT CASE
+			WHEN lang ILIKE 'ru%' THEN 'russian'::regconfig
+			WHEN lang ILIKE 'en%' THEN 'english'::regconfig
+			ELSE 'simple'::regconfig
+		END
+	$$ LANGUAGE SQL IMMUTABLE`,
+	`ALTER TABLE conversation_messages ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_search ON conversation_messages USING GIN (search_vector)`,
+
+	// Автоматические сводки сессий: summary_through - время последнего учтенного сообщения
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS ended_at TIMESTAMP`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS summarized_at TIMESTAMP`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS summary_through TIMESTAMP`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS summary_attempts INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_sessions_unsummarized ON voice_sessions (created_at) WHERE summarized_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_sessions_user_summarized ON voice_sessions (user_id, summarized_at DESC) WHERE summarized_at IS NOT NULL`,
+
+	// Долговременная память: факты о пользователе. Удаленные факты остаются с deleted_at,
+	// чтобы извлечение не добавляло их заново
+	`CREATE TABLE IF NOT EXISTS memory_facts (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		fact TEXT NOT NULL,
+		fact_key TEXT NOT NULL,
+		category VARCHAR(20) NOT NULL DEFAULT 'other',
+		importance SMALLINT NOT NULL DEFAULT 1,
+		source VARCHAR(20) NOT NULL,
+		session_id INTEGER,
+		mentions INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP,
+		UNIQUE (user_id, fact_key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_memory_facts_user_active ON memory_facts (user_id) WHERE deleted_at IS NULL`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS facts_extracted_at TIMESTAMP`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS facts_attempts INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_sessions_facts_pending ON voice_sessions (summarized_at) WHERE facts_extracted_at IS NULL AND summarized_at IS NOT NULL`,
+
+	// Выгрузка персональных данных и удаление аккаунта. Журнал privacy_audit_log
+	// не ссылается на users: записи о действиях остаются после удаления данных
+	`CREATE TABLE IF NOT EXISTS data_exports (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		file_path TEXT,
+		size_bytes BIGINT,
+		error TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		expires_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_active ON data_exports (user_id) WHERE status IN ('pending', 'processing')`,
+	`CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports (user_id, created_at DESC)`,
+	// Архив скачивается по случайному токену, а не по угадываемой паре user_id и export_id
+	`ALTER TABLE data_exports ADD COLUMN IF NOT EXISTS download_token VARCHAR(64)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_token ON data_exports (download_token) WHERE download_token IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS account_deletions (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		scheduled_for TIMESTAMP NOT NULL,
+		canceled_at TIMESTAMP,
+		completed_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_account_deletions_pending ON account_deletions (user_id) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS idx_account_deletions_due ON account_deletions (scheduled_for) WHERE status = 'pending'`,
+	// Ключевой хеш telegram_id удаленных аккаунтов: повторная регистрация не получает
+	// пробный период и не засчитывается как приглашение. Таблица не ссылается на users.
+	`CREATE TABLE IF NOT EXISTS deleted_accounts (
+		telegram_hash VARCHAR(64) PRIMARY KEY,
+		deleted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS privacy_audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		action VARCHAR(50) NOT NULL,
+		details JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_privacy_audit_log_user ON privacy_audit_log (user_id, created_at DESC)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+
+	// Сроки хранения данных по таблицам и тарифам (free - без платной подписки).
+	// retention_days NULL - хранить бессрочно
+	`CREATE TABLE IF NOT EXISTS retention_policies (
+		table_name VARCHAR(50) NOT NULL,
+		tier VARCHAR(20) NOT NULL,
+		retention_days INTEGER CHECK (retention_days > 0),
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (table_name, tier)
+	)`,
+	`INSERT INTO retention_policies (table_name, tier, retention_days) VALUES
+		('conversation_messages', 'free', 30),
+		('conversation_messages', 'paid', 365),
+		('user_activity', 'free', 90),
+		('user_activity', 'paid', 90)
+	ON CONFLICT DO NOTHING`,
+	`CREATE TABLE IF NOT EXISTS retention_purges (
+		id SERIAL PRIMARY KEY,
+		table_name VARCHAR(50) NOT NULL,
+		tier VARCHAR(20) NOT NULL,
+		retention_days INTEGER NOT NULL,
+		rows_deleted BIGINT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		error TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_retention_purges_created ON retention_purges (created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_created ON conversation_messages (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_activity_created ON user_activity (created_at)`,
+
+	// Ключи данных пользователей, завернутые мастер-ключом; content_key_id NULL - открытый текст
+	`CREATE TABLE IF NOT EXISTS user_data_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		master_key_id VARCHAR(50) NOT NULL,
+		wrapped_key BYTEA NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		retired_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_data_keys_current ON user_data_keys (user_id) WHERE retired_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_user_data_keys_master ON user_data_keys (master_key_id)`,
+	`ALTER TABLE conversation_messages ADD COLUMN IF NOT EXISTS content_key_id INTEGER`,
+	`ALTER TABLE voice_prompts ADD COLUMN IF NOT EXISTS content_key_id INTEGER`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_content_key ON conversation_messages (content_key_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_plaintext ON conversation_messages (id) WHERE content_key_id IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_prompts_content_key ON voice_prompts (content_key_id)`,
+
+	// Неизменяемые версии промптов. voice_prompts хранит текущую версию, а правки и откаты
+	// добавляют новую. user_id - владелец промпта (NULL у базовых), нужен для шифрования.
+	`CREATE TABLE IF NOT EXISTS voice_prompt_versions (
+		id SERIAL PRIMARY KEY,
+		prompt_id INTEGER NOT NULL REFERENCES voice_prompts(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		user_id INTEGER,
+		title TEXT NOT NULL,
+		description TEXT,
+		content TEXT NOT NULL,
+		content_key_id INTEGER,
+		category TEXT,
+		voice_gender TEXT,
+		author VARCHAR(100) NOT NULL,
+		change_note TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (prompt_id, version)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_prompt_versions_content_key ON voice_prompt_versions (content_key_id)`,
+	`ALTER TABLE voice_prompts ADD COLUMN IF NOT EXISTS current_version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS selected_prompt_version INTEGER`,
+	// Промпты без истории (созданные до версий или в обход API) получают снимок текущей версии
+	`INSERT INTO voice_prompt_versions (prompt_id, version, user_id, title, description, content, content_key_id,
+	                                   category, voice_gender, author, change_note, created_at)
+	SELECT vp.id, vp.current_version, vp.user_id, vp.title, vp.description, vp.content, vp.content_key_id,
+	       vp.category, vp.voice_gender, 'system', 'Initial version', COALESCE(vp.created_at, CURRENT_TIMESTAMP)
+	FROM voice_prompts vp
+	WHERE NOT EXISTS (SELECT 1 FROM voice_prompt_versions v WHERE v.prompt_id = vp.id)`,
+
+	// Базовые промпты: slug - ключ для импорта, sort_order - порядок в списке,
+	// titles - названия на других языках ({"en": "..."}), версионируются вместе с промптом
+	`ALTER TABLE voice_prompts ADD COLUMN IF NOT EXISTS slug VARCHAR(100)`,
+	`ALTER TABLE voice_prompts ADD COLUMN IF NOT EXISTS sort_order INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE voice_prompts ADD COLUMN IF NOT EXISTS titles JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE voice_prompt_versions ADD COLUMN IF NOT EXISTS titles JSONB NOT NULL DEFAULT '{}'`,
+	`UPDATE voice_prompts SET slug = 'prompt-' || id WHERE is_base = true AND slug IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_voice_prompts_base_slug ON voice_prompts (slug) WHERE is_base = true`,
+
+	// Открытый текст не хранится рядом с зашифрованным: поисковый вектор зашифрованного
+	// сообщения состоит из хешей слов на ключе данных (search_key_id), сводка сессии и факт
+	// памяти шифруются, ключ дублей факта - хеш на ключе данных. Вектор, построенный не тем
+	// ключом, что содержимое (после шифрования или ротации), строится заново.
+	`ALTER TABLE conversation_messages ADD COLUMN IF NOT EXISTS search_key_id INTEGER`,
+	`CREATE INDEX IF NOT EXISTS idx_conversation_messages_search_stale ON conversation_messages (id)
+		WHERE search_vector IS NULL OR search_key_id IS DISTINCT FROM content_key_id`,
+	`ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS summary_key_id INTEGER`,
+	`CREATE INDEX IF NOT EXISTS idx_voice_sessions_summary_key ON voice_sessions (summary_key_id)`,
+	`ALTER TABLE memory_facts ADD COLUMN IF NOT EXISTS fact_data_key_id INTEGER`,
+	`CREATE INDEX IF NOT EXISTS idx_memory_facts_data_key ON memory_facts (fact_data_key_id)`,
+
+	// Ключ данных, который не удалось развернуть при перезаворачивании: rewrap_failed_for -
+	// текущий мастер-ключ на момент ошибки, до его смены ключ не выбирается повторно
+	`ALTER TABLE user_data_keys ADD COLUMN IF NOT EXISTS rewrap_failed_for VARCHAR(50)`,
+	`ALTER TABLE user_data_keys ADD COLUMN IF NOT EXISTS rewrap_error TEXT`,
+	`ALTER TABLE user_data_keys ADD COLUMN IF NOT EXISTS rewrap_failed_at TIMESTAMP`,
+
+	// Строки, которые reencrypt_content не смог расшифровать. Строка не выбирается снова,
+	// пока не изменится ключ данных, которым она зашифрована (key_id)
+	`CREATE TABLE IF NOT EXISTS reencrypt_failures (
+		table_name VARCHAR(50) NOT NULL,
+		row_id INTEGER NOT NULL,
+		key_id INTEGER,
+		error TEXT NOT NULL,
+		failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (table_name, row_id)
+	)`,
+}
+
+// Migrate применяет схему таблиц backend'а
+func (db *DB) Migrate(ctx context.Context) error {
+	for _, stmt := range schemaStatements {
+		if _, err := db.Pool.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to apply schema statement: %w", err)
+		}
+	}
+
+	log.Infof("✅ Database schema is up to date (%d statements)", len(schemaStatements))
+
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package envelope реализует конвертное шифрование: данные шифруются ключами данных
+// (по ключу на пользователя), а ключи данных хранятся завернутыми мастер-ключом из конфигурации.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DataKeySize - длина ключа данных (AES-256)
+const DataKeySize = 32
+
+// Keyring - мастер-ключи. Новые ключи данных заворачиваются текущим (первым) ключом,
+// остальные нужны, чтобы развернуть ключи, завернутые до ротации.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// Default используется сервисами; nil - шифрование выключено. main заменяет его
+// ключами из конфигурации.
+var Default *Keyring
+
+// ParseKeyring разбирает список мастер-ключей "id:base64" через запятую или перевод
+// строки. Первый ключ - текущий. Ключ - 32 байта в base64.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+
+	for _, item := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(item, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || len(id) > 50 {
+			return nil, fmt.Errorf("invalid master key entry, expected id:base64")
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate master key %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %q: %w", id, err)
+		}
+		if len(key) != DataKeySize {
+			return nil, fmt.Errorf("master key %q must be %d bytes", id, DataKeySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %q: %w", id, err)
+		}
+
+		k.keys[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+
+	if k.primary == "" {
+		return nil, fmt.Errorf("no master keys")
+	}
+	return k, nil
+}
+
+// LoadKeyring берет ключи из файла, если он задан, иначе из строки.
+// Если не задано ни то, ни другое, возвращает nil: шифрование выключено.
+func LoadKeyring(spec string, file string) (*Keyring, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key file: %w", err)
+		}
+		defer f.Close()
+
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		spec = strings.Join(lines, "\n")
+	}
+
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return ParseKeyring(spec)
+}
+
+// Primary возвращает идентификатор текущего мастер-ключа
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// NewDataKey генерирует случайный ключ данных
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// Wrap заворачивает ключ данных текущим мастер-ключом и возвращает идентификатор этого ключа
+func (k *Keyring) Wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", nil, err
+	}
+	return k.primary, wrapped, nil
+}
+
+// Unwrap разворачивает ключ данных мастер-ключом masterID
+func (k *Keyring) Unwrap(masterID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[masterID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", masterID)
+	}
+	dataKey, err := open(aead, wrapped, []byte(masterID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Seal шифрует текст ключом данных. aad привязывает шифротекст к месту хранения:
+// перенесенный в другую таблицу или другому пользователю, он не расшифруется.
+func Seal(dataKey []byte, plaintext string, aad string) (string, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open расшифровывает текст, зашифрованный Seal с тем же aad
+func Open(dataKey []byte, ciphertext string, aad string) (string, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	plain, err := open(aead, sealed, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plain, nil
+}
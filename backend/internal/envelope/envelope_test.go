@@ -0,0 +1,216 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(id string, seed string) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(seed, DataKeySize)[:DataKeySize]))
+}
+
+func mustParse(t *testing.T, spec string) *Keyring {
+	t.Helper()
+
+	k, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return k
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	if len(dataKey) != DataKeySize {
+		t.Fatalf("NewDataKey() length = %d, want %d", len(dataKey), DataKeySize)
+	}
+
+	for _, plaintext := range []string{"", "hello", "Привет, мир! 🌍", strings.Repeat("x", 10000)} {
+		sealed, err := Seal(dataKey, plaintext, "messages:1")
+		if err != nil {
+			t.Fatalf("Seal: %v", err)
+		}
+		if plaintext != "" && strings.Contains(sealed, plaintext) {
+			t.Fatalf("Seal() leaks plaintext")
+		}
+		got, err := Open(dataKey, sealed, "messages:1")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if got != plaintext {
+			t.Fatalf("Open() = %q, want %q", got, plaintext)
+		}
+	}
+
+	// Каждый раз новый nonce: одинаковый текст дает разный шифротекст
+	first, _ := Seal(dataKey, "hello", "messages:1")
+	second, _ := Seal(dataKey, "hello", "messages:1")
+	if first == second {
+		t.Fatalf("Seal() is deterministic: %q", first)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	otherKey, _ := NewDataKey()
+	sealed, err := Seal(dataKey, "secret", "messages:1")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	raw[len(raw)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext string
+		aad        string
+	}{
+		{"wrong aad table", dataKey, sealed, "prompts:1"},
+		{"wrong aad user", dataKey, sealed, "messages:2"},
+		{"wrong key", otherKey, sealed, "messages:1"},
+		{"tampered", dataKey, tampered, "messages:1"},
+		{"not base64", dataKey, "not base64!", "messages:1"},
+		{"too short", dataKey, base64.StdEncoding.EncodeToString([]byte("short")), "messages:1"},
+		{"invalid key length", dataKey[:10], sealed, "messages:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Open(tt.key, tt.ciphertext, tt.aad); err == nil {
+				t.Fatalf("Open() = %q, want error", got)
+			}
+		})
+	}
+}
+
+func TestWrapUnwrapAcrossRotation(t *testing.T) {
+	dataKey, _ := NewDataKey()
+
+	old := mustParse(t, testKey("old", "a"))
+	masterID, wrapped, err := old.Wrap(dataKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if masterID != "old" {
+		t.Fatalf("Wrap() master key = %q, want old", masterID)
+	}
+
+	// После ротации старый ключ остается в списке: ключ данных разворачивается
+	rotated := mustParse(t, testKey("new", "b")+","+testKey("old", "a"))
+	if rotated.Primary() != "new" {
+		t.Fatalf("Primary() = %q, want new", rotated.Primary())
+	}
+	got, err := rotated.Unwrap(masterID, wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap with retired master key: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatalf("Unwrap() returned a different data key")
+	}
+
+	// Перезаворачивание идет текущим ключом
+	newID, rewrapped, err := rotated.Wrap(got)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if newID != "new" {
+		t.Fatalf("Wrap() master key = %q, want new", newID)
+	}
+
+	// Старый ключ убран из списка: ключи, завернутые им, не разворачиваются
+	removed := mustParse(t, testKey("new", "b"))
+	if _, err := removed.Unwrap(masterID, wrapped); err == nil {
+		t.Fatalf("Unwrap with removed master key succeeded")
+	}
+	if _, err := removed.Unwrap(newID, rewrapped); err != nil {
+		t.Fatalf("Unwrap of rewrapped key: %v", err)
+	}
+
+	// Тот же id с другим ключом и чужой id не подходят
+	replaced := mustParse(t, testKey("old", "c"))
+	if _, err := replaced.Unwrap(masterID, wrapped); err == nil {
+		t.Fatalf("Unwrap with a different key under the same id succeeded")
+	}
+	if _, err := rotated.Unwrap("new", wrapped); err == nil {
+		t.Fatalf("Unwrap with a master id other than the wrapping one succeeded")
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	shortKey := "short:" + base64.StdEncoding.EncodeToString([]byte("too short"))
+
+	tests := []struct {
+		name        string
+		spec        string
+		wantPrimary string
+		wantErr     bool
+	}{
+		{"single", testKey("k1", "a"), "k1", false},
+		{"comma separated", testKey("k1", "a") + "," + testKey("k2", "b"), "k1", false},
+		{"lines with comments", "# текущий\n" + testKey("k2", "b") + "\n\n" + testKey("k1", "a") + "\n", "k2", false},
+		{"spaces trimmed", "  " + testKey("k1", "a") + " , " + testKey("k2", "b"), "k1", false},
+
+		{"empty", "", "", true},
+		{"only comments", "# nothing here", "", true},
+		{"missing id", ":" + strings.TrimPrefix(testKey("k1", "a"), "k1:"), "", true},
+		{"missing separator", "k1", "", true},
+		{"id too long", testKey(strings.Repeat("k", 51), "a"), "", true},
+		{"duplicate id", testKey("k1", "a") + "," + testKey("k1", "b"), "", true},
+		{"invalid base64", "k1:not-base64!", "", true},
+		{"wrong length", shortKey, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyring(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKeyring(%q) succeeded, want error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyring: %v", err)
+			}
+			if k.Primary() != tt.wantPrimary {
+				t.Fatalf("Primary() = %q, want %q", k.Primary(), tt.wantPrimary)
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	k, err := LoadKeyring("", "")
+	if err != nil || k != nil {
+		t.Fatalf("LoadKeyring without keys = %v, %v; want encryption disabled", k, err)
+	}
+
+	// Файл важнее строки
+	file := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(file, []byte("# ключи\n"+testKey("file", "a")+"\n"), 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	k, err = LoadKeyring(testKey("env", "b"), file)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	if k.Primary() != "file" {
+		t.Fatalf("Primary() = %q, want file", k.Primary())
+	}
+
+	if _, err := LoadKeyring("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("LoadKeyring with a missing file succeeded")
+	}
+	if _, err := LoadKeyring("k1:broken", ""); err == nil {
+		t.Fatalf("LoadKeyring with a malformed key succeeded")
+	}
+}
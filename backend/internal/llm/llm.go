@@ -0,0 +1,21 @@
+// Package llm описывает текстовые LLM-запросы (без Realtime API), которые
+// backend выполняет сам: суммаризация сессий, извлечение фактов и т.п.
+package llm
+
+import "context"
+
+// Completer - адаптер текстовой модели
+type Completer interface {
+	// Complete возвращает ответ модели на один запрос
+	Complete(ctx context.Context, req *CompletionRequest) (string, error)
+}
+
+type CompletionRequest struct {
+	Model  string
+	System string
+	Prompt string
+	// MaxTokens ограничивает длину ответа (0 - ограничение провайдера)
+	MaxTokens int
+	// JSON просит модель вернуть один JSON-объект
+	JSON bool
+}
@@ -0,0 +1,146 @@
+// Package llmtest предоставляет локальный фейк OpenAI Chat Completions API
+// для тестирования суммаризации и других LLM-запросов без обращения к OpenAI.
+package llmtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"voice-ai-backend/internal/llm"
+)
+
+// Request - зафиксированный запрос к модели
+type Request struct {
+	Model     string
+	System    string
+	Prompt    string
+	MaxTokens int
+	JSON      bool
+}
+
+// Server - фейковый Chat Completions API поверх httptest.Server
+type Server struct {
+	*httptest.Server
+	APIKey string
+
+	mu          sync.Mutex
+	requests    []Request
+	reply       func(req Request) string
+	failStatus  int
+	failMessage string
+}
+
+// NewServer создает фейк, который по умолчанию отвечает фиксированной сводкой в JSON
+func NewServer(apiKey string) *Server {
+	s := &Server{
+		APIKey: apiKey,
+		reply: func(req Request) string {
+			return `{"topic": "Тестовая тема", "summary": "Тестовая сводка разговора."}`
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client возвращает адаптер, настроенный на этот фейковый сервер
+func (s *Server) Client() *llm.OpenAIClient {
+	return llm.NewOpenAIClient(s.URL, s.APIKey)
+}
+
+// SetReply задает ответ модели в зависимости от запроса
+func (s *Server) SetReply(reply func(req Request) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = reply
+}
+
+// Fail заставляет все запросы завершаться ошибкой API (status 0 - снова отвечать успешно)
+func (s *Server) Fail(status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failStatus = status
+	s.failMessage = message
+}
+
+// Requests возвращает все полученные запросы
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"error": map[string]string{"message": "Incorrect API key provided"},
+		})
+		return
+	}
+	if r.URL.Path != "/chat/completions" {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": map[string]string{"message": "Unknown endpoint"},
+		})
+		return
+	}
+
+	var body struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		MaxTokens      int               `json:"max_tokens"`
+		ResponseFormat map[string]string `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error": map[string]string{"message": "Invalid JSON body"},
+		})
+		return
+	}
+
+	req := Request{
+		Model:     body.Model,
+		MaxTokens: body.MaxTokens,
+		JSON:      body.ResponseFormat["type"] == "json_object",
+	}
+	for _, message := range body.Messages {
+		switch message.Role {
+		case "system":
+			req.System = message.Content
+		case "user":
+			req.Prompt = message.Content
+		}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	failStatus, failMessage, reply := s.failStatus, s.failMessage, s.reply
+	s.mu.Unlock()
+
+	if failStatus != 0 {
+		writeJSON(w, failStatus, map[string]interface{}{
+			"error": map[string]string{"message": failMessage},
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "chat.completion",
+		"model":  req.Model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": reply(req)},
+				"finish_reason": "stop",
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
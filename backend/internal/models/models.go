@@ -15,6 +15,7 @@ type User struct {
 	SelectedModel *string   `json:"selected_model,omitempty" db:"selected_model"`
 	SelectedVoice *string   `json:"selected_voice,omitempty" db:"selected_voice"`
 	SelectedPromptID *int   `json:"selected_prompt_id,omitempty" db:"selected_prompt_id"`
+	ReferralCode  *string   `json:"referral_code,omitempty" db:"referral_code"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 	LastActive    time.Time `json:"last_active" db:"last_active"`
@@ -30,6 +31,7 @@ type SubscriptionPlan struct {
 	TokenAmount int       `json:"token_amount" db:"token_amount"`
 	Features    []string  `json:"features" db:"features"`
 	IsActive    bool      `json:"is_active" db:"is_active"`
+	PriceStars  *int      `json:"price_stars,omitempty" db:"price_stars"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -48,20 +50,21 @@ type UserSubscription struct {
 
 // TokenUsage represents token usage log
 type TokenUsage struct {
-	ID               int       `json:"id" db:"id"`
-	UserID           int       `json:"user_id" db:"user_id"`
-	SessionID        string    `json:"session_id" db:"session_id"`
-	InputTokens      int       `json:"input_tokens" db:"input_tokens"`
-	OutputTokens     int       `json:"output_tokens" db:"output_tokens"`
-	TotalTokens      int       `json:"total_tokens" db:"total_tokens"`
-	CostTokens       int       `json:"cost_tokens" db:"cost_tokens"`
-	InputTextTokens  int       `json:"input_text_tokens" db:"input_text_tokens"`
-	InputAudioTokens int       `json:"input_audio_tokens" db:"input_audio_tokens"`
-	InputImageTokens int       `json:"input_image_tokens" db:"input_image_tokens"`
-	CachedTokens     int       `json:"cached_tokens" db:"cached_tokens"`
-	OutputTextTokens int       `json:"output_text_tokens" db:"output_text_tokens"`
-	OutputAudioTokens int      `json:"output_audio_tokens" db:"output_audio_tokens"`
-	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	ID                int       `json:"id" db:"id"`
+	UserID            int       `json:"user_id" db:"user_id"`
+	SessionID         string    `json:"session_id" db:"session_id"`
+	InputTokens       int       `json:"input_tokens" db:"input_tokens"`
+	OutputTokens      int       `json:"output_tokens" db:"output_tokens"`
+	TotalTokens       int       `json:"total_tokens" db:"total_tokens"`
+	CostTokens        int       `json:"cost_tokens" db:"cost_tokens"`
+	InputTextTokens   int       `json:"input_text_tokens" db:"input_text_tokens"`
+	InputAudioTokens  int       `json:"input_audio_tokens" db:"input_audio_tokens"`
+	InputImageTokens  int       `json:"input_image_tokens" db:"input_image_tokens"`
+	CachedTokens      int       `json:"cached_tokens" db:"cached_tokens"`
+	OutputTextTokens  int       `json:"output_text_tokens" db:"output_text_tokens"`
+	OutputAudioTokens int       `json:"output_audio_tokens" db:"output_audio_tokens"`
+	Model             *string   `json:"model,omitempty" db:"model"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 }
 
 // ConversationMessage represents a conversation message
@@ -87,10 +90,141 @@ type VoicePrompt struct {
 	Category    *string   `json:"category,omitempty" db:"category"`
 	VoiceGender *string   `json:"voice_gender,omitempty" db:"voice_gender"`
 	IsActive    bool      `json:"is_active" db:"is_active"`
+	Version     int       `json:"version" db:"current_version"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// VoicePromptVersion is an immutable snapshot of a prompt
+type VoicePromptVersion struct {
+	ID          int               `json:"id" db:"id"`
+	PromptID    int               `json:"prompt_id" db:"prompt_id"`
+	Version     int               `json:"version" db:"version"`
+	Title       string            `json:"title" db:"title"`
+	Titles      map[string]string `json:"titles,omitempty" db:"titles"`
+	Description *string           `json:"description,omitempty" db:"description"`
+	Content     string            `json:"content" db:"content"`
+	Category    *string           `json:"category,omitempty" db:"category"`
+	VoiceGender *string           `json:"voice_gender,omitempty" db:"voice_gender"`
+	Author      string            `json:"author" db:"author"` // user:<id>, admin name or system
+	ChangeNote  *string           `json:"change_note,omitempty" db:"change_note"`
+	IsCurrent   bool              `json:"is_current"`
+	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+}
+
+// BasePrompt is a built-in prompt as seen by admins
+type BasePrompt struct {
+	ID           int               `json:"id"`
+	Slug         string            `json:"slug"`
+	Title        string            `json:"title"`
+	Titles       map[string]string `json:"titles"` // localized titles by language code
+	Description  *string           `json:"description,omitempty"`
+	Content      string            `json:"content"`
+	Category     *string           `json:"category,omitempty"`
+	VoiceGender  *string           `json:"voice_gender,omitempty"`
+	PlanTier     string            `json:"plan_tier"`
+	PlanRequired int               `json:"plan_required"`
+	SortOrder    int               `json:"sort_order"`
+	IsActive     bool              `json:"is_active"`
+	Version      int               `json:"version"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// BasePromptData is the editable part of a base prompt and the JSON import/export format
+type BasePromptData struct {
+	Slug        string            `json:"slug"` // stable key for import, generated if empty on create
+	Title       string            `json:"title" binding:"required"`
+	Titles      map[string]string `json:"titles,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Content     string            `json:"content" binding:"required"`
+	Category    *string           `json:"category,omitempty"`
+	VoiceGender *string           `json:"voice_gender,omitempty"` // any, male or female
+	PlanTier    string            `json:"plan_tier,omitempty"`    // basic, premium or pro; defaults to basic
+	SortOrder   *int              `json:"sort_order,omitempty"`   // defaults to the end of the list
+	IsActive    *bool             `json:"is_active,omitempty"`    // defaults to true
+}
+
+type CreateBasePromptRequest struct {
+	BasePromptData
+	Author string `json:"author"` // defaults to admin
+}
+
+// UpdateBasePromptRequest edits a base prompt; omitted fields keep their values.
+// Changes to the text fields create a new version.
+type UpdateBasePromptRequest struct {
+	PromptID    int               `json:"prompt_id" binding:"required"`
+	Slug        *string           `json:"slug"`
+	Title       *string           `json:"title"`
+	Titles      map[string]string `json:"titles"`
+	Description *string           `json:"description"`
+	Content     *string           `json:"content"`
+	Category    *string           `json:"category"`
+	VoiceGender *string           `json:"voice_gender"`
+	PlanTier    *string           `json:"plan_tier"`
+	SortOrder   *int              `json:"sort_order"`
+	Author      string            `json:"author"`
+	ChangeNote  *string           `json:"change_note"`
+}
+
+type ReorderBasePromptsRequest struct {
+	PromptIDs []int `json:"prompt_ids" binding:"required,min=1"`
+}
+
+type BasePromptStatusRequest struct {
+	PromptID int `json:"prompt_id" binding:"required"`
+}
+
+// BasePromptExport is the bulk export file; ImportBasePromptsRequest accepts it as is
+type BasePromptExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Prompts    []BasePromptData `json:"prompts"`
+}
+
+// ImportBasePromptsRequest upserts base prompts by slug. Prompts missing from the file are kept.
+type ImportBasePromptsRequest struct {
+	Prompts []BasePromptData `json:"prompts" binding:"required,min=1,dive"`
+	Author  string           `json:"author"`
+	DryRun  bool             `json:"dry_run"`
+}
+
+type BasePromptImportResult struct {
+	DryRun    bool                   `json:"dry_run"`
+	Created   int                    `json:"created"`
+	Updated   int                    `json:"updated"`
+	Unchanged int                    `json:"unchanged"`
+	Items     []BasePromptImportItem `json:"items"`
+}
+
+type BasePromptImportItem struct {
+	Slug     string `json:"slug"`
+	PromptID int    `json:"prompt_id"`
+	Action   string `json:"action"` // created, updated or unchanged
+	Version  int    `json:"version"`
+}
+
+// PromptDiff compares two versions of a prompt
+type PromptDiff struct {
+	PromptID int                 `json:"prompt_id"`
+	From     int                 `json:"from"`
+	To       int                 `json:"to"`
+	Fields   []PromptFieldChange `json:"fields"`  // changed fields other than content
+	Content  []PromptDiffLine    `json:"content"` // line diff of the content
+	Unified  string              `json:"unified"`
+}
+
+// PromptFieldChange is a changed prompt field
+type PromptFieldChange struct {
+	Field string  `json:"field"`
+	From  *string `json:"from"`
+	To    *string `json:"to"`
+}
+
+// PromptDiffLine is one line of a content diff
+type PromptDiffLine struct {
+	Op   string `json:"op"` // equal, add, remove
+	Text string `json:"text"`
+}
+
 // UserActivity represents user activity log
 type UserActivity struct {
 	ID         int                    `json:"id" db:"id"`
@@ -104,14 +238,250 @@ type UserActivity struct {
 
 // VoiceSession represents voice session statistics
 type VoiceSession struct {
-	ID                    int       `json:"id" db:"id"`
-	UserID                *int      `json:"user_id,omitempty" db:"user_id"`
-	WordsSpoken           int       `json:"words_spoken" db:"words_spoken"`
-	AIResponses           int       `json:"ai_responses" db:"ai_responses"`
-	SessionQuality        *float64  `json:"session_quality,omitempty" db:"session_quality"`
-	CreatedAt             time.Time `json:"created_at" db:"created_at"`
-	ContextSummary        *string   `json:"context_summary,omitempty" db:"context_summary"`
-	LastConversationTopic *string   `json:"last_conversation_topic,omitempty" db:"last_conversation_topic"`
+	ID                    int        `json:"id" db:"id"`
+	UserID                *int       `json:"user_id,omitempty" db:"user_id"`
+	WordsSpoken           int        `json:"words_spoken" db:"words_spoken"`
+	AIResponses           int        `json:"ai_responses" db:"ai_responses"`
+	SessionQuality        *float64   `json:"session_quality,omitempty" db:"session_quality"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	ContextSummary        *string    `json:"context_summary,omitempty" db:"context_summary"`
+	LastConversationTopic *string    `json:"last_conversation_topic,omitempty" db:"last_conversation_topic"`
+	EndedAt               *time.Time `json:"ended_at,omitempty" db:"ended_at"`
+	SummarizedAt          *time.Time `json:"summarized_at,omitempty" db:"summarized_at"`
+}
+
+// JobRun represents a single background job execution
+type JobRun struct {
+	ID         int        `json:"id" db:"id"`
+	JobName    string     `json:"job_name" db:"job_name"`
+	InstanceID string     `json:"instance_id" db:"instance_id"`
+	Status     string     `json:"status" db:"status"`
+	Error      *string    `json:"error,omitempty" db:"error"`
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// StarsInvoice represents a Telegram Stars invoice for a plan purchase
+type StarsInvoice struct {
+	ID                      int        `json:"id" db:"id"`
+	UserID                  int        `json:"user_id" db:"user_id"`
+	PlanID                  int        `json:"plan_id" db:"plan_id"`
+	Payload                 string     `json:"payload" db:"payload"`
+	Amount                  int        `json:"amount" db:"amount"`
+	Status                  string     `json:"status" db:"status"`
+	InvoiceLink             string     `json:"invoice_link" db:"invoice_link"`
+	TelegramPaymentChargeID *string    `json:"telegram_payment_charge_id,omitempty" db:"telegram_payment_charge_id"`
+	SubscriptionID          *int       `json:"subscription_id,omitempty" db:"subscription_id"`
+	CreatedAt               time.Time  `json:"created_at" db:"created_at"`
+	PaidAt                  *time.Time `json:"paid_at,omitempty" db:"paid_at"`
+}
+
+// Payment represents an order paid through a card payment gateway
+type Payment struct {
+	ID                int        `json:"id" db:"id"`
+	UserID            int        `json:"user_id" db:"user_id"`
+	PlanID            int        `json:"plan_id" db:"plan_id"`
+	Provider          string     `json:"provider" db:"provider"`
+	ProviderPaymentID *string    `json:"provider_payment_id,omitempty" db:"provider_payment_id"`
+	Amount            float64    `json:"amount" db:"amount"`
+	Currency          string     `json:"currency" db:"currency"`
+	Status            string     `json:"status" db:"status"`
+	ConfirmationURL   *string    `json:"confirmation_url,omitempty" db:"confirmation_url"`
+	SubscriptionID    *int       `json:"subscription_id,omitempty" db:"subscription_id"`
+	TokensGranted     int        `json:"tokens_granted" db:"tokens_granted"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	PaidAt            *time.Time `json:"paid_at,omitempty" db:"paid_at"`
+	RefundedAt        *time.Time `json:"refunded_at,omitempty" db:"refunded_at"`
+	ScheduleDowngrade bool       `json:"schedule_downgrade" db:"schedule_downgrade"`
+}
+
+// PromoCode represents a marketing promo code
+type PromoCode struct {
+	ID              int        `json:"id" db:"id"`
+	Code            string     `json:"code" db:"code"`
+	Kind            string     `json:"kind" db:"kind"` // 'tokens', 'discount' or 'trial'
+	TokenAmount     int        `json:"token_amount" db:"token_amount"`
+	DiscountPercent int        `json:"discount_percent" db:"discount_percent"`
+	TrialDays       int        `json:"trial_days" db:"trial_days"`
+	PlanID          *int       `json:"plan_id,omitempty" db:"plan_id"`
+	MaxUses         *int       `json:"max_uses,omitempty" db:"max_uses"`
+	UsesCount       int        `json:"uses_count" db:"uses_count"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	IsActive        bool       `json:"is_active" db:"is_active"`
+	CreatedBy       *string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+}
+
+// PromoRedemption represents a single promo code redemption (audit record)
+type PromoRedemption struct {
+	ID             int       `json:"id" db:"id"`
+	PromoCodeID    int       `json:"promo_code_id" db:"promo_code_id"`
+	Code           string    `json:"code"`
+	UserID         int       `json:"user_id" db:"user_id"`
+	Status         string    `json:"status" db:"status"` // 'credited', 'pending', 'reserved', 'applied'
+	TokensGranted  int       `json:"tokens_granted" db:"tokens_granted"`
+	SubscriptionID *int      `json:"subscription_id,omitempty" db:"subscription_id"`
+	PaymentID      *int      `json:"payment_id,omitempty" db:"payment_id"`
+	IPAddress      *string   `json:"ip_address,omitempty" db:"ip_address"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// UserTrial tracks the one-time trial granted at signup
+type UserTrial struct {
+	UserID         int        `json:"user_id" db:"user_id"`
+	SubscriptionID *int       `json:"subscription_id,omitempty" db:"subscription_id"`
+	PlanID         int        `json:"plan_id" db:"plan_id"`
+	TokenAmount    int        `json:"token_amount" db:"token_amount"`
+	Status         string     `json:"status" db:"status"` // 'active', 'converted' or 'upgraded'
+	GrantedAt      time.Time  `json:"granted_at" db:"granted_at"`
+	EndsAt         time.Time  `json:"ends_at" db:"ends_at"`
+	ConvertedAt    *time.Time `json:"converted_at,omitempty" db:"converted_at"`
+}
+
+// ScheduledPlanChange represents a downgrade deferred to the end of the current period
+type ScheduledPlanChange struct {
+	ID                 int        `json:"id" db:"id"`
+	UserID             int        `json:"user_id" db:"user_id"`
+	FromSubscriptionID *int       `json:"from_subscription_id,omitempty" db:"from_subscription_id"`
+	ToPlanID           int        `json:"to_plan_id" db:"to_plan_id"`
+	ToPlanName         string     `json:"to_plan_name" db:"to_plan_name"`
+	PaymentID          *int       `json:"payment_id,omitempty" db:"payment_id"`
+	Status             string     `json:"status" db:"status"` // 'pending', 'applied' or 'canceled'
+	EffectiveAt        *time.Time `json:"effective_at,omitempty" db:"effective_at"`
+	SubscriptionID     *int       `json:"subscription_id,omitempty" db:"subscription_id"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	AppliedAt          *time.Time `json:"applied_at,omitempty" db:"applied_at"`
+}
+
+// TokenTransfer represents tokens sent from one user to another
+type TokenTransfer struct {
+	ID               int       `json:"id" db:"id"`
+	SenderID         int       `json:"sender_id" db:"sender_id"`
+	RecipientID      int       `json:"recipient_id" db:"recipient_id"`
+	Amount           int       `json:"amount" db:"amount"`
+	Note             *string   `json:"note,omitempty" db:"note"`
+	Direction        string    `json:"direction,omitempty"` // 'in' or 'out' relative to the requesting user
+	CounterpartyName *string   `json:"counterparty_name,omitempty"`
+	SenderNewBalance *int      `json:"sender_new_balance,omitempty"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+// TokenLedgerEntry is a single balance change recorded for audit
+type TokenLedgerEntry struct {
+	ID           int64     `json:"id" db:"id"`
+	UserID       int       `json:"user_id" db:"user_id"`
+	Delta        int       `json:"delta" db:"delta"`
+	BalanceAfter int       `json:"balance_after" db:"balance_after"`
+	Reason       string    `json:"reason" db:"reason"`
+	ReferenceID  *int      `json:"reference_id,omitempty" db:"reference_id"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// TokenGrant tracks a credited batch of tokens that may expire
+type TokenGrant struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Source      string     `json:"source" db:"source"`
+	ReferenceID *int       `json:"reference_id,omitempty" db:"reference_id"`
+	Amount      int        `json:"amount" db:"amount"`
+	Remaining   int        `json:"remaining" db:"remaining"`
+	Status      string     `json:"status" db:"status"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty" db:"closed_at"`
+}
+
+// SpendingLimits are user-configured token spending caps
+type SpendingLimits struct {
+	DailyLimit    *int `json:"daily_limit"`
+	MonthlyLimit  *int `json:"monthly_limit"`
+	AlertsEnabled bool `json:"alerts_enabled"`
+	UsedToday     int  `json:"used_today"`
+	UsedThisMonth int  `json:"used_this_month"`
+}
+
+// Notification is an entry of the in-app notification feed
+type Notification struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	Kind      string     `json:"kind" db:"kind"`
+	Message   string     `json:"message" db:"message"`
+	ReadAt    *time.Time `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// MemoryFact is a long-term fact about the user injected into the system prompt
+type MemoryFact struct {
+	ID         int       `json:"id" db:"id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Fact       string    `json:"fact" db:"fact"`
+	Category   string    `json:"category" db:"category"`
+	Importance int       `json:"importance" db:"importance"`
+	Source     string    `json:"source" db:"source"` // extracted, user
+	SessionID  *int      `json:"session_id,omitempty" db:"session_id"`
+	Mentions   int       `json:"mentions" db:"mentions"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// DataExport is an asynchronous archive of all personal data of a user
+type DataExport struct {
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	Status        string     `json:"status" db:"status"` // pending, processing, ready, failed, expired
+	SizeBytes     *int64     `json:"size_bytes,omitempty" db:"size_bytes"`
+	Error         *string    `json:"error,omitempty" db:"error"`
+	DownloadToken *string    `json:"download_token,omitempty" db:"download_token"` // issued when ready, cleared on expiry
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// AccountDeletion is a request to erase the account after a cooling-off period
+type AccountDeletion struct {
+	ID           int        `json:"id" db:"id"`
+	UserID       int        `json:"user_id" db:"user_id"`
+	Status       string     `json:"status" db:"status"` // pending, canceled, completed
+	RequestedAt  time.Time  `json:"requested_at" db:"requested_at"`
+	ScheduledFor time.Time  `json:"scheduled_for" db:"scheduled_for"`
+	CanceledAt   *time.Time `json:"canceled_at,omitempty" db:"canceled_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// Organization is a group of users sharing the owner's token pool
+type Organization struct {
+	ID         int       `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	OwnerID    int       `json:"owner_id" db:"owner_id"`
+	InviteCode string    `json:"invite_code,omitempty" db:"invite_code"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// OrganizationMember represents a user's membership in an organization
+type OrganizationMember struct {
+	UserID        int       `json:"user_id" db:"user_id"`
+	Username      *string   `json:"username,omitempty" db:"username"`
+	FirstName     string    `json:"first_name" db:"first_name"`
+	Role          string    `json:"role" db:"role"` // 'owner' or 'member'
+	MonthlyCap    *int      `json:"monthly_cap,omitempty" db:"monthly_cap"`
+	UsedThisMonth int       `json:"used_this_month"`
+	JoinedAt      time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// Referral represents a referrer/referee link created from a start parameter
+type Referral struct {
+	ID             int        `json:"id" db:"id"`
+	ReferrerID     int        `json:"referrer_id" db:"referrer_id"`
+	RefereeID      int        `json:"referee_id" db:"referee_id"`
+	StartParam     *string    `json:"start_param,omitempty" db:"start_param"`
+	Status         string     `json:"status" db:"status"` // 'pending', 'rewarded' or 'rejected'
+	RejectReason   *string    `json:"reject_reason,omitempty" db:"reject_reason"`
+	RewardTrigger  *string    `json:"reward_trigger,omitempty" db:"reward_trigger"`
+	ReferrerReward int        `json:"referrer_reward" db:"referrer_reward"`
+	RefereeReward  int        `json:"referee_reward" db:"referee_reward"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	RewardedAt     *time.Time `json:"rewarded_at,omitempty" db:"rewarded_at"`
 }
 
 // API Request/Response structures
@@ -122,6 +492,7 @@ type CreateUserRequest struct {
 	FirstName    string  `json:"first_name" binding:"required"`
 	LastName     *string `json:"last_name"`
 	LanguageCode *string `json:"language_code"`
+	StartParam   *string `json:"start_param"`
 }
 
 type UpdateUserModelRequest struct {
@@ -130,10 +501,11 @@ type UpdateUserModelRequest struct {
 }
 
 type TokenUsageRequest struct {
-	UserID    int                    `json:"user_id" binding:"required"`
-	SessionID string                 `json:"session_id"`
-	Usage     OpenAITokenUsage       `json:"usage" binding:"required"`
-	CheckOnly bool                   `json:"check_only"`
+	UserID    int              `json:"user_id" binding:"required"`
+	SessionID string           `json:"session_id"`
+	Model     *string          `json:"model"` // defaults to the user's selected model
+	Usage     OpenAITokenUsage `json:"usage" binding:"required"`
+	CheckOnly bool             `json:"check_only"`
 }
 
 type OpenAITokenUsage struct {
@@ -168,15 +540,152 @@ type CreatePromptRequest struct {
 	VoiceGender *string `json:"voice_gender"`
 }
 
+// UpdatePromptRequest edits a prompt; omitted fields keep their values
+type UpdatePromptRequest struct {
+	UserID      int     `json:"user_id" binding:"required"`
+	PromptID    int     `json:"prompt_id" binding:"required"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Content     *string `json:"content"`
+	Category    *string `json:"category"`
+	VoiceGender *string `json:"voice_gender"`
+	ChangeNote  *string `json:"change_note"`
+}
+
+// PreviewPromptRequest renders session instructions for a user. Content previews a draft
+// template, PromptID another prompt; without both the selected prompt is used.
+type PreviewPromptRequest struct {
+	UserID      int     `json:"user_id" binding:"required"`
+	PromptID    *int    `json:"prompt_id"`
+	Content     *string `json:"content"`
+	VoiceGender *string `json:"voice_gender"`
+	Voice       *string `json:"voice"` // defaults to the user's selected voice
+}
+
+// PromptPreview is what a realtime session would receive as instructions
+type PromptPreview struct {
+	Voice         string         `json:"voice"`
+	Instructions  string         `json:"instructions"`
+	ContextReport *ContextReport `json:"context_report"`
+}
+
+type RollbackPromptRequest struct {
+	PromptID   int     `json:"prompt_id" binding:"required"`
+	Version    int     `json:"version" binding:"required"`
+	Author     string  `json:"author"` // defaults to admin
+	ChangeNote *string `json:"change_note"`
+}
+
 type CreateSubscriptionRequest struct {
-	UserID    int     `json:"user_id" binding:"required"`
-	PlanID    int     `json:"plan_id" binding:"required"`
-	PaymentID *string `json:"payment_id"`
+	UserID            int     `json:"user_id" binding:"required"`
+	PlanID            int     `json:"plan_id" binding:"required"`
+	PaymentID         *string `json:"payment_id"`
+	ScheduleDowngrade bool    `json:"schedule_downgrade"`
+}
+
+type PlanChangePreviewRequest struct {
+	UserID            int  `json:"user_id" binding:"required"`
+	PlanID            int  `json:"plan_id" binding:"required"`
+	ScheduleDowngrade bool `json:"schedule_downgrade"`
+}
+
+type CreateStarsInvoiceRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+	PlanID int `json:"plan_id" binding:"required"`
+}
+
+type CreateCheckoutRequest struct {
+	UserID            int    `json:"user_id" binding:"required"`
+	PlanID            int    `json:"plan_id" binding:"required"`
+	Provider          string `json:"provider"`
+	ReturnURL         string `json:"return_url"`
+	ScheduleDowngrade bool   `json:"schedule_downgrade"`
+}
+
+type CreatePromoCodeRequest struct {
+	Code            string     `json:"code" binding:"required"`
+	Kind            string     `json:"kind" binding:"required"`
+	TokenAmount     int        `json:"token_amount"`
+	DiscountPercent int        `json:"discount_percent"`
+	TrialDays       int        `json:"trial_days"`
+	PlanID          *int       `json:"plan_id"`
+	MaxUses         *int       `json:"max_uses"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	CreatedBy       *string    `json:"created_by"`
+}
+
+type RedeemPromoRequest struct {
+	UserID int    `json:"user_id" binding:"required"`
+	Code   string `json:"code" binding:"required"`
+}
+
+type TransferTokensRequest struct {
+	UserID         int     `json:"user_id" binding:"required"`
+	Recipient      string  `json:"recipient" binding:"required"` // Telegram username or referral code
+	Amount         int     `json:"amount" binding:"required"`
+	Note           *string `json:"note"`
+	IdempotencyKey *string `json:"idempotency_key"`
+}
+
+type UpdateSpendingLimitsRequest struct {
+	UserID        int   `json:"user_id" binding:"required"`
+	DailyLimit    *int  `json:"daily_limit"`   // null - no limit
+	MonthlyLimit  *int  `json:"monthly_limit"` // null - no limit
+	AlertsEnabled *bool `json:"alerts_enabled"`
+}
+
+type MarkNotificationsReadRequest struct {
+	UserID int   `json:"user_id" binding:"required"`
+	IDs    []int `json:"ids"` // empty - mark all as read
+}
+
+type CreateMemoryFactRequest struct {
+	UserID   int    `json:"user_id" binding:"required"`
+	Fact     string `json:"fact" binding:"required"`
+	Category string `json:"category"` // default: other
+}
+
+type UpdateMemoryFactRequest struct {
+	UserID   int     `json:"user_id" binding:"required"`
+	FactID   int     `json:"fact_id" binding:"required"`
+	Fact     *string `json:"fact"`
+	Category *string `json:"category"`
+}
+
+type PrivacyRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
+type UpdateRetentionPolicyRequest struct {
+	Table         string `json:"table" binding:"required"`
+	Tier          string `json:"tier" binding:"required"`
+	RetentionDays *int   `json:"retention_days"` // null - keep forever
+}
+
+type RotateDataKeysRequest struct {
+	UserID *int `json:"user_id"` // null - all users
+}
+
+type CreateOrganizationRequest struct {
+	UserID int    `json:"user_id" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+}
+
+type JoinOrganizationRequest struct {
+	UserID     int    `json:"user_id" binding:"required"`
+	InviteCode string `json:"invite_code" binding:"required"`
+}
+
+type UpdateMemberCapRequest struct {
+	UserID     int  `json:"user_id" binding:"required"`
+	MemberID   int  `json:"member_id" binding:"required"`
+	MonthlyCap *int `json:"monthly_cap"`
 }
 
 type AddTokensRequest struct {
-	UserID      int `json:"user_id" binding:"required"`
-	TokensToAdd int `json:"tokens_to_add" binding:"required"`
+	UserID        int  `json:"user_id" binding:"required"`
+	TokensToAdd   int  `json:"tokens_to_add" binding:"required"`
+	ExpiresInDays *int `json:"expires_in_days"` // tokens expire after this many days
 }
 
 type CreatePlanRequest struct {
@@ -187,6 +696,7 @@ type CreatePlanRequest struct {
 	TokenAmount int      `json:"token_amount" binding:"required"`
 	Features    []string `json:"features"`
 	IsActive    bool     `json:"is_active"`
+	PriceStars  *int     `json:"price_stars"`
 }
 
 type UpdatePlanRequest struct {
@@ -198,11 +708,13 @@ type UpdatePlanRequest struct {
 	TokenAmount int      `json:"token_amount" binding:"required"`
 	Features    []string `json:"features"`
 	IsActive    bool     `json:"is_active"`
+	PriceStars  *int     `json:"price_stars"`
 }
 
 type SelectPromptRequest struct {
-	UserID   int `json:"user_id" binding:"required"`
-	PromptID int `json:"prompt_id" binding:"required"`
+	UserID   int  `json:"user_id" binding:"required"`
+	PromptID int  `json:"prompt_id" binding:"required"`
+	Version  *int `json:"version"` // pin a version, null - always use the latest
 }
 
 type SelectVoiceRequest struct {
@@ -227,6 +739,12 @@ type CreateVoiceSessionRequest struct {
 	LastConversationTopic *string  `json:"last_conversation_topic"`
 }
 
+// EndVoiceSessionRequest closes a voice session so that it gets summarized
+type EndVoiceSessionRequest struct {
+	UserID    int `json:"user_id" binding:"required"`
+	SessionID int `json:"session_id" binding:"required"`
+}
+
 // API Response structures
 
 type APIResponse struct {
@@ -237,13 +755,22 @@ type APIResponse struct {
 }
 
 type UserResponse struct {
-	User                  *User   `json:"user"`
-	HasActiveSubscription bool    `json:"has_active_subscription"`
-	CurrentPlanName       *string `json:"current_plan_name"`
+	User                  *User      `json:"user"`
+	HasActiveSubscription bool       `json:"has_active_subscription"`
+	CurrentPlanName       *string    `json:"current_plan_name"`
+	Trial                 *UserTrial `json:"trial,omitempty"`
 }
 
 type TokenBalanceResponse struct {
-	TokenBalance int `json:"token_balance"`
+	TokenBalance int               `json:"token_balance"`
+	Expirations  []TokenExpiration `json:"expirations,omitempty"`
+}
+
+// TokenExpiration is an amount of tokens that will expire at the given time
+type TokenExpiration struct {
+	Amount    int       `json:"amount"`
+	Source    string    `json:"source"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type TokenUsageResponse struct {
@@ -271,9 +798,243 @@ type PromptsResponse struct {
 	BasePrompts   []VoicePrompt  `json:"basePrompts"`
 	UserPrompts   []VoicePrompt  `json:"userPrompts"`
 	SelectedPromptID *int        `json:"selectedPromptId"`
+	SelectedPromptVersion *int   `json:"selectedPromptVersion"` // pinned version, null - latest
 	PromptLimits  PromptLimits   `json:"promptLimits"`
 }
 
+// UsageTotals sums token usage split by direction and modality
+type UsageTotals struct {
+	Requests          int `json:"requests"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	TotalTokens       int `json:"total_tokens"`
+	InputTextTokens   int `json:"input_text_tokens"`
+	InputAudioTokens  int `json:"input_audio_tokens"`
+	InputImageTokens  int `json:"input_image_tokens"`
+	CachedTokens      int `json:"cached_tokens"`
+	OutputTextTokens  int `json:"output_text_tokens"`
+	OutputAudioTokens int `json:"output_audio_tokens"`
+}
+
+// UsageBucket is token usage within one hour, day or week
+type UsageBucket struct {
+	BucketStart time.Time `json:"bucket_start"`
+	UsageTotals
+}
+
+// ModelUsage is token usage of a single model
+type ModelUsage struct {
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// SessionUsage is token usage of a single session
+type SessionUsage struct {
+	SessionID   string    `json:"session_id"`
+	FirstUsedAt time.Time `json:"first_used_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	UsageTotals
+}
+
+// UsageAnalyticsResponse is returned by GET /api/tokens/usage
+type UsageAnalyticsResponse struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Bucket    string         `json:"bucket"`
+	Totals    UsageTotals    `json:"totals"`
+	Buckets   []UsageBucket  `json:"buckets"`
+	ByModel   []ModelUsage   `json:"by_model"`
+	BySession []SessionUsage `json:"by_session"`
+}
+
+// ConversationSession summarizes the messages of one voice session
+type ConversationSession struct {
+	SessionID             int        `json:"session_id"`
+	CreatedAt             time.Time  `json:"created_at"`
+	ContextSummary        *string    `json:"context_summary,omitempty"`
+	LastConversationTopic *string    `json:"last_conversation_topic,omitempty"`
+	MessageCount          int        `json:"message_count"`
+	UserMessages          int        `json:"user_messages"`
+	AssistantMessages     int        `json:"assistant_messages"`
+	AudioDurationSeconds  int        `json:"audio_duration_seconds"`
+	FirstMessageAt        *time.Time `json:"first_message_at,omitempty"`
+	LastMessageAt         *time.Time `json:"last_message_at,omitempty"`
+}
+
+// ConversationSessionsPage is a page of conversation sessions
+type ConversationSessionsPage struct {
+	Sessions   []ConversationSession `json:"sessions"`
+	NextCursor *string               `json:"next_cursor,omitempty"`
+}
+
+// ConversationMessagesPage is a page of conversation messages
+type ConversationMessagesPage struct {
+	Messages   []ConversationMessage `json:"messages"`
+	NextCursor *string               `json:"next_cursor,omitempty"`
+}
+
+// ConversationSearchResult is a message matching a full-text search query
+type ConversationSearchResult struct {
+	MessageID   int       `json:"message_id"`
+	SessionID   *int      `json:"session_id,omitempty"`
+	MessageType string    `json:"message_type"`
+	Snippet     string    `json:"snippet"` // HTML-escaped text with <mark> highlights
+	Rank        float32   `json:"rank"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RetentionPolicy is how long rows of a table are kept for users of a plan tier
+type RetentionPolicy struct {
+	Table         string    `json:"table" db:"table_name"`
+	Tier          string    `json:"tier" db:"tier"`                     // free, paid
+	RetentionDays *int      `json:"retention_days" db:"retention_days"` // nil - keep forever
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RetentionPreviewEntry is a dry-run result for one retention policy
+type RetentionPreviewEntry struct {
+	Table         string     `json:"table"`
+	Tier          string     `json:"tier"`
+	RetentionDays int        `json:"retention_days"`
+	Cutoff        time.Time  `json:"cutoff"`
+	Rows          int64      `json:"rows"`
+	OldestAt      *time.Time `json:"oldest_at,omitempty"`
+}
+
+// RetentionPurgeStats aggregates rows purged by one retention policy
+type RetentionPurgeStats struct {
+	Table       string     `json:"table"`
+	Tier        string     `json:"tier"`
+	Runs        int        `json:"runs"`
+	Failures    int        `json:"failures"`
+	RowsDeleted int64      `json:"rows_deleted"`
+	DurationMs  int64      `json:"duration_ms"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastError   *string    `json:"last_error,omitempty"`
+}
+
+// EncryptionStatus shows progress of content encryption and key rotation
+type EncryptionStatus struct {
+	Enabled          bool                    `json:"enabled"`
+	PrimaryKeyID     string                  `json:"primary_key_id,omitempty"`
+	DataKeys         int                     `json:"data_keys"`
+	RetiredDataKeys  int                     `json:"retired_data_keys"`
+	KeysToRewrap     int                     `json:"keys_to_rewrap"`     // wrapped by a non-primary master key
+	KeysRewrapFailed int                     `json:"keys_rewrap_failed"` // could not be unwrapped, skipped until the primary key changes
+	Tables           []EncryptionTableStatus `json:"tables"`
+}
+
+// EncryptionTableStatus counts rows of a table by encryption state
+type EncryptionTableStatus struct {
+	Table           string `json:"table"`
+	EncryptedRows   int64  `json:"encrypted_rows"`
+	PlaintextRows   int64  `json:"plaintext_rows"`
+	PendingRotation int64  `json:"pending_rotation"` // encrypted with a retired data key
+	FailedRows      int64  `json:"failed_rows"`      // could not be decrypted, skipped by re-encryption
+}
+
+// ContextReport describes which sections made it into the session instructions
+type ContextReport struct {
+	Budget   int                    `json:"budget"`
+	Used     int                    `json:"used"`
+	Sections []ContextSectionReport `json:"sections"`
+}
+
+// ContextSectionReport is the outcome of fitting one section into the token budget
+type ContextSectionReport struct {
+	Name           string `json:"name"`
+	Priority       int    `json:"priority"`
+	Tokens         int    `json:"tokens"`
+	OriginalTokens int    `json:"original_tokens"`
+	Items          int    `json:"items"`
+	TotalItems     int    `json:"total_items"`
+	Truncated      bool   `json:"truncated"`
+}
+
+// PlanChangePreview describes what switching to another plan would do
+type PlanChangePreview struct {
+	ChangeType      string     `json:"change_type"` // 'new', 'renewal', 'upgrade' or 'downgrade'
+	ProrationMode   string     `json:"proration_mode"`
+	CurrentPlanID   *int       `json:"current_plan_id,omitempty"`
+	CurrentPlanName *string    `json:"current_plan_name,omitempty"`
+	NewPlanID       int        `json:"new_plan_id"`
+	NewPlanName     string     `json:"new_plan_name"`
+	PlanPrice       float64    `json:"plan_price"`
+	CreditAmount    float64    `json:"credit_amount"`
+	AmountDue       float64    `json:"amount_due"`
+	Currency        string     `json:"currency"`
+	UnusedTokens    int        `json:"unused_tokens"`
+	CarriedTokens   int        `json:"carried_tokens"`
+	NewTokenBalance int        `json:"new_token_balance"`
+	Scheduled       bool       `json:"scheduled"`
+	EffectiveAt     *time.Time `json:"effective_at,omitempty"`
+}
+
+// PlanChangeResult is returned after a plan change is applied or scheduled
+type PlanChangeResult struct {
+	ChangeType        string     `json:"change_type"`
+	SubscriptionID    *int       `json:"subscription_id,omitempty"`
+	NewTokenBalance   int        `json:"new_token_balance"`
+	CarriedTokens     int        `json:"carried_tokens"`
+	Scheduled         bool       `json:"scheduled"`
+	ScheduledChangeID *int       `json:"scheduled_change_id,omitempty"`
+	EffectiveAt       *time.Time `json:"effective_at,omitempty"`
+	CanceledPaymentID *int       `json:"-"` // paid scheduled change replaced by this one, to be refunded
+}
+
+type OrganizationResponse struct {
+	Organization *Organization        `json:"organization"`
+	Role         string               `json:"role"`
+	PoolBalance  int                  `json:"pool_balance"`
+	Members      []OrganizationMember `json:"members"`
+}
+
+type MemberUsage struct {
+	UserID     int     `json:"user_id"`
+	Username   *string `json:"username,omitempty"`
+	FirstName  string  `json:"first_name"`
+	Role       string  `json:"role"`
+	TokensUsed int     `json:"tokens_used"`
+	Requests   int     `json:"requests"`
+	MonthlyCap *int    `json:"monthly_cap,omitempty"`
+}
+
+type OrganizationUsageResponse struct {
+	OrganizationID int           `json:"organization_id"`
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	TotalTokens    int           `json:"total_tokens"`
+	Members        []MemberUsage `json:"members"`
+}
+
+type ReferralInfoResponse struct {
+	ReferralCode  string `json:"referral_code"`
+	StartParam    string `json:"start_param"`
+	TotalReferred int    `json:"total_referred"`
+	PendingCount  int    `json:"pending_count"`
+	RewardedCount int    `json:"rewarded_count"`
+	TokensEarned  int    `json:"tokens_earned"`
+	ReferrerBonus int    `json:"referrer_bonus"`
+	RefereeBonus  int    `json:"referee_bonus"`
+}
+
+type ReferralStatsResponse struct {
+	TotalReferrals    int                  `json:"total_referrals"`
+	PendingReferrals  int                  `json:"pending_referrals"`
+	RewardedReferrals int                  `json:"rewarded_referrals"`
+	RejectedReferrals int                  `json:"rejected_referrals"`
+	TokensAwarded     int                  `json:"tokens_awarded"`
+	RejectReasons     map[string]int       `json:"reject_reasons"`
+	TopReferrers      []ReferrerStatsEntry `json:"top_referrers"`
+}
+
+type ReferrerStatsEntry struct {
+	UserID        int     `json:"user_id"`
+	Username      *string `json:"username,omitempty"`
+	TotalReferred int     `json:"total_referred"`
+	RewardedCount int     `json:"rewarded_count"`
+}
+
 type PlanLevel struct {
 	PlanName  string `json:"plan_name"`
 	PlanLevel int    `json:"plan_level"`
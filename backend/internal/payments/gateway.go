@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+)
+
+// Статусы заказа в таблице payments
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusFailed   = "failed"
+	StatusRefunded = "refunded"
+)
+
+// Типы событий webhook, общие для всех провайдеров
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentCanceled  = "payment.canceled"
+	EventRefundSucceeded  = "refund.succeeded"
+)
+
+// ErrInvalidSignature возвращается, если подпись webhook не прошла проверку
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Gateway - адаптер платежного провайдера
+type Gateway interface {
+	// Name возвращает идентификатор провайдера (используется в URL webhook)
+	Name() string
+
+	// CreateCheckout создает платеж и возвращает ссылку на оплату
+	CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error)
+
+	// ParseWebhook проверяет подпись и разбирает уведомление провайдера
+	ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error)
+
+	// Refund возвращает платеж полностью
+	Refund(ctx context.Context, req *RefundRequest) (*Refund, error)
+}
+
+type CheckoutRequest struct {
+	OrderID        int
+	Amount         float64
+	Currency       string
+	Description    string
+	ReturnURL      string
+	IdempotencyKey string
+}
+
+type Checkout struct {
+	ProviderPaymentID string
+	ConfirmationURL   string
+	Status            string
+}
+
+type RefundRequest struct {
+	ProviderPaymentID string
+	Amount            float64
+	Currency          string
+	Reason            string
+	IdempotencyKey    string
+}
+
+type Refund struct {
+	ProviderRefundID string
+	// Succeeded = true, если провайдер провел возврат синхронно
+	Succeeded bool
+}
+
+type WebhookEvent struct {
+	Event             string
+	ProviderPaymentID string
+	Amount            float64
+	Currency          string
+}
+
+// MinorUnits переводит сумму в копейки (центы). Суммы заказа и уведомления
+// сравниваются в целых единицах: NUMERIC(12,2) из базы и разобранная строка
+// провайдера могут различаться в последнем бите float64.
+func MinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,74 @@
+package payments_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"voice-ai-backend/internal/payments"
+	"voice-ai-backend/internal/payments/paymentstest"
+)
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   int64
+	}{
+		{0, 0},
+		{199, 19900},
+		{199.99, 19999},
+		{1990.1, 199010},
+		{0.1 + 0.2, 30},
+	}
+
+	for _, tt := range tests {
+		if got := payments.MinorUnits(tt.amount); got != tt.want {
+			t.Errorf("MinorUnits(%v) = %d, want %d", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestParseWebhookSignature(t *testing.T) {
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	gateway := fake.Gateway()
+
+	body, signature := fake.Notification(payments.EventPaymentSucceeded, "fake-payment-1")
+	tampered := append([]byte(nil), body...)
+	tampered[len(tampered)-2] = ' '
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantErr   bool
+	}{
+		{"valid", body, signature, false},
+		{"missing signature", body, "", true},
+		{"not hex", body, "not-a-signature", true},
+		{"other secret", body, payments.Sign("other-secret", body), true},
+		{"tampered body", tampered, signature, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.signature != "" {
+				header.Set(payments.SignatureHeader, tt.signature)
+			}
+
+			event, err := gateway.ParseWebhook(header, tt.body)
+			if tt.wantErr {
+				if !errors.Is(err, payments.ErrInvalidSignature) {
+					t.Fatalf("ParseWebhook() error = %v, want ErrInvalidSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWebhook() error = %v", err)
+			}
+			if event.Event != payments.EventPaymentSucceeded || event.ProviderPaymentID != "fake-payment-1" {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package paymentstest предоставляет локальный фейк платежного API в стиле
+// YooKassa и генерацию подписанных webhook-уведомлений для тестов.
+package paymentstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"voice-ai-backend/internal/payments"
+)
+
+// Payment - платеж, созданный через фейковый API
+type Payment struct {
+	ID             string
+	Amount         string
+	Currency       string
+	Status         string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+// Server - фейковый платежный API поверх httptest.Server
+type Server struct {
+	*httptest.Server
+	ShopID        string
+	SecretKey     string
+	WebhookSecret string
+
+	mu            sync.Mutex
+	payments      map[string]*Payment
+	byIdempotency map[string]string
+	refunds       []string
+	refundPending bool
+	nextID        int
+}
+
+func NewServer(shopID, secretKey, webhookSecret string) *Server {
+	s := &Server{
+		ShopID:        shopID,
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		payments:      make(map[string]*Payment),
+		byIdempotency: make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Gateway возвращает адаптер, настроенный на этот фейковый сервер
+func (s *Server) Gateway() *payments.YooKassaGateway {
+	return payments.NewYooKassaGateway(s.URL, s.ShopID, s.SecretKey, s.WebhookSecret)
+}
+
+// SetRefundPending заставляет возвраты проходить асинхронно (через webhook)
+func (s *Server) SetRefundPending(pending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refundPending = pending
+}
+
+// Payment возвращает платеж по id
+func (s *Server) Payment(id string) *Payment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.payments[id]
+}
+
+// Refunds возвращает id платежей, по которым запрошен возврат
+func (s *Server) Refunds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.refunds...)
+}
+
+// Notification формирует подписанное уведомление о событии по платежу.
+// Возвращает тело и значение заголовка payments.SignatureHeader.
+func (s *Server) Notification(event string, paymentID string) ([]byte, string) {
+	s.mu.Lock()
+	payment := s.payments[paymentID]
+	s.mu.Unlock()
+
+	object := map[string]interface{}{"id": paymentID}
+	if payment != nil {
+		object["amount"] = map[string]string{"value": payment.Amount, "currency": payment.Currency}
+		object["status"] = eventStatus(event)
+	}
+	if event == payments.EventRefundSucceeded {
+		object["id"] = "refund-" + paymentID
+		object["payment_id"] = paymentID
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":   "notification",
+		"event":  event,
+		"object": object,
+	})
+
+	return body, payments.Sign(s.WebhookSecret, body)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	shopID, secretKey, ok := r.BasicAuth()
+	if !ok || shopID != s.ShopID || secretKey != s.SecretKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"type": "error", "code": "invalid_credentials"})
+		return
+	}
+
+	idempotencyKey := r.Header.Get("Idempotence-Key")
+	if idempotencyKey == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+
+	var body struct {
+		Amount struct {
+			Value    string `json:"value"`
+			Currency string `json:"currency"`
+		} `json:"amount"`
+		PaymentID string            `json:"payment_id"`
+		Metadata  map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"type": "error", "code": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/payments":
+		id, seen := s.byIdempotency[idempotencyKey]
+		if !seen {
+			s.nextID++
+			id = fmt.Sprintf("fake-payment-%d", s.nextID)
+			s.payments[id] = &Payment{
+				ID:             id,
+				Amount:         body.Amount.Value,
+				Currency:       body.Amount.Currency,
+				Status:         "pending",
+				Metadata:       body.Metadata,
+				IdempotencyKey: idempotencyKey,
+			}
+			s.byIdempotency[idempotencyKey] = id
+		}
+		payment := s.payments[id]
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     payment.ID,
+			"status": payment.Status,
+			"amount": map[string]string{"value": payment.Amount, "currency": payment.Currency},
+			"confirmation": map[string]string{
+				"type":             "redirect",
+				"confirmation_url": s.URL + "/checkout/" + payment.ID,
+			},
+		})
+
+	case "/refunds":
+		payment, found := s.payments[body.PaymentID]
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]string{"type": "error", "code": "not_found"})
+			return
+		}
+		s.refunds = append(s.refunds, payment.ID)
+		status := "succeeded"
+		if s.refundPending {
+			status = "pending"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":         "refund-" + payment.ID,
+			"payment_id": payment.ID,
+			"status":     status,
+			"amount":     map[string]string{"value": body.Amount.Value, "currency": body.Amount.Currency},
+		})
+
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"type": "error", "code": "not_found"})
+	}
+}
+
+func eventStatus(event string) string {
+	switch event {
+	case payments.EventPaymentSucceeded, payments.EventRefundSucceeded:
+		return "succeeded"
+	case payments.EventPaymentCanceled:
+		return "canceled"
+	}
+	return "pending"
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,210 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader - заголовок с HMAC-SHA256 подписью тела webhook
+const SignatureHeader = "X-Webhook-Signature"
+
+// YooKassaGateway - адаптер для HTTP API в стиле YooKassa
+type YooKassaGateway struct {
+	baseURL       string
+	shopID        string
+	secretKey     string
+	webhookSecret string
+	httpClient    *http.Client
+}
+
+type yooAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type yooPayment struct {
+	ID           string    `json:"id"`
+	Status       string    `json:"status"`
+	Amount       yooAmount `json:"amount"`
+	Confirmation *struct {
+		Type            string `json:"type"`
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation,omitempty"`
+	PaymentID string `json:"payment_id,omitempty"`
+}
+
+type yooNotification struct {
+	Type   string     `json:"type"`
+	Event  string     `json:"event"`
+	Object yooPayment `json:"object"`
+}
+
+func NewYooKassaGateway(baseURL, shopID, secretKey, webhookSecret string) *YooKassaGateway {
+	return &YooKassaGateway{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		shopID:        shopID,
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		httpClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+func (g *YooKassaGateway) Name() string {
+	return "yookassa"
+}
+
+// CreateCheckout создает платеж с подтверждением через redirect
+func (g *YooKassaGateway) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	body := map[string]interface{}{
+		"amount":  yooAmount{Value: formatAmount(req.Amount), Currency: req.Currency},
+		"capture": true,
+		"confirmation": map[string]string{
+			"type":       "redirect",
+			"return_url": req.ReturnURL,
+		},
+		"description": req.Description,
+		"metadata": map[string]string{
+			"order_id": strconv.Itoa(req.OrderID),
+		},
+	}
+
+	var payment yooPayment
+	if err := g.post(ctx, "/payments", req.IdempotencyKey, body, &payment); err != nil {
+		return nil, err
+	}
+
+	checkout := &Checkout{
+		ProviderPaymentID: payment.ID,
+		Status:            payment.Status,
+	}
+	if payment.Confirmation != nil {
+		checkout.ConfirmationURL = payment.Confirmation.ConfirmationURL
+	}
+
+	return checkout, nil
+}
+
+// Refund создает возврат по платежу
+func (g *YooKassaGateway) Refund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	body := map[string]interface{}{
+		"payment_id":  req.ProviderPaymentID,
+		"amount":      yooAmount{Value: formatAmount(req.Amount), Currency: req.Currency},
+		"description": req.Reason,
+	}
+
+	var refund yooPayment
+	if err := g.post(ctx, "/refunds", req.IdempotencyKey, body, &refund); err != nil {
+		return nil, err
+	}
+
+	return &Refund{
+		ProviderRefundID: refund.ID,
+		Succeeded:        refund.Status == "succeeded",
+	}, nil
+}
+
+// ParseWebhook проверяет HMAC-подпись и переводит уведомление в общий формат
+func (g *YooKassaGateway) ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+	if g.webhookSecret == "" || !VerifySignature(g.webhookSecret, body, header.Get(SignatureHeader)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var notification yooNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("failed to decode notification: %w", err)
+	}
+
+	event := &WebhookEvent{
+		Event:             notification.Event,
+		ProviderPaymentID: notification.Object.ID,
+		Currency:          notification.Object.Amount.Currency,
+	}
+
+	// В уведомлении о возврате id - это id возврата, платеж указан в payment_id
+	if notification.Event == EventRefundSucceeded {
+		event.ProviderPaymentID = notification.Object.PaymentID
+	}
+
+	if notification.Object.Amount.Value != "" {
+		amount, err := strconv.ParseFloat(notification.Object.Amount.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount in notification: %w", err)
+		}
+		event.Amount = amount
+	}
+
+	return event, nil
+}
+
+func (g *YooKassaGateway) post(ctx context.Context, path string, idempotencyKey string, body interface{}, result interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+path, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.SetBasicAuth(g.shopID, g.secretKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotence-Key", idempotencyKey)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gateway returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// Sign вычисляет подпись тела webhook (hex HMAC-SHA256)
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature сравнивает подпись за постоянное время
+func VerifySignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(Sign(secret, body))
+	if err != nil {
+		return false
+	}
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, actual)
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,250 @@
+// Package prompttmpl - шаблоны промптов. Поддерживаются только подстановка известных
+// переменных {{first_name}} и условные блоки {{#if female}}...{{else}}...{{/if}}:
+// шаблон не может вызывать функции или обращаться к чему-то кроме перечисленных переменных.
+package prompttmpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Variables - переменные, доступные в шаблоне, с описанием
+var Variables = map[string]string{
+	"first_name": "имя пользователя",
+	"language":   "язык пользователя",
+	"local_time": "текущие дата и время",
+	"plan_name":  "название тарифа",
+	"memory":     "что ассистент знает о пользователе",
+	"voice":      "выбранный голос",
+	"female":     "голос женский (для {{#if}})",
+	"male":       "голос мужской (для {{#if}})",
+}
+
+// Values - значения переменных. Условие {{#if name}} истинно, если значение не пустое.
+type Values map[string]string
+
+type nodeKind int
+
+const (
+	nodeText nodeKind = iota
+	nodeVar
+	nodeIf
+)
+
+type node struct {
+	kind nodeKind
+	text string // текст или имя переменной
+	then []node
+	els  []node
+}
+
+// Template - разобранный шаблон
+type Template struct {
+	nodes []node
+	uses  map[string]bool
+}
+
+// Parse разбирает и проверяет шаблон: неизвестные переменные, незакрытые теги
+// и несбалансированные блоки - ошибка с номером строки
+func Parse(text string) (*Template, error) {
+	p := &parser{text: text, uses: map[string]bool{}}
+	nodes, end, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if end != "" {
+		return nil, p.errorf("unexpected {{%s}}", end)
+	}
+	return &Template{nodes: nodes, uses: p.uses}, nil
+}
+
+// MustParse - Parse для шаблонов в коде; ошибка разбора - паника
+func MustParse(text string) *Template {
+	t, err := Parse(text)
+	if err != nil {
+		panic("prompttmpl: " + err.Error())
+	}
+	return t
+}
+
+// Validate проверяет шаблон без рендеринга
+func Validate(text string) error {
+	_, err := Parse(text)
+	return err
+}
+
+// Uses сообщает, обращается ли шаблон к переменной
+func (t *Template) Uses(name string) bool {
+	return t.uses[name]
+}
+
+// Render подставляет значения; отсутствующие переменные считаются пустыми
+func (t *Template) Render(values Values) string {
+	var b strings.Builder
+	render(&b, t.nodes, values)
+	return strings.TrimSpace(b.String())
+}
+
+func render(b *strings.Builder, nodes []node, values Values) {
+	for _, n := range nodes {
+		switch n.kind {
+		case nodeText:
+			b.WriteString(n.text)
+		case nodeVar:
+			b.WriteString(values[n.text])
+		case nodeIf:
+			if strings.TrimSpace(values[n.text]) != "" {
+				render(b, n.then, values)
+			} else {
+				render(b, n.els, values)
+			}
+		}
+	}
+}
+
+type parser struct {
+	text string
+	pos  int
+	uses map[string]bool
+	// endTag - начало последнего {{else}} или {{/if}}, для номера строки в ошибке
+	endTag int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.text[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// parse читает узлы до конца текста или до {{else}} / {{/if}}; возвращает, чем закончился блок
+func (p *parser) parse(depth int) ([]node, string, error) {
+	var nodes []node
+	for p.pos < len(p.text) {
+		open := strings.Index(p.text[p.pos:], "{{")
+		if open < 0 {
+			nodes = append(nodes, node{kind: nodeText, text: p.text[p.pos:]})
+			p.pos = len(p.text)
+			break
+		}
+		if open > 0 {
+			nodes = append(nodes, node{kind: nodeText, text: p.text[p.pos : p.pos+open]})
+			p.pos += open
+		}
+
+		tagStart := p.pos
+		closeAt := strings.Index(p.text[p.pos+2:], "}}")
+		if closeAt < 0 {
+			return nil, "", p.errorf("unclosed tag")
+		}
+		tag := strings.TrimSpace(p.text[p.pos+2 : p.pos+2+closeAt])
+		tagEnd := p.pos + 2 + closeAt + 2
+
+		switch {
+		case tag == "else" || tag == "/if":
+			if depth == 0 {
+				return nil, "", p.errorf("unexpected {{%s}}", tag)
+			}
+			p.endTag = tagStart
+			nodes = p.trimStandalone(nodes, tagStart, tagEnd)
+			return nodes, tag, nil
+
+		case tag == "#if" || strings.HasPrefix(tag, "#if "):
+			name := strings.TrimSpace(strings.TrimPrefix(tag, "#if"))
+			if err := p.checkName(name); err != nil {
+				return nil, "", err
+			}
+			nodes = p.trimStandalone(nodes, tagStart, tagEnd)
+
+			ifNode := node{kind: nodeIf, text: name}
+			then, end, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, "", err
+			}
+			ifNode.then = then
+			if end == "else" {
+				els, end2, err := p.parse(depth + 1)
+				if err != nil {
+					return nil, "", err
+				}
+				if end2 == "else" {
+					p.pos = p.endTag
+					return nil, "", p.errorf("unexpected {{else}}")
+				}
+				if end2 != "/if" {
+					p.pos = tagStart
+					return nil, "", p.errorf("unclosed {{#if %s}}", name)
+				}
+				ifNode.els = els
+			} else if end != "/if" {
+				p.pos = tagStart
+				return nil, "", p.errorf("unclosed {{#if %s}}", name)
+			}
+			nodes = append(nodes, ifNode)
+
+		default:
+			if err := p.checkName(tag); err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, node{kind: nodeVar, text: tag})
+			p.pos = tagEnd
+		}
+	}
+
+	if depth > 0 {
+		return nil, "", nil
+	}
+	return nodes, "", nil
+}
+
+func (p *parser) checkName(name string) error {
+	if name == "" {
+		return p.errorf("empty tag")
+	}
+	if _, ok := Variables[name]; !ok {
+		return p.errorf("unknown variable %q", name)
+	}
+	p.uses[name] = true
+	return nil
+}
+
+// trimStandalone убирает строку целиком, если тег блока стоит на ней один, чтобы
+// условные блоки не оставляли пустых строк. Сдвигает позицию за тег.
+func (p *parser) trimStandalone(nodes []node, tagStart int, tagEnd int) []node {
+	lineStart := strings.LastIndexByte(p.text[:tagStart], '\n') + 1
+	if strings.TrimLeft(p.text[lineStart:tagStart], " \t") != "" {
+		p.pos = tagEnd
+		return nodes
+	}
+	rest := strings.TrimLeft(p.text[tagEnd:], " \t")
+	var after int
+	switch {
+	case strings.HasPrefix(rest, "\r\n"):
+		after = len(p.text) - len(rest) + 2
+	case strings.HasPrefix(rest, "\n"):
+		after = len(p.text) - len(rest) + 1
+	case rest == "":
+		after = len(p.text)
+	default:
+		p.pos = tagEnd
+		return nodes
+	}
+
+	// Отступ перед тегом - хвост последнего текстового узла
+	if indent := tagStart - lineStart; indent > 0 && len(nodes) > 0 && nodes[len(nodes)-1].kind == nodeText &&
+		len(nodes[len(nodes)-1].text) >= indent {
+		last := &nodes[len(nodes)-1]
+		last.text = last.text[:len(last.text)-indent]
+	}
+	p.pos = after
+	return nodes
+}
+
+// Names возвращает имена переменных по алфавиту
+func Names() []string {
+	names := make([]string, 0, len(Variables))
+	for name := range Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,146 @@
+package prompttmpl
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	female := Values{"first_name": "Анна", "female": "1", "memory": "любит кофе"}
+	male := Values{"first_name": "Иван", "male": "1"}
+
+	tests := []struct {
+		name   string
+		text   string
+		values Values
+		want   string
+	}{
+		{"plain text", "Ты дружелюбный ассистент.", nil, "Ты дружелюбный ассистент."},
+		{"variable", "Привет, {{first_name}}!", female, "Привет, Анна!"},
+		{"spaces inside tag", "Привет, {{ first_name }}!", female, "Привет, Анна!"},
+		{"missing value is empty", "Привет, {{first_name}}!", nil, "Привет, !"},
+		{"result is trimmed", "  {{first_name}}\n\n", female, "Анна"},
+		{"single braces kept", "{first_name} и }}", female, "{first_name} и }}"},
+
+		{"if true", "{{#if female}}она{{else}}он{{/if}}", female, "она"},
+		{"if false", "{{#if female}}она{{else}}он{{/if}}", male, "он"},
+		{"if blank value is false", "{{#if female}}она{{else}}он{{/if}}", Values{"female": "  "}, "он"},
+		{"if without else", "a {{#if female}}b{{/if}} c", male, "a  c"},
+		{"variable inside if", "{{#if memory}}Помни: {{memory}}.{{/if}}", female, "Помни: любит кофе."},
+		{
+			"nested if", "{{#if female}}{{#if memory}}ж+п{{else}}ж{{/if}}{{else}}{{#if male}}м{{/if}}{{/if}}",
+			Values{"female": "1"}, "ж",
+		},
+		{
+			"nested if outer false", "{{#if female}}{{#if memory}}ж+п{{else}}ж{{/if}}{{else}}{{#if male}}м{{/if}}{{/if}}",
+			male, "м",
+		},
+
+		// Теги блоков на отдельной строке не оставляют пустых строк
+		{"standalone then", "Начало\n{{#if female}}\nОна\n{{else}}\nОн\n{{/if}}\nКонец", female, "Начало\nОна\nКонец"},
+		{"standalone else", "Начало\n{{#if female}}\nОна\n{{else}}\nОн\n{{/if}}\nКонец", male, "Начало\nОн\nКонец"},
+		{"standalone indented", "Начало\n  {{#if female}}\n  Она\n  {{/if}}\nКонец", female, "Начало\n  Она\nКонец"},
+		{"standalone indented false", "Начало\n  {{#if female}}\n  Она\n  {{/if}}\nКонец", male, "Начало\nКонец"},
+		{"standalone trailing spaces", "Начало\n{{#if female}}  \nОна\n{{/if}}\t\nКонец", female, "Начало\nОна\nКонец"},
+		{"standalone crlf", "Начало\r\n{{#if female}}\r\nОна\r\n{{/if}}\r\nКонец", female, "Начало\r\nОна\r\nКонец"},
+		{"standalone at end of text", "Начало\n{{#if female}}\nОна\n{{/if}}", female, "Начало\nОна"},
+		{"not standalone after text", "Да {{#if female}}\nОна{{/if}}", female, "Да \nОна"},
+		{"not standalone before text", "Начало\n{{#if female}}Она\n{{/if}}", female, "Начало\nОна"},
+		{"variable line kept", "Начало\n{{first_name}}\nКонец", male, "Начало\nИван\nКонец"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			if got := tmpl.Render(tt.values); got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"unknown variable", "Привет, {{name}}!", `line 1: unknown variable "name"`},
+		{"unknown variable line", "Первая\nВторая\nТретья {{name}}", `line 3: unknown variable "name"`},
+		{"unknown if variable", "\n{{#if rich}}да{{/if}}", `line 2: unknown variable "rich"`},
+		{"function call", "{{printf \"%s\" first_name}}", `line 1: unknown variable "printf \"%s\" first_name"`},
+		{"empty tag", "a\n{{ }}", "line 2: empty tag"},
+		{"empty if", "{{#if}}a{{/if}}", "line 1: empty tag"},
+		{"unclosed tag", "a\nb {{first_name", "line 2: unclosed tag"},
+		{"unexpected close", "a\n{{/if}}", "line 2: unexpected {{/if}}"},
+		{"unexpected else", "a {{else}} b", "line 1: unexpected {{else}}"},
+		{"unclosed if", "a\n{{#if female}}\nона", "line 2: unclosed {{#if female}}"},
+		{"unclosed if after else", "{{#if female}}она{{else}}он", "line 1: unclosed {{#if female}}"},
+		{"unclosed nested if", "{{#if female}}\n{{#if memory}}\nп\n{{/if}}", "line 1: unclosed {{#if female}}"},
+		{"second else", "{{#if female}}\nа\n{{else}}\nб\n{{else}}\nв\n{{/if}}", "line 5: unexpected {{else}}"},
+		{"extra close", "{{#if female}}а{{/if}}\n{{/if}}", "line 2: unexpected {{/if}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("Parse(%q) error = %v, want %q", tt.text, err, tt.want)
+			}
+			if err := Validate(tt.text); err == nil || err.Error() != tt.want {
+				t.Fatalf("Validate(%q) error = %v, want %q", tt.text, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUses(t *testing.T) {
+	tmpl, err := Parse("{{first_name}} {{#if female}}{{memory}}{{else}}{{voice}}{{/if}}")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for name, want := range map[string]bool{
+		"first_name": true, "female": true, "memory": true, "voice": true,
+		"language": false, "male": false,
+	} {
+		if got := tmpl.Uses(name); got != want {
+			t.Errorf("Uses(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	if got := MustParse("Привет, {{first_name}}").Render(Values{"first_name": "Анна"}); got != "Привет, Анна" {
+		t.Fatalf("MustParse().Render() = %q", got)
+	}
+
+	defer func() {
+		r := recover()
+		msg, _ := r.(string)
+		if !strings.HasPrefix(msg, "prompttmpl: line 1: unknown variable") {
+			t.Fatalf("MustParse panic = %v, want parse error", r)
+		}
+	}()
+	MustParse("{{name}}")
+	t.Fatalf("MustParse with invalid template did not panic")
+}
+
+func TestNames(t *testing.T) {
+	names := Names()
+	if len(names) != len(Variables) || !sort.StringsAreSorted(names) {
+		t.Fatalf("Names() = %v, want all variables sorted", names)
+	}
+	for _, name := range names {
+		if _, ok := Variables[name]; !ok {
+			t.Fatalf("Names() returned unknown variable %q", name)
+		}
+	}
+	if !reflect.DeepEqual(names, Names()) {
+		t.Fatalf("Names() is not stable")
+	}
+}
@@ -0,0 +1,296 @@
+package redact
+
+import (
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind - тип персональных данных
+type Kind string
+
+const (
+	KindEmail Kind = "email"
+	KindPhone Kind = "phone"
+	KindCard  Kind = "card"
+	KindIBAN  Kind = "iban"
+)
+
+// Kinds - все типы в порядке приоритета: при пересечении побеждает более ранний
+var Kinds = []Kind{KindEmail, KindIBAN, KindCard, KindPhone}
+
+// Match - найденный фрагмент text[Start:End]
+type Match struct {
+	Kind  Kind
+	Start int
+	End   int
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[\p{L}\p{N}._%+\-]+@[\p{L}\p{N}\-]+(?:\.[\p{L}\p{N}\-]+)*\.\p{L}{2,}`)
+	// IBAN: код страны, контрольные цифры и до 30 букв и цифр, в том числе группами по 4
+	ibanPattern = regexp.MustCompile(`[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}`)
+	// Российский расчетный счет - 20 цифр, иногда с пробелами
+	accountPattern = regexp.MustCompile(`\d(?: ?\d){19}`)
+	cardPattern    = regexp.MustCompile(`\d(?:[ \-]?\d){12,18}`)
+	phonePattern   = regexp.MustCompile(`[+(]*\d[\d ().\-]{6,}\d`)
+)
+
+type detector struct {
+	kind    Kind
+	pattern *regexp.Regexp
+	valid   func(candidate string) bool
+}
+
+var detectors = []detector{
+	{KindEmail, emailPattern, nil},
+	{KindIBAN, ibanPattern, validIBAN},
+	{KindIBAN, accountPattern, validAccount},
+	{KindCard, cardPattern, validCard},
+	{KindPhone, phonePattern, validPhone},
+}
+
+// Detect находит персональные данные в тексте. Совпадения не пересекаются
+// и отсортированы по позиции.
+func Detect(text string) []Match {
+	var matches []Match
+	for _, d := range detectors {
+		for _, m := range d.find(text) {
+			if !overlaps(matches, m) {
+				matches = append(matches, m)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Start < matches[j].Start
+	})
+	return matches
+}
+
+func overlaps(matches []Match, m Match) bool {
+	for _, existing := range matches {
+		if m.Start < existing.End && existing.Start < m.End {
+			return true
+		}
+	}
+	return false
+}
+
+// find проверяет кандидатов, найденных регулярным выражением. Кандидат может захватить
+// соседние числа («позвоните 8 900 123-45-67 10 раз»), поэтому внутри него ищется самый левый
+// и самый длинный фрагмент, который проходит проверку и не примыкает к буквам или цифрам.
+func (d detector) find(text string) []Match {
+	var matches []Match
+	for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+		if d.valid == nil {
+			if isolated(text, loc[0], loc[1]) {
+				matches = append(matches, Match{Kind: d.kind, Start: loc[0], End: loc[1]})
+			}
+			continue
+		}
+
+		for start := loc[0]; start < loc[1]; {
+			end := d.longestValid(text, start, loc[1])
+			if end < 0 {
+				start++
+				continue
+			}
+			matches = append(matches, Match{Kind: d.kind, Start: start, End: end})
+			start = end
+		}
+	}
+	return matches
+}
+
+func (d detector) longestValid(text string, start int, limit int) int {
+	if !isBoundaryBefore(text, start) || !isCandidateStart(text[start]) {
+		return -1
+	}
+	for end := limit; end > start; end-- {
+		if !isAlnumByte(text[end-1]) || !isBoundaryAfter(text, end) {
+			continue
+		}
+		if d.valid(text[start:end]) {
+			return end
+		}
+	}
+	return -1
+}
+
+func isCandidateStart(b byte) bool {
+	return isAlnumByte(b) || b == '+' || b == '('
+}
+
+func isAlnumByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z'
+}
+
+func isolated(text string, start int, end int) bool {
+	return isBoundaryBefore(text, start) && isBoundaryAfter(text, end)
+}
+
+// isBoundaryBefore - перед позицией нет буквы или цифры (в том числе кириллической)
+func isBoundaryBefore(text string, pos int) bool {
+	if pos == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:pos])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func isBoundaryAfter(text string, pos int) bool {
+	if pos >= len(text) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(text[pos:])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// validCard - 13-19 цифр с верной контрольной суммой Луна, слитно или группами
+// как на карте: по 4 цифры (4111 1111 1111 1111) или 4-6-5 / 4-6-4 (Amex, Diners)
+func validCard(candidate string) bool {
+	digits := digitsOnly(candidate)
+	if len(digits) < 13 || len(digits) > 19 || !isCardGrouping(digitGroups(candidate)) {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func isCardGrouping(groups []int) bool {
+	if len(groups) == 1 {
+		return true
+	}
+	if len(groups) == 3 && groups[0] == 4 && groups[1] == 6 && (groups[2] == 5 || groups[2] == 4) {
+		return true
+	}
+	for i, g := range groups {
+		if g != 4 && !(i == len(groups)-1 && g < 4) {
+			return false
+		}
+	}
+	return true
+}
+
+// phoneGroupings - разбивки 10-значного номера на группы цифр: 495 123 4567, 900 123-45-67, 495 1234567.
+// Даты и прочие числа с разделителями (2024-05-15 10) под них не подходят.
+var phoneGroupings = []string{"3,3,4", "3,3,2,2", "3,7"}
+
+// validPhone принимает международный номер с «+» (10-15 цифр), российский номер из 11 цифр
+// на 7 или 8 (8 900 123-45-67, 89001234567) и номер из 10 цифр: (495) 123-45-67, 555-123-4567
+func validPhone(candidate string) bool {
+	if strings.Count(candidate, "(") != strings.Count(candidate, ")") {
+		return false
+	}
+	digits := digitsOnly(candidate)
+	if strings.HasPrefix(candidate, "+") {
+		return len(digits) >= 10 && len(digits) <= 15
+	}
+
+	groups := digitGroups(candidate)
+	switch len(digits) {
+	case 11:
+		if digits[0] != '7' && digits[0] != '8' {
+			return false
+		}
+		if len(groups) == 1 {
+			return true
+		}
+		return groups[0] == 1 && (len(groups) == 2 || isPhoneGrouping(groups[1:]))
+	case 10:
+		return isPhoneGrouping(groups)
+	}
+	return false
+}
+
+func isPhoneGrouping(groups []int) bool {
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = strconv.Itoa(g)
+	}
+	key := strings.Join(parts, ",")
+	for _, grouping := range phoneGroupings {
+		if key == grouping {
+			return true
+		}
+	}
+	return false
+}
+
+// digitGroups возвращает длины групп цифр, разделенных пробелами, скобками, точками или дефисами
+func digitGroups(s string) []int {
+	var groups []int
+	run := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			run++
+			continue
+		}
+		if run > 0 {
+			groups = append(groups, run)
+			run = 0
+		}
+	}
+	if run > 0 {
+		groups = append(groups, run)
+	}
+	return groups
+}
+
+// validIBAN - длина 15-34 и остаток 1 по модулю 97 (ISO 13616)
+func validIBAN(candidate string) bool {
+	iban := strings.ReplaceAll(candidate, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	var numeric strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			numeric.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validAccount - ровно 20 цифр (номер счета в российских реквизитах)
+func validAccount(candidate string) bool {
+	return len(digitsOnly(candidate)) == 20
+}
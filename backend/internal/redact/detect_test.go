@@ -0,0 +1,70 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+)
+
+type found struct {
+	kind Kind
+	text string
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []found
+	}{
+		// Телефоны
+		{"ru phone with plus", "Мой номер +7 900 123-45-67, звоните", []found{{KindPhone, "+7 900 123-45-67"}}},
+		{"ru phone with 8 and brackets", "звоните 8 (495) 123-45-67 вечером", []found{{KindPhone, "8 (495) 123-45-67"}}},
+		{"ru phone solid", "телефон 89001234567", []found{{KindPhone, "89001234567"}}},
+		{"ru phone followed by number", "позвоните 8 900 123-45-67 10 раз", []found{{KindPhone, "8 900 123-45-67"}}},
+		{"en phone with brackets", "call me at (555) 123-4567 tomorrow", []found{{KindPhone, "(555) 123-4567"}}},
+		{"en international phone", "my number is +1 415 555 2671", []found{{KindPhone, "+1 415 555 2671"}}},
+
+		// Карты
+		{"card in groups", "карта 4111 1111 1111 1111", []found{{KindCard, "4111 1111 1111 1111"}}},
+		{"card solid", "card 5500000000000004 expires", []found{{KindCard, "5500000000000004"}}},
+		{"amex grouping", "amex 3782 822463 10005", []found{{KindCard, "3782 822463 10005"}}},
+
+		// IBAN и счета
+		{"iban in groups", "IBAN: DE89 3704 0044 0532 0130 00.", []found{{KindIBAN, "DE89 3704 0044 0532 0130 00"}}},
+		{"iban solid", "pay to GB82WEST12345698765432 please", []found{{KindIBAN, "GB82WEST12345698765432"}}},
+		{"ru account", "р/с 40817810099910004312 в Сбербанке", []found{{KindIBAN, "40817810099910004312"}}},
+
+		// Email
+		{"email", "пишите на ivan.petrov@mail.ru", []found{{KindEmail, "ivan.petrov@mail.ru"}}},
+		{"email with plus", "Анна: anna+test@example.com.", []found{{KindEmail, "anna+test@example.com"}}},
+
+		{
+			"several kinds in order",
+			"почта a@b.io, тел. +7 900 123-45-67, карта 4111111111111111",
+			[]found{{KindEmail, "a@b.io"}, {KindPhone, "+7 900 123-45-67"}, {KindCard, "4111111111111111"}},
+		},
+
+		// Не персональные данные
+		{"iso date and time", "встреча 2024-05-15 10:30", nil},
+		{"dotted date", "родился 15.05.1990 в Москве", nil},
+		{"order number", "Заказ 1234567890 отправлен", nil},
+		{"inn of company", "ИНН 7707083893", nil},
+		{"inn of person", "ИНН 500100732259", nil},
+		{"luhn invalid card", "карта 4111 1111 1111 1112", nil},
+		{"iban bad checksum", "IBAN DE88 3704 0044 0532 0130 00", nil},
+		{"digits inside word", "артикул AB12345678901CD", nil},
+		{"short number", "мне 25 лет, живу в доме 12", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []found
+			for _, m := range Detect(tt.text) {
+				got = append(got, found{m.Kind, tt.text[m.Start:m.End]})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Detect(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
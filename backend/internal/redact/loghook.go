@@ -0,0 +1,32 @@
+package redact
+
+import log "github.com/sirupsen/logrus"
+
+// LogHook маскирует персональные данные в сообщениях и строковых полях logrus
+// до того, как запись попадет в вывод
+type LogHook struct {
+	redactor *Redactor
+}
+
+func NewLogHook(redactor *Redactor) *LogHook {
+	return &LogHook{
+		redactor: redactor,
+	}
+}
+
+func (h *LogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *LogHook) Fire(entry *log.Entry) error {
+	entry.Message = h.redactor.Mask(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = h.redactor.Mask(v)
+		case error:
+			entry.Data[key] = h.redactor.Mask(v.Error())
+		}
+	}
+	return nil
+}
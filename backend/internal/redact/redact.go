@@ -0,0 +1,213 @@
+// Package redact находит персональные данные (email, телефоны, номера карт, IBAN и счета)
+// в тексте и маскирует или шифрует их перед сохранением и записью в лог.
+package redact
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Action - что делать с найденным фрагментом
+type Action string
+
+const (
+	// ActionOff оставляет фрагмент как есть
+	ActionOff Action = "off"
+	// ActionMask заменяет фрагмент меткой типа: [EMAIL]
+	ActionMask Action = "mask"
+	// ActionEncrypt заменяет фрагмент шифротекстом AES-GCM: [EMAIL:enc:...]
+	ActionEncrypt Action = "encrypt"
+)
+
+// Policy задает действие для каждого типа данных; отсутствующий тип не обрабатывается
+type Policy map[Kind]Action
+
+// DefaultPolicy маскирует все типы
+func DefaultPolicy() Policy {
+	policy := Policy{}
+	for _, kind := range Kinds {
+		policy[kind] = ActionMask
+	}
+	return policy
+}
+
+// ParsePolicy разбирает политику вида "email=mask,phone=encrypt,card=mask".
+// Пустая строка - политика по умолчанию, "off" - не обрабатывать ничего.
+// Типы, не указанные в строке, маскируются.
+func ParsePolicy(spec string) (Policy, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "off" {
+		return Policy{}, nil
+	}
+
+	policy := DefaultPolicy()
+	if spec == "" {
+		return policy, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		kind, action, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid redaction rule %q", part)
+		}
+		k := Kind(strings.TrimSpace(kind))
+		if _, known := policy[k]; !known {
+			return nil, fmt.Errorf("unknown redaction kind %q", kind)
+		}
+		a := Action(strings.TrimSpace(action))
+		switch a {
+		case ActionOff, ActionMask, ActionEncrypt:
+		default:
+			return nil, fmt.Errorf("unknown redaction action %q", action)
+		}
+		policy[k] = a
+	}
+
+	return policy, nil
+}
+
+// Redactor применяет политику к тексту. Безопасен для конкурентного использования.
+type Redactor struct {
+	policy Policy
+	aead   cipher.AEAD
+}
+
+// New создает Redactor. key (16, 24 или 32 байта) нужен, только если политика
+// что-то шифрует.
+func New(policy Policy, key []byte) (*Redactor, error) {
+	r := &Redactor{policy: policy}
+
+	needsKey := false
+	for _, action := range policy {
+		if action == ActionEncrypt {
+			needsKey = true
+		}
+	}
+	if !needsKey {
+		return r, nil
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("encryption key is required for encrypt redaction")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	r.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init AES-GCM: %w", err)
+	}
+	return r, nil
+}
+
+// Default используется сервисами и логгером; main заменяет его Redactor'ом из конфигурации
+var Default, _ = New(DefaultPolicy(), nil)
+
+// String применяет политику к тексту перед сохранением
+func (r *Redactor) String(text string) string {
+	return r.apply(text, false)
+}
+
+// Mask применяет политику, но вместо шифрования маскирует: шифротекст в логах бесполезен
+func (r *Redactor) Mask(text string) string {
+	return r.apply(text, true)
+}
+
+// Value рекурсивно применяет политику к строкам в JSON-подобном значении (metadata и т.п.)
+func (r *Redactor) Value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.String(v)
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[key] = r.Value(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = r.Value(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// Map - Value для map[string]interface{}; nil остается nil
+func (r *Redactor) Map(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return r.Value(m).(map[string]interface{})
+}
+
+func (r *Redactor) apply(text string, maskOnly bool) string {
+	if len(r.policy) == 0 || text == "" {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range Detect(text) {
+		action := r.policy[m.Kind]
+		if action == "" || action == ActionOff {
+			continue
+		}
+
+		b.WriteString(text[last:m.Start])
+		if action == ActionEncrypt && !maskOnly {
+			b.WriteString(r.encrypt(m.Kind, text[m.Start:m.End]))
+		} else {
+			b.WriteString(placeholder(m.Kind))
+		}
+		last = m.End
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func placeholder(kind Kind) string {
+	return "[" + strings.ToUpper(string(kind)) + "]"
+}
+
+func (r *Redactor) encrypt(kind Kind, value string) string {
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return placeholder(kind)
+	}
+	sealed := r.aead.Seal(nonce, nonce, []byte(value), []byte(kind))
+	return "[" + strings.ToUpper(string(kind)) + ":enc:" + base64.RawURLEncoding.EncodeToString(sealed) + "]"
+}
+
+var encryptedPattern = regexp.MustCompile(`\[([A-Z]+):enc:([A-Za-z0-9_\-]+)\]`)
+
+// Reveal расшифровывает фрагменты [KIND:enc:...] в тексте. Фрагменты, которые
+// не удалось расшифровать, остаются как есть.
+func (r *Redactor) Reveal(text string) string {
+	if r.aead == nil || !strings.Contains(text, ":enc:") {
+		return text
+	}
+	return encryptedPattern.ReplaceAllStringFunc(text, func(token string) string {
+		parts := encryptedPattern.FindStringSubmatch(token)
+		sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || len(sealed) < r.aead.NonceSize() {
+			return token
+		}
+		nonce, ciphertext := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+		plain, err := r.aead.Open(nil, nonce, ciphertext, []byte(strings.ToLower(parts[1])))
+		if err != nil {
+			return token
+		}
+		return string(plain)
+	})
+}
@@ -0,0 +1,157 @@
+package redact
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Policy
+		wantErr bool
+	}{
+		{"", DefaultPolicy(), false},
+		{"off", Policy{}, false},
+		{"phone=encrypt, card=off", Policy{KindEmail: ActionMask, KindIBAN: ActionMask, KindCard: ActionOff, KindPhone: ActionEncrypt}, false},
+		{"phone", nil, true},
+		{"passport=mask", nil, true},
+		{"phone=hide", nil, true},
+	}
+
+	for _, tt := range tests {
+		policy, err := ParsePolicy(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePolicy(%q) = %v, want error", tt.spec, policy)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(policy, tt.want) {
+			t.Errorf("ParsePolicy(%q) = %v, %v, want %v", tt.spec, policy, err, tt.want)
+		}
+	}
+}
+
+func TestNewRequiresKeyForEncrypt(t *testing.T) {
+	if _, err := New(Policy{KindPhone: ActionEncrypt}, nil); err == nil {
+		t.Fatalf("New without key for encrypt policy succeeded")
+	}
+	if _, err := New(Policy{KindPhone: ActionEncrypt}, []byte("short")); err == nil {
+		t.Fatalf("New with invalid key succeeded")
+	}
+	if _, err := New(DefaultPolicy(), nil); err != nil {
+		t.Fatalf("New for mask policy: %v", err)
+	}
+}
+
+func TestRedactorPolicies(t *testing.T) {
+	const text = "Пишите на ivan@mail.ru или звоните +7 900 123-45-67"
+
+	tests := []struct {
+		name   string
+		policy Policy
+		// String - что сохраняется, Mask - что пишется в лог
+		wantString string
+		wantMask   string
+	}{
+		{
+			name:       "off",
+			policy:     Policy{},
+			wantString: text,
+			wantMask:   text,
+		},
+		{
+			name:       "mask",
+			policy:     DefaultPolicy(),
+			wantString: "Пишите на [EMAIL] или звоните [PHONE]",
+			wantMask:   "Пишите на [EMAIL] или звоните [PHONE]",
+		},
+		{
+			name:       "mask one kind",
+			policy:     Policy{KindPhone: ActionMask, KindEmail: ActionOff},
+			wantString: "Пишите на ivan@mail.ru или звоните [PHONE]",
+			wantMask:   "Пишите на ivan@mail.ru или звоните [PHONE]",
+		},
+		{
+			name:       "encrypt",
+			policy:     Policy{KindEmail: ActionMask, KindPhone: ActionEncrypt},
+			wantString: "Пишите на [EMAIL] или звоните [PHONE:enc:",
+			wantMask:   "Пишите на [EMAIL] или звоните [PHONE]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.policy, testKey)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			stored := r.String(text)
+			if strings.HasSuffix(tt.wantString, ":enc:") {
+				if !strings.HasPrefix(stored, tt.wantString) || strings.Contains(stored, "123-45-67") {
+					t.Fatalf("String() = %q, want encrypted phone after %q", stored, tt.wantString)
+				}
+			} else if stored != tt.wantString {
+				t.Fatalf("String() = %q, want %q", stored, tt.wantString)
+			}
+			if got := r.Mask(text); got != tt.wantMask {
+				t.Fatalf("Mask() = %q, want %q", got, tt.wantMask)
+			}
+
+			// Reveal возвращает зашифрованные фрагменты, маскированные остаются метками
+			wantRevealed := tt.wantString
+			if strings.HasSuffix(tt.wantString, ":enc:") {
+				wantRevealed = "Пишите на [EMAIL] или звоните +7 900 123-45-67"
+			}
+			if got := r.Reveal(stored); got != wantRevealed {
+				t.Fatalf("Reveal() = %q, want %q", got, wantRevealed)
+			}
+
+			value := map[string]interface{}{
+				"text":   text,
+				"nested": map[string]interface{}{"items": []interface{}{text, 42}},
+				"count":  3,
+			}
+			redacted := r.Map(value)
+			items := redacted["nested"].(map[string]interface{})["items"].([]interface{})
+			for _, got := range []interface{}{redacted["text"], items[0]} {
+				if r.Reveal(got.(string)) != wantRevealed {
+					t.Fatalf("Map() string = %q, want redacted like String()", got)
+				}
+			}
+			if items[1] != 42 || redacted["count"] != 3 {
+				t.Fatalf("Map() changed non-string values: %v", redacted)
+			}
+			if value["text"] != text {
+				t.Fatalf("Map() modified its input")
+			}
+			if r.Map(nil) != nil {
+				t.Fatalf("Map(nil) != nil")
+			}
+			if got := r.Value(text).(string); r.Reveal(got) != wantRevealed {
+				t.Fatalf("Value(string) = %q, want redacted like String()", got)
+			}
+		})
+	}
+}
+
+func TestRevealWithOtherKey(t *testing.T) {
+	r, err := New(Policy{KindPhone: ActionEncrypt}, testKey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	other, err := New(Policy{KindPhone: ActionEncrypt}, []byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	stored := r.String("звоните +7 900 123-45-67")
+	if got := other.Reveal(stored); got != stored {
+		t.Fatalf("Reveal with other key = %q, want token left as is", got)
+	}
+}
@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/5 * * * *", false},
+		{"0 3 * * 1-5", false},
+		{"0,15,30,45 8-18/2 1 1-12 *", false},
+		{"5/20 * * * *", false},
+		{"0 0 * * 7", false},
+		{"  @daily  ", false},
+		{"@hourly", false},
+
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * 32 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"10-5 * * * *", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"a * * * *", true},
+		{"1-x * * * *", true},
+		{"1,,2 * * * *", true},
+		{"@fortnightly", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSchedule(%q) = %v, want error", tt.expr, s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tt.expr, err)
+			}
+			if s.String() != tt.expr {
+				t.Fatalf("String() = %q, want %q", s.String(), tt.expr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database: %v", err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	ny := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute is strictly after", "* * * * *", utc(2024, 5, 15, 10, 30), utc(2024, 5, 15, 10, 31)},
+		{"seconds are truncated", "* * * * *", utc(2024, 5, 15, 10, 30).Add(45 * time.Second), utc(2024, 5, 15, 10, 31)},
+		{"step", "*/15 * * * *", utc(2024, 5, 15, 10, 31), utc(2024, 5, 15, 10, 45)},
+		{"step from value", "5/20 * * * *", utc(2024, 5, 15, 10, 26), utc(2024, 5, 15, 10, 45)},
+		{"next hour", "*/15 * * * *", utc(2024, 5, 15, 10, 45), utc(2024, 5, 15, 11, 0)},
+		{"next day", "30 3 * * *", utc(2024, 5, 15, 3, 30), utc(2024, 5, 16, 3, 30)},
+		{"month boundary", "0 0 * * *", utc(2024, 1, 31, 12, 0), utc(2024, 2, 1, 0, 0)},
+		{"leap day", "0 12 29 2 *", utc(2023, 3, 1, 0, 0), utc(2024, 2, 29, 12, 0)},
+		{"day 31 skips short months", "0 0 31 * *", utc(2024, 4, 1, 0, 0), utc(2024, 5, 31, 0, 0)},
+		{"year boundary", "0 0 1 1 *", utc(2024, 12, 31, 23, 59), utc(2025, 1, 1, 0, 0)},
+		{"yearly alias", "@yearly", utc(2024, 6, 1, 0, 0), utc(2025, 1, 1, 0, 0)},
+		{"weekdays", "0 9 * * 1-5", utc(2024, 5, 17, 9, 0), utc(2024, 5, 20, 9, 0)},
+		{"sunday as 7", "0 0 * * 7", utc(2024, 5, 15, 0, 0), utc(2024, 5, 19, 0, 0)},
+		{"day of month or day of week", "0 0 20 * 1", utc(2024, 5, 14, 0, 0), utc(2024, 5, 20, 0, 0)},
+		{"day of month or day of week, weekday first", "0 0 25 * 5", utc(2024, 5, 14, 0, 0), utc(2024, 5, 17, 0, 0)},
+		{"restricted day of month with any weekday", "0 0 15 * *", utc(2024, 5, 16, 0, 0), utc(2024, 6, 15, 0, 0)},
+		{"never", "0 0 30 2 *", utc(2024, 1, 1, 0, 0), time.Time{}},
+
+		// Перевод часов вперед: 2024-03-10 02:00 EST сразу становится 03:00 EDT
+		{"dst forward skips missing time", "30 2 * * *", ny(2024, 3, 10, 0, 0), ny(2024, 3, 11, 2, 30)},
+		{"dst forward hourly", "0 * * * *", ny(2024, 3, 10, 1, 0), ny(2024, 3, 10, 3, 0)},
+		{"dst forward keeps local time", "0 12 * * *", ny(2024, 3, 9, 12, 0), ny(2024, 3, 10, 12, 0)},
+		// Перевод часов назад: 2024-11-03 01:00-01:59 повторяется
+		{"dst backward fires once", "30 1 * * *", ny(2024, 11, 3, 1, 30), ny(2024, 11, 4, 1, 30)},
+		{"dst backward daily", "0 12 * * *", ny(2024, 11, 2, 12, 0), ny(2024, 11, 3, 12, 0)},
+		{"dst backward hourly", "0 * * * *", ny(2024, 11, 3, 1, 0), ny(2024, 11, 3, 2, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tt.expr, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Fatalf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/llm"
+	"voice-ai-backend/internal/services"
+	"voice-ai-backend/internal/telegram"
+)
+
+// RegisterDefaultJobs регистрирует штатные задачи обслуживания
+func RegisterDefaultJobs(s *Scheduler) error {
+	planService := services.NewPlanService()
+	retentionService := services.NewRetentionService()
+	tokenService := services.NewTokenService()
+	conversationService := services.NewConversationService()
+	bot := telegram.NewClient(config.AppConfig.TelegramBotToken, config.AppConfig.TelegramAPIURL)
+	completer := llm.NewOpenAIClient(config.AppConfig.OpenAIAPIURL, config.AppConfig.OpenAIAPIKey)
+	summaryService := services.NewSummaryService(completer)
+	memoryService := services.NewMemoryService(services.NewLLMFactExtractor(completer))
+	privacyService := services.NewPrivacyService(bot)
+	encryptionService := services.NewEncryptionService()
+	notificationService := services.NewNotificationService(bot)
+
+	jobs := []struct {
+		name    string
+		spec    string
+		timeout time.Duration
+		run     JobFunc
+	}{
+		{
+			name:    "expire_subscriptions",
+			spec:    "*/5 * * * *",
+			timeout: 2 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := planService.ExpireSubscriptions(ctx)
+				return err
+			},
+		},
+		{
+			name:    "apply_scheduled_plan_changes",
+			spec:    "*/5 * * * *",
+			timeout: 2 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := planService.ApplyScheduledPlanChanges(ctx)
+				return err
+			},
+		},
+		{
+			name:    "convert_expired_trials",
+			spec:    "*/10 * * * *",
+			timeout: 2 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := planService.ConvertExpiredTrials(ctx)
+				return err
+			},
+		},
+		{
+			name:    "expire_token_grants",
+			spec:    "*/15 * * * *",
+			timeout: 5 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := tokenService.ExpireTokenGrants(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name:    "index_conversation_messages",
+			spec:    "*/10 * * * *",
+			timeout: 5 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := conversationService.IndexMessages(ctx, 5000)
+				return err
+			},
+		},
+		{
+			name:    "summarize_voice_sessions",
+			spec:    "*/2 * * * *",
+			timeout: 5 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := summaryService.SummarizeEndedSessions(ctx, 20)
+				return err
+			},
+		},
+		{
+			name:    "extract_memory_facts",
+			spec:    "*/5 * * * *",
+			timeout: 5 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := memoryService.ExtractPendingSessions(ctx, 20)
+				return err
+			},
+		},
+		{
+			name:    "build_data_exports",
+			spec:    "* * * * *",
+			timeout: 10 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := privacyService.BuildPendingExports(ctx, 5)
+				return err
+			},
+		},
+		{
+			name:    "purge_data_exports",
+			spec:    "0 * * * *",
+			timeout: 5 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := privacyService.PurgeExpiredExports(ctx)
+				return err
+			},
+		},
+		{
+			name:    "process_account_deletions",
+			spec:    "15 * * * *",
+			timeout: 10 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := privacyService.ProcessDueDeletions(ctx, 50)
+				return err
+			},
+		},
+		{
+			name:    "apply_retention_policies",
+			spec:    "30 3 * * *",
+			timeout: 30 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := retentionService.ApplyPolicies(ctx, 5000)
+				return err
+			},
+		},
+		{
+			name:    "reencrypt_content",
+			spec:    "*/5 * * * *",
+			timeout: 10 * time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := encryptionService.ReencryptContent(ctx, 2000)
+				return err
+			},
+		},
+		{
+			name:    "deliver_notifications",
+			spec:    "* * * * *",
+			timeout: time.Minute,
+			run: func(ctx context.Context) error {
+				_, err := notificationService.DeliverNotifications(ctx, 100)
+				return err
+			},
+		},
+	}
+
+	for _, job := range jobs {
+		if err := s.Register(job.name, job.spec, job.timeout, job.run); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+	"voice-ai-backend/internal/database"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JobFunc выполняет одну итерацию фоновой задачи
+type JobFunc func(ctx context.Context) error
+
+// Job описывает зарегистрированную фоновую задачу
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Timeout  time.Duration
+	Run      JobFunc
+}
+
+// Scheduler запускает задачи по cron-расписанию. Перед каждым запуском
+// берется advisory lock в PostgreSQL, поэтому при нескольких репликах
+// задачу выполняет только одна из них.
+type Scheduler struct {
+	instanceID string
+	jobs       []*Job
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+const defaultJobTimeout = 10 * time.Minute
+
+func New() *Scheduler {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &Scheduler{
+		instanceID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// Register добавляет задачу с cron-расписанием
+func (s *Scheduler) Register(name string, spec string, timeout time.Duration, run JobFunc) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("failed to register job %s: %w", name, err)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultJobTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return fmt.Errorf("failed to register job %s: scheduler already started", name)
+	}
+
+	for _, job := range s.jobs {
+		if job.Name == name {
+			return fmt.Errorf("failed to register job %s: duplicate job name", name)
+		}
+	}
+
+	s.jobs = append(s.jobs, &Job{
+		Name:     name,
+		Schedule: schedule,
+		Timeout:  timeout,
+		Run:      run,
+	})
+
+	return nil
+}
+
+// Start запускает цикл планирования для всех зарегистрированных задач
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.started = true
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+
+	log.Infof("⏰ Scheduler started with %d jobs (instance: %s)", len(s.jobs), s.instanceID)
+}
+
+// Stop останавливает планирование и ждет завершения выполняющихся задач
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.started = false
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("✅ Scheduler stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduler did not stop in time: %w", ctx.Err())
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	defer s.wg.Done()
+
+	for {
+		next := job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Warnf("Job %s has no upcoming runs for schedule %q", job.Name, job.Schedule)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.runOnce(ctx, job)
+		}
+	}
+}
+
+// runOnce выполняет задачу, если удалось захватить advisory lock
+func (s *Scheduler) runOnce(ctx context.Context, job *Job) {
+	conn, err := database.Database.Pool.Acquire(ctx)
+	if err != nil {
+		log.Errorf("Job %s: failed to acquire connection: %v", job.Name, err)
+		return
+	}
+	defer conn.Release()
+
+	lockKey := advisoryLockKey(job.Name)
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+		log.Errorf("Job %s: failed to acquire advisory lock: %v", job.Name, err)
+		return
+	}
+	if !locked {
+		log.Debugf("Job %s is running on another instance, skipping", job.Name)
+		return
+	}
+	defer func() {
+		// Разблокируем даже при отмененном контексте, иначе lock останется на соединении в пуле
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.Exec(unlockCtx, `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			log.Errorf("Job %s: failed to release advisory lock: %v", job.Name, err)
+		}
+	}()
+
+	var runID int
+	err = conn.QueryRow(ctx, `
+		INSERT INTO scheduler_job_runs (job_name, instance_id, status, started_at)
+		VALUES ($1, $2, 'running', CURRENT_TIMESTAMP)
+		RETURNING id
+	`, job.Name, s.instanceID).Scan(&runID)
+	if err != nil {
+		log.Errorf("Job %s: failed to record run: %v", job.Name, err)
+		return
+	}
+
+	startTime := time.Now()
+	runErr := s.execute(ctx, job)
+
+	status := "success"
+	var errText *string
+	if runErr != nil {
+		status = "failed"
+		msg := runErr.Error()
+		errText = &msg
+		log.Errorf("❌ Job %s failed after %s: %v", job.Name, time.Since(startTime), runErr)
+	} else {
+		log.Infof("✅ Job %s finished in %s", job.Name, time.Since(startTime))
+	}
+
+	finishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = conn.Exec(finishCtx, `
+		UPDATE scheduler_job_runs
+		SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, status, errText, runID)
+	if err != nil {
+		log.Errorf("Job %s: failed to update run %d: %v", job.Name, runID, err)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, job *Job) (err error) {
+	jobCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return job.Run(jobCtx)
+}
+
+func advisoryLockKey(jobName string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("scheduler:" + jobName))
+	return int64(h.Sum64())
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/redact"
 )
 
 type ActivityService struct{}
@@ -18,7 +19,7 @@ func NewActivityService() *ActivityService {
 func (s *ActivityService) LogActivity(ctx context.Context, req *models.LogActivityRequest) (int, error) {
 	conn := database.Database.Pool
 
-	metadataJSON, err := json.Marshal(req.Metadata)
+	metadataJSON, err := json.Marshal(redact.Default.Map(req.Metadata))
 	if err != nil {
 		metadataJSON = []byte("{}")
 	}
@@ -21,7 +21,7 @@ func (s *AdminService) GetAllPlansForAdmin(ctx context.Context) ([]models.Subscr
 	conn := database.Database.Pool
 
 	rows, err := conn.Query(ctx, `
-		SELECT id, name, description, price, currency, token_amount, features, is_active, created_at
+		SELECT id, name, description, price, currency, token_amount, features, is_active, price_stars, created_at
 		FROM subscription_plans
 		ORDER BY id ASC
 	`)
@@ -35,7 +35,7 @@ func (s *AdminService) GetAllPlansForAdmin(ctx context.Context) ([]models.Subscr
 		var plan models.SubscriptionPlan
 		err := rows.Scan(
 			&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-			&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.CreatedAt,
+			&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.PriceStars, &plan.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan plan: %w", err)
@@ -52,12 +52,12 @@ func (s *AdminService) CreatePlan(ctx context.Context, req *models.CreatePlanReq
 
 	var plan models.SubscriptionPlan
 	err := conn.QueryRow(ctx, `
-		INSERT INTO subscription_plans (name, description, price, currency, token_amount, features, is_active, created_at)
+		INSERT INTO subscription_plans (name, description, price, currency, token_amount, features, is_active, price_stars, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
-		RETURNING id, name, description, price, currency, token_amount, features, is_active, created_at
-	`, req.Name, req.Description, req.Price, req.Currency, req.TokenAmount, req.Features, req.IsActive).Scan(
+		RETURNING id, name, description, price, currency, token_amount, features, is_active, price_stars, created_at
+	`, req.Name, req.Description, req.Price, req.Currency, req.TokenAmount, req.Features, req.IsActive, req.PriceStars).Scan(
 		&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-		&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.CreatedAt,
+		&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.PriceStars, &plan.CreatedAt,
 	)
 
 	if err != nil {
@@ -77,12 +77,12 @@ func (s *AdminService) UpdatePlan(ctx context.Context, req *models.UpdatePlanReq
 	err := conn.QueryRow(ctx, `
 		UPDATE subscription_plans
 		SET name = $1, description = $2, price = $3, currency = $4,
-		    token_amount = $5, features = $6, is_active = $7
-		WHERE id = $8
-		RETURNING id, name, description, price, currency, token_amount, features, is_active, created_at
-	`, req.Name, req.Description, req.Price, req.Currency, req.TokenAmount, req.Features, req.IsActive, req.PlanID).Scan(
+		    token_amount = $5, features = $6, is_active = $7, price_stars = $8
+		WHERE id = $9
+		RETURNING id, name, description, price, currency, token_amount, features, is_active, price_stars, created_at
+	`, req.Name, req.Description, req.Price, req.Currency, req.TokenAmount, req.Features, req.IsActive, req.PriceStars, req.PlanID).Scan(
 		&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-		&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.CreatedAt,
+		&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.PriceStars, &plan.CreatedAt,
 	)
 
 	if err == pgx.ErrNoRows {
@@ -117,3 +117,35 @@ func (s *AdminService) DeletePlan(ctx context.Context, planID int) error {
 
 	return nil
 }
+
+// GetJobRuns получает историю запусков фоновых задач
+func (s *AdminService) GetJobRuns(ctx context.Context, jobName string, limit int) ([]models.JobRun, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT id, job_name, instance_id, status, error, started_at, finished_at
+		FROM scheduler_job_runs
+		WHERE $1 = '' OR job_name = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`, jobName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.JobRun
+	for rows.Next() {
+		var run models.JobRun
+		err := rows.Scan(
+			&run.ID, &run.JobName, &run.InstanceID, &run.Status,
+			&run.Error, &run.StartedAt, &run.FinishedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
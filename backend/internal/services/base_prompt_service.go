@@ -0,0 +1,571 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/prompttmpl"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// BasePromptService управляет базовыми промптами (is_base = true) из админки
+type BasePromptService struct{}
+
+func NewBasePromptService() *BasePromptService {
+	return &BasePromptService{}
+}
+
+// promptPlanTiers - уровни plan_required базовых промптов и планы подписки, которые их
+// открывают. Бесплатный и неизвестные планы получают первый уровень, последнему уровню
+// доступны все базовые промпты. maxUserPrompts - лимит своих промптов, -1 - без ограничений.
+var promptPlanTiers = []struct {
+	name           string
+	level          int
+	planName       string
+	maxUserPrompts int
+}{
+	{"basic", 1, "Базовый", 0},
+	{"premium", 2, "Премиум", 3},
+	{"pro", 3, "Про", -1},
+}
+
+// freePlanName - название плана пользователя без активной подписки
+const freePlanName = "Бесплатный план"
+
+// PromptPlanTiers возвращает названия уровней по возрастанию
+func PromptPlanTiers() []string {
+	names := make([]string, 0, len(promptPlanTiers))
+	for _, tier := range promptPlanTiers {
+		names = append(names, tier.name)
+	}
+	return names
+}
+
+func planTierLevel(name string) (int, bool) {
+	if name == "" {
+		return promptPlanTiers[0].level, true
+	}
+	for _, tier := range promptPlanTiers {
+		if tier.name == name {
+			return tier.level, true
+		}
+	}
+	return 0, false
+}
+
+// subscriptionPromptTier возвращает уровень промптов и лимит своих промптов для плана
+// подписки по его названию; all - доступны все базовые промпты
+func subscriptionPromptTier(planName string) (level int, maxUserPrompts int, all bool) {
+	for i, tier := range promptPlanTiers {
+		if tier.planName == planName {
+			return tier.level, tier.maxUserPrompts, i == len(promptPlanTiers)-1
+		}
+	}
+	return promptPlanTiers[0].level, promptPlanTiers[0].maxUserPrompts, false
+}
+
+// planTierName возвращает название уровня; для уровней вне списка (заданных в БД вручную) - пустую строку
+func planTierName(level int) string {
+	for _, tier := range promptPlanTiers {
+		if tier.level == level {
+			return tier.name
+		}
+	}
+	return ""
+}
+
+// planTierAtLeast возвращает наименьший уровень не ниже level, чтобы промпт не стал доступнее
+func planTierAtLeast(level int) string {
+	for _, tier := range promptPlanTiers {
+		if tier.level >= level {
+			return tier.name
+		}
+	}
+	return promptPlanTiers[len(promptPlanTiers)-1].name
+}
+
+var (
+	promptSlugPattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+	promptLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+	promptVoiceGenders    = map[string]bool{"any": true, "male": true, "female": true}
+)
+
+// validateBasePrompt проверяет поля промпта и возвращает уровень plan_required
+func validateBasePrompt(data *models.BasePromptData) (int, error) {
+	if strings.TrimSpace(data.Title) == "" || strings.TrimSpace(data.Content) == "" {
+		return 0, fmt.Errorf("title and content cannot be empty")
+	}
+	if data.Slug != "" && !promptSlugPattern.MatchString(data.Slug) {
+		return 0, fmt.Errorf("invalid slug")
+	}
+	for language, title := range data.Titles {
+		if !promptLanguagePattern.MatchString(language) || strings.TrimSpace(title) == "" {
+			return 0, fmt.Errorf("invalid titles")
+		}
+	}
+	if data.VoiceGender != nil && !promptVoiceGenders[*data.VoiceGender] {
+		return 0, fmt.Errorf("invalid voice gender")
+	}
+	level, ok := planTierLevel(data.PlanTier)
+	if !ok {
+		return 0, fmt.Errorf("invalid plan tier")
+	}
+	if err := prompttmpl.Validate(data.Content); err != nil {
+		return 0, fmt.Errorf("invalid template: %w", err)
+	}
+	if data.Titles == nil {
+		data.Titles = map[string]string{}
+	}
+	return level, nil
+}
+
+const basePromptColumns = `id, COALESCE(slug, ''), title, titles, description, content, category, voice_gender,
+	plan_required, sort_order, is_active, current_version, created_at`
+
+func scanBasePrompt(row pgx.Row) (*models.BasePrompt, error) {
+	var p models.BasePrompt
+	err := row.Scan(&p.ID, &p.Slug, &p.Title, &p.Titles, &p.Description, &p.Content, &p.Category, &p.VoiceGender,
+		&p.PlanRequired, &p.SortOrder, &p.IsActive, &p.Version, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.PlanTier = planTierName(p.PlanRequired)
+	return &p, nil
+}
+
+// ListBasePrompts возвращает все базовые промпты, включая неактивные, в порядке показа
+func (s *BasePromptService) ListBasePrompts(ctx context.Context) ([]models.BasePrompt, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT `+basePromptColumns+`
+		FROM voice_prompts
+		WHERE is_base = true
+		ORDER BY sort_order ASC, plan_required ASC, title ASC, id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query base prompts: %w", err)
+	}
+	defer rows.Close()
+
+	prompts := []models.BasePrompt{}
+	for rows.Next() {
+		p, err := scanBasePrompt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan base prompt: %w", err)
+		}
+		prompts = append(prompts, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query base prompts: %w", err)
+	}
+
+	return prompts, nil
+}
+
+// CreateBasePrompt создает базовый промпт и его первую версию
+func (s *BasePromptService) CreateBasePrompt(ctx context.Context, req *models.CreateBasePromptRequest) (*models.BasePrompt, error) {
+	level, err := validateBasePrompt(&req.BasePromptData)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	prompt, err := insertBasePrompt(ctx, tx, &req.BasePromptData, level, promptAuthorAdmin(req.Author), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("✅ Created base prompt %s (ID: %d)", prompt.Slug, prompt.ID)
+
+	return prompt, nil
+}
+
+// insertBasePrompt добавляет промпт; без slug он получает "prompt-<id>", без sort_order - место в конце списка
+func insertBasePrompt(ctx context.Context, tx pgx.Tx, data *models.BasePromptData, level int, author string, note *string) (*models.BasePrompt, error) {
+	var promptID int
+	err := tx.QueryRow(ctx, `
+		INSERT INTO voice_prompts (slug, title, titles, description, content, category, voice_gender,
+		                           is_base, plan_required, sort_order, is_active, current_version, created_at)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, true, $8,
+		        COALESCE($9, (SELECT COALESCE(MAX(sort_order), 0) + 1 FROM voice_prompts WHERE is_base = true)),
+		        COALESCE($10, true), 1, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, data.Slug, data.Title, data.Titles, data.Description, data.Content, data.Category, data.VoiceGender,
+		level, data.SortOrder, data.IsActive).Scan(&promptID)
+	if err != nil {
+		return nil, basePromptWriteError("failed to create base prompt", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE voice_prompts SET slug = 'prompt-' || id WHERE id = $1 AND slug IS NULL
+	`, promptID)
+	if err != nil {
+		return nil, basePromptWriteError("failed to set prompt slug", err)
+	}
+	if err := insertPromptVersion(ctx, tx, promptID, author, note); err != nil {
+		return nil, err
+	}
+
+	prompt, err := scanBasePrompt(tx.QueryRow(ctx, `SELECT `+basePromptColumns+` FROM voice_prompts WHERE id = $1`, promptID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get base prompt: %w", err)
+	}
+	return prompt, nil
+}
+
+// basePromptWriteError превращает нарушение уникальности slug в понятную ошибку
+func basePromptWriteError(message string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return fmt.Errorf("slug already exists")
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+func lockBasePrompt(ctx context.Context, tx pgx.Tx, promptID int) (*models.BasePrompt, error) {
+	prompt, err := scanBasePrompt(tx.QueryRow(ctx, `
+		SELECT `+basePromptColumns+` FROM voice_prompts WHERE id = $1 AND is_base = true FOR UPDATE
+	`, promptID))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("prompt not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock base prompt: %w", err)
+	}
+	return prompt, nil
+}
+
+// basePromptData - редактируемые поля промпта, с которых начинается правка или экспорт
+func basePromptData(p *models.BasePrompt) models.BasePromptData {
+	sortOrder, isActive := p.SortOrder, p.IsActive
+	return models.BasePromptData{
+		Slug:        p.Slug,
+		Title:       p.Title,
+		Titles:      maps.Clone(p.Titles),
+		Description: p.Description,
+		Content:     p.Content,
+		Category:    p.Category,
+		VoiceGender: p.VoiceGender,
+		PlanTier:    planTierName(p.PlanRequired),
+		SortOrder:   &sortOrder,
+		IsActive:    &isActive,
+	}
+}
+
+func equalOptional(a *string, b *string) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+// updateBasePrompt записывает новые поля промпта. Изменение текстовых полей (названия, описание,
+// содержимое, категория, род голоса) создает новую версию; уровень плана, порядок и активность
+// меняются без версии. Возвращает false, если менять нечего.
+func updateBasePrompt(ctx context.Context, tx pgx.Tx, current *models.BasePrompt, next *models.BasePromptData, level int, author string, note *string) (*models.BasePrompt, bool, error) {
+	contentChanged := current.Title != next.Title ||
+		!maps.Equal(current.Titles, next.Titles) ||
+		!equalOptional(current.Description, next.Description) ||
+		current.Content != next.Content ||
+		!equalOptional(current.Category, next.Category) ||
+		!equalOptional(current.VoiceGender, next.VoiceGender)
+
+	sortOrder, isActive := current.SortOrder, current.IsActive
+	if next.SortOrder != nil {
+		sortOrder = *next.SortOrder
+	}
+	if next.IsActive != nil {
+		isActive = *next.IsActive
+	}
+	metaChanged := current.Slug != next.Slug || current.PlanRequired != level ||
+		current.SortOrder != sortOrder || current.IsActive != isActive
+
+	if !contentChanged && !metaChanged {
+		return current, false, nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		UPDATE voice_prompts
+		SET slug = $2, title = $3, titles = $4, description = $5, content = $6, category = $7, voice_gender = $8,
+		    plan_required = $9, sort_order = $10, is_active = $11,
+		    current_version = current_version + CASE WHEN $12 THEN 1 ELSE 0 END
+		WHERE id = $1
+	`, current.ID, next.Slug, next.Title, next.Titles, next.Description, next.Content, next.Category, next.VoiceGender,
+		level, sortOrder, isActive, contentChanged)
+	if err != nil {
+		return nil, false, basePromptWriteError("failed to update base prompt", err)
+	}
+	if contentChanged {
+		if err := insertPromptVersion(ctx, tx, current.ID, author, note); err != nil {
+			return nil, false, err
+		}
+	}
+
+	prompt, err := scanBasePrompt(tx.QueryRow(ctx, `SELECT `+basePromptColumns+` FROM voice_prompts WHERE id = $1`, current.ID))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get base prompt: %w", err)
+	}
+	return prompt, true, nil
+}
+
+// UpdateBasePrompt изменяет базовый промпт; правка текста сохраняется новой версией от имени author
+func (s *BasePromptService) UpdateBasePrompt(ctx context.Context, req *models.UpdateBasePromptRequest) (*models.BasePrompt, error) {
+	if req.Slug == nil && req.Title == nil && req.Titles == nil && req.Description == nil && req.Content == nil &&
+		req.Category == nil && req.VoiceGender == nil && req.PlanTier == nil && req.SortOrder == nil {
+		return nil, fmt.Errorf("nothing to update")
+	}
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := lockBasePrompt(ctx, tx, req.PromptID)
+	if err != nil {
+		return nil, err
+	}
+
+	next := basePromptData(current)
+	if next.Slug == "" {
+		next.Slug = fmt.Sprintf("prompt-%d", current.ID)
+	}
+	if req.Slug != nil {
+		if *req.Slug == "" {
+			return nil, fmt.Errorf("invalid slug")
+		}
+		next.Slug = *req.Slug
+	}
+	if req.Title != nil {
+		next.Title = *req.Title
+	}
+	if req.Titles != nil {
+		next.Titles = req.Titles
+	}
+	if req.Description != nil {
+		next.Description = req.Description
+	}
+	if req.Content != nil {
+		next.Content = *req.Content
+	}
+	if req.Category != nil {
+		next.Category = req.Category
+	}
+	if req.VoiceGender != nil {
+		next.VoiceGender = req.VoiceGender
+	}
+	if req.PlanTier != nil {
+		next.PlanTier = *req.PlanTier
+	}
+	if req.SortOrder != nil {
+		next.SortOrder = req.SortOrder
+	}
+
+	level, err := validateBasePrompt(&next)
+	if err != nil {
+		return nil, err
+	}
+	if req.PlanTier == nil {
+		// Уровень вне списка сохраняется, пока его не поменяют явно
+		level = current.PlanRequired
+	}
+
+	prompt, changed, err := updateBasePrompt(ctx, tx, current, &next, level, promptAuthorAdmin(req.Author), req.ChangeNote)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if changed {
+		log.Infof("✏️ Base prompt %d updated (version %d)", prompt.ID, prompt.Version)
+	}
+
+	return prompt, nil
+}
+
+// ReorderBasePrompts ставит перечисленные промпты в начало списка в заданном порядке;
+// остальные базовые промпты идут следом, сохраняя свой порядок
+func (s *BasePromptService) ReorderBasePrompts(ctx context.Context, promptIDs []int) ([]models.BasePrompt, error) {
+	seen := map[int]bool{}
+	for _, id := range promptIDs {
+		if seen[id] {
+			return nil, fmt.Errorf("invalid prompt order")
+		}
+		seen[id] = true
+	}
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE voice_prompts vp
+		SET sort_order = o.position
+		FROM unnest($1::int[]) WITH ORDINALITY AS o(id, position)
+		WHERE vp.id = o.id AND vp.is_base = true
+	`, promptIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reorder base prompts: %w", err)
+	}
+	if int(result.RowsAffected()) != len(promptIDs) {
+		return nil, fmt.Errorf("prompt not found")
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE voice_prompts vp
+		SET sort_order = $2 + r.position
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY sort_order, plan_required, title, id) AS position
+			FROM voice_prompts
+			WHERE is_base = true AND NOT (id = ANY($1))
+		) r
+		WHERE vp.id = r.id
+	`, promptIDs, len(promptIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reorder base prompts: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.ListBasePrompts(ctx)
+}
+
+// SetBasePromptActive включает или выключает базовый промпт. Выключенный промпт пропадает
+// из списков, а у выбравших его пользователей сессия начинается с персоны по умолчанию.
+func (s *BasePromptService) SetBasePromptActive(ctx context.Context, promptID int, active bool) (*models.BasePrompt, error) {
+	prompt, err := scanBasePrompt(database.Database.Pool.QueryRow(ctx, `
+		UPDATE voice_prompts SET is_active = $2
+		WHERE id = $1 AND is_base = true
+		RETURNING `+basePromptColumns, promptID, active))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("prompt not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update base prompt: %w", err)
+	}
+
+	log.Infof("✅ Base prompt %d active: %v", promptID, active)
+
+	return prompt, nil
+}
+
+// ExportBasePrompts выгружает все базовые промпты в формате импорта
+func (s *BasePromptService) ExportBasePrompts(ctx context.Context) (*models.BasePromptExport, error) {
+	prompts, err := s.ListBasePrompts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &models.BasePromptExport{
+		ExportedAt: time.Now(),
+		Prompts:    make([]models.BasePromptData, 0, len(prompts)),
+	}
+	for i := range prompts {
+		data := basePromptData(&prompts[i])
+		if data.PlanTier == "" {
+			data.PlanTier = planTierAtLeast(prompts[i].PlanRequired)
+		}
+		export.Prompts = append(export.Prompts, data)
+	}
+	return export, nil
+}
+
+// ImportBasePrompts создает и обновляет базовые промпты по slug в одной транзакции.
+// Промпты, которых нет в файле, не трогаются. dry_run считает изменения и откатывает их.
+func (s *BasePromptService) ImportBasePrompts(ctx context.Context, req *models.ImportBasePromptsRequest) (*models.BasePromptImportResult, error) {
+	levels := make([]int, len(req.Prompts))
+	seen := map[string]bool{}
+	for i := range req.Prompts {
+		data := &req.Prompts[i]
+		if data.Slug == "" {
+			return nil, fmt.Errorf("invalid prompt %d: slug is required", i+1)
+		}
+		if seen[data.Slug] {
+			return nil, fmt.Errorf("invalid prompt %d: duplicate slug %s", i+1, data.Slug)
+		}
+		seen[data.Slug] = true
+
+		level, err := validateBasePrompt(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt %d (%s): %w", i+1, data.Slug, err)
+		}
+		levels[i] = level
+	}
+
+	author := promptAuthorAdmin(req.Author)
+	note := "Imported"
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result := &models.BasePromptImportResult{
+		DryRun: req.DryRun,
+		Items:  make([]models.BasePromptImportItem, 0, len(req.Prompts)),
+	}
+	for i := range req.Prompts {
+		data := &req.Prompts[i]
+		item := models.BasePromptImportItem{Slug: data.Slug}
+
+		current, err := scanBasePrompt(tx.QueryRow(ctx, `
+			SELECT `+basePromptColumns+` FROM voice_prompts WHERE is_base = true AND slug = $1 FOR UPDATE
+		`, data.Slug))
+		switch {
+		case err == pgx.ErrNoRows:
+			prompt, err := insertBasePrompt(ctx, tx, data, levels[i], author, &note)
+			if err != nil {
+				return nil, err
+			}
+			item.PromptID, item.Version, item.Action = prompt.ID, prompt.Version, "created"
+			result.Created++
+		case err != nil:
+			return nil, fmt.Errorf("failed to get base prompt: %w", err)
+		default:
+			prompt, changed, err := updateBasePrompt(ctx, tx, current, data, levels[i], author, &note)
+			if err != nil {
+				return nil, err
+			}
+			item.PromptID, item.Version, item.Action = prompt.ID, prompt.Version, "unchanged"
+			if changed {
+				item.Action = "updated"
+				result.Updated++
+			} else {
+				result.Unchanged++
+			}
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if req.DryRun {
+		return result, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("📥 Imported base prompts by %s: %d created, %d updated, %d unchanged",
+		author, result.Created, result.Updated, result.Unchanged)
+
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/models"
+)
+
+func TestSubscriptionPromptTier(t *testing.T) {
+	tests := []struct {
+		planName   string
+		wantLevel  int
+		wantMax    int
+		wantAllFor bool
+	}{
+		{freePlanName, 1, 0, false},
+		{"Базовый", 1, 0, false},
+		{"Премиум", 2, 3, false},
+		{"Про", 3, -1, true},
+		{"Неизвестный план", 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.planName, func(t *testing.T) {
+			level, maxUserPrompts, all := subscriptionPromptTier(tt.planName)
+			if level != tt.wantLevel || maxUserPrompts != tt.wantMax || all != tt.wantAllFor {
+				t.Errorf("subscriptionPromptTier(%q) = %d, %d, %t; want %d, %d, %t",
+					tt.planName, level, maxUserPrompts, all, tt.wantLevel, tt.wantMax, tt.wantAllFor)
+			}
+		})
+	}
+
+	// Уровни планов подписки совпадают с уровнями plan_tier базовых промптов
+	for _, tier := range promptPlanTiers {
+		level, _, _ := subscriptionPromptTier(tier.planName)
+		if tierLevel, ok := planTierLevel(tier.name); !ok || tierLevel != level {
+			t.Errorf("plan %q has level %d, plan tier %q has %d", tier.planName, level, tier.name, tierLevel)
+		}
+	}
+}
+
+func TestSelectPromptChecksPlanTier(t *testing.T) {
+	ctx := testDB(t)
+	basePrompts := NewBasePromptService()
+	user := createTestUser(t, ctx, testTelegramID())
+
+	createPrompt := func(tier string) *models.BasePrompt {
+		prompt, err := basePrompts.CreateBasePrompt(ctx, &models.CreateBasePromptRequest{
+			BasePromptData: models.BasePromptData{
+				Slug:     fmt.Sprintf("tier-test-%s-%d", tier, time.Now().UnixNano()),
+				Title:    "Промпт " + tier,
+				Content:  "Ты помощник.",
+				PlanTier: tier,
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateBasePrompt(%s): %v", tier, err)
+		}
+		return prompt
+	}
+	basic, premium := createPrompt("basic"), createPrompt("premium")
+
+	// Без подписки доступен только первый уровень
+	prompts := NewPromptService()
+	if err := prompts.SelectPrompt(ctx, user.ID, basic.ID, nil); err != nil {
+		t.Errorf("SelectPrompt(basic) on free plan: %v", err)
+	}
+	if err := prompts.SelectPrompt(ctx, user.ID, premium.ID, nil); err == nil {
+		t.Errorf("SelectPrompt(premium) on free plan succeeded")
+	}
+}
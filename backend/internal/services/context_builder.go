@@ -0,0 +1,174 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"voice-ai-backend/internal/models"
+)
+
+// Приоритеты разделов инструкций сессии (меньше - важнее). В этом же порядке разделы идут в тексте.
+const (
+	ContextPriorityPersona = iota + 1
+	ContextPriorityUserPrompt
+	ContextPriorityGender
+	ContextPriorityMemory
+	ContextPrioritySummary
+	ContextPriorityRecentTurns
+)
+
+// ContextSection - раздел инструкций. Items - неделимые части раздела (факты, реплики);
+// раздел из одной части при нехватке бюджета обрезается по тексту.
+type ContextSection struct {
+	Name     string
+	Priority int
+	Header   string
+	Items    []string
+	Footer   string
+	// MaxTokens ограничивает размер раздела (0 - только общий бюджет)
+	MaxTokens int
+	// KeepLast отбрасывает части с начала (для реплик важнее последние)
+	KeepLast bool
+}
+
+// ContextBuilder собирает инструкции сессии в пределах бюджета токенов.
+// Разделы заполняются по приоритету: менее важные получают то, что осталось.
+// Результат детерминирован: одинаковые разделы и бюджет дают одинаковый текст.
+type ContextBuilder struct {
+	budget   int
+	sections []ContextSection
+}
+
+func NewContextBuilder(budget int) *ContextBuilder {
+	return &ContextBuilder{
+		budget: budget,
+	}
+}
+
+// Add добавляет раздел; разделы без частей игнорируются
+func (b *ContextBuilder) Add(section ContextSection) {
+	var items []string
+	for _, item := range section.Items {
+		if strings.TrimSpace(item) != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	section.Items = items
+	b.sections = append(b.sections, section)
+}
+
+// Build возвращает текст инструкций и отчет о том, что в него вошло
+func (b *ContextBuilder) Build() (string, *models.ContextReport) {
+	sections := append([]ContextSection(nil), b.sections...)
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].Priority < sections[j].Priority
+	})
+
+	report := &models.ContextReport{
+		Budget:   b.budget,
+		Sections: []models.ContextSectionReport{},
+	}
+
+	remaining := b.budget
+	var parts []string
+	for _, section := range sections {
+		text, sectionReport := fitSection(section, remaining)
+		report.Sections = append(report.Sections, sectionReport)
+		if text == "" {
+			continue
+		}
+
+		// Разделы склеиваются через пустую строку
+		cost := sectionReport.Tokens
+		if len(parts) > 0 {
+			cost++
+		}
+		remaining -= cost
+		report.Used += cost
+		parts = append(parts, text)
+	}
+
+	return strings.Join(parts, "\n\n"), report
+}
+
+// fitSection укладывает раздел в available токенов (с учетом MaxTokens раздела)
+func fitSection(section ContextSection, available int) (string, models.ContextSectionReport) {
+	report := models.ContextSectionReport{
+		Name:           section.Name,
+		Priority:       section.Priority,
+		TotalItems:     len(section.Items),
+		OriginalTokens: estimateTokens(renderSection(section.Header, section.Items, section.Footer)),
+	}
+
+	limit := available
+	if section.MaxTokens > 0 && section.MaxTokens < limit {
+		limit = section.MaxTokens
+	}
+
+	budget := limit - estimateTokens(section.Header) - estimateTokens(section.Footer)
+	if budget <= 0 {
+		report.Truncated = true
+		return "", report
+	}
+
+	var items []string
+	if len(section.Items) == 1 {
+		item := section.Items[0]
+		if estimateTokens(item) > budget {
+			// Один токен оставляем на многоточие
+			if budget < 2 {
+				report.Truncated = true
+				return "", report
+			}
+			item = truncateToTokens(item, budget-1)
+		}
+		items = []string{item}
+	} else {
+		order := make([]int, len(section.Items))
+		for i := range order {
+			order[i] = i
+			if section.KeepLast {
+				order[i] = len(section.Items) - 1 - i
+			}
+		}
+
+		for _, i := range order {
+			cost := estimateTokens(section.Items[i]) + 1
+			if cost > budget {
+				break
+			}
+			budget -= cost
+			if section.KeepLast {
+				items = append([]string{section.Items[i]}, items...)
+			} else {
+				items = append(items, section.Items[i])
+			}
+		}
+	}
+
+	if len(items) == 0 {
+		report.Truncated = true
+		return "", report
+	}
+
+	text := renderSection(section.Header, items, section.Footer)
+	report.Items = len(items)
+	report.Tokens = estimateTokens(text)
+	report.Truncated = report.Tokens < report.OriginalTokens
+
+	return text, report
+}
+
+func renderSection(header string, items []string, footer string) string {
+	var parts []string
+	if header != "" {
+		parts = append(parts, header)
+	}
+	parts = append(parts, strings.Join(items, "\n"))
+	if footer != "" {
+		parts = append(parts, footer)
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"voice-ai-backend/internal/models"
+)
+
+// testTokens возвращает текст, который estimateTokens оценивает ровно в n токенов
+func testTokens(char string, n int) string {
+	return strings.Repeat(char, 4*n)
+}
+
+func TestContextBuilder(t *testing.T) {
+	tests := []struct {
+		name          string
+		budget        int
+		sections      []ContextSection
+		wantText      string
+		wantItems     []int
+		wantTruncated []bool
+	}{
+		{
+			name:   "sections ordered by priority",
+			budget: 100,
+			sections: []ContextSection{
+				{Name: "summary", Priority: ContextPrioritySummary, Header: "S:", Items: []string{testTokens("s", 2)}},
+				{Name: "persona", Priority: ContextPriorityPersona, Items: []string{testTokens("p", 3)}},
+			},
+			wantText:      testTokens("p", 3) + "\n\nS:\n" + testTokens("s", 2),
+			wantItems:     []int{1, 1},
+			wantTruncated: []bool{false, false},
+		},
+		{
+			name:   "budget exhaustion drops lower priority",
+			budget: 12,
+			sections: []ContextSection{
+				{Name: "memory", Priority: ContextPriorityMemory, Header: "M:", Items: []string{testTokens("a", 2), testTokens("b", 2)}},
+				{Name: "persona", Priority: ContextPriorityPersona, Items: []string{testTokens("p", 10)}},
+			},
+			wantText:      testTokens("p", 10),
+			wantItems:     []int{1, 0},
+			wantTruncated: []bool{false, true},
+		},
+		{
+			name:   "lower priority gets what is left",
+			budget: 14,
+			sections: []ContextSection{
+				{Name: "persona", Priority: ContextPriorityPersona, Items: []string{testTokens("p", 10)}},
+				{Name: "memory", Priority: ContextPriorityMemory, Header: "M:", Items: []string{testTokens("a", 2), testTokens("b", 2), testTokens("c", 2)}},
+			},
+			wantText:      testTokens("p", 10) + "\n\nM:\n" + testTokens("a", 2),
+			wantItems:     []int{1, 1},
+			wantTruncated: []bool{false, true},
+		},
+		{
+			name:   "KeepLast drops oldest turns",
+			budget: 100,
+			sections: []ContextSection{
+				{
+					Name: "turns", Priority: ContextPriorityRecentTurns, MaxTokens: 9, KeepLast: true,
+					Items: []string{testTokens("a", 2), testTokens("b", 2), testTokens("c", 2), testTokens("d", 2)},
+				},
+			},
+			wantText:      testTokens("b", 2) + "\n" + testTokens("c", 2) + "\n" + testTokens("d", 2),
+			wantItems:     []int{3},
+			wantTruncated: []bool{true},
+		},
+		{
+			name:   "without KeepLast newest items dropped",
+			budget: 100,
+			sections: []ContextSection{
+				{
+					Name: "memory", Priority: ContextPriorityMemory, MaxTokens: 9,
+					Items: []string{testTokens("a", 2), testTokens("b", 2), testTokens("c", 2), testTokens("d", 2)},
+				},
+			},
+			wantText:      testTokens("a", 2) + "\n" + testTokens("b", 2) + "\n" + testTokens("c", 2),
+			wantItems:     []int{3},
+			wantTruncated: []bool{true},
+		},
+		{
+			name:   "single item section truncated",
+			budget: 5,
+			sections: []ContextSection{
+				{Name: "prompt", Priority: ContextPriorityUserPrompt, Items: []string{testTokens("u", 10)}},
+			},
+			wantText:      testTokens("u", 4) + "…",
+			wantItems:     []int{1},
+			wantTruncated: []bool{true},
+		},
+		{
+			name:   "single item without room for ellipsis dropped",
+			budget: 1,
+			sections: []ContextSection{
+				{Name: "prompt", Priority: ContextPriorityUserPrompt, Items: []string{testTokens("u", 10)}},
+			},
+			wantText:      "",
+			wantItems:     []int{0},
+			wantTruncated: []bool{true},
+		},
+		{
+			name:   "blank items ignored",
+			budget: 100,
+			sections: []ContextSection{
+				{Name: "memory", Priority: ContextPriorityMemory, Items: []string{" ", ""}},
+				{Name: "persona", Priority: ContextPriorityPersona, Items: []string{testTokens("p", 2)}},
+			},
+			wantText:      testTokens("p", 2),
+			wantItems:     []int{1},
+			wantTruncated: []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewContextBuilder(tt.budget)
+			for _, section := range tt.sections {
+				builder.Add(section)
+			}
+			text, report := builder.Build()
+
+			if text != tt.wantText {
+				t.Fatalf("Build() text = %q, want %q", text, tt.wantText)
+			}
+			if report.Used > tt.budget {
+				t.Fatalf("report.Used = %d, exceeds budget %d", report.Used, tt.budget)
+			}
+			if len(report.Sections) != len(tt.wantItems) {
+				t.Fatalf("report has %d sections, want %d", len(report.Sections), len(tt.wantItems))
+			}
+			for i, section := range report.Sections {
+				if section.Items != tt.wantItems[i] || section.Truncated != tt.wantTruncated[i] {
+					t.Fatalf("section %q: items %d truncated %v, want items %d truncated %v",
+						section.Name, section.Items, section.Truncated, tt.wantItems[i], tt.wantTruncated[i])
+				}
+			}
+		})
+	}
+}
+
+func TestContextBuilderDeterministic(t *testing.T) {
+	sections := []ContextSection{
+		{Name: "turns", Priority: ContextPriorityRecentTurns, KeepLast: true, Items: []string{testTokens("a", 3), testTokens("b", 3), testTokens("c", 3)}},
+		{Name: "memory", Priority: ContextPriorityMemory, Header: "M:", Items: []string{testTokens("m", 2), testTokens("n", 2)}},
+		{Name: "summary", Priority: ContextPrioritySummary, Header: "S:", Items: []string{testTokens("s", 5)}},
+		{Name: "gender", Priority: ContextPriorityGender, Items: []string{testTokens("g", 1)}},
+		{Name: "persona", Priority: ContextPriorityPersona, Items: []string{testTokens("p", 6)}},
+	}
+
+	build := func() (string, *models.ContextReport) {
+		builder := NewContextBuilder(25)
+		for _, section := range sections {
+			builder.Add(section)
+		}
+		return builder.Build()
+	}
+
+	wantText, wantReport := build()
+	for i := 0; i < 20; i++ {
+		text, report := build()
+		if text != wantText || !reflect.DeepEqual(report, wantReport) {
+			t.Fatalf("run %d: Build() = %q, %+v, want %q, %+v", i, text, report, wantText, wantReport)
+		}
+	}
+}
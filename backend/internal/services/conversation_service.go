@@ -2,25 +2,60 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/redact"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Размер страницы истории по умолчанию и максимальный
+const (
+	historyDefaultLimit = 50
+	historyMaxLimit     = 200
 )
 
+// MessageHistoryFilter - параметры выборки сообщений истории
+type MessageHistoryFilter struct {
+	UserID    int
+	SessionID *int
+	Role      *string
+	From      *time.Time
+	To        *time.Time
+	Cursor    string
+	Limit     int
+	Ascending bool
+}
+
 type ConversationService struct{}
 
 func NewConversationService() *ConversationService {
 	return &ConversationService{}
 }
 
-// GetUserConversation получает историю разговора пользователя
+// GetUserConversation получает последние limit сообщений пользователя в хронологическом порядке
 func (s *ConversationService) GetUserConversation(ctx context.Context, userID int, limit int) ([]models.ConversationMessage, error) {
 	conn := database.Database.Pool
 
+	// Содержимое может быть зашифровано, поэтому сообщения читаются из таблицы напрямую
+	// и расшифровываются здесь, а не через get_user_conversation_context
 	rows, err := conn.Query(ctx, `
-		SELECT role, content, msg_timestamp
-		FROM get_user_conversation_context($1, $2)
-		ORDER BY msg_timestamp ASC
+		SELECT message_type, content, content_key_id, created_at
+		FROM (
+			SELECT message_type, content, content_key_id, created_at, id
+			FROM conversation_messages
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at ASC, id ASC
 	`, userID, limit)
 
 	if err != nil {
@@ -31,10 +66,15 @@ func (s *ConversationService) GetUserConversation(ctx context.Context, userID in
 	var messages []models.ConversationMessage
 	for rows.Next() {
 		var msg models.ConversationMessage
-		err := rows.Scan(&msg.MessageType, &msg.Content, &msg.CreatedAt)
+		var keyID *int
+		err := rows.Scan(&msg.MessageType, &msg.Content, &keyID, &msg.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
+		if msg.Content, err = openContent(ctx, userID, contentTableMessages, msg.Content, keyID); err != nil {
+			return nil, err
+		}
+		msg.Content = redact.Default.Reveal(msg.Content)
 		messages = append(messages, msg)
 	}
 
@@ -45,12 +85,35 @@ func (s *ConversationService) GetUserConversation(ctx context.Context, userID in
 func (s *ConversationService) SaveMessage(ctx context.Context, req *models.SaveConversationRequest) (int, error) {
 	conn := database.Database.Pool
 
+	// Персональные данные маскируются до сохранения, поэтому не попадают и в поисковый индекс
+	content := redact.Default.String(req.Content)
+
+	sealed, keyID, err := sealContent(ctx, req.UserID, contentTableMessages, content)
+	if err != nil {
+		return 0, err
+	}
+
+	// Поисковый вектор строится сразу при вставке на языке пользователя: из открытого
+	// текста или, если сообщение зашифровано, из хешей слов на его ключе данных
+	plainBody := content
+	var vector *string
+	if keyID != nil {
+		vectors, err := sealedSearchVectors(ctx, []int{req.UserID}, []int{*keyID}, []string{content})
+		if err != nil {
+			return 0, err
+		}
+		plainBody, vector = "", &vectors[0]
+	}
+
 	var messageID int
-	err := conn.QueryRow(ctx, `
-		INSERT INTO conversation_messages (user_id, session_id, message_type, content, audio_duration_seconds, created_at)
-		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+	err = conn.QueryRow(ctx, `
+		INSERT INTO conversation_messages (user_id, session_id, message_type, content, content_key_id, audio_duration_seconds,
+		                                   search_vector, search_key_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6,
+		        COALESCE($8::text::tsvector, to_tsvector(conversation_search_config((SELECT language_code FROM users WHERE id = $1)), $7)),
+		        $5, CURRENT_TIMESTAMP)
 		RETURNING id
-	`, req.UserID, req.SessionID, req.MessageType, req.Content, req.AudioDurationSeconds).Scan(&messageID)
+	`, req.UserID, req.SessionID, req.MessageType, sealed, keyID, req.AudioDurationSeconds, plainBody, vector).Scan(&messageID)
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to save message: %w", err)
@@ -58,3 +121,514 @@ func (s *ConversationService) SaveMessage(ctx context.Context, req *models.SaveC
 
 	return messageID, nil
 }
+
+// encodeHistoryCursor кодирует позицию (created_at, id) последней записи страницы
+func encodeHistoryCursor(createdAt time.Time, id int) string {
+	raw := fmt.Sprintf("%d:%d", createdAt.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+
+	// Время в БД хранится без часового пояса, поэтому работаем с ним как с UTC
+	return time.UnixMicro(micros).UTC(), id, nil
+}
+
+func historyLimit(limit int) int {
+	if limit <= 0 {
+		return historyDefaultLimit
+	}
+	if limit > historyMaxLimit {
+		return historyMaxLimit
+	}
+	return limit
+}
+
+// GetMessageHistory постранично возвращает сообщения пользователя с фильтрами по сессии,
+// роли и периоду. Страницы листаются курсором по (created_at, id).
+func (s *ConversationService) GetMessageHistory(ctx context.Context, filter *MessageHistoryFilter) (*models.ConversationMessagesPage, error) {
+	if filter.Role != nil && *filter.Role != "user" && *filter.Role != "assistant" {
+		return nil, fmt.Errorf("invalid role")
+	}
+
+	var cursorTime *time.Time
+	var cursorID int
+	if filter.Cursor != "" {
+		t, id, err := decodeHistoryCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursorTime, cursorID = &t, id
+	}
+
+	limit := historyLimit(filter.Limit)
+
+	comparison, direction := "<", "DESC"
+	if filter.Ascending {
+		comparison, direction = ">", "ASC"
+	}
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id, user_id, session_id, message_type, content, content_key_id,
+		       COALESCE(audio_duration_seconds, 0), created_at
+		FROM conversation_messages
+		WHERE user_id = $1
+		  AND ($2::int IS NULL OR session_id = $2)
+		  AND ($3::text IS NULL OR message_type = $3)
+		  AND ($4::timestamp IS NULL OR created_at >= $4)
+		  AND ($5::timestamp IS NULL OR created_at < $5)
+		  AND ($6::timestamp IS NULL OR (created_at, id) `+comparison+` ($6, $7))
+		ORDER BY created_at `+direction+`, id `+direction+`
+		LIMIT $8
+	`, filter.UserID, filter.SessionID, filter.Role, filter.From, filter.To, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message history: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ConversationMessagesPage{Messages: []models.ConversationMessage{}}
+	for rows.Next() {
+		var msg models.ConversationMessage
+		var keyID *int
+		err := rows.Scan(
+			&msg.ID, &msg.UserID, &msg.SessionID, &msg.MessageType, &msg.Content, &keyID,
+			&msg.AudioDurationSeconds, &msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if msg.Content, err = openContent(ctx, msg.UserID, contentTableMessages, msg.Content, keyID); err != nil {
+			return nil, err
+		}
+		// История отдается владельцу: зашифрованные фрагменты расшифровываются, как в выгрузке
+		msg.Content = redact.Default.Reveal(msg.Content)
+		page.Messages = append(page.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query message history: %w", err)
+	}
+
+	// Лишняя запись означает, что есть следующая страница
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		last := page.Messages[limit-1]
+		next := encodeHistoryCursor(last.CreatedAt, last.ID)
+		page.NextCursor = &next
+	}
+
+	return page, nil
+}
+
+// GetConversationSessions постранично возвращает голосовые сессии пользователя
+// (от новых к старым) со сводкой по их сообщениям
+func (s *ConversationService) GetConversationSessions(ctx context.Context, userID int, from *time.Time, to *time.Time, cursor string, limit int) (*models.ConversationSessionsPage, error) {
+	var cursorTime *time.Time
+	var cursorID int
+	if cursor != "" {
+		t, id, err := decodeHistoryCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursorTime, cursorID = &t, id
+	}
+
+	limit = historyLimit(limit)
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT vs.id, vs.created_at, vs.context_summary, vs.summary_key_id, vs.last_conversation_topic,
+		       COUNT(cm.id),
+		       COUNT(cm.id) FILTER (WHERE cm.message_type = 'user'),
+		       COUNT(cm.id) FILTER (WHERE cm.message_type = 'assistant'),
+		       COALESCE(SUM(cm.audio_duration_seconds), 0),
+		       MIN(cm.created_at), MAX(cm.created_at)
+		FROM voice_sessions vs
+		LEFT JOIN conversation_messages cm ON cm.session_id = vs.id AND cm.user_id = vs.user_id
+		WHERE vs.user_id = $1
+		  AND ($2::timestamp IS NULL OR vs.created_at >= $2)
+		  AND ($3::timestamp IS NULL OR vs.created_at < $3)
+		  AND ($4::timestamp IS NULL OR (vs.created_at, vs.id) < ($4, $5))
+		GROUP BY vs.id
+		ORDER BY vs.created_at DESC, vs.id DESC
+		LIMIT $6
+	`, userID, from, to, cursorTime, cursorID, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation sessions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.ConversationSessionsPage{Sessions: []models.ConversationSession{}}
+	for rows.Next() {
+		var session models.ConversationSession
+		var summaryKeyID *int
+		err := rows.Scan(
+			&session.SessionID, &session.CreatedAt, &session.ContextSummary, &summaryKeyID, &session.LastConversationTopic,
+			&session.MessageCount, &session.UserMessages, &session.AssistantMessages,
+			&session.AudioDurationSeconds, &session.FirstMessageAt, &session.LastMessageAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation session: %w", err)
+		}
+		if session.ContextSummary, err = openOptionalContent(ctx, userID, contentTableSessions, session.ContextSummary, summaryKeyID); err != nil {
+			return nil, err
+		}
+		page.Sessions = append(page.Sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query conversation sessions: %w", err)
+	}
+
+	if len(page.Sessions) > limit {
+		page.Sessions = page.Sessions[:limit]
+		last := page.Sessions[limit-1]
+		next := encodeHistoryCursor(last.CreatedAt, last.SessionID)
+		page.NextCursor = &next
+	}
+
+	return page, nil
+}
+
+// SearchMessages ищет по истории пользователя полнотекстовым поиском и возвращает
+// фрагменты с подсветкой совпадений, отсортированные по релевантности
+func (s *ConversationService) SearchMessages(ctx context.Context, userID int, query string, sessionID *int, from *time.Time, to *time.Time, limit int, offset int) ([]models.ConversationSearchResult, bool, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, false, fmt.Errorf("query is required")
+	}
+	if len([]rune(query)) > 200 {
+		return nil, false, fmt.Errorf("query is too long")
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	limit = historyLimit(limit)
+
+	// Запрос строится и на языке пользователя (со стеммингом), и без стемминга -
+	// так находятся и слова на другом языке
+	var plainQuery string
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT (websearch_to_tsquery(conversation_search_config(language_code), $2)
+		            || websearch_to_tsquery('simple', $2))::text
+		FROM users WHERE id = $1
+	`, userID, query).Scan(&plainQuery)
+	if err == pgx.ErrNoRows {
+		return []models.ConversationSearchResult{}, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse search query: %w", err)
+	}
+
+	// Векторы зашифрованных сообщений состоят из хешей слов, поэтому для каждого ключа
+	// данных пользователя запрос хешируется тем же ключом
+	termKeys, err := userTermKeys(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	keyIDs := make([]int, 0, len(termKeys))
+	hashedQueries := make([]string, 0, len(termKeys))
+	for keyID, key := range termKeys {
+		keyIDs = append(keyIDs, keyID)
+		hashedQueries = append(hashedQueries, hashSearchQuery(key, plainQuery))
+	}
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		WITH q AS (
+			SELECT NULL::int AS key_id, $2::text::tsquery AS query
+			UNION ALL
+			SELECT t.key_id, t.query::tsquery FROM unnest($8::int[], $9::text[]) AS t(key_id, query)
+		)
+		SELECT cm.id, cm.session_id, cm.message_type, cm.content, cm.content_key_id,
+		       ts_rank(cm.search_vector, q.query) AS rank,
+		       cm.created_at
+		FROM conversation_messages cm
+		JOIN q ON q.key_id IS NOT DISTINCT FROM cm.search_key_id
+		WHERE cm.user_id = $1
+		  AND cm.search_vector @@ q.query
+		  AND ($3::int IS NULL OR cm.session_id = $3)
+		  AND ($4::timestamp IS NULL OR cm.created_at >= $4)
+		  AND ($5::timestamp IS NULL OR cm.created_at < $5)
+		ORDER BY rank DESC, cm.created_at DESC, cm.id DESC
+		LIMIT $6 OFFSET $7
+	`, userID, plainQuery, sessionID, from, to, limit+1, offset, keyIDs, hashedQueries)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := []models.ConversationSearchResult{}
+	var contents []string
+	for rows.Next() {
+		var r models.ConversationSearchResult
+		var content string
+		var keyID *int
+		if err := rows.Scan(&r.MessageID, &r.SessionID, &r.MessageType, &content, &keyID, &r.Rank, &r.CreatedAt); err != nil {
+			return nil, false, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		if content, err = openContent(ctx, userID, contentTableMessages, content, keyID); err != nil {
+			return nil, false, err
+		}
+		results = append(results, r)
+		contents = append(contents, redact.Default.Reveal(content))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+		contents = contents[:limit]
+	}
+
+	if len(results) > 0 {
+		snippets, err := highlightSnippets(ctx, userID, query, contents)
+		if err != nil {
+			return nil, false, err
+		}
+		for i := range results {
+			results[i].Snippet = snippets[i]
+		}
+	}
+
+	return results, hasMore, nil
+}
+
+// highlightSnippets строит фрагменты с подсветкой совпадений по расшифрованным текстам
+func highlightSnippets(ctx context.Context, userID int, query string, contents []string) ([]string, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		WITH q AS (
+			SELECT conversation_search_config(language_code) AS cfg,
+			       websearch_to_tsquery(conversation_search_config(language_code), $2)
+			           || websearch_to_tsquery('simple', $2) AS query
+			FROM users WHERE id = $1
+		)
+		SELECT ts_headline(q.cfg,
+		           replace(replace(replace(t.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		           q.query,
+		           'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "')
+		FROM unnest($3::text[]) WITH ORDINALITY AS t(body, n), q
+		ORDER BY t.n
+	`, userID, query, contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to highlight search results: %w", err)
+	}
+	defer rows.Close()
+
+	snippets := make([]string, 0, len(contents))
+	for rows.Next() {
+		var snippet string
+		if err := rows.Scan(&snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search snippet: %w", err)
+		}
+		snippets = append(snippets, snippet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to highlight search results: %w", err)
+	}
+	if len(snippets) != len(contents) {
+		return nil, fmt.Errorf("failed to highlight search results")
+	}
+
+	return snippets, nil
+}
+
+// IndexMessages заполняет поисковый вектор для сообщений, сохраненных до появления
+// поиска или в обход SaveMessage, и перестраивает векторы, построенные не тем ключом,
+// что содержимое: после шифрования открытых сообщений и после ротации ключей.
+// За запуск обрабатывается не больше batchSize сообщений.
+func (s *ConversationService) IndexMessages(ctx context.Context, batchSize int) (int64, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT id, user_id, COALESCE(content, ''), content_key_id
+		FROM conversation_messages
+		WHERE search_vector IS NULL OR search_key_id IS DISTINCT FROM content_key_id
+		ORDER BY id
+		LIMIT $1
+	`, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get messages to index: %w", err)
+	}
+
+	var plainIDs []int
+	var plainBodies []string
+	var sealedIDs, sealedUsers, sealedKeys []int
+	var sealedBodies []string
+	var failedIDs, failedKeys []int
+	for rows.Next() {
+		var id, userID int
+		var content string
+		var keyID *int
+		if err := rows.Scan(&id, &userID, &content, &keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if keyID == nil {
+			plainIDs = append(plainIDs, id)
+			plainBodies = append(plainBodies, content)
+			continue
+		}
+		if content, err = openContent(ctx, userID, contentTableMessages, content, keyID); err != nil {
+			// Без пустого вектора строка снова попадала бы в выборку и при достаточном
+			// числе таких строк индексация остановилась бы; в поиск она не попадает
+			log.Warnf("Failed to index message %d, storing empty search vector: %v", id, err)
+			failedIDs = append(failedIDs, id)
+			failedKeys = append(failedKeys, *keyID)
+			continue
+		}
+		sealedIDs = append(sealedIDs, id)
+		sealedUsers = append(sealedUsers, userID)
+		sealedKeys = append(sealedKeys, *keyID)
+		sealedBodies = append(sealedBodies, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get messages to index: %w", err)
+	}
+
+	var indexed int64
+	if len(plainIDs) > 0 {
+		result, err := conn.Exec(ctx, `
+			UPDATE conversation_messages cm
+			SET search_vector = to_tsvector(conversation_search_config(u.language_code), t.body), search_key_id = NULL
+			FROM unnest($1::int[], $2::text[]) AS t(id, body), users u
+			WHERE cm.id = t.id AND u.id = cm.user_id AND cm.content_key_id IS NULL
+		`, plainIDs, plainBodies)
+		if err != nil {
+			return 0, fmt.Errorf("failed to index messages: %w", err)
+		}
+		indexed += result.RowsAffected()
+	}
+
+	if len(sealedIDs)+len(failedIDs) > 0 {
+		vectors, err := sealedSearchVectors(ctx, sealedUsers, sealedKeys, sealedBodies)
+		if err != nil {
+			return indexed, err
+		}
+		for range failedIDs {
+			vectors = append(vectors, "")
+		}
+
+		// Сообщение, перешифрованное после чтения, останется в выборке до следующего запуска
+		result, err := conn.Exec(ctx, `
+			UPDATE conversation_messages cm
+			SET search_vector = t.vector::tsvector, search_key_id = t.key_id
+			FROM unnest($1::int[], $2::int[], $3::text[]) AS t(id, key_id, vector)
+			WHERE cm.id = t.id AND cm.content_key_id = t.key_id
+		`, append(sealedIDs, failedIDs...), append(sealedKeys, failedKeys...), vectors)
+		if err != nil {
+			return indexed, fmt.Errorf("failed to index messages: %w", err)
+		}
+		indexed += result.RowsAffected()
+	}
+
+	if indexed > 0 {
+		log.Infof("🔎 Indexed %d conversation messages for search", indexed)
+	}
+
+	return indexed, nil
+}
+
+// searchLexeme - лексема поискового вектора с позициями в тексте
+type searchLexeme struct {
+	lexeme    string
+	positions []int16
+}
+
+// sealedSearchVectors строит поисковые векторы зашифрованных сообщений. Текст разбирается
+// на лексемы на языке пользователя, как открытый, но каждая лексема заменяется хешем
+// на ключе данных сообщения: в базе не остается слов, а позиции сохраняют поиск фраз
+// и ранжирование. Возвращает текстовое представление tsvector для каждого сообщения.
+func sealedSearchVectors(ctx context.Context, userIDs []int, keyIDs []int, bodies []string) ([]string, error) {
+	if len(bodies) == 0 {
+		return nil, nil
+	}
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT t.n, l.lexeme, l.positions
+		FROM unnest($1::int[], $2::text[]) WITH ORDINALITY AS t(user_id, body, n)
+		JOIN users u ON u.id = t.user_id
+		CROSS JOIN LATERAL unnest(to_tsvector(conversation_search_config(u.language_code), t.body)) AS l
+	`, userIDs, bodies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse messages for search: %w", err)
+	}
+	lexemes := make([][]searchLexeme, len(bodies))
+	for rows.Next() {
+		var n int
+		var l searchLexeme
+		if err := rows.Scan(&n, &l.lexeme, &l.positions); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan search lexeme: %w", err)
+		}
+		lexemes[n-1] = append(lexemes[n-1], l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse messages for search: %w", err)
+	}
+
+	termKeys := map[int][]byte{}
+	vectors := make([]string, len(bodies))
+	for i := range bodies {
+		key, ok := termKeys[keyIDs[i]]
+		if !ok {
+			if key, err = contentTermKey(ctx, userIDs[i], keyIDs[i]); err != nil {
+				return nil, err
+			}
+			termKeys[keyIDs[i]] = key
+		}
+		vectors[i] = hashedSearchVector(key, lexemes[i])
+	}
+	return vectors, nil
+}
+
+// hashedSearchVector возвращает текст tsvector из хешей лексем с их позициями
+func hashedSearchVector(key []byte, lexemes []searchLexeme) string {
+	var b strings.Builder
+	for i, l := range lexemes {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(hashTerm(key, l.lexeme))
+		for j, pos := range l.positions {
+			if j == 0 {
+				b.WriteByte(':')
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(int(pos)))
+		}
+	}
+	return b.String()
+}
+
+// tsqueryLexeme - лексема в текстовом представлении tsquery: в кавычках, кавычка внутри удвоена
+var tsqueryLexeme = regexp.MustCompile(`'((?:[^']|'')*)'`)
+
+// hashSearchQuery заменяет лексемы текстового tsquery их хешами на ключе key,
+// сохраняя операторы, - запрос для векторов из sealedSearchVectors
+func hashSearchQuery(key []byte, query string) string {
+	return tsqueryLexeme.ReplaceAllStringFunc(query, func(quoted string) string {
+		lexeme := quoted[1 : len(quoted)-1]
+		lexeme = strings.ReplaceAll(lexeme, "''", "'")
+		lexeme = strings.ReplaceAll(lexeme, `\\`, `\`)
+		return "'" + hashTerm(key, lexeme) + "'"
+	})
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+)
+
+// Интеграционные тесты работают с настоящим PostgreSQL. Базовые таблицы (users,
+// subscription_plans, user_subscriptions, ...) и SQL-функции разворачиваются вне
+// репозитория, поэтому нужна база с уже созданной схемой: TEST_DATABASE_URL.
+// Без нее такие тесты пропускаются.
+
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+// testDB подключает сервисы к тестовой базе и применяет схему backend'а
+func testDB(t *testing.T) context.Context {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	testDBOnce.Do(func() {
+		os.Setenv("DATABASE_URL", url)
+		os.Setenv("TRIAL_ENABLED", "false")
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			os.Setenv("OPENAI_API_KEY", "test")
+		}
+		if os.Getenv("DELETED_ACCOUNT_SALT") == "" {
+			os.Setenv("DELETED_ACCOUNT_SALT", "test")
+		}
+		if testDBErr = config.Load(); testDBErr != nil {
+			return
+		}
+		if testDBErr = database.Connect(url); testDBErr != nil {
+			return
+		}
+		testDBErr = database.Database.Migrate(context.Background())
+	})
+	if testDBErr != nil {
+		t.Fatalf("test database: %v", testDBErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// testTelegramID возвращает уникальный telegram_id, чтобы тесты не мешали друг другу
+func testTelegramID() int64 {
+	return time.Now().UnixNano() % 1_000_000_000_000
+}
+
+// createTestUser регистрирует пользователя с указанным telegram_id
+func createTestUser(t *testing.T, ctx context.Context, telegramID int64) *models.User {
+	t.Helper()
+
+	resp, err := NewUserService().CreateOrUpdateUser(ctx, &models.CreateUserRequest{
+		TelegramID: fmt.Sprint(telegramID),
+		FirstName:  "Test",
+	}, 0)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return resp.User
+}
+
+// createTestPlan создает активный план с ценой в рублях и (если priceStars > 0) в Stars
+func createTestPlan(t *testing.T, ctx context.Context, tokens int, price float64, priceStars int) *models.SubscriptionPlan {
+	t.Helper()
+
+	req := &models.CreatePlanRequest{
+		Name:        fmt.Sprintf("test-plan-%d", time.Now().UnixNano()),
+		Price:       price,
+		Currency:    "RUB",
+		TokenAmount: tokens,
+		Features:    []string{},
+		IsActive:    true,
+	}
+	if priceStars > 0 {
+		req.PriceStars = &priceStars
+	}
+	plan, err := NewAdminService().CreatePlan(ctx, req)
+	if err != nil {
+		t.Fatalf("create plan: %v", err)
+	}
+	return plan
+}
+
+// userTokenBalance возвращает текущий баланс пользователя
+func userTokenBalance(t *testing.T, ctx context.Context, userID int) int {
+	t.Helper()
+
+	var balance int
+	err := database.Database.Pool.QueryRow(ctx, `SELECT token_balance FROM users WHERE id = $1`, userID).Scan(&balance)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	return balance
+}
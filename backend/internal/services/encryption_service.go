@@ -0,0 +1,674 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/envelope"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// Виды зашифрованного содержимого; вид входит в aad и совпадает с таблицей
+// (версии промптов шифруются как промпты)
+const (
+	contentTableMessages = "conversation_messages"
+	contentTablePrompts  = "voice_prompts"
+	contentTableSessions = "voice_sessions"
+	contentTableMemory   = "memory_facts"
+)
+
+// encryptedColumn - зашифрованное поле таблицы и поле с идентификатором ключа данных.
+// Если задан hashColumn, в нем хранится хеш hashInput(текст) на ключе данных записи -
+// по нему ищутся дубли, не раскрывая текста.
+type encryptedColumn struct {
+	table      string
+	kind       string
+	column     string
+	keyColumn  string
+	scope      string
+	hashColumn string
+	hashInput  func(string) string
+}
+
+// encryptedContentTables - поля, которые шифрует и перешифровывает ReencryptContent, с условием
+// на строки, принадлежащие пользователю (базовые промпты не шифруются). Снимок промпта
+// шифруется как промпт, поэтому переносится в историю версий без перешифрования.
+var encryptedContentTables = []encryptedColumn{
+	{table: contentTableMessages, kind: contentTableMessages, column: "content", keyColumn: "content_key_id", scope: `user_id IS NOT NULL`},
+	{table: contentTablePrompts, kind: contentTablePrompts, column: "content", keyColumn: "content_key_id", scope: `user_id IS NOT NULL AND is_base = false`},
+	{table: "voice_prompt_versions", kind: contentTablePrompts, column: "content", keyColumn: "content_key_id", scope: `user_id IS NOT NULL`},
+	{table: contentTableSessions, kind: contentTableSessions, column: "context_summary", keyColumn: "summary_key_id", scope: `user_id IS NOT NULL AND context_summary IS NOT NULL`},
+	{
+		table: contentTableMemory, kind: contentTableMemory, column: "fact", keyColumn: "fact_data_key_id", scope: `user_id IS NOT NULL`,
+		hashColumn: "fact_key", hashInput: normalizeFactKey,
+	},
+}
+
+// currentDataKeyTTL - сколько текущий ключ пользователя живет в кеше: после ротации
+// на другом экземпляре сервиса новые записи не дольше этого времени идут старым ключом
+const currentDataKeyTTL = 5 * time.Minute
+
+// dataKeyCacheLimit - при превышении кеш развернутых ключей очищается целиком
+const dataKeyCacheLimit = 10000
+
+type cachedDataKey struct {
+	id       int
+	key      []byte
+	loadedAt time.Time
+}
+
+// dataKeyCache хранит развернутые ключи данных, чтобы не ходить в БД на каждое сообщение
+type dataKeyCache struct {
+	mu      sync.Mutex
+	keys    map[int][]byte
+	owners  map[int]int
+	current map[int]cachedDataKey
+}
+
+var dataKeys = &dataKeyCache{
+	keys:    map[int][]byte{},
+	owners:  map[int]int{},
+	current: map[int]cachedDataKey{},
+}
+
+func (c *dataKeyCache) get(keyID int) ([]byte, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[keyID]
+	return key, c.owners[keyID], ok
+}
+
+func (c *dataKeyCache) put(keyID int, userID int, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) >= dataKeyCacheLimit {
+		c.keys = map[int][]byte{}
+		c.owners = map[int]int{}
+	}
+	c.keys[keyID] = key
+	c.owners[keyID] = userID
+}
+
+func (c *dataKeyCache) getCurrent(userID int) (cachedDataKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.current[userID]
+	if !ok || time.Since(k.loadedAt) > currentDataKeyTTL {
+		return cachedDataKey{}, false
+	}
+	return k, true
+}
+
+func (c *dataKeyCache) putCurrent(userID int, keyID int, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.current) >= dataKeyCacheLimit {
+		c.current = map[int]cachedDataKey{}
+	}
+	c.current[userID] = cachedDataKey{id: keyID, key: key, loadedAt: time.Now()}
+}
+
+// forget удаляет из кеша ключи пользователя; nil - всех пользователей
+func (c *dataKeyCache) forget(userID *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if userID == nil {
+		c.keys = map[int][]byte{}
+		c.owners = map[int]int{}
+		c.current = map[int]cachedDataKey{}
+		return
+	}
+	delete(c.current, *userID)
+	for keyID, owner := range c.owners {
+		if owner == *userID {
+			delete(c.keys, keyID)
+			delete(c.owners, keyID)
+		}
+	}
+}
+
+func contentAAD(table string, userID int) string {
+	return table + ":" + strconv.Itoa(userID)
+}
+
+// currentDataKey возвращает действующий ключ данных пользователя, создавая его при первом обращении
+func currentDataKey(ctx context.Context, userID int) (int, []byte, error) {
+	if k, ok := dataKeys.getCurrent(userID); ok {
+		return k.id, k.key, nil
+	}
+
+	conn := database.Database.Pool
+
+	var keyID int
+	var masterID string
+	var wrapped []byte
+	err := conn.QueryRow(ctx, `
+		SELECT id, master_key_id, wrapped_key FROM user_data_keys
+		WHERE user_id = $1 AND retired_at IS NULL
+	`, userID).Scan(&keyID, &masterID, &wrapped)
+	if err != nil && err != pgx.ErrNoRows {
+		return 0, nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	if err == pgx.ErrNoRows {
+		key, err := envelope.NewDataKey()
+		if err != nil {
+			return 0, nil, err
+		}
+		masterID, wrapped, err = envelope.Default.Wrap(key)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+
+		// Ключ мог создать параллельный запрос - тогда берем его
+		err = conn.QueryRow(ctx, `
+			INSERT INTO user_data_keys (user_id, master_key_id, wrapped_key, created_at)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id) WHERE retired_at IS NULL DO NOTHING
+			RETURNING id
+		`, userID, masterID, wrapped).Scan(&keyID)
+		if err == pgx.ErrNoRows {
+			return currentDataKey(ctx, userID)
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to create data key: %w", err)
+		}
+
+		dataKeys.put(keyID, userID, key)
+		dataKeys.putCurrent(userID, keyID, key)
+		return keyID, key, nil
+	}
+
+	key, err := envelope.Default.Unwrap(masterID, wrapped)
+	if err != nil {
+		return 0, nil, err
+	}
+	dataKeys.put(keyID, userID, key)
+	dataKeys.putCurrent(userID, keyID, key)
+	return keyID, key, nil
+}
+
+// dataKeyByID возвращает ключ данных пользователя по идентификатору
+func dataKeyByID(ctx context.Context, userID int, keyID int) ([]byte, error) {
+	if key, owner, ok := dataKeys.get(keyID); ok && owner == userID {
+		return key, nil
+	}
+
+	var masterID string
+	var wrapped []byte
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT master_key_id, wrapped_key FROM user_data_keys WHERE id = $1 AND user_id = $2
+	`, keyID, userID).Scan(&masterID, &wrapped)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("data key not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+
+	key, err := envelope.Default.Unwrap(masterID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	dataKeys.put(keyID, userID, key)
+	return key, nil
+}
+
+// sealContent шифрует текст текущим ключом данных пользователя. Если мастер-ключи
+// не настроены, текст сохраняется открытым и keyID равен nil.
+func sealContent(ctx context.Context, userID int, table string, plaintext string) (string, *int, error) {
+	if envelope.Default == nil {
+		return plaintext, nil, nil
+	}
+
+	keyID, key, err := currentDataKey(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := envelope.Seal(key, plaintext, contentAAD(table, userID))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt content: %w", err)
+	}
+	return sealed, &keyID, nil
+}
+
+// openContent расшифровывает текст, сохраненный sealContent; keyID nil - текст открытый
+func openContent(ctx context.Context, userID int, table string, content string, keyID *int) (string, error) {
+	if keyID == nil {
+		return content, nil
+	}
+	if envelope.Default == nil {
+		return "", fmt.Errorf("content is encrypted but encryption keys are not configured")
+	}
+
+	key, err := dataKeyByID(ctx, userID, *keyID)
+	if err != nil {
+		return "", err
+	}
+	plain, err := envelope.Open(key, content, contentAAD(table, userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt content: %w", err)
+	}
+	return plain, nil
+}
+
+// sealOptionalContent - sealContent для необязательного поля: nil остается nil
+func sealOptionalContent(ctx context.Context, userID int, table string, plaintext *string) (*string, *int, error) {
+	if plaintext == nil {
+		return nil, nil, nil
+	}
+	sealed, keyID, err := sealContent(ctx, userID, table, *plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sealed, keyID, nil
+}
+
+// openOptionalContent - openContent для необязательного поля: nil остается nil
+func openOptionalContent(ctx context.Context, userID int, table string, content *string, keyID *int) (*string, error) {
+	if content == nil {
+		return nil, nil
+	}
+	plain, err := openContent(ctx, userID, table, *content, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &plain, nil
+}
+
+// termHashSize - длина хеша термина в байтах (в hex вдвое длиннее)
+const termHashSize = 16
+
+// termKey выводит из ключа данных ключ для хеширования терминов: поисковых слов
+// и ключей дублей. С удалением ключа данных хеши теряют смысл вместе с шифротекстом.
+func termKey(dataKey []byte) []byte {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("search-terms"))
+	return mac.Sum(nil)
+}
+
+// hashTerm возвращает хеш термина на ключе key. Хеш не раскрывает слово без ключа данных
+// и совпадает для одинаковых слов одного ключа, поэтому по нему работают индексы.
+func hashTerm(key []byte, term string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(term))
+	return hex.EncodeToString(mac.Sum(nil)[:termHashSize])
+}
+
+// contentTermKey возвращает ключ хеширования терминов для записи на ключе данных keyID
+func contentTermKey(ctx context.Context, userID int, keyID int) ([]byte, error) {
+	key, err := dataKeyByID(ctx, userID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return termKey(key), nil
+}
+
+// userTermKeys возвращает ключи хеширования терминов по всем ключам данных пользователя
+// (ключ данных -> ключ терминов): записи, еще не перешифрованные после ротации, хешированы
+// выведенными ключами. Ключи, которые не удалось развернуть, пропускаются.
+func userTermKeys(ctx context.Context, userID int) (map[int][]byte, error) {
+	if envelope.Default == nil {
+		return nil, nil
+	}
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id FROM user_data_keys WHERE user_id = $1 ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data keys: %w", err)
+	}
+	var keyIDs []int
+	for rows.Next() {
+		var keyID int
+		if err := rows.Scan(&keyID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get data keys: %w", err)
+	}
+
+	keys := make(map[int][]byte, len(keyIDs))
+	for _, keyID := range keyIDs {
+		key, err := contentTermKey(ctx, userID, keyID)
+		if err != nil {
+			log.Warnf("Failed to load data key %d of user %d: %v", keyID, userID, err)
+			continue
+		}
+		keys[keyID] = key
+	}
+	return keys, nil
+}
+
+// forgetDataKeys убирает из кеша ключи пользователя после их удаления
+func forgetDataKeys(userID int) {
+	dataKeys.forget(&userID)
+}
+
+type EncryptionService struct{}
+
+func NewEncryptionService() *EncryptionService {
+	return &EncryptionService{}
+}
+
+// GetStatus показывает, сколько данных зашифровано и сколько ждет перешифрования
+func (s *EncryptionService) GetStatus(ctx context.Context) (*models.EncryptionStatus, error) {
+	conn := database.Database.Pool
+
+	status := &models.EncryptionStatus{
+		Enabled: envelope.Default != nil,
+		Tables:  []models.EncryptionTableStatus{},
+	}
+	if envelope.Default != nil {
+		status.PrimaryKeyID = envelope.Default.Primary()
+	}
+
+	err := conn.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE retired_at IS NULL),
+		       COUNT(*) FILTER (WHERE retired_at IS NOT NULL),
+		       COUNT(*) FILTER (WHERE master_key_id <> $1),
+		       COUNT(*) FILTER (WHERE master_key_id <> $1 AND rewrap_failed_for = $1)
+		FROM user_data_keys
+	`, status.PrimaryKeyID).Scan(&status.DataKeys, &status.RetiredDataKeys, &status.KeysToRewrap, &status.KeysRewrapFailed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count data keys: %w", err)
+	}
+	if !status.Enabled {
+		status.KeysToRewrap = 0
+		status.KeysRewrapFailed = 0
+	}
+
+	for _, t := range encryptedContentTables {
+		ts := models.EncryptionTableStatus{Table: t.table}
+		err := conn.QueryRow(ctx, fmt.Sprintf(`
+			SELECT COUNT(*) FILTER (WHERE c.%[3]s IS NOT NULL),
+			       COUNT(*) FILTER (WHERE c.%[3]s IS NULL),
+			       COUNT(*) FILTER (WHERE k.retired_at IS NOT NULL),
+			       COUNT(*) FILTER (WHERE f.row_id IS NOT NULL)
+			FROM %[1]s c
+			LEFT JOIN user_data_keys k ON k.id = c.%[3]s
+			LEFT JOIN reencrypt_failures f
+			       ON f.table_name = '%[1]s' AND f.row_id = c.id AND f.key_id IS NOT DISTINCT FROM c.%[3]s
+			WHERE c.%[2]s
+		`, t.table, t.scope, t.keyColumn)).Scan(&ts.EncryptedRows, &ts.PlaintextRows, &ts.PendingRotation, &ts.FailedRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s rows: %w", t.table, err)
+		}
+		status.Tables = append(status.Tables, ts)
+	}
+
+	return status, nil
+}
+
+// RotateDataKeys выводит из работы текущие ключи данных пользователя (nil - всех пользователей).
+// Новые записи получают новый ключ, старые перешифровывает задача ReencryptContent.
+func (s *EncryptionService) RotateDataKeys(ctx context.Context, userID *int) (int64, error) {
+	if envelope.Default == nil {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+
+	result, err := database.Database.Pool.Exec(ctx, `
+		UPDATE user_data_keys SET retired_at = CURRENT_TIMESTAMP
+		WHERE retired_at IS NULL AND ($1::int IS NULL OR user_id = $1)
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retire data keys: %w", err)
+	}
+	dataKeys.forget(userID)
+
+	log.Infof("🔐 Retired %d data keys", result.RowsAffected())
+
+	return result.RowsAffected(), nil
+}
+
+// ReencryptContent выполняет ротацию: перезаворачивает ключи данных текущим мастер-ключом,
+// шифрует открытые записи и перешифровывает записи на выведенных ключах. За запуск
+// обрабатывается не больше batchSize записей каждой таблицы; выведенные ключи, на которые
+// больше ничего не ссылается, удаляются.
+func (s *EncryptionService) ReencryptContent(ctx context.Context, batchSize int) (int, error) {
+	if envelope.Default == nil {
+		return 0, nil
+	}
+
+	if err := s.rewrapDataKeys(ctx, batchSize); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, t := range encryptedContentTables {
+		count, err := reencryptTable(ctx, t, batchSize)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if count > 0 {
+			log.Infof("🔐 Re-encrypted %d %s rows", count, t.table)
+		}
+	}
+
+	// Отметки об ошибках удаленных или перешифрованных с тех пор строк больше не нужны
+	for _, t := range encryptedContentTables {
+		_, err := database.Database.Pool.Exec(ctx, fmt.Sprintf(`
+			DELETE FROM reencrypt_failures f
+			WHERE f.table_name = '%[1]s'
+			  AND NOT EXISTS (SELECT 1 FROM %[1]s c WHERE c.id = f.row_id AND c.%[2]s IS NOT DISTINCT FROM f.key_id)
+		`, t.table, t.keyColumn))
+		if err != nil {
+			return total, fmt.Errorf("failed to clean up %s re-encryption failures: %w", t.table, err)
+		}
+	}
+
+	// Выведенный ключ удаляется не сразу: другие экземпляры сервиса могут писать им,
+	// пока не истечет кеш текущего ключа. Поисковые векторы на удаленном ключе
+	// не мешают: запись уже перешифрована, и IndexMessages строит вектор заново.
+	var references strings.Builder
+	for _, t := range encryptedContentTables {
+		fmt.Fprintf(&references, " AND NOT EXISTS (SELECT 1 FROM %s WHERE %s = k.id)", t.table, t.keyColumn)
+	}
+	result, err := database.Database.Pool.Exec(ctx, `
+		DELETE FROM user_data_keys k
+		WHERE k.retired_at < CURRENT_TIMESTAMP - INTERVAL '1 hour'`+references.String())
+	if err != nil {
+		return total, fmt.Errorf("failed to delete retired data keys: %w", err)
+	}
+	if result.RowsAffected() > 0 {
+		log.Infof("🔐 Deleted %d retired data keys", result.RowsAffected())
+	}
+
+	return total, nil
+}
+
+// rewrapDataKeys заворачивает текущим мастер-ключом ключи, завернутые прежними.
+// Ключ, который не удалось развернуть (его мастер-ключ убран из конфигурации или запись
+// повреждена), отмечается и больше не выбирается, пока не сменится текущий мастер-ключ:
+// иначе такие ключи занимали бы весь пакет и остальные никогда не перезаворачивались бы.
+func (s *EncryptionService) rewrapDataKeys(ctx context.Context, batchSize int) error {
+	conn := database.Database.Pool
+	primary := envelope.Default.Primary()
+
+	rows, err := conn.Query(ctx, `
+		SELECT id, master_key_id, wrapped_key FROM user_data_keys
+		WHERE master_key_id <> $1 AND rewrap_failed_for IS DISTINCT FROM $1
+		ORDER BY id
+		LIMIT $2
+	`, primary, batchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get data keys to rewrap: %w", err)
+	}
+
+	type wrappedKey struct {
+		id       int
+		masterID string
+		wrapped  []byte
+	}
+	var keys []wrappedKey
+	for rows.Next() {
+		var k wrappedKey
+		if err := rows.Scan(&k.id, &k.masterID, &k.wrapped); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get data keys to rewrap: %w", err)
+	}
+
+	rewrapped, failed := 0, 0
+	for _, k := range keys {
+		key, err := envelope.Default.Unwrap(k.masterID, k.wrapped)
+		if err != nil {
+			log.Warnf("Failed to unwrap data key %d, skipping it until master key changes: %v", k.id, err)
+			_, err = conn.Exec(ctx, `
+				UPDATE user_data_keys SET rewrap_failed_for = $2, rewrap_error = $3, rewrap_failed_at = CURRENT_TIMESTAMP
+				WHERE id = $1
+			`, k.id, primary, err.Error())
+			if err != nil {
+				return fmt.Errorf("failed to record rewrap failure of data key %d: %w", k.id, err)
+			}
+			failed++
+			continue
+		}
+		masterID, wrapped, err := envelope.Default.Wrap(key)
+		if err != nil {
+			return fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		_, err = conn.Exec(ctx, `
+			UPDATE user_data_keys
+			SET master_key_id = $1, wrapped_key = $2,
+			    rewrap_failed_for = NULL, rewrap_error = NULL, rewrap_failed_at = NULL
+			WHERE id = $3 AND master_key_id = $4
+		`, masterID, wrapped, k.id, k.masterID)
+		if err != nil {
+			return fmt.Errorf("failed to rewrap data key %d: %w", k.id, err)
+		}
+		rewrapped++
+	}
+
+	if rewrapped > 0 {
+		log.Infof("🔐 Rewrapped %d data keys with master key %s", rewrapped, primary)
+	}
+	if failed > 0 {
+		log.Errorf("🔐 Failed to unwrap %d data keys, they are excluded from rewrapping", failed)
+	}
+
+	return nil
+}
+
+// reencryptTable шифрует текущими ключами открытые записи и записи на выведенных ключах
+// и пересчитывает хеш записи, если он есть. Запись, которую не удалось расшифровать,
+// отмечается в reencrypt_failures и больше не выбирается, пока не сменится ее ключ:
+// иначе такие записи занимали бы весь пакет и остальные никогда не перешифровывались бы.
+func reencryptTable(ctx context.Context, t encryptedColumn, batchSize int) (int, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, fmt.Sprintf(`
+		SELECT c.id, c.user_id, c.%[3]s, c.%[4]s FROM %[1]s c
+		WHERE %[2]s
+		  AND (c.%[4]s IS NULL
+		       OR c.%[4]s IN (SELECT id FROM user_data_keys WHERE retired_at IS NOT NULL))
+		  AND NOT EXISTS (
+		      SELECT 1 FROM reencrypt_failures f
+		      WHERE f.table_name = '%[1]s' AND f.row_id = c.id AND f.key_id IS NOT DISTINCT FROM c.%[4]s
+		  )
+		ORDER BY c.id
+		LIMIT $1
+	`, t.table, t.scope, t.column, t.keyColumn), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s rows to encrypt: %w", t.table, err)
+	}
+
+	type contentRow struct {
+		id      int
+		userID  int
+		content string
+		keyID   *int
+	}
+	var pending []contentRow
+	for rows.Next() {
+		var r contentRow
+		if err := rows.Scan(&r.id, &r.userID, &r.content, &r.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s row: %w", t.table, err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get %s rows to encrypt: %w", t.table, err)
+	}
+
+	count, failed := 0, 0
+	for _, r := range pending {
+		plain, err := openContent(ctx, r.userID, t.kind, r.content, r.keyID)
+		if err != nil {
+			log.Warnf("Failed to decrypt %s row %d, skipping it until its data key changes: %v", t.table, r.id, err)
+			_, err = conn.Exec(ctx, `
+				INSERT INTO reencrypt_failures (table_name, row_id, key_id, error, failed_at)
+				VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+				ON CONFLICT (table_name, row_id) DO UPDATE
+				SET key_id = EXCLUDED.key_id, error = EXCLUDED.error, failed_at = EXCLUDED.failed_at
+			`, t.table, r.id, r.keyID, err.Error())
+			if err != nil {
+				return count, fmt.Errorf("failed to record re-encryption failure of %s row %d: %w", t.table, r.id, err)
+			}
+			failed++
+			continue
+		}
+		sealed, keyID, err := sealContent(ctx, r.userID, t.kind, plain)
+		if err != nil {
+			return count, err
+		}
+
+		// Запись не трогаем, если ее успели изменить после чтения
+		if t.hashColumn == "" {
+			_, err = conn.Exec(ctx, fmt.Sprintf(`
+				UPDATE %s SET %s = $1, %s = $2
+				WHERE id = $3 AND %[3]s IS NOT DISTINCT FROM $4
+			`, t.table, t.column, t.keyColumn), sealed, keyID, r.id, r.keyID)
+		} else {
+			key, keyErr := contentTermKey(ctx, r.userID, *keyID)
+			if keyErr != nil {
+				return count, keyErr
+			}
+			_, err = conn.Exec(ctx, fmt.Sprintf(`
+				UPDATE %s SET %s = $1, %s = $2, %s = $5
+				WHERE id = $3 AND %[3]s IS NOT DISTINCT FROM $4
+			`, t.table, t.column, t.keyColumn, t.hashColumn), sealed, keyID, r.id, r.keyID, hashTerm(key, t.hashInput(plain)))
+
+			// Такая же запись уже есть на текущем ключе - эта лишняя
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				log.Infof("Deleting %s row %d: duplicate of a row on the current data key", t.table, r.id)
+				_, err = conn.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, t.table), r.id)
+			}
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to re-encrypt %s row %d: %w", t.table, r.id, err)
+		}
+		count++
+	}
+
+	if failed > 0 {
+		log.Errorf("🔐 Failed to decrypt %d %s rows, they are excluded from re-encryption", failed, t.table)
+	}
+
+	return count, nil
+}
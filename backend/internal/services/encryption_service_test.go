@@ -0,0 +1,326 @@
+package services
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/envelope"
+	"voice-ai-backend/internal/models"
+)
+
+var (
+	testTermKey  = termKey([]byte("0123456789abcdef0123456789abcdef"))
+	otherTermKey = termKey([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func TestHashedSearchVector(t *testing.T) {
+	lexemes := []searchLexeme{
+		{lexeme: "кот", positions: []int16{2, 5}},
+		{lexeme: "молок", positions: []int16{4}},
+	}
+
+	vector := hashedSearchVector(testTermKey, lexemes)
+	want := hashTerm(testTermKey, "кот") + ":2,5 " + hashTerm(testTermKey, "молок") + ":4"
+	if vector != want {
+		t.Fatalf("hashedSearchVector = %q, want %q", vector, want)
+	}
+	if strings.Contains(vector, "кот") || strings.Contains(vector, "молок") {
+		t.Fatalf("hashedSearchVector leaks lexemes: %q", vector)
+	}
+	if other := hashedSearchVector(otherTermKey, lexemes); other == vector {
+		t.Fatalf("hashedSearchVector does not depend on the data key")
+	}
+	if empty := hashedSearchVector(testTermKey, nil); empty != "" {
+		t.Fatalf("hashedSearchVector(nil) = %q, want empty vector", empty)
+	}
+}
+
+func TestHashSearchQuery(t *testing.T) {
+	h := func(lexeme string) string { return "'" + hashTerm(testTermKey, lexeme) + "'" }
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"single", `'кот'`, h("кот")},
+		{"operators kept", `'кот' & !'собак' | 'молок' <-> 'вкусн'`, h("кот") + " & !" + h("собак") + " | " + h("молок") + " <-> " + h("вкусн")},
+		{"quote escaped", `'it''s' & 'ok'`, h("it's") + " & " + h("ok")},
+		{"backslash escaped", `'a\\b'`, h(`a\b`)},
+		{"grouping", `( 'кот' | 'кошк' ) & 'сыт'`, "( " + h("кот") + " | " + h("кошк") + " ) & " + h("сыт")},
+		{"empty query", ``, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashSearchQuery(testTermKey, tt.query); got != tt.want {
+				t.Fatalf("hashSearchQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// useTestKeyring включает шифрование мастер-ключами spec на время теста
+func useTestKeyring(t *testing.T, spec string) {
+	t.Helper()
+
+	keyring, err := envelope.ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	previous := envelope.Default
+	envelope.Default = keyring
+	dataKeys.forget(nil)
+	t.Cleanup(func() {
+		envelope.Default = previous
+		dataKeys.forget(nil)
+	})
+}
+
+func testMasterKey(id string, seed string) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(seed, 32)[:32]))
+}
+
+func TestEncryptedRowsKeepNoPlaintext(t *testing.T) {
+	ctx := testDB(t)
+	useTestKeyring(t, testMasterKey("test-1", "k"))
+	conn := database.Database.Pool
+
+	user := createTestUser(t, ctx, testTelegramID())
+	sessionID := createTestSession(t, ctx, user.ID)
+	conversations := NewConversationService()
+
+	// Сообщения: в векторе только хеши, поиск по ним работает
+	var vector string
+	var searchKeyID, contentKeyID *int
+	err := conn.QueryRow(ctx, `
+		SELECT search_vector::text, search_key_id, content_key_id FROM conversation_messages
+		WHERE session_id = $1 AND message_type = 'user'
+	`, sessionID).Scan(&vector, &searchKeyID, &contentKeyID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if contentKeyID == nil || searchKeyID == nil || *searchKeyID != *contentKeyID {
+		t.Fatalf("search_key_id = %v, content_key_id = %v, want the same data key", searchKeyID, contentKeyID)
+	}
+	if vector == "" || strings.Contains(vector, "казан") || strings.Contains(vector, "отпуск") {
+		t.Fatalf("search vector of encrypted message = %q, want hashed terms", vector)
+	}
+
+	results, _, err := conversations.SearchMessages(ctx, user.ID, "Казань", nil, nil, nil, 10, 0)
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Snippet, "<mark>Казань</mark>") {
+		t.Fatalf("SearchMessages = %+v, want the encrypted message with highlighted match", results)
+	}
+
+	// Сводка сессии
+	if err := markSessionSummarized(ctx, sessionID, user.ID, &sessionSummary{Summary: "Пользователь едет в Казань"}, nil); err != nil {
+		t.Fatalf("markSessionSummarized: %v", err)
+	}
+	var storedSummary string
+	if err := conn.QueryRow(ctx, `SELECT context_summary FROM voice_sessions WHERE id = $1`, sessionID).Scan(&storedSummary); err != nil {
+		t.Fatalf("get summary: %v", err)
+	}
+	if strings.Contains(storedSummary, "Казань") {
+		t.Fatalf("context_summary stored in plaintext: %q", storedSummary)
+	}
+	sessions, err := NewSessionService().GetUserVoiceSessions(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatalf("GetUserVoiceSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ContextSummary == nil || *sessions[0].ContextSummary != "Пользователь едет в Казань" {
+		t.Fatalf("GetUserVoiceSessions = %+v, want decrypted summary", sessions)
+	}
+
+	// Факты памяти: текст зашифрован, ключ дублей - хеш, дубль находится
+	memory := NewMemoryService(nil)
+	fact, err := memory.CreateFact(ctx, &models.CreateMemoryFactRequest{UserID: user.ID, Fact: "Живет в Казани"})
+	if err != nil {
+		t.Fatalf("CreateFact: %v", err)
+	}
+	if fact.Fact != "Живет в Казани" {
+		t.Fatalf("CreateFact returned %q, want decrypted fact", fact.Fact)
+	}
+	var storedFact, storedKey string
+	if err := conn.QueryRow(ctx, `SELECT fact, fact_key FROM memory_facts WHERE id = $1`, fact.ID).Scan(&storedFact, &storedKey); err != nil {
+		t.Fatalf("get fact: %v", err)
+	}
+	if strings.Contains(storedFact, "Казани") || strings.Contains(storedKey, "казани") {
+		t.Fatalf("memory fact stored in plaintext: fact %q, key %q", storedFact, storedKey)
+	}
+
+	// После ротации дубль на выведенном ключе находится
+	if _, err := NewEncryptionService().RotateDataKeys(ctx, &user.ID); err != nil {
+		t.Fatalf("RotateDataKeys: %v", err)
+	}
+	again, err := memory.CreateFact(ctx, &models.CreateMemoryFactRequest{UserID: user.ID, Fact: "живет в казани."})
+	if err != nil {
+		t.Fatalf("CreateFact after rotation: %v", err)
+	}
+	if again.ID != fact.ID {
+		t.Fatalf("CreateFact after rotation created fact %d, want duplicate of %d", again.ID, fact.ID)
+	}
+
+	// Вектор из открытого текста (построенный до шифрования) перестраивается хешами
+	_, err = conn.Exec(ctx, `
+		UPDATE conversation_messages SET search_vector = to_tsvector('simple', 'Казань'), search_key_id = NULL
+		WHERE session_id = $1 AND message_type = 'user'
+	`, sessionID)
+	if err != nil {
+		t.Fatalf("store plaintext vector: %v", err)
+	}
+	if _, err := conversations.IndexMessages(ctx, 1000); err != nil {
+		t.Fatalf("IndexMessages: %v", err)
+	}
+	err = conn.QueryRow(ctx, `
+		SELECT search_vector::text, search_key_id, content_key_id FROM conversation_messages
+		WHERE session_id = $1 AND message_type = 'user'
+	`, sessionID).Scan(&vector, &searchKeyID, &contentKeyID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if strings.Contains(vector, "казан") || searchKeyID == nil || contentKeyID == nil || *searchKeyID != *contentKeyID {
+		t.Fatalf("reindexed vector %q with search_key_id = %v, content_key_id = %v, want hashed terms", vector, searchKeyID, contentKeyID)
+	}
+	results, _, err = conversations.SearchMessages(ctx, user.ID, "Казань", nil, nil, nil, 10, 0)
+	if err != nil {
+		t.Fatalf("SearchMessages after reindex: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("SearchMessages after reindex = %+v, want the message", results)
+	}
+}
+
+func TestRewrapSkipsKeysThatFailToUnwrap(t *testing.T) {
+	ctx := testDB(t)
+	useTestKeyring(t, testMasterKey("test-1", "k"))
+	conn := database.Database.Pool
+
+	user := createTestUser(t, ctx, testTelegramID())
+	var keyID int
+	err := conn.QueryRow(ctx, `
+		INSERT INTO user_data_keys (user_id, master_key_id, wrapped_key, retired_at)
+		VALUES ($1, 'removed', 'broken', CURRENT_TIMESTAMP)
+		RETURNING id
+	`, user.ID).Scan(&keyID)
+	if err != nil {
+		t.Fatalf("insert data key: %v", err)
+	}
+
+	service := NewEncryptionService()
+	if err := service.rewrapDataKeys(ctx, 1000); err != nil {
+		t.Fatalf("rewrapDataKeys: %v", err)
+	}
+	var failedFor, rewrapError *string
+	err = conn.QueryRow(ctx, `
+		SELECT rewrap_failed_for, rewrap_error FROM user_data_keys WHERE id = $1
+	`, keyID).Scan(&failedFor, &rewrapError)
+	if err != nil {
+		t.Fatalf("get data key: %v", err)
+	}
+	if failedFor == nil || *failedFor != "test-1" || rewrapError == nil {
+		t.Fatalf("rewrap failure not recorded: failed_for %v, error %v", failedFor, rewrapError)
+	}
+
+	status, err := service.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	if status.KeysRewrapFailed < 1 {
+		t.Fatalf("GetStatus().KeysRewrapFailed = %d, want the broken key counted", status.KeysRewrapFailed)
+	}
+
+	// Следующий запуск ключ не выбирает: отметка об ошибке не меняется
+	if _, err := conn.Exec(ctx, `UPDATE user_data_keys SET rewrap_error = 'seen' WHERE id = $1`, keyID); err != nil {
+		t.Fatalf("mark data key: %v", err)
+	}
+	if err := service.rewrapDataKeys(ctx, 1000); err != nil {
+		t.Fatalf("rewrapDataKeys: %v", err)
+	}
+	if err := conn.QueryRow(ctx, `SELECT rewrap_error FROM user_data_keys WHERE id = $1`, keyID).Scan(&rewrapError); err != nil {
+		t.Fatalf("get data key: %v", err)
+	}
+	if rewrapError == nil || *rewrapError != "seen" {
+		t.Fatalf("key that failed to unwrap was retried: rewrap_error %v", rewrapError)
+	}
+}
+
+func TestReencryptSkipsRowsThatFailToDecrypt(t *testing.T) {
+	ctx := testDB(t)
+	useTestKeyring(t, testMasterKey("test-1", "k"))
+	conn := database.Database.Pool
+
+	user := createTestUser(t, ctx, testTelegramID())
+	sessionID := createTestSession(t, ctx, user.ID)
+	var brokenKeyID int
+	err := conn.QueryRow(ctx, `
+		INSERT INTO user_data_keys (user_id, master_key_id, wrapped_key, retired_at)
+		VALUES ($1, 'removed', 'broken', CURRENT_TIMESTAMP)
+		RETURNING id
+	`, user.ID).Scan(&brokenKeyID)
+	if err != nil {
+		t.Fatalf("insert data key: %v", err)
+	}
+
+	// Первое сообщение сессии не расшифровать, второе - открытый текст после него
+	var badID, plainID int
+	err = conn.QueryRow(ctx, `
+		SELECT MIN(id), MAX(id) FROM conversation_messages WHERE session_id = $1
+	`, sessionID).Scan(&badID, &plainID)
+	if err != nil {
+		t.Fatalf("get messages: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		UPDATE conversation_messages SET content = 'broken', content_key_id = $2 WHERE id = $1
+	`, badID, brokenKeyID); err != nil {
+		t.Fatalf("break message: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		UPDATE conversation_messages SET content = 'Отличный выбор!', content_key_id = NULL WHERE id = $1
+	`, plainID); err != nil {
+		t.Fatalf("store plaintext message: %v", err)
+	}
+
+	// Пакетами по одной записи: испорченная запись не должна занимать пакет вечно
+	messages := encryptedContentTables[0]
+	var plainKeyID *int
+	for i := 0; i < 1000; i++ {
+		if _, err := reencryptTable(ctx, messages, 1); err != nil {
+			t.Fatalf("reencryptTable: %v", err)
+		}
+		if err := conn.QueryRow(ctx, `SELECT content_key_id FROM conversation_messages WHERE id = $1`, plainID).Scan(&plainKeyID); err != nil {
+			t.Fatalf("get message: %v", err)
+		}
+		if plainKeyID != nil {
+			break
+		}
+	}
+	if plainKeyID == nil {
+		t.Fatalf("plaintext message behind an undecryptable one was never encrypted")
+	}
+
+	var failedKeyID *int
+	var failure string
+	err = conn.QueryRow(ctx, `
+		SELECT key_id, error FROM reencrypt_failures WHERE table_name = $1 AND row_id = $2
+	`, messages.table, badID).Scan(&failedKeyID, &failure)
+	if err != nil {
+		t.Fatalf("get re-encryption failure: %v", err)
+	}
+	if failedKeyID == nil || *failedKeyID != brokenKeyID || failure == "" {
+		t.Fatalf("re-encryption failure recorded with key %v, error %q; want key %d", failedKeyID, failure, brokenKeyID)
+	}
+
+	status, err := NewEncryptionService().GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	for _, ts := range status.Tables {
+		if ts.Table == messages.table && ts.FailedRows < 1 {
+			t.Fatalf("GetStatus() %s failed_rows = %d, want the broken row counted", ts.Table, ts.FailedRows)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/redact"
+	"voice-ai-backend/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Форматы выгрузки истории и их Content-Type
+var exportContentTypes = map[string]string{
+	"md":   "text/markdown; charset=utf-8",
+	"json": "application/json; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+	"srt":  "application/x-subrip; charset=utf-8",
+}
+
+// exportFlushEvery - через сколько сообщений отдавать накопленное клиенту при потоковой выгрузке
+const exportFlushEvery = 200
+
+// TranscriptExportFilter - что выгружать: одну сессию или период
+type TranscriptExportFilter struct {
+	UserID    int
+	SessionID *int
+	From      *time.Time
+	To        *time.Time
+	Format    string
+}
+
+type ExportService struct {
+	bot *telegram.Client
+}
+
+func NewExportService(bot *telegram.Client) *ExportService {
+	return &ExportService{
+		bot: bot,
+	}
+}
+
+// ExportContentType возвращает Content-Type формата выгрузки
+func ExportContentType(format string) string {
+	return exportContentTypes[format]
+}
+
+// ExportFilename возвращает имя файла выгрузки
+func ExportFilename(filter *TranscriptExportFilter) string {
+	if filter.SessionID != nil {
+		return fmt.Sprintf("conversation-session-%d.%s", *filter.SessionID, filter.Format)
+	}
+	return fmt.Sprintf("conversation-%s.%s", time.Now().Format("2006-01-02"), filter.Format)
+}
+
+// PrepareExport проверяет параметры выгрузки до начала записи ответа:
+// после первого байта сообщить об ошибке статусом уже не получится
+func (s *ExportService) PrepareExport(ctx context.Context, filter *TranscriptExportFilter) error {
+	if _, ok := exportContentTypes[filter.Format]; !ok {
+		return fmt.Errorf("invalid format")
+	}
+
+	conn := database.Database.Pool
+
+	if filter.SessionID != nil {
+		var exists bool
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM voice_sessions WHERE id = $1 AND user_id = $2)
+		`, *filter.SessionID, filter.UserID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check session: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("session not found")
+		}
+	}
+
+	var hasMessages bool
+	err := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_messages
+			WHERE user_id = $1
+			  AND ($2::int IS NULL OR session_id = $2)
+			  AND ($3::timestamp IS NULL OR created_at >= $3)
+			  AND ($4::timestamp IS NULL OR created_at < $4)
+		)
+	`, filter.UserID, filter.SessionID, filter.From, filter.To).Scan(&hasMessages)
+	if err != nil {
+		return fmt.Errorf("failed to check messages: %w", err)
+	}
+	if !hasMessages {
+		return fmt.Errorf("no messages to export")
+	}
+
+	return nil
+}
+
+// WriteTranscript потоково записывает историю в w: сообщения читаются из БД курсором
+// и сразу форматируются, поэтому размер выгрузки не ограничен памятью.
+func (s *ExportService) WriteTranscript(ctx context.Context, filter *TranscriptExportFilter, w io.Writer) error {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id, user_id, session_id, message_type, content, content_key_id,
+		       COALESCE(audio_duration_seconds, 0), created_at
+		FROM conversation_messages
+		WHERE user_id = $1
+		  AND ($2::int IS NULL OR session_id = $2)
+		  AND ($3::timestamp IS NULL OR created_at >= $3)
+		  AND ($4::timestamp IS NULL OR created_at < $4)
+		ORDER BY created_at, id
+	`, filter.UserID, filter.SessionID, filter.From, filter.To)
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	buf := bufio.NewWriterSize(w, 32*1024)
+	flusher, _ := w.(http.Flusher)
+
+	writer := newTranscriptWriter(filter, buf)
+	if err := writer.begin(); err != nil {
+		return err
+	}
+
+	count := 0
+	for rows.Next() {
+		var m models.ConversationMessage
+		var keyID *int
+		err := rows.Scan(&m.ID, &m.UserID, &m.SessionID, &m.MessageType, &m.Content, &keyID, &m.AudioDurationSeconds, &m.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		if m.Content, err = openContent(ctx, m.UserID, contentTableMessages, m.Content, keyID); err != nil {
+			return err
+		}
+		// Владельцу истории зашифрованные фрагменты отдаются расшифрованными
+		m.Content = redact.Default.Reveal(m.Content)
+		if err := writer.message(&m); err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			if err := buf.Flush(); err != nil {
+				return fmt.Errorf("failed to write export: %w", err)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	if err := writer.end(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// SendTranscript отправляет выгрузку файлом в Telegram-чат пользователя.
+// Файл формируется потоково прямо в тело запроса к Bot API.
+func (s *ExportService) SendTranscript(ctx context.Context, filter *TranscriptExportFilter) error {
+	if !s.bot.Enabled() {
+		return fmt.Errorf("telegram bot is not configured")
+	}
+	if err := s.PrepareExport(ctx, filter); err != nil {
+		return err
+	}
+
+	var telegramID string
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT telegram_id FROM users WHERE id = $1
+	`, filter.UserID).Scan(&telegramID)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.WriteTranscript(ctx, filter, writer))
+	}()
+
+	filename := ExportFilename(filter)
+	err = s.bot.SendDocument(ctx, telegramID, filename, reader, "📄 История разговора")
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to send export: %w", err)
+	}
+
+	log.Infof("📄 Sent conversation export %s to user %d", filename, filter.UserID)
+
+	return nil
+}
+
+type transcriptWriter interface {
+	begin() error
+	message(m *models.ConversationMessage) error
+	end() error
+}
+
+func newTranscriptWriter(filter *TranscriptExportFilter, w *bufio.Writer) transcriptWriter {
+	switch filter.Format {
+	case "json":
+		return &jsonTranscriptWriter{w: w, filter: filter}
+	case "txt":
+		return &textTranscriptWriter{w: w}
+	case "srt":
+		return &srtTranscriptWriter{w: w}
+	}
+	return &markdownTranscriptWriter{w: w, filter: filter}
+}
+
+// exportPeriod описывает, что попало в выгрузку
+func exportPeriod(filter *TranscriptExportFilter) string {
+	if filter.SessionID != nil {
+		return fmt.Sprintf("Сессия %d", *filter.SessionID)
+	}
+	switch {
+	case filter.From != nil && filter.To != nil:
+		return fmt.Sprintf("%s - %s", filter.From.Format("2006-01-02"), filter.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	case filter.From != nil:
+		return "С " + filter.From.Format("2006-01-02")
+	case filter.To != nil:
+		return "По " + filter.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	return "Вся история"
+}
+
+type markdownTranscriptWriter struct {
+	w      *bufio.Writer
+	filter *TranscriptExportFilter
+}
+
+func (t *markdownTranscriptWriter) begin() error {
+	_, err := fmt.Fprintf(t.w, "# История разговора\n\n_%s · выгружено %s_\n\n", exportPeriod(t.filter), time.Now().Format("2006-01-02 15:04"))
+	return err
+}
+
+func (t *markdownTranscriptWriter) message(m *models.ConversationMessage) error {
+	_, err := fmt.Fprintf(t.w, "**%s** · %s\n\n%s\n\n", transcriptRoleName(m.MessageType), m.CreatedAt.Format("2006-01-02 15:04:05"), m.Content)
+	return err
+}
+
+func (t *markdownTranscriptWriter) end() error {
+	return nil
+}
+
+type textTranscriptWriter struct {
+	w *bufio.Writer
+}
+
+func (t *textTranscriptWriter) begin() error {
+	return nil
+}
+
+func (t *textTranscriptWriter) message(m *models.ConversationMessage) error {
+	_, err := fmt.Fprintf(t.w, "[%s] %s: %s\n", m.CreatedAt.Format("2006-01-02 15:04:05"), transcriptRoleName(m.MessageType), m.Content)
+	return err
+}
+
+func (t *textTranscriptWriter) end() error {
+	return nil
+}
+
+type jsonTranscriptWriter struct {
+	w      *bufio.Writer
+	filter *TranscriptExportFilter
+	count  int
+}
+
+func (t *jsonTranscriptWriter) begin() error {
+	header, err := json.Marshal(map[string]interface{}{
+		"user_id":     t.filter.UserID,
+		"session_id":  t.filter.SessionID,
+		"from":        t.filter.From,
+		"to":          t.filter.To,
+		"exported_at": time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode export header: %w", err)
+	}
+
+	// Заголовок дополняется массивом messages, который пишется по одному сообщению
+	_, err = t.w.Write(header[:len(header)-1])
+	if err == nil {
+		_, err = t.w.WriteString(`,"messages":[`)
+	}
+	return err
+}
+
+func (t *jsonTranscriptWriter) message(m *models.ConversationMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if t.count > 0 {
+		if err := t.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	t.count++
+	_, err = t.w.Write(data)
+	return err
+}
+
+func (t *jsonTranscriptWriter) end() error {
+	_, err := t.w.WriteString("]}\n")
+	return err
+}
+
+// srtBlankLines - пустые строки внутри субтитра разорвали бы блок SRT
+var srtBlankLines = regexp.MustCompile(`\n\s*\n`)
+
+// srtTranscriptWriter размечает реплики по времени: каждая начинается там, где закончилась
+// предыдущая, и длится audio_duration_seconds. Для реплик без записи звука длительность
+// оценивается по длине текста (~15 символов в секунду, не меньше 2 секунд).
+type srtTranscriptWriter struct {
+	w       *bufio.Writer
+	index   int
+	elapsed time.Duration
+}
+
+func (t *srtTranscriptWriter) begin() error {
+	return nil
+}
+
+func (t *srtTranscriptWriter) message(m *models.ConversationMessage) error {
+	duration := time.Duration(m.AudioDurationSeconds) * time.Second
+	if duration <= 0 {
+		duration = time.Duration(utf8.RuneCountInString(m.Content)) * time.Second / 15
+		if duration < 2*time.Second {
+			duration = 2 * time.Second
+		}
+	}
+
+	start := t.elapsed
+	t.elapsed += duration
+	t.index++
+
+	text := srtBlankLines.ReplaceAllString(strings.TrimSpace(m.Content), "\n")
+	_, err := fmt.Fprintf(t.w, "%d\n%s --> %s\n%s: %s\n\n",
+		t.index, formatSRTTime(start), formatSRTTime(t.elapsed), transcriptRoleName(m.MessageType), text)
+	return err
+}
+
+func (t *srtTranscriptWriter) end() error {
+	return nil
+}
+
+// formatSRTTime форматирует смещение как ЧЧ:ММ:СС,ммм
+func formatSRTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,627 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/llm"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// Источники фактов памяти
+const (
+	MemorySourceExtracted = "extracted"
+	MemorySourceUser      = "user"
+)
+
+// Категории фактов памяти
+var memoryCategories = map[string]bool{
+	"profile":    true,
+	"preference": true,
+	"goal":       true,
+	"other":      true,
+}
+
+const (
+	memoryFactMaxLength       = 300
+	memoryMaxTranscriptTokens = 6000
+	memoryMaxAttempts         = 3
+)
+
+const factExtractionPrompt = `Ты ведешь долговременную память голосового ассистента. Выпиши из разговора
+устойчивые факты о пользователе, которые пригодятся в будущих разговорах: имя, возраст, город, работа,
+семья, предпочтения, цели и текущие задачи.
+
+Правила:
+- Только факты о самом пользователе, явно следующие из его слов
+- Не повторяй уже известные факты
+- Каждый факт - одно короткое утверждение в третьем лице на языке разговора
+- category: profile, preference, goal или other
+- importance: 3 - важно всегда (имя, ключевые обстоятельства), 2 - полезно, 1 - мелочь
+
+Верни только JSON: {"facts": [{"fact": "...", "category": "...", "importance": 1}]}`
+
+// ExtractedFact - факт, найденный в расшифровке разговора
+type ExtractedFact struct {
+	Fact       string `json:"fact"`
+	Category   string `json:"category"`
+	Importance int    `json:"importance"`
+}
+
+// FactExtractor извлекает факты о пользователе из расшифровки разговора
+type FactExtractor interface {
+	ExtractFacts(ctx context.Context, transcript string, known []string) ([]ExtractedFact, error)
+}
+
+// LLMFactExtractor извлекает факты с помощью текстовой модели
+type LLMFactExtractor struct {
+	completer llm.Completer
+}
+
+func NewLLMFactExtractor(completer llm.Completer) *LLMFactExtractor {
+	return &LLMFactExtractor{
+		completer: completer,
+	}
+}
+
+func (e *LLMFactExtractor) ExtractFacts(ctx context.Context, transcript string, known []string) ([]ExtractedFact, error) {
+	var prompt strings.Builder
+	if len(known) > 0 {
+		prompt.WriteString("УЖЕ ИЗВЕСТНО:\n- ")
+		prompt.WriteString(strings.Join(known, "\n- "))
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("РАЗГОВОР:\n")
+	prompt.WriteString(transcript)
+
+	reply, err := e.completer.Complete(ctx, &llm.CompletionRequest{
+		Model:     config.AppConfig.SummaryModel,
+		System:    factExtractionPrompt,
+		Prompt:    prompt.String(),
+		MaxTokens: 500,
+		JSON:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Facts []ExtractedFact `json:"facts"`
+	}
+	if err := json.Unmarshal([]byte(trimJSONReply(reply)), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode extracted facts: %w", err)
+	}
+	return result.Facts, nil
+}
+
+type MemoryService struct {
+	extractor FactExtractor
+}
+
+func NewMemoryService(extractor FactExtractor) *MemoryService {
+	return &MemoryService{
+		extractor: extractor,
+	}
+}
+
+// normalizeFactKey приводит факт к ключу для поиска дублей
+func normalizeFactKey(fact string) string {
+	key := strings.ToLower(strings.Join(strings.Fields(fact), " "))
+	return strings.TrimRight(key, ".!;")
+}
+
+// validateFact проверяет текст и категорию факта, пустая категория - other
+func validateFact(fact string, category string) (string, string, error) {
+	fact = strings.TrimSpace(fact)
+	if fact == "" {
+		return "", "", fmt.Errorf("invalid fact")
+	}
+	if len([]rune(fact)) > memoryFactMaxLength {
+		return "", "", fmt.Errorf("fact is too long")
+	}
+
+	if category == "" {
+		category = "other"
+	}
+	if !memoryCategories[category] {
+		return "", "", fmt.Errorf("invalid category")
+	}
+
+	return fact, category, nil
+}
+
+// sealFact шифрует факт ключом данных пользователя и возвращает ключ дублей для него:
+// хеш нормализованного текста на том же ключе данных, без шифрования - сам нормализованный текст
+func sealFact(ctx context.Context, userID int, text string) (string, *int, string, error) {
+	sealed, keyID, err := sealContent(ctx, userID, contentTableMemory, text)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if keyID == nil {
+		return sealed, nil, normalizeFactKey(text), nil
+	}
+	key, err := contentTermKey(ctx, userID, *keyID)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return sealed, keyID, hashTerm(key, normalizeFactKey(text)), nil
+}
+
+// factKeys возвращает все ключи дублей, под которыми мог быть сохранен факт: нормализованный
+// текст (факт сохранен без шифрования) и его хеши на ключах данных пользователя
+func factKeys(termKeys map[int][]byte, text string) []string {
+	normalized := normalizeFactKey(text)
+	keys := []string{normalized}
+	for _, key := range termKeys {
+		keys = append(keys, hashTerm(key, normalized))
+	}
+	return keys
+}
+
+const memoryFactColumns = `id, user_id, fact, fact_data_key_id, category, importance, source, session_id, mentions, created_at, updated_at, last_seen_at`
+
+// scanMemoryFact читает факт и расшифровывает его текст
+func scanMemoryFact(ctx context.Context, row pgx.Row, f *models.MemoryFact) error {
+	var keyID *int
+	err := row.Scan(
+		&f.ID, &f.UserID, &f.Fact, &keyID, &f.Category, &f.Importance, &f.Source, &f.SessionID,
+		&f.Mentions, &f.CreatedAt, &f.UpdatedAt, &f.LastSeenAt,
+	)
+	if err != nil {
+		return err
+	}
+	f.Fact, err = openContent(ctx, f.UserID, contentTableMemory, f.Fact, keyID)
+	return err
+}
+
+// memoryRelevanceOrder - порядок фактов по важности: сначала важные и подтвержденные
+// пользователем, затем часто упоминаемые и недавние
+const memoryRelevanceOrder = `importance DESC, (source = 'user') DESC, mentions DESC, last_seen_at DESC, id`
+
+// memoryEvictionOrder - обратный memoryRelevanceOrder порядок: первым идет наименее важный факт
+const memoryEvictionOrder = `importance, (source = 'user'), mentions, last_seen_at, id DESC`
+
+// GetFacts возвращает факты пользователя в порядке важности
+func (s *MemoryService) GetFacts(ctx context.Context, userID int) ([]models.MemoryFact, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT `+memoryFactColumns+`
+		FROM memory_facts
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY `+memoryRelevanceOrder, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query memory facts: %w", err)
+	}
+	defer rows.Close()
+
+	facts := []models.MemoryFact{}
+	for rows.Next() {
+		var fact models.MemoryFact
+		if err := scanMemoryFact(ctx, rows, &fact); err != nil {
+			return nil, fmt.Errorf("failed to scan memory fact: %w", err)
+		}
+		facts = append(facts, fact)
+	}
+
+	return facts, nil
+}
+
+// CreateFact добавляет факт от имени пользователя. Ранее удаленный такой же факт восстанавливается.
+func (s *MemoryService) CreateFact(ctx context.Context, req *models.CreateMemoryFactRequest) (*models.MemoryFact, error) {
+	text, category, err := validateFact(req.Fact, req.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := database.Database.Pool
+
+	var count int
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM memory_facts WHERE user_id = $1 AND deleted_at IS NULL
+	`, req.UserID).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count memory facts: %w", err)
+	}
+	if count >= config.AppConfig.MemoryMaxFacts {
+		return nil, fmt.Errorf("memory is full")
+	}
+
+	sealed, keyID, key, err := sealFact(ctx, req.UserID, text)
+	if err != nil {
+		return nil, err
+	}
+	termKeys, err := userTermKeys(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Такой же факт мог быть сохранен на другом ключе данных (до ротации) - тогда
+	// обновляется он; предпочтение записи на текущем ключе, чтобы ключ дублей не совпал
+	var fact models.MemoryFact
+	err = scanMemoryFact(ctx, conn.QueryRow(ctx, `
+		UPDATE memory_facts SET
+			fact = $3,
+			fact_data_key_id = $4,
+			fact_key = $5,
+			category = $6,
+			source = $7,
+			deleted_at = NULL,
+			updated_at = CURRENT_TIMESTAMP,
+			last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM memory_facts
+			WHERE user_id = $1 AND fact_key = ANY($2)
+			ORDER BY (fact_key = $5) DESC, deleted_at NULLS FIRST, id
+			LIMIT 1
+		)
+		RETURNING `+memoryFactColumns,
+		req.UserID, factKeys(termKeys, text), sealed, keyID, key, category, MemorySourceUser), &fact)
+	if err == pgx.ErrNoRows {
+		err = scanMemoryFact(ctx, conn.QueryRow(ctx, `
+			INSERT INTO memory_facts (user_id, fact, fact_data_key_id, fact_key, category, importance, source, created_at, updated_at, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, 2, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, fact_key) DO UPDATE SET
+				fact = EXCLUDED.fact,
+				fact_data_key_id = EXCLUDED.fact_data_key_id,
+				category = EXCLUDED.category,
+				source = EXCLUDED.source,
+				deleted_at = NULL,
+				updated_at = CURRENT_TIMESTAMP,
+				last_seen_at = CURRENT_TIMESTAMP
+			RETURNING `+memoryFactColumns,
+			req.UserID, sealed, keyID, key, category, MemorySourceUser), &fact)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory fact: %w", err)
+	}
+
+	return &fact, nil
+}
+
+// UpdateFact изменяет текст или категорию факта; факт считается подтвержденным пользователем
+func (s *MemoryService) UpdateFact(ctx context.Context, req *models.UpdateMemoryFactRequest) (*models.MemoryFact, error) {
+	conn := database.Database.Pool
+
+	var current models.MemoryFact
+	err := scanMemoryFact(ctx, conn.QueryRow(ctx, `
+		SELECT `+memoryFactColumns+` FROM memory_facts
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, req.FactID, req.UserID), &current)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("fact not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory fact: %w", err)
+	}
+
+	text, category := current.Fact, current.Category
+	if req.Fact != nil {
+		text = *req.Fact
+	}
+	if req.Category != nil {
+		category = *req.Category
+	}
+	text, category, err = validateFact(text, category)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, keyID, key, err := sealFact(ctx, req.UserID, text)
+	if err != nil {
+		return nil, err
+	}
+	termKeys, err := userTermKeys(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	keys := factKeys(termKeys, text)
+
+	// Пользователь сам вернул ранее удаленный факт - отметка об удалении больше не нужна
+	_, err = conn.Exec(ctx, `
+		DELETE FROM memory_facts
+		WHERE user_id = $1 AND fact_key = ANY($2) AND id != $3 AND deleted_at IS NOT NULL
+	`, req.UserID, keys, req.FactID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear deleted memory fact: %w", err)
+	}
+
+	// Такой же факт на другом ключе данных не нарушает уникальность ключа дублей
+	var duplicate bool
+	err = conn.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM memory_facts WHERE user_id = $1 AND fact_key = ANY($2) AND id != $3)
+	`, req.UserID, keys, req.FactID).Scan(&duplicate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check memory fact: %w", err)
+	}
+	if duplicate {
+		return nil, fmt.Errorf("fact already exists")
+	}
+
+	var fact models.MemoryFact
+	err = scanMemoryFact(ctx, conn.QueryRow(ctx, `
+		UPDATE memory_facts
+		SET fact = $3, fact_data_key_id = $4, fact_key = $5, category = $6, source = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+memoryFactColumns,
+		req.FactID, req.UserID, sealed, keyID, key, category, MemorySourceUser), &fact)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, fmt.Errorf("fact already exists")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update memory fact: %w", err)
+	}
+
+	return &fact, nil
+}
+
+// DeleteFact удаляет факт. Запись остается помеченной, чтобы факт не был извлечен повторно.
+func (s *MemoryService) DeleteFact(ctx context.Context, userID int, factID int) error {
+	result, err := database.Database.Pool.Exec(ctx, `
+		UPDATE memory_facts SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, factID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete memory fact: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("fact not found")
+	}
+	return nil
+}
+
+// ExtractPendingSessions извлекает факты из сессий, по которым уже составлена сводка.
+// Ошибка по одной сессии не останавливает пакет - сессия будет повторена в следующий запуск.
+func (s *MemoryService) ExtractPendingSessions(ctx context.Context, batchSize int) (int, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id FROM voice_sessions
+		WHERE summarized_at IS NOT NULL
+		  AND facts_extracted_at IS NULL
+		  AND user_id IS NOT NULL
+		  AND facts_attempts < $1
+		  AND created_at > CURRENT_TIMESTAMP - INTERVAL '7 days'
+		ORDER BY summarized_at
+		LIMIT $2
+	`, memoryMaxAttempts, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find sessions for fact extraction: %w", err)
+	}
+	var sessionIDs []int
+	for rows.Next() {
+		var sessionID int
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan session id: %w", err)
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	rows.Close()
+
+	totalFacts := 0
+	for _, sessionID := range sessionIDs {
+		added, err := s.ExtractSessionFacts(ctx, sessionID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return totalFacts, err
+			}
+			log.Warnf("Failed to extract memory facts from session %d: %v", sessionID, err)
+			continue
+		}
+		totalFacts += added
+	}
+
+	if totalFacts > 0 {
+		log.Infof("🧠 Extracted %d memory facts from %d sessions", totalFacts, len(sessionIDs))
+	}
+
+	return totalFacts, nil
+}
+
+// ExtractSessionFacts сохраняет факты из расшифровки сессии и возвращает число новых фактов.
+// Повторно найденный факт увеличивает счетчик упоминаний; удаленные пользователем факты не возвращаются.
+func (s *MemoryService) ExtractSessionFacts(ctx context.Context, sessionID int) (int, error) {
+	conn := database.Database.Pool
+
+	var userID int
+	err := conn.QueryRow(ctx, `
+		SELECT user_id FROM voice_sessions
+		WHERE id = $1 AND user_id IS NOT NULL AND facts_extracted_at IS NULL
+	`, sessionID).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get voice session: %w", err)
+	}
+
+	messages, err := loadSessionTranscript(ctx, sessionID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var extracted []ExtractedFact
+	if len(messages) > 0 {
+		known, err := s.GetFacts(ctx, userID)
+		if err != nil {
+			return 0, err
+		}
+		knownFacts := make([]string, 0, len(known))
+		for _, fact := range known {
+			knownFacts = append(knownFacts, fact.Fact)
+		}
+
+		extracted, err = s.extractor.ExtractFacts(ctx, formatTranscript(messages, memoryMaxTranscriptTokens), knownFacts)
+		if err != nil {
+			if _, updateErr := conn.Exec(ctx, `
+				UPDATE voice_sessions SET facts_attempts = facts_attempts + 1 WHERE id = $1
+			`, sessionID); updateErr != nil {
+				log.Warnf("Failed to record fact extraction attempt for session %d: %v", sessionID, updateErr)
+			}
+			return 0, fmt.Errorf("failed to extract facts from session %d: %w", sessionID, err)
+		}
+	}
+
+	termKeys, err := userTermKeys(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var active int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM memory_facts WHERE user_id = $1 AND deleted_at IS NULL
+	`, userID).Scan(&active)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count memory facts: %w", err)
+	}
+
+	added := 0
+	for _, item := range extracted {
+		text, category, err := validateFact(item.Fact, item.Category)
+		if err != nil {
+			continue
+		}
+		importance := item.Importance
+		if importance < 1 {
+			importance = 1
+		} else if importance > 3 {
+			importance = 3
+		}
+		sealed, keyID, key, err := sealFact(ctx, userID, text)
+		if err != nil {
+			return 0, err
+		}
+		keys := append(factKeys(termKeys, text), key)
+
+		result, err := tx.Exec(ctx, `
+			UPDATE memory_facts
+			SET mentions = mentions + 1, importance = GREATEST(importance, $3), last_seen_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND fact_key = ANY($2) AND deleted_at IS NULL
+		`, userID, keys, importance)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update memory fact: %w", err)
+		}
+		if result.RowsAffected() > 0 {
+			continue
+		}
+
+		// Удаленный пользователем факт (на любом ключе данных) не добавляется снова
+		var factID int
+		err = tx.QueryRow(ctx, `
+			INSERT INTO memory_facts (user_id, fact, fact_data_key_id, fact_key, category, importance, source, session_id, created_at, updated_at, last_seen_at)
+			SELECT $1::int, $2::text, $3::int, $4::text, $5::text, $6::int, $7::text, $8::int, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			WHERE NOT EXISTS (SELECT 1 FROM memory_facts WHERE user_id = $1 AND fact_key = ANY($9))
+			ON CONFLICT (user_id, fact_key) DO NOTHING
+			RETURNING id
+		`, userID, sealed, keyID, key, category, importance, MemorySourceExtracted, sessionID, keys).Scan(&factID)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to save memory fact: %w", err)
+		}
+		active++
+		added++
+
+		if active <= config.AppConfig.MemoryMaxFacts {
+			continue
+		}
+		evictedID, err := evictMemoryFact(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+		if evictedID != 0 {
+			active--
+		}
+		if evictedID == factID {
+			added--
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE voice_sessions SET facts_extracted_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark session facts extracted: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return added, nil
+}
+
+// evictMemoryFact освобождает место в переполненной памяти: удаляет наименее важный
+// извлеченный факт (возможно, только что добавленный). Факты, добавленные пользователем,
+// не вытесняются. Удаление окончательное: в отличие от удаленного пользователем,
+// вытесненный факт может быть извлечен снова. Возвращает id удаленного факта или 0.
+func evictMemoryFact(ctx context.Context, tx pgx.Tx, userID int) (int, error) {
+	var factID int
+	err := tx.QueryRow(ctx, `
+		DELETE FROM memory_facts
+		WHERE id = (
+			SELECT id FROM memory_facts
+			WHERE user_id = $1 AND source = $2 AND deleted_at IS NULL
+			ORDER BY `+memoryEvictionOrder+`
+			LIMIT 1
+		)
+		RETURNING id
+	`, userID, MemorySourceExtracted).Scan(&factID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict memory fact: %w", err)
+	}
+	return factID, nil
+}
+
+// memoryPromptMaxFacts - сколько самых важных фактов предлагать в системный промпт;
+// сколько из них поместится, решает бюджет раздела памяти
+const memoryPromptMaxFacts = 50
+
+// loadMemoryFacts возвращает самые важные факты о пользователе для системного промпта.
+// Ошибка чтения памяти не мешает начать разговор.
+func loadMemoryFacts(ctx context.Context, userID int) []string {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT fact, fact_data_key_id FROM memory_facts
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY `+memoryRelevanceOrder+`
+		LIMIT $2
+	`, userID, memoryPromptMaxFacts)
+	if err != nil {
+		log.Warnf("Failed to get memory facts for user %d: %v", userID, err)
+		return nil
+	}
+	defer rows.Close()
+
+	var facts []string
+	for rows.Next() {
+		var fact string
+		var keyID *int
+		if err := rows.Scan(&fact, &keyID); err != nil {
+			continue
+		}
+		if fact, err = openContent(ctx, userID, contentTableMemory, fact, keyID); err != nil {
+			log.Warnf("Failed to decrypt memory fact of user %d: %v", userID, err)
+			continue
+		}
+		facts = append(facts, "- "+fact)
+	}
+
+	return facts
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/models"
+)
+
+// stubFactExtractor возвращает заранее заданные факты
+type stubFactExtractor struct {
+	facts []ExtractedFact
+}
+
+func (e *stubFactExtractor) ExtractFacts(ctx context.Context, transcript string, known []string) ([]ExtractedFact, error) {
+	return e.facts, nil
+}
+
+func TestMemoryEvictsLeastImportantExtractedFact(t *testing.T) {
+	ctx := testDB(t)
+
+	maxFacts := config.AppConfig.MemoryMaxFacts
+	config.AppConfig.MemoryMaxFacts = 3
+	t.Cleanup(func() { config.AppConfig.MemoryMaxFacts = maxFacts })
+
+	user := createTestUser(t, ctx, testTelegramID())
+	extractor := &stubFactExtractor{}
+	service := NewMemoryService(extractor)
+
+	_, err := service.CreateFact(ctx, &models.CreateMemoryFactRequest{UserID: user.ID, Fact: "Зовут Анна", Category: "profile"})
+	if err != nil {
+		t.Fatalf("CreateFact: %v", err)
+	}
+
+	extract := func(wantAdded int, facts ...ExtractedFact) {
+		t.Helper()
+		extractor.facts = facts
+		added, err := service.ExtractSessionFacts(ctx, createTestSession(t, ctx, user.ID))
+		if err != nil {
+			t.Fatalf("ExtractSessionFacts: %v", err)
+		}
+		if added != wantAdded {
+			t.Fatalf("ExtractSessionFacts added %d facts, want %d", added, wantAdded)
+		}
+	}
+	expectFacts := func(want ...string) {
+		t.Helper()
+		facts, err := service.GetFacts(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetFacts: %v", err)
+		}
+		var got []string
+		for _, fact := range facts {
+			got = append(got, fact.Fact)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("memory facts = %q, want %q", got, want)
+		}
+	}
+
+	extract(2,
+		ExtractedFact{Fact: "Любит чай", Category: "preference", Importance: 1},
+		ExtractedFact{Fact: "Живет в Казани", Category: "profile", Importance: 2},
+	)
+	expectFacts("Зовут Анна", "Любит чай", "Живет в Казани")
+
+	// Более важный факт вытесняет наименее важный извлеченный
+	extract(1, ExtractedFact{Fact: "Готовится к марафону", Category: "goal", Importance: 3})
+	expectFacts("Зовут Анна", "Живет в Казани", "Готовится к марафону")
+
+	// Новый факт менее важен всех сохраненных и сам оказывается вытесненным
+	extract(0, ExtractedFact{Fact: "Пьет кофе по утрам", Category: "preference", Importance: 1})
+	expectFacts("Зовут Анна", "Живет в Казани", "Готовится к марафону")
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Типы уведомлений в ленте
+const (
+	NotificationLowBalance    = "low_balance"
+	NotificationSpendingLimit = "spending_limit"
+	NotificationDataExport    = "data_export"
+	NotificationAccountDelete = "account_deletion"
+)
+
+type NotificationService struct {
+	bot *telegram.Client
+}
+
+func NewNotificationService(bot *telegram.Client) *NotificationService {
+	return &NotificationService{
+		bot: bot,
+	}
+}
+
+// notificationMaxAttempts - сколько раз задача пытается доставить уведомление в Telegram
+const notificationMaxAttempts = 5
+
+// Notify добавляет уведомление в ленту пользователя. Сообщением бота его доставит
+// задача deliver_notifications, поэтому вызывающий не ждет Telegram.
+func (s *NotificationService) Notify(ctx context.Context, userID int, kind string, message string) error {
+	return enqueueNotification(ctx, userID, kind, message)
+}
+
+func enqueueNotification(ctx context.Context, userID int, kind string, message string) error {
+	_, err := database.Database.Pool.Exec(ctx, `
+		INSERT INTO notifications (user_id, kind, message, delivery_status, created_at)
+		VALUES ($1, $2, $3, 'pending', CURRENT_TIMESTAMP)
+	`, userID, kind, message)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	return nil
+}
+
+// DeliverNotifications отправляет сообщением бота до batchSize недоставленных уведомлений.
+// Ошибка доставки не теряет уведомление: оно остается в ленте, а отправка повторяется
+// при следующем запуске, пока не кончатся попытки. Возвращает число доставленных.
+func (s *NotificationService) DeliverNotifications(ctx context.Context, batchSize int) (int, error) {
+	conn := database.Database.Pool
+
+	// Без бота доставлять некуда: уведомления остаются только в ленте
+	if !s.bot.Enabled() {
+		_, err := conn.Exec(ctx, `
+			UPDATE notifications SET delivery_status = 'skipped' WHERE delivery_status = 'pending'
+		`)
+		if err != nil {
+			return 0, fmt.Errorf("failed to skip notifications: %w", err)
+		}
+		return 0, nil
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT n.id, n.user_id, n.message, u.telegram_id
+		FROM notifications n
+		JOIN users u ON u.id = n.user_id
+		WHERE n.delivery_status = 'pending'
+		ORDER BY n.id
+		LIMIT $1
+	`, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query pending notifications: %w", err)
+	}
+
+	type pendingNotification struct {
+		id         int
+		userID     int
+		message    string
+		telegramID string
+	}
+	var pending []pendingNotification
+	for rows.Next() {
+		var n pendingNotification
+		if err := rows.Scan(&n.id, &n.userID, &n.message, &n.telegramID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		pending = append(pending, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query pending notifications: %w", err)
+	}
+
+	delivered := 0
+	for _, n := range pending {
+		if err := s.bot.SendMessage(ctx, n.telegramID, n.message); err != nil {
+			log.Warnf("Failed to deliver notification %d to user %d: %v", n.id, n.userID, err)
+			_, err = conn.Exec(ctx, `
+				UPDATE notifications
+				SET delivery_attempts = delivery_attempts + 1,
+				    delivery_status = CASE WHEN delivery_attempts + 1 >= $2 THEN 'failed' ELSE delivery_status END
+				WHERE id = $1
+			`, n.id, notificationMaxAttempts)
+			if err != nil {
+				return delivered, fmt.Errorf("failed to record delivery attempt: %w", err)
+			}
+			continue
+		}
+
+		_, err = conn.Exec(ctx, `
+			UPDATE notifications
+			SET delivery_status = 'sent', delivery_attempts = delivery_attempts + 1, delivered_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, n.id)
+		if err != nil {
+			return delivered, fmt.Errorf("failed to mark notification delivered: %w", err)
+		}
+		delivered++
+	}
+
+	if delivered > 0 {
+		log.Infof("📨 Delivered %d notifications", delivered)
+	}
+
+	return delivered, nil
+}
+
+// claimAlert отмечает, что предупреждение alertKey выдано в периоде periodKey.
+// Возвращает false, если в этом периоде оно уже было выдано.
+func claimAlert(ctx context.Context, userID int, alertKey string, periodKey string) (bool, error) {
+	var id int
+	err := database.Database.Pool.QueryRow(ctx, `
+		INSERT INTO token_alerts (user_id, alert_key, period_key, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, alert_key, period_key) DO NOTHING
+		RETURNING id
+	`, userID, alertKey, periodKey).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim alert: %w", err)
+	}
+	return true, nil
+}
+
+// checkTokenAlerts проверяет пороги остатка токенов и лимитов расхода после списания
+// и ставит предупреждения в ленту. Каждое предупреждение выдается один раз за период:
+// остаток - за подписку (или календарный месяц без подписки), лимиты - за день и месяц.
+func checkTokenAlerts(ctx context.Context, userID int) error {
+	conn := database.Database.Pool
+
+	limits, err := getSpendingLimits(ctx, conn, userID)
+	if err != nil {
+		return err
+	}
+	if !limits.AlertsEnabled {
+		return nil
+	}
+
+	account, err := resolveTokenAccount(ctx, conn, userID)
+	if err != nil {
+		return err
+	}
+
+	var balance int
+	err = conn.QueryRow(ctx, `SELECT token_balance FROM users WHERE id = $1`, account.payerID).Scan(&balance)
+	if err != nil {
+		return fmt.Errorf("failed to get token balance: %w", err)
+	}
+
+	// Пороги считаются от всех токенов подписки, включая перенесенный остаток
+	var subscriptionID, planTokens int
+	err = conn.QueryRow(ctx, `
+		SELECT us.id, `+planTokenAllowanceSQL+`
+		FROM user_subscriptions us
+		JOIN subscription_plans sp ON sp.id = us.plan_id
+		WHERE us.user_id = $1 AND us.status = 'active'
+		ORDER BY us.start_date DESC
+		LIMIT 1
+	`, account.payerID).Scan(&subscriptionID, &planTokens)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to get active subscription: %w", err)
+	}
+
+	now := time.Now()
+	balancePeriod := "month:" + now.Format("2006-01")
+	if subscriptionID != 0 {
+		balancePeriod = fmt.Sprintf("subscription:%d", subscriptionID)
+	}
+
+	// Если остаток сразу пересек несколько порогов, сообщаем только о самом низком
+	lowestPercent := 0
+	if planTokens > 0 {
+		for _, percent := range config.AppConfig.TokenAlertPercents {
+			if percent <= 0 || balance*100 > planTokens*percent {
+				continue
+			}
+			claimed, err := claimAlert(ctx, userID, fmt.Sprintf("balance_%d", percent), balancePeriod)
+			if err != nil {
+				return err
+			}
+			if claimed && (lowestPercent == 0 || percent < lowestPercent) {
+				lowestPercent = percent
+			}
+		}
+	}
+
+	spendable := account.spendable(balance)
+	if lowestPercent > 0 {
+		message := fmt.Sprintf("⚠️ Осталось меньше %d%% токенов плана: %d из %d.", lowestPercent, balance, planTokens)
+		if err := enqueueNotification(ctx, userID, NotificationLowBalance, message); err != nil {
+			return err
+		}
+	} else if spendable < config.AppConfig.MinTokenThreshold {
+		claimed, err := claimAlert(ctx, userID, "balance_min", balancePeriod)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			message := fmt.Sprintf("⚠️ Токены почти закончились: осталось %d. Пополните баланс, чтобы продолжить разговор.", spendable)
+			if err := enqueueNotification(ctx, userID, NotificationLowBalance, message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if limits.DailyLimit != nil {
+		err := checkLimitAlert(ctx, userID, "daily", "day:"+now.Format("2006-01-02"), limits.UsedToday, *limits.DailyLimit)
+		if err != nil {
+			return err
+		}
+	}
+	if limits.MonthlyLimit != nil {
+		err := checkLimitAlert(ctx, userID, "monthly", "month:"+now.Format("2006-01"), limits.UsedThisMonth, *limits.MonthlyLimit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkLimitAlert предупреждает о приближении к лимиту расхода и о его исчерпании
+func checkLimitAlert(ctx context.Context, userID int, limitName string, periodKey string, used int, limit int) error {
+	percent := used * 100 / limit
+	warningPercent := config.AppConfig.SpendingCapWarningPercent
+
+	var alertKey, message string
+	periodName := map[string]string{"daily": "дневного", "monthly": "месячного"}[limitName]
+	switch {
+	case percent >= 100:
+		alertKey = limitName + "_limit_reached"
+		message = fmt.Sprintf("⛔ Лимит %s расхода исчерпан: %d из %d токенов.", periodName, used, limit)
+	case warningPercent > 0 && percent >= warningPercent:
+		alertKey = fmt.Sprintf("%s_limit_%d", limitName, warningPercent)
+		message = fmt.Sprintf("📊 Израсходовано %d%% %s лимита: %d из %d токенов.", percent, periodName, used, limit)
+	default:
+		return nil
+	}
+
+	claimed, err := claimAlert(ctx, userID, alertKey, periodKey)
+	if err != nil || !claimed {
+		return err
+	}
+
+	return enqueueNotification(ctx, userID, NotificationSpendingLimit, message)
+}
+
+// GetNotifications возвращает ленту уведомлений пользователя и число непрочитанных
+func (s *NotificationService) GetNotifications(ctx context.Context, userID int, limit int, unreadOnly bool) ([]models.Notification, int, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT id, user_id, kind, message, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1 AND ($2 = false OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Message, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+
+	var unread int
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL
+	`, userID).Scan(&unread)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+
+	return notifications, unread, nil
+}
+
+// MarkNotificationsRead отмечает уведомления прочитанными (все, если ids пуст)
+func (s *NotificationService) MarkNotificationsRead(ctx context.Context, userID int, ids []int) (int64, error) {
+	if ids == nil {
+		ids = []int{}
+	}
+
+	result, err := database.Database.Pool.Exec(ctx, `
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::int[]) = 0 OR id = ANY($2))
+	`, userID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 	"voice-ai-backend/internal/config"
 	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/prompttmpl"
 
+	"github.com/jackc/pgx/v5"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -39,68 +43,52 @@ type OpenAITokenResponse struct {
 	} `json:"client_secret"`
 }
 
-// GetEphemeralToken получает ephemeral token для OpenAI Realtime API
-func (s *OpenAIService) GetEphemeralToken(ctx context.Context, userID *int) (map[string]interface{}, error) {
-	selectedVoice := "ash"
-	selectedModel := "gpt-realtime"
-	conversationHistory := ""
+// defaultRealtimeModel - модель Realtime API, если пользователь не выбрал другую
+const defaultRealtimeModel = "gpt-realtime"
 
-	// Если указан user_id, получаем его настройки
-	if userID != nil {
-		conn := database.Database.Pool
+// defaultVoice - голос, если пользователь не выбрал другой
+const defaultVoice = "ash"
 
-		// Получаем выбранный голос и модель
-		var voice, model *string
-		err := conn.QueryRow(ctx, `
-			SELECT selected_voice, selected_model FROM users WHERE id = $1
-		`, *userID).Scan(&voice, &model)
+// loadSessionSettings возвращает выбранные пользователем голос и модель
+func loadSessionSettings(ctx context.Context, userID int) (string, string) {
+	selectedVoice := defaultVoice
+	selectedModel := defaultRealtimeModel
 
-		if err == nil {
-			if voice != nil {
-				selectedVoice = *voice
-			}
-			if model != nil {
-				selectedModel = *model
-			}
+	var voice, model *string
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT selected_voice, selected_model FROM users WHERE id = $1
+	`, userID).Scan(&voice, &model)
+
+	if err == nil {
+		if voice != nil {
+			selectedVoice = *voice
+		}
+		if model != nil {
+			selectedModel = *model
 		}
+	}
+	return selectedVoice, selectedModel
+}
 
-		// Получаем историю разговора
-		rows, err := conn.Query(ctx, `
-			SELECT role, content, msg_timestamp
-			FROM get_user_conversation_context($1, 6)
-			ORDER BY msg_timestamp ASC
-		`, *userID)
-
-		if err == nil {
-			defer rows.Close()
-
-			var messages []string
-			for rows.Next() {
-				var role, content string
-				var timestamp interface{}
-				if err := rows.Scan(&role, &content, &timestamp); err == nil {
-					roleText := "Пользователь"
-					if role == "assistant" {
-						roleText = "Ты"
-					}
-					messages = append(messages, fmt.Sprintf("%s: %s", roleText, content))
-				}
-			}
+// GetEphemeralToken получает ephemeral token для OpenAI Realtime API
+func (s *OpenAIService) GetEphemeralToken(ctx context.Context, userID *int) (map[string]interface{}, error) {
+	selectedVoice := defaultVoice
+	selectedModel := defaultRealtimeModel
 
-			if len(messages) > 0 {
-				conversationHistory = "\n\nКОНТЕКСТ ПРЕДЫДУЩЕГО РАЗГОВОРА:\n"
-				for _, msg := range messages {
-					conversationHistory += msg + "\n"
-				}
-				conversationHistory += "\nПродолжи разговор, учитывая этот контекст. Если пользователь спросит \"о чем мы говорили\", ссылайся на этот контекст."
-			}
-		} else {
-			log.Warnf("Failed to get conversation history for user %d: %v", *userID, err)
-		}
+	// Если указан user_id, получаем его настройки
+	if userID != nil {
+		selectedVoice, selectedModel = loadSessionSettings(ctx, *userID)
 	}
 
 	// Получаем системный промпт
-	systemPrompt := s.getSystemPrompt(ctx, selectedVoice, conversationHistory, userID)
+	systemPrompt, contextReport := s.getSystemPrompt(ctx, selectedVoice, userID)
+	if config.AppConfig.ContextDebug {
+		for _, section := range contextReport.Sections {
+			log.Infof("🧩 Context %s: %d/%d tokens, %d/%d items, truncated: %v",
+				section.Name, section.Tokens, section.OriginalTokens, section.Items, section.TotalItems, section.Truncated)
+		}
+		log.Infof("🧩 Context for user %v: %d of %d tokens", userID, contextReport.Used, contextReport.Budget)
+	}
 
 	// Формируем конфигурацию сессии
 	sessionConfig := OpenAISessionConfig{}
@@ -143,42 +131,23 @@ func (s *OpenAIService) GetEphemeralToken(ctx context.Context, userID *int) (map
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	if config.AppConfig.ContextDebug {
+		result["context_report"] = contextReport
+	}
+
 	return result, nil
 }
 
-func (s *OpenAIService) getSystemPrompt(ctx context.Context, voice string, conversationHistory string, userID *int) string {
-	baseContext := conversationHistory
-	promptContent := ""
+// contextMaxRawMessages - сколько последних реплик, не вошедших в сводку, добавлять в контекст
+const contextMaxRawMessages = 6
 
-	// Пытаемся получить пользовательский промпт
-	if userID != nil {
-		conn := database.Database.Pool
-
-		var content, voiceGender *string
-		err := conn.QueryRow(ctx, `
-			SELECT vp.content, vp.voice_gender
-			FROM users u
-			LEFT JOIN voice_prompts vp ON u.selected_prompt_id = vp.id
-			WHERE u.id = $1 AND (vp.is_active = true OR vp.id IS NULL)
-		`, *userID).Scan(&content, &voiceGender)
-
-		if err == nil && content != nil {
-			promptContent = *content
-
-			// Добавляем инструкции для женских голосов
-			if voice == "marin" || voice == "coral" || voice == "shimmer" ||
-			   (voiceGender != nil && *voiceGender == "female") {
-				promptContent += "\n\nВАЖНО: Ты используешь женский голос, поэтому всегда говори в женском роде (поняла вместо понял, готова вместо готов, и т.д.)."
-			}
+// genderRuleTemplate - правило грамматического рода для промптов, которые сами не проверяют {{#if female}}
+var genderRuleTemplate = prompttmpl.MustParse("{{#if female}}ВАЖНО: Ты используешь женский голос, поэтому всегда говори в женском роде (поняла вместо понял, готова вместо готов, и т.д.).{{/if}}")
 
-			promptContent += baseContext
-			return promptContent
-		}
-	}
+const historyInstruction = "Продолжи разговор, учитывая этот контекст. Если пользователь спросит \"о чем мы говорили\", ссылайся на этот контекст."
 
-	// Дефолтный промпт для женских голосов
-	if voice == "marin" || voice == "coral" || voice == "shimmer" {
-		return `Ты дружелюбная и внимательная ИИ-ассистентка. Ты говоришь женским голосом и должна использовать женский род в речи.
+// Дефолтный промпт для женских голосов
+const defaultFemalePersona = `Ты дружелюбная и внимательная ИИ-ассистентка. Ты говоришь женским голосом и должна использовать женский род в речи.
 
 Основные принципы:
 - Используй женский род (поняла вместо понял, готова вместо готов, etc.)
@@ -188,15 +157,226 @@ func (s *OpenAIService) getSystemPrompt(ctx context.Context, voice string, conve
 - Поддерживай живую беседу
 - Всегда отвечай на том же языке, на котором к тебе обращается пользователь
 
-Помни: ты не просто ИИ, а именно ассистентка с женским голосом, общайся соответственно.` + baseContext
-	}
+Помни: ты не просто ИИ, а именно ассистентка с женским голосом, общайся соответственно.`
 
-	// Общий дефолтный промпт
-	return `Ты дружелюбный и полезный ИИ-ассистент. Отвечай естественно и по делу.
+// Общий дефолтный промпт
+const defaultPersona = `Ты дружелюбный и полезный ИИ-ассистент. Отвечай естественно и по делу.
 
 Основные принципы:
 - Говори кратко, но информативно
 - Будь полезным и отзывчивым
 - Поддерживай живую беседу
-- Всегда отвечай на том же языке, на котором к тебе обращается пользователь` + baseContext
+- Всегда отвечай на том же языке, на котором к тебе обращается пользователь`
+
+func isFemaleVoice(voice string) bool {
+	return voice == "marin" || voice == "coral" || voice == "shimmer"
+}
+
+// loadConversationContext возвращает последнюю накопительную сводку сессии с темой
+// и самые свежие реплики, которые в сводку еще не вошли (в хронологическом порядке)
+func loadConversationContext(ctx context.Context, userID int) (string, []string) {
+	conn := database.Database.Pool
+
+	var summary, topic *string
+	var summaryKeyID *int
+	var summaryThrough *time.Time
+	err := conn.QueryRow(ctx, `
+		SELECT context_summary, summary_key_id, last_conversation_topic, summary_through
+		FROM voice_sessions
+		WHERE user_id = $1 AND summarized_at IS NOT NULL AND context_summary IS NOT NULL
+		ORDER BY summarized_at DESC, id DESC
+		LIMIT 1
+	`, userID).Scan(&summary, &summaryKeyID, &topic, &summaryThrough)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Warnf("Failed to get conversation summary for user %d: %v", userID, err)
+	}
+	if summary, err = openOptionalContent(ctx, userID, contentTableSessions, summary, summaryKeyID); err != nil {
+		log.Warnf("Failed to decrypt conversation summary for user %d: %v", userID, err)
+	}
+
+	summaryText := ""
+	if summary != nil {
+		summaryText = *summary
+		if topic != nil && *topic != "" {
+			summaryText += "\nПоследняя тема: " + *topic
+		}
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT message_type, content, content_key_id
+		FROM conversation_messages
+		WHERE user_id = $1 AND message_type IN ('user', 'assistant')
+		  AND ($2::timestamp IS NULL OR created_at > $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, userID, summaryThrough, contextMaxRawMessages)
+	if err != nil {
+		log.Warnf("Failed to get conversation history for user %d: %v", userID, err)
+		return summaryText, nil
+	}
+	defer rows.Close()
+
+	var turns []string
+	for rows.Next() {
+		var role, content string
+		var keyID *int
+		if err := rows.Scan(&role, &content, &keyID); err != nil {
+			continue
+		}
+		content, err := openContent(ctx, userID, contentTableMessages, content, keyID)
+		if err != nil {
+			log.Warnf("Failed to decrypt conversation message for user %d: %v", userID, err)
+			continue
+		}
+		roleText := "Пользователь"
+		if role == "assistant" {
+			roleText = "Ты"
+		}
+		turns = append([]string{fmt.Sprintf("%s: %s", roleText, content)}, turns...)
+	}
+
+	return summaryText, turns
+}
+
+// getSystemPrompt собирает инструкции сессии с выбранным промптом пользователя
+func (s *OpenAIService) getSystemPrompt(ctx context.Context, voice string, userID *int) (string, *models.ContextReport) {
+	var prompt *promptSource
+	if userID != nil {
+		prompt = loadSelectedPrompt(ctx, *userID)
+	}
+	return s.buildInstructions(ctx, voice, userID, prompt)
+}
+
+// buildInstructions собирает инструкции сессии в пределах INSTRUCTIONS_TOKEN_BUDGET.
+// Разделы по убыванию приоритета: базовая персона (если промпта нет), промпт пользователя
+// (шаблон с подставленными переменными), правила грамматического рода, память, сводка и последние реплики.
+func (s *OpenAIService) buildInstructions(ctx context.Context, voice string, userID *int, prompt *promptSource) (string, *models.ContextReport) {
+	builder := NewContextBuilder(config.AppConfig.InstructionsTokenBudget)
+
+	female := isFemaleVoice(voice) || (prompt != nil && prompt.voiceGender != nil && *prompt.voiceGender == "female")
+	values := loadPromptValues(ctx, userID, voice, female)
+
+	var memory []string
+	if userID != nil {
+		memory = loadMemoryFacts(ctx, *userID)
+	}
+
+	if prompt != nil {
+		promptContent := prompt.content
+		tmpl, err := prompttmpl.Parse(prompt.content)
+		if err != nil {
+			// Промпты, сохраненные до появления шаблонов, могут содержать «{{» как обычный текст
+			log.Warnf("Prompt is not a valid template, using it as is: %v", err)
+		} else {
+			// Память, подставленная в промпт, не повторяется отдельным разделом
+			if tmpl.Uses("memory") {
+				values["memory"] = renderMemory(memory)
+				memory = nil
+			}
+			promptContent = tmpl.Render(values)
+		}
+
+		builder.Add(ContextSection{
+			Name:     "user_prompt",
+			Priority: ContextPriorityUserPrompt,
+			Items:    []string{promptContent},
+		})
+
+		// Правило рода добавляется, только если промпт сам не различает голоса
+		if tmpl == nil || (!tmpl.Uses("female") && !tmpl.Uses("male")) {
+			builder.Add(ContextSection{
+				Name:     "gender",
+				Priority: ContextPriorityGender,
+				Items:    []string{genderRuleTemplate.Render(values)},
+			})
+		}
+	} else {
+		persona := defaultPersona
+		if isFemaleVoice(voice) {
+			persona = defaultFemalePersona
+		}
+		builder.Add(ContextSection{
+			Name:     "persona",
+			Priority: ContextPriorityPersona,
+			Items:    []string{persona},
+		})
+	}
+
+	if userID != nil {
+		builder.Add(ContextSection{
+			Name:      "memory",
+			Priority:  ContextPriorityMemory,
+			Header:    "ЧТО ТЫ ЗНАЕШЬ О ПОЛЬЗОВАТЕЛЕ:",
+			Items:     memory,
+			MaxTokens: config.AppConfig.MemoryTokenBudget,
+		})
+
+		summary, turns := loadConversationContext(ctx, *userID)
+		summarySection := ContextSection{
+			Name:      "summary",
+			Priority:  ContextPrioritySummary,
+			Header:    "КРАТКОЕ СОДЕРЖАНИЕ ПРЕДЫДУЩИХ РАЗГОВОРОВ:",
+			Items:     []string{summary},
+			MaxTokens: config.AppConfig.ContextTokenBudget,
+		}
+		turnsSection := ContextSection{
+			Name:      "recent_turns",
+			Priority:  ContextPriorityRecentTurns,
+			Header:    "КОНТЕКСТ ПРЕДЫДУЩЕГО РАЗГОВОРА:",
+			Items:     turns,
+			MaxTokens: config.AppConfig.ContextTokenBudget,
+			KeepLast:  true,
+		}
+		// Напоминание о контексте ставим в конец последнего раздела истории
+		if len(turns) > 0 {
+			turnsSection.Footer = historyInstruction
+		} else {
+			summarySection.Footer = historyInstruction
+		}
+		builder.Add(summarySection)
+		builder.Add(turnsSection)
+	}
+
+	return builder.Build()
+}
+
+// PreviewInstructions возвращает инструкции, которые получит сессия пользователя.
+// Можно подставить другой промпт (prompt_id) или черновик шаблона (content) и другой голос.
+func (s *OpenAIService) PreviewInstructions(ctx context.Context, req *models.PreviewPromptRequest) (*models.PromptPreview, error) {
+	voice, _ := loadSessionSettings(ctx, req.UserID)
+	if req.Voice != nil && *req.Voice != "" {
+		voice = *req.Voice
+	}
+
+	var prompt *promptSource
+	switch {
+	case req.Content != nil:
+		if err := prompttmpl.Validate(*req.Content); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		prompt = &promptSource{content: *req.Content, voiceGender: req.VoiceGender}
+	case req.PromptID != nil:
+		accessible, err := promptAccessible(ctx, req.UserID, *req.PromptID)
+		if err != nil {
+			return nil, err
+		}
+		if !accessible {
+			return nil, fmt.Errorf("prompt not found or not accessible")
+		}
+		if prompt, err = loadPrompt(ctx, *req.PromptID); err != nil {
+			return nil, err
+		}
+		if req.VoiceGender != nil {
+			prompt.voiceGender = req.VoiceGender
+		}
+	default:
+		prompt = loadSelectedPrompt(ctx, req.UserID)
+	}
+
+	instructions, report := s.buildInstructions(ctx, voice, &req.UserID, prompt)
+	return &models.PromptPreview{
+		Voice:         voice,
+		Instructions:  instructions,
+		ContextReport: report,
+	}, nil
 }
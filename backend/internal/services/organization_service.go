@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// organizationStartPrefix - префикс start_param в ссылке приглашения в организацию
+const organizationStartPrefix = "org_"
+
+const organizationInviteCodeLength = 10
+
+type OrganizationService struct{}
+
+func NewOrganizationService() *OrganizationService {
+	return &OrganizationService{}
+}
+
+// IsOrganizationStartParam проверяет, что start_param - приглашение в организацию
+func IsOrganizationStartParam(startParam string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(startParam)), organizationStartPrefix)
+}
+
+func parseOrganizationInviteCode(code string) string {
+	code = strings.TrimSpace(code)
+	if IsOrganizationStartParam(code) {
+		code = code[len(organizationStartPrefix):]
+	}
+	return strings.ToUpper(code)
+}
+
+// insertInviteCode пытается сохранить новый код приглашения, повторяя при коллизии
+func insertInviteCode(ctx context.Context, tx pgx.Tx, query string, args ...any) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := generateInviteCode(organizationInviteCodeLength)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate invite code: %w", err)
+		}
+
+		var stored string
+		err = tx.QueryRow(ctx, query, append([]any{code}, args...)...).Scan(&stored)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return stored, nil
+	}
+
+	return "", fmt.Errorf("failed to generate unique invite code")
+}
+
+// CreateOrganization создает организацию, владельцем которой становится пользователь.
+// Общий пул токенов - это баланс владельца, пополняемый его подпиской.
+func (s *OrganizationService) CreateOrganization(ctx context.Context, ownerID int, name string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 100 {
+		return nil, fmt.Errorf("invalid organization name")
+	}
+
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, ownerID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check user: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM organization_members WHERE user_id = $1 AND status = 'active')
+	`, ownerID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check membership: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("user already in organization")
+	}
+
+	org := models.Organization{Name: name, OwnerID: ownerID}
+	org.InviteCode, err = insertInviteCode(ctx, tx, `
+		INSERT INTO organizations (invite_code, name, owner_id, created_at, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (invite_code) DO NOTHING
+		RETURNING invite_code
+	`, name, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT id, created_at FROM organizations WHERE owner_id = $1
+	`, ownerID).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role, status, joined_at)
+		VALUES ($1, $2, 'owner', 'active', CURRENT_TIMESTAMP)
+	`, org.ID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add owner: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("👨‍👩‍👧 Organization %d created by user %d", org.ID, ownerID)
+
+	return &org, nil
+}
+
+// GetUserOrganization возвращает организацию пользователя со списком участников.
+// Код приглашения виден только владельцу.
+func (s *OrganizationService) GetUserOrganization(ctx context.Context, userID int) (*models.OrganizationResponse, error) {
+	conn := database.Database.Pool
+
+	var org models.Organization
+	var role string
+	var poolBalance int
+	err := conn.QueryRow(ctx, `
+		SELECT o.id, o.name, o.owner_id, o.invite_code, o.created_at, m.role, u.token_balance
+		FROM organization_members m
+		JOIN organizations o ON o.id = m.organization_id
+		JOIN users u ON u.id = o.owner_id
+		WHERE m.user_id = $1 AND m.status = 'active'
+	`, userID).Scan(&org.ID, &org.Name, &org.OwnerID, &org.InviteCode, &org.CreatedAt, &role, &poolBalance)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("organization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	if role != "owner" {
+		org.InviteCode = ""
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT m.user_id, u.username, u.first_name, m.role, m.monthly_cap, m.joined_at,
+		       COALESCE((
+		           SELECT SUM(tu.cost_tokens) FROM token_usage tu
+		           WHERE tu.organization_id = m.organization_id
+		             AND COALESCE(tu.actor_user_id, tu.user_id) = m.user_id
+		             AND tu.created_at >= date_trunc('month', CURRENT_TIMESTAMP)
+		       ), 0)
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1 AND m.status = 'active'
+		ORDER BY m.role DESC, m.joined_at ASC
+	`, org.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		var member models.OrganizationMember
+		err := rows.Scan(
+			&member.UserID, &member.Username, &member.FirstName, &member.Role,
+			&member.MonthlyCap, &member.JoinedAt, &member.UsedThisMonth,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	return &models.OrganizationResponse{
+		Organization: &org,
+		Role:         role,
+		PoolBalance:  poolBalance,
+		Members:      members,
+	}, nil
+}
+
+// JoinOrganization добавляет пользователя в организацию по коду приглашения
+func (s *OrganizationService) JoinOrganization(ctx context.Context, userID int, inviteCode string) (*models.Organization, error) {
+	code := parseOrganizationInviteCode(inviteCode)
+	if code == "" {
+		return nil, fmt.Errorf("organization not found")
+	}
+
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокируем организацию, чтобы лимит участников не обошли параллельные вступления
+	var org models.Organization
+	err = tx.QueryRow(ctx, `
+		SELECT id, name, owner_id, created_at FROM organizations WHERE invite_code = $1 FOR UPDATE
+	`, code).Scan(&org.ID, &org.Name, &org.OwnerID, &org.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("organization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	var currentOrgID *int
+	err = tx.QueryRow(ctx, `
+		SELECT organization_id FROM organization_members WHERE user_id = $1 AND status = 'active'
+	`, userID).Scan(&currentOrgID)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to check membership: %w", err)
+	}
+	if currentOrgID != nil {
+		if *currentOrgID == org.ID {
+			return &org, nil
+		}
+		return nil, fmt.Errorf("user already in organization")
+	}
+
+	var membersCount int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND status = 'active'
+	`, org.ID).Scan(&membersCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+	if membersCount >= config.AppConfig.OrganizationMaxMembers {
+		return nil, fmt.Errorf("organization is full")
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role, status, joined_at)
+		VALUES ($1, $2, 'member', 'active', CURRENT_TIMESTAMP)
+	`, org.ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("👥 User %d joined organization %d", userID, org.ID)
+
+	return &org, nil
+}
+
+// RegenerateInviteCode выпускает новый код приглашения, старая ссылка перестает работать
+func (s *OrganizationService) RegenerateInviteCode(ctx context.Context, ownerID int) (string, error) {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var orgID int
+	err = tx.QueryRow(ctx, `SELECT id FROM organizations WHERE owner_id = $1 FOR UPDATE`, ownerID).Scan(&orgID)
+	if err == pgx.ErrNoRows {
+		return "", fmt.Errorf("organization not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	code, err := insertInviteCode(ctx, tx, `
+		UPDATE organizations SET invite_code = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM organizations WHERE invite_code = $1)
+		RETURNING invite_code
+	`, orgID)
+	if err != nil {
+		return "", fmt.Errorf("failed to regenerate invite code: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return code, nil
+}
+
+// SetMemberCap задает участнику лимит расхода токенов из пула на календарный месяц.
+// nil снимает лимит.
+func (s *OrganizationService) SetMemberCap(ctx context.Context, ownerID int, memberID int, monthlyCap *int) error {
+	if monthlyCap != nil && *monthlyCap < 0 {
+		return fmt.Errorf("invalid monthly cap")
+	}
+
+	result, err := database.Database.Pool.Exec(ctx, `
+		UPDATE organization_members m
+		SET monthly_cap = $1
+		FROM organizations o
+		WHERE o.id = m.organization_id AND o.owner_id = $2
+		  AND m.user_id = $3 AND m.status = 'active' AND m.role = 'member'
+	`, monthlyCap, ownerID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to set member cap: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("member not found")
+	}
+
+	return nil
+}
+
+// RemoveMember исключает участника (владелец) или выводит пользователя из организации (сам участник)
+func (s *OrganizationService) RemoveMember(ctx context.Context, actorID int, memberID int) error {
+	conn := database.Database.Pool
+
+	var orgID, ownerID int
+	err := conn.QueryRow(ctx, `
+		SELECT o.id, o.owner_id
+		FROM organization_members m
+		JOIN organizations o ON o.id = m.organization_id
+		WHERE m.user_id = $1 AND m.status = 'active'
+	`, memberID).Scan(&orgID, &ownerID)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("member not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get membership: %w", err)
+	}
+
+	if memberID == ownerID {
+		return fmt.Errorf("owner cannot leave organization")
+	}
+	if actorID != memberID && actorID != ownerID {
+		return fmt.Errorf("forbidden")
+	}
+
+	_, err = conn.Exec(ctx, `
+		UPDATE organization_members
+		SET status = 'removed', removed_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1 AND user_id = $2 AND status = 'active'
+	`, orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	log.Infof("👋 User %d left organization %d", memberID, orgID)
+
+	return nil
+}
+
+// GetUsageBreakdown возвращает владельцу расход пула по участникам за период
+func (s *OrganizationService) GetUsageBreakdown(ctx context.Context, ownerID int, from time.Time, to time.Time) (*models.OrganizationUsageResponse, error) {
+	conn := database.Database.Pool
+
+	var orgID int
+	err := conn.QueryRow(ctx, `SELECT id FROM organizations WHERE owner_id = $1`, ownerID).Scan(&orgID)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("organization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	// В разбивку попадают и бывшие участники, если они расходовали пул в этом периоде
+	rows, err := conn.Query(ctx, `
+		SELECT u.id, u.username, u.first_name, COALESCE(m.role, 'former'), m.monthly_cap,
+		       COALESCE(SUM(tu.cost_tokens), 0), COUNT(tu.id)
+		FROM token_usage tu
+		JOIN users u ON u.id = COALESCE(tu.actor_user_id, tu.user_id)
+		LEFT JOIN organization_members m ON m.organization_id = tu.organization_id
+			AND m.user_id = u.id AND m.status = 'active'
+		WHERE tu.organization_id = $1 AND tu.created_at >= $2 AND tu.created_at < $3
+		GROUP BY u.id, u.username, u.first_name, m.role, m.monthly_cap
+		ORDER BY 6 DESC
+	`, orgID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage breakdown: %w", err)
+	}
+	defer rows.Close()
+
+	usage := &models.OrganizationUsageResponse{
+		OrganizationID: orgID,
+		From:           from,
+		To:             to,
+		Members:        []models.MemberUsage{},
+	}
+	for rows.Next() {
+		var member models.MemberUsage
+		err := rows.Scan(
+			&member.UserID, &member.Username, &member.FirstName, &member.Role, &member.MonthlyCap,
+			&member.TokensUsed, &member.Requests,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member usage: %w", err)
+		}
+		usage.TotalTokens += member.TokensUsed
+		usage.Members = append(usage.Members, member)
+	}
+
+	return usage, nil
+}
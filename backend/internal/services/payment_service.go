@@ -0,0 +1,719 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/payments"
+	"voice-ai-backend/internal/telegram"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+type PaymentService struct {
+	bot             *telegram.Client
+	gateways        map[string]payments.Gateway
+	tokenService    *TokenService
+	promoService    *PromoService
+	referralService *ReferralService
+	planService     *PlanService
+}
+
+func NewPaymentService(bot *telegram.Client, gateways ...payments.Gateway) *PaymentService {
+	registry := make(map[string]payments.Gateway, len(gateways))
+	for _, gateway := range gateways {
+		registry[gateway.Name()] = gateway
+	}
+
+	return &PaymentService{
+		bot:             bot,
+		gateways:        registry,
+		tokenService:    NewTokenService(),
+		promoService:    NewPromoService(),
+		referralService: NewReferralService(),
+		planService:     NewPlanService(),
+	}
+}
+
+// CreateStarsInvoice создает счет в Telegram Stars на покупку плана
+func (s *PaymentService) CreateStarsInvoice(ctx context.Context, userID int, planID int) (*models.StarsInvoice, error) {
+	conn := database.Database.Pool
+
+	var planName string
+	var description *string
+	var tokenAmount int
+	var priceStars *int
+	err := conn.QueryRow(ctx, `
+		SELECT name, description, token_amount, price_stars
+		FROM subscription_plans
+		WHERE id = $1 AND is_active = true
+	`, planID).Scan(&planName, &description, &tokenAmount, &priceStars)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("plan not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if priceStars == nil || *priceStars <= 0 {
+		return nil, fmt.Errorf("plan is not available for stars payment")
+	}
+
+	// Зачет за остаток текущего плана такой же, как при оплате картой
+	preview, err := s.planService.PreviewPlanChange(ctx, userID, planID, false)
+	if err != nil {
+		return nil, err
+	}
+	amount := starsAmountDue(*priceStars, preview)
+
+	invoiceDescription := fmt.Sprintf("%d токенов", tokenAmount)
+	if description != nil && *description != "" {
+		invoiceDescription = *description
+	}
+
+	payload := uuid.NewString()
+	link, err := s.bot.CreateInvoiceLink(ctx, &telegram.CreateInvoiceLinkParams{
+		Title:       planName,
+		Description: invoiceDescription,
+		Payload:     payload,
+		Currency:    telegram.StarsCurrency,
+		Prices: []telegram.LabeledPrice{
+			{Label: planName, Amount: amount},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice link: %w", err)
+	}
+
+	var invoice models.StarsInvoice
+	err = conn.QueryRow(ctx, `
+		INSERT INTO star_invoices (user_id, plan_id, payload, amount, status, invoice_link, created_at)
+		VALUES ($1, $2, $3, $4, 'pending', $5, CURRENT_TIMESTAMP)
+		RETURNING id, user_id, plan_id, payload, amount, status, invoice_link, created_at
+	`, userID, planID, payload, amount, link).Scan(
+		&invoice.ID, &invoice.UserID, &invoice.PlanID, &invoice.Payload,
+		&invoice.Amount, &invoice.Status, &invoice.InvoiceLink, &invoice.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save invoice: %w", err)
+	}
+
+	log.Infof("⭐ Created stars invoice %d for user %d, plan %d (%d of %d XTR)", invoice.ID, userID, planID, amount, *priceStars)
+
+	return &invoice, nil
+}
+
+// starsAmountDue переводит сумму к оплате из предпросмотра в Stars пропорционально
+// цене плана. Скидки по промокодам к Stars не применяются.
+func starsAmountDue(priceStars int, preview *models.PlanChangePreview) int {
+	if preview.PlanPrice <= 0 || preview.AmountDue >= preview.PlanPrice {
+		return priceStars
+	}
+	amount := int(math.Ceil(float64(priceStars) * preview.AmountDue / preview.PlanPrice))
+	if amount < 1 {
+		amount = 1
+	}
+	return amount
+}
+
+// HandleTelegramUpdate обрабатывает платежные обновления от бота
+func (s *PaymentService) HandleTelegramUpdate(ctx context.Context, update *telegram.Update) error {
+	if update.PreCheckoutQuery != nil {
+		return s.handlePreCheckout(ctx, update.PreCheckoutQuery)
+	}
+
+	if update.Message != nil && update.Message.SuccessfulPayment != nil {
+		return s.handleSuccessfulPayment(ctx, update.Message)
+	}
+
+	return nil
+}
+
+// handlePreCheckout проверяет, что счет существует, не оплачен и совпадает по сумме
+func (s *PaymentService) handlePreCheckout(ctx context.Context, query *telegram.PreCheckoutQuery) error {
+	var invoice starInvoiceState
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT si.status, si.amount, u.telegram_id, sp.is_active
+		FROM star_invoices si
+		JOIN users u ON u.id = si.user_id
+		JOIN subscription_plans sp ON sp.id = si.plan_id
+		WHERE si.payload = $1
+	`, query.InvoicePayload).Scan(&invoice.status, &invoice.amount, &invoice.telegramID, &invoice.planActive)
+
+	var reason string
+	switch {
+	case err == pgx.ErrNoRows:
+		reason = preCheckoutRejection(query, nil)
+	case err != nil:
+		return fmt.Errorf("failed to get invoice: %w", err)
+	default:
+		reason = preCheckoutRejection(query, &invoice)
+	}
+
+	if reason != "" {
+		log.Warnf("Rejecting pre-checkout %s for payload %s: %s", query.ID, query.InvoicePayload, reason)
+	}
+
+	if err := s.bot.AnswerPreCheckoutQuery(ctx, query.ID, reason == "", reason); err != nil {
+		return fmt.Errorf("failed to answer pre-checkout query: %w", err)
+	}
+
+	return nil
+}
+
+// starInvoiceState - состояние счета в Stars, с которым сверяется pre_checkout_query
+type starInvoiceState struct {
+	status     string
+	amount     int
+	telegramID string
+	planActive bool
+}
+
+// preCheckoutRejection возвращает причину отказа в оплате (для пользователя) или пустую
+// строку, если оплату можно принять. invoice == nil - счет по payload не найден.
+func preCheckoutRejection(query *telegram.PreCheckoutQuery, invoice *starInvoiceState) string {
+	switch {
+	case invoice == nil:
+		return "Счет не найден"
+	case invoice.status != "pending":
+		return "Счет уже оплачен"
+	case query.Currency != telegram.StarsCurrency || query.TotalAmount != invoice.amount:
+		return "Сумма счета изменилась"
+	case invoice.telegramID != strconv.FormatInt(query.From.ID, 10):
+		return "Счет выставлен другому пользователю"
+	case !invoice.planActive:
+		return "План больше недоступен"
+	}
+	return ""
+}
+
+// handleSuccessfulPayment помечает счет оплаченным и активирует подписку.
+// Повторная доставка того же платежа не начисляет токены второй раз.
+func (s *PaymentService) handleSuccessfulPayment(ctx context.Context, message *telegram.Message) error {
+	payment := message.SuccessfulPayment
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var invoiceID, userID, planID, amount int
+	var status string
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, plan_id, amount, status
+		FROM star_invoices
+		WHERE payload = $1
+		FOR UPDATE
+	`, payment.InvoicePayload).Scan(&invoiceID, &userID, &planID, &amount, &status)
+
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("invoice not found for payload %s", payment.InvoicePayload)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get invoice: %w", err)
+	}
+
+	if status != "pending" {
+		log.Infof("Stars invoice %d already in status %s, skipping", invoiceID, status)
+		return nil
+	}
+
+	chargeID := payment.TelegramPaymentChargeID
+
+	// Деньги уже списаны: ошибка здесь заставила бы Telegram повторять update
+	// бесконечно. Счет помечается к возврату, оплата возвращается пользователю.
+	if payment.Currency != telegram.StarsCurrency || payment.TotalAmount != amount {
+		log.Errorf("❌ Stars payment amount mismatch for invoice %d: got %d %s, expected %d %s",
+			invoiceID, payment.TotalAmount, payment.Currency, amount, telegram.StarsCurrency)
+
+		_, err = tx.Exec(ctx, `
+			UPDATE star_invoices SET status = 'refund_required', telegram_payment_charge_id = $1
+			WHERE id = $2
+		`, chargeID, invoiceID)
+		if err != nil {
+			return fmt.Errorf("failed to mark invoice for refund: %w", err)
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+
+		s.refundStarInvoice(ctx, invoiceID, message, chargeID)
+		return nil
+	}
+
+	change, err := s.planService.ChangePlanTx(ctx, tx, userID, planID, &chargeID, nil, false)
+	if err != nil {
+		return err
+	}
+	subscriptionID, newBalance := *change.SubscriptionID, change.NewTokenBalance
+
+	_, err = tx.Exec(ctx, `
+		UPDATE star_invoices
+		SET status = 'paid', telegram_payment_charge_id = $1, subscription_id = $2, paid_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, chargeID, subscriptionID, invoiceID)
+	if err != nil {
+		return fmt.Errorf("failed to mark invoice paid: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("✅ Stars payment for invoice %d: user %d subscribed to plan %d, balance %d", invoiceID, userID, planID, newBalance)
+
+	s.refundReplacedChange(ctx, change)
+
+	if err := s.referralService.RewardReferral(ctx, userID, ReferralTriggerPaidPlan); err != nil {
+		log.Warnf("Failed to reward referral for user %d: %v", userID, err)
+	}
+
+	chatID := strconv.FormatInt(message.Chat.ID, 10)
+	text := fmt.Sprintf("Оплата получена! План активирован, на балансе %d токенов.", newBalance)
+	if err := s.bot.SendMessage(ctx, chatID, text); err != nil {
+		log.Warnf("Failed to send payment confirmation to chat %s: %v", chatID, err)
+	}
+
+	return nil
+}
+
+// refundStarInvoice возвращает оплату счета в Stars. Если Telegram отказал,
+// счет остается в статусе refund_required для ручного возврата.
+func (s *PaymentService) refundStarInvoice(ctx context.Context, invoiceID int, message *telegram.Message, chargeID string) {
+	payerID := message.Chat.ID
+	if message.From != nil {
+		payerID = message.From.ID
+	}
+
+	if err := s.bot.RefundStarPayment(ctx, payerID, chargeID); err != nil {
+		log.Errorf("❌ Failed to refund stars invoice %d, manual refund required: %v", invoiceID, err)
+		return
+	}
+
+	_, err := database.Database.Pool.Exec(ctx, `
+		UPDATE star_invoices SET status = 'refunded' WHERE id = $1 AND status = 'refund_required'
+	`, invoiceID)
+	if err != nil {
+		log.Errorf("Failed to mark stars invoice %d refunded: %v", invoiceID, err)
+		return
+	}
+
+	log.Infof("↩️ Stars invoice %d refunded to user %d", invoiceID, payerID)
+
+	chatID := strconv.FormatInt(message.Chat.ID, 10)
+	text := "Сумма оплаты не совпала со счетом, звезды возвращены. Пожалуйста, создайте новый счет."
+	if err := s.bot.SendMessage(ctx, chatID, text); err != nil {
+		log.Warnf("Failed to send refund notice to chat %s: %v", chatID, err)
+	}
+}
+
+const paymentColumns = `
+	id, user_id, plan_id, provider, provider_payment_id, amount, currency, status,
+	confirmation_url, subscription_id, tokens_granted, created_at, paid_at, refunded_at,
+	schedule_downgrade
+`
+
+func scanPayment(row pgx.Row, payment *models.Payment) error {
+	return row.Scan(
+		&payment.ID, &payment.UserID, &payment.PlanID, &payment.Provider, &payment.ProviderPaymentID,
+		&payment.Amount, &payment.Currency, &payment.Status, &payment.ConfirmationURL,
+		&payment.SubscriptionID, &payment.TokensGranted, &payment.CreatedAt, &payment.PaidAt, &payment.RefundedAt,
+		&payment.ScheduleDowngrade,
+	)
+}
+
+func (s *PaymentService) gateway(provider string) (payments.Gateway, error) {
+	gateway, ok := s.gateways[provider]
+	if !ok {
+		return nil, fmt.Errorf("payment provider not available")
+	}
+	return gateway, nil
+}
+
+// CreateCheckout создает заказ в статусе pending и платеж у провайдера
+func (s *PaymentService) CreateCheckout(ctx context.Context, req *models.CreateCheckoutRequest) (*models.Payment, error) {
+	gateway, err := s.gateway(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := database.Database.Pool
+
+	// Сумма к оплате учитывает перерасчет за остаток текущего плана
+	preview, err := s.planService.PreviewPlanChange(ctx, req.UserID, req.PlanID, req.ScheduleDowngrade)
+	if err != nil {
+		return nil, err
+	}
+
+	if preview.PlanPrice <= 0 {
+		return nil, fmt.Errorf("plan is free")
+	}
+
+	planName, currency, price := preview.NewPlanName, preview.Currency, preview.AmountDue
+
+	// Применяем скидку по промокоду, если она есть
+	redemptionID, discountPercent, err := s.promoService.reserveDiscount(ctx, req.UserID, req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if redemptionID != nil {
+		price = math.Round(price*float64(100-discountPercent)) / 100
+	}
+
+	idempotencyKey := uuid.NewString()
+
+	var payment models.Payment
+	err = scanPayment(conn.QueryRow(ctx, `
+		INSERT INTO payments (
+			user_id, plan_id, provider, idempotency_key, amount, currency, status,
+			promo_redemption_id, schedule_downgrade, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, 'pending', $7, $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+paymentColumns, req.UserID, req.PlanID, gateway.Name(), idempotencyKey, price, currency,
+		redemptionID, preview.Scheduled), &payment)
+	if err != nil {
+		if redemptionID != nil {
+			_ = s.promoService.releaseDiscount(ctx, conn, *redemptionID)
+		}
+		return nil, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	if redemptionID != nil {
+		if err := s.promoService.attachDiscount(ctx, conn, *redemptionID, payment.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	checkout, err := gateway.CreateCheckout(ctx, &payments.CheckoutRequest{
+		OrderID:        payment.ID,
+		Amount:         price,
+		Currency:       currency,
+		Description:    fmt.Sprintf("Подписка «%s»", planName),
+		ReturnURL:      req.ReturnURL,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		_, _ = conn.Exec(ctx, `
+			UPDATE payments SET status = 'failed', updated_at = CURRENT_TIMESTAMP WHERE id = $1
+		`, payment.ID)
+		if redemptionID != nil {
+			_ = s.promoService.releaseDiscount(ctx, conn, *redemptionID)
+		}
+		return nil, fmt.Errorf("failed to create checkout: %w", err)
+	}
+
+	err = scanPayment(conn.QueryRow(ctx, `
+		UPDATE payments
+		SET provider_payment_id = $1, confirmation_url = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING `+paymentColumns, checkout.ProviderPaymentID, checkout.ConfirmationURL, payment.ID), &payment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save checkout: %w", err)
+	}
+
+	log.Infof("💳 Created %s checkout for payment %d (user %d, plan %d)", gateway.Name(), payment.ID, req.UserID, req.PlanID)
+
+	return &payment, nil
+}
+
+// HandleGatewayWebhook проверяет подпись уведомления и применяет событие к заказу
+func (s *PaymentService) HandleGatewayWebhook(ctx context.Context, provider string, header http.Header, body []byte) error {
+	gateway, err := s.gateway(provider)
+	if err != nil {
+		return err
+	}
+
+	event, err := gateway.ParseWebhook(header, body)
+	if err != nil {
+		return err
+	}
+
+	switch event.Event {
+	case payments.EventPaymentSucceeded:
+		return s.completePayment(ctx, provider, event)
+	case payments.EventPaymentCanceled:
+		return s.failPayment(ctx, provider, event.ProviderPaymentID)
+	case payments.EventRefundSucceeded:
+		return s.finalizeRefund(ctx, provider, event.ProviderPaymentID)
+	}
+
+	log.Debugf("Ignoring %s webhook event %s", provider, event.Event)
+	return nil
+}
+
+// completePayment помечает заказ оплаченным и активирует подписку
+func (s *PaymentService) completePayment(ctx context.Context, provider string, event *payments.WebhookEvent) error {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var payment models.Payment
+	err = scanPayment(tx.QueryRow(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE provider = $1 AND provider_payment_id = $2
+		FOR UPDATE
+	`, provider, event.ProviderPaymentID), &payment)
+
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("payment not found: %s", event.ProviderPaymentID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if payment.Status != payments.StatusPending {
+		log.Infof("Payment %d already in status %s, skipping", payment.ID, payment.Status)
+		return nil
+	}
+
+	if event.Currency != payment.Currency || payments.MinorUnits(event.Amount) != payments.MinorUnits(payment.Amount) {
+		return fmt.Errorf("payment amount mismatch for payment %d: got %.2f %s, expected %.2f %s",
+			payment.ID, event.Amount, event.Currency, payment.Amount, payment.Currency)
+	}
+
+	change, err := s.planService.ChangePlanTx(ctx, tx, payment.UserID, payment.PlanID,
+		payment.ProviderPaymentID, &payment.ID, payment.ScheduleDowngrade)
+	if err != nil {
+		return err
+	}
+
+	// Отложенное понижение привяжет подписку и токены к заказу при активации
+	_, err = tx.Exec(ctx, `
+		UPDATE payments
+		SET status = 'paid', subscription_id = $1,
+		    tokens_granted = CASE WHEN $1::int IS NULL THEN 0
+		                          ELSE (SELECT token_amount FROM subscription_plans WHERE id = $2) END,
+		    paid_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, change.SubscriptionID, payment.PlanID, payment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to mark payment paid: %w", err)
+	}
+
+	if err := s.promoService.applyDiscount(ctx, tx, payment.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("✅ Payment %d paid: user %d, plan %d (%s, scheduled: %t), balance %d",
+		payment.ID, payment.UserID, payment.PlanID, change.ChangeType, change.Scheduled, change.NewTokenBalance)
+
+	s.refundReplacedChange(ctx, change)
+
+	if err := s.referralService.RewardReferral(ctx, payment.UserID, ReferralTriggerPaidPlan); err != nil {
+		log.Warnf("Failed to reward referral for user %d: %v", payment.UserID, err)
+	}
+
+	return nil
+}
+
+func (s *PaymentService) failPayment(ctx context.Context, provider string, providerPaymentID string) error {
+	conn := database.Database.Pool
+
+	var redemptionID *int
+	err := conn.QueryRow(ctx, `
+		UPDATE payments
+		SET status = 'failed', updated_at = CURRENT_TIMESTAMP
+		WHERE provider = $1 AND provider_payment_id = $2 AND status = 'pending'
+		RETURNING promo_redemption_id
+	`, provider, providerPaymentID).Scan(&redemptionID)
+
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark payment failed: %w", err)
+	}
+
+	// Неоплаченный заказ возвращает скидку пользователю
+	if redemptionID != nil {
+		return s.promoService.releaseDiscount(ctx, conn, *redemptionID)
+	}
+
+	return nil
+}
+
+// refundReplacedChange возвращает платеж оплаченной смены плана, которую отменила
+// прошедшая оплата: обе оплаты могли начаться, пока ни одна не была подтверждена.
+func (s *PaymentService) refundReplacedChange(ctx context.Context, change *models.PlanChangeResult) {
+	if change.CanceledPaymentID == nil {
+		return
+	}
+	if _, err := s.RefundPayment(ctx, *change.CanceledPaymentID, "plan change replaced"); err != nil {
+		log.Errorf("❌ Failed to refund replaced plan change payment %d: %v", *change.CanceledPaymentID, err)
+	}
+}
+
+// RefundPayment запрашивает возврат у провайдера. Если провайдер провел
+// возврат сразу, токены списываются немедленно, иначе - по webhook.
+func (s *PaymentService) RefundPayment(ctx context.Context, paymentID int, reason string) (*models.Payment, error) {
+	conn := database.Database.Pool
+
+	var payment models.Payment
+	err := scanPayment(conn.QueryRow(ctx, `
+		SELECT `+paymentColumns+` FROM payments WHERE id = $1
+	`, paymentID), &payment)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("payment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if payment.Status != payments.StatusPaid || payment.ProviderPaymentID == nil {
+		return nil, fmt.Errorf("payment is not refundable")
+	}
+
+	gateway, err := s.gateway(payment.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := gateway.Refund(ctx, &payments.RefundRequest{
+		ProviderPaymentID: *payment.ProviderPaymentID,
+		Amount:            payment.Amount,
+		Currency:          payment.Currency,
+		Reason:            reason,
+		IdempotencyKey:    fmt.Sprintf("refund-%d", payment.ID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	if refund.Succeeded {
+		if err := s.finalizeRefund(ctx, payment.Provider, *payment.ProviderPaymentID); err != nil {
+			return nil, err
+		}
+	}
+
+	err = scanPayment(conn.QueryRow(ctx, `
+		SELECT `+paymentColumns+` FROM payments WHERE id = $1
+	`, paymentID), &payment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return &payment, nil
+}
+
+// finalizeRefund помечает заказ возвращенным, закрывает подписку и
+// списывает начисленные по ней токены. Повторный вызов ничего не делает.
+func (s *PaymentService) finalizeRefund(ctx context.Context, provider string, providerPaymentID string) error {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var payment models.Payment
+	err = scanPayment(tx.QueryRow(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE provider = $1 AND provider_payment_id = $2
+		FOR UPDATE
+	`, provider, providerPaymentID), &payment)
+
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("payment not found: %s", providerPaymentID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if payment.Status == payments.StatusRefunded {
+		return nil
+	}
+	if payment.Status != payments.StatusPaid {
+		return fmt.Errorf("payment %d cannot be refunded from status %s", payment.ID, payment.Status)
+	}
+
+	if payment.SubscriptionID != nil {
+		_, err = tx.Exec(ctx, `
+			UPDATE user_subscriptions
+			SET status = 'cancelled', end_date = NOW(), updated_at = NOW()
+			WHERE id = $1 AND status = 'active'
+		`, *payment.SubscriptionID)
+		if err != nil {
+			return fmt.Errorf("failed to cancel subscription: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE scheduled_plan_changes SET status = 'canceled'
+		WHERE payment_id = $1 AND status = 'pending'
+	`, payment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled plan change: %w", err)
+	}
+
+	if payment.TokensGranted > 0 {
+		if _, _, err := s.tokenService.RevokeTokens(ctx, tx, payment.UserID, payment.TokensGranted); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE payments
+		SET status = 'refunded', refunded_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, payment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to mark payment refunded: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("↩️ Payment %d refunded for user %d", payment.ID, payment.UserID)
+
+	return nil
+}
+
+// GetUserPayments получает заказы пользователя
+func (s *PaymentService) GetUserPayments(ctx context.Context, userID int, limit int) ([]models.Payment, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payments: %w", err)
+	}
+	defer rows.Close()
+
+	var result []models.Payment
+	for rows.Next() {
+		var payment models.Payment
+		if err := scanPayment(rows, &payment); err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		result = append(result, payment)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/payments"
+	"voice-ai-backend/internal/payments/paymentstest"
+	"voice-ai-backend/internal/telegram"
+	"voice-ai-backend/internal/telegram/telegramtest"
+)
+
+func TestPreCheckoutRejection(t *testing.T) {
+	pending := &starInvoiceState{status: "pending", amount: 250, telegramID: "1001", planActive: true}
+	query := func(fromID int64, currency string, amount int) *telegram.PreCheckoutQuery {
+		return &telegram.PreCheckoutQuery{
+			ID:             "pcq",
+			From:           telegram.User{ID: fromID},
+			Currency:       currency,
+			TotalAmount:    amount,
+			InvoicePayload: "payload",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   *telegram.PreCheckoutQuery
+		invoice *starInvoiceState
+		want    string
+	}{
+		{"accepted", query(1001, telegram.StarsCurrency, 250), pending, ""},
+		{"unknown payload", query(1001, telegram.StarsCurrency, 250), nil, "Счет не найден"},
+		{"already paid", query(1001, telegram.StarsCurrency, 250),
+			&starInvoiceState{status: "paid", amount: 250, telegramID: "1001", planActive: true}, "Счет уже оплачен"},
+		{"wrong amount", query(1001, telegram.StarsCurrency, 200), pending, "Сумма счета изменилась"},
+		{"wrong currency", query(1001, "RUB", 250), pending, "Сумма счета изменилась"},
+		{"other user", query(2002, telegram.StarsCurrency, 250), pending, "Счет выставлен другому пользователю"},
+		{"plan disabled", query(1001, telegram.StarsCurrency, 250),
+			&starInvoiceState{status: "pending", amount: 250, telegramID: "1001", planActive: false}, "План больше недоступен"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preCheckoutRejection(tt.query, tt.invoice); got != tt.want {
+				t.Errorf("preCheckoutRejection() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStarsAmountDue(t *testing.T) {
+	tests := []struct {
+		name      string
+		planPrice float64
+		amountDue float64
+		want      int
+	}{
+		{"full price", 199, 199, 250},
+		{"half credited", 200, 100, 125},
+		{"rounded up", 199, 100, 126},
+		{"minimum charge", 199, 0.01, 1},
+		{"plan priced only in stars", 0, 0, 250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview := &models.PlanChangePreview{PlanPrice: tt.planPrice, AmountDue: tt.amountDue}
+			if got := starsAmountDue(250, preview); got != tt.want {
+				t.Errorf("starsAmountDue() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStarsPreCheckoutRejected(t *testing.T) {
+	ctx := testDB(t)
+	fake := telegramtest.NewServer("test-token")
+	defer fake.Close()
+	service := NewPaymentService(telegram.NewClient(fake.Token, fake.URL))
+
+	telegramID := testTelegramID()
+	user := createTestUser(t, ctx, telegramID)
+	plan := createTestPlan(t, ctx, 1000, 199, 250)
+	invoice, err := service.CreateStarsInvoice(ctx, user.ID, plan.ID)
+	if err != nil {
+		t.Fatalf("CreateStarsInvoice: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		fromID  int64
+		payload string
+		amount  int
+	}{
+		{"wrong amount", telegramID, invoice.Payload, invoice.Amount - 1},
+		{"wrong payload", telegramID, "unknown-" + invoice.Payload, invoice.Amount},
+		{"wrong user", telegramID + 1, invoice.Payload, invoice.Amount},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := telegramtest.PreCheckoutUpdate(i+1, tt.fromID, tt.payload, tt.amount)
+			if err := service.HandleTelegramUpdate(ctx, &update); err != nil {
+				t.Fatalf("HandleTelegramUpdate: %v", err)
+			}
+
+			calls := fake.Calls("answerPreCheckoutQuery")
+			if len(calls) != i+1 {
+				t.Fatalf("answerPreCheckoutQuery calls = %d, want %d", len(calls), i+1)
+			}
+			params := calls[i].Params
+			if params["pre_checkout_query_id"] != update.PreCheckoutQuery.ID {
+				t.Errorf("pre_checkout_query_id = %v, want %s", params["pre_checkout_query_id"], update.PreCheckoutQuery.ID)
+			}
+			if params["ok"] != false {
+				t.Errorf("ok = %v, want false", params["ok"])
+			}
+			if msg, _ := params["error_message"].(string); msg == "" {
+				t.Error("error_message is empty")
+			}
+		})
+	}
+}
+
+func TestStarsSuccessfulPaymentIdempotent(t *testing.T) {
+	ctx := testDB(t)
+	fake := telegramtest.NewServer("test-token")
+	defer fake.Close()
+	service := NewPaymentService(telegram.NewClient(fake.Token, fake.URL))
+
+	telegramID := testTelegramID()
+	user := createTestUser(t, ctx, telegramID)
+	plan := createTestPlan(t, ctx, 1000, 199, 250)
+	invoice, err := service.CreateStarsInvoice(ctx, user.ID, plan.ID)
+	if err != nil {
+		t.Fatalf("CreateStarsInvoice: %v", err)
+	}
+
+	chargeID := fmt.Sprintf("charge-%d", telegramID)
+	// Telegram повторяет update, если не получил ответ: оба раза приходит один и тот же платеж
+	for i := 0; i < 2; i++ {
+		update := telegramtest.SuccessfulPaymentUpdate(100+i, telegramID, invoice.Payload, invoice.Amount, chargeID)
+		if err := service.HandleTelegramUpdate(ctx, &update); err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+	}
+
+	if balance := userTokenBalance(t, ctx, user.ID); balance != plan.TokenAmount {
+		t.Errorf("token_balance = %d, want %d", balance, plan.TokenAmount)
+	}
+
+	var status string
+	var subscriptions int
+	err = database.Database.Pool.QueryRow(ctx, `
+		SELECT si.status,
+		       (SELECT COUNT(*) FROM user_subscriptions us WHERE us.user_id = si.user_id AND us.plan_id = si.plan_id)
+		FROM star_invoices si WHERE si.id = $1
+	`, invoice.ID).Scan(&status, &subscriptions)
+	if err != nil {
+		t.Fatalf("get invoice: %v", err)
+	}
+	if status != "paid" {
+		t.Errorf("invoice status = %q, want paid", status)
+	}
+	if subscriptions != 1 {
+		t.Errorf("subscriptions = %d, want 1", subscriptions)
+	}
+
+	if calls := fake.Calls("sendMessage"); len(calls) != 1 {
+		t.Errorf("sendMessage calls = %d, want 1", len(calls))
+	}
+}
+
+func TestStarsPaymentAmountMismatchRefunded(t *testing.T) {
+	ctx := testDB(t)
+	fake := telegramtest.NewServer("test-token")
+	defer fake.Close()
+	service := NewPaymentService(telegram.NewClient(fake.Token, fake.URL))
+
+	tests := []struct {
+		name       string
+		failRefund bool
+		wantStatus string
+	}{
+		{"refunded", false, "refunded"},
+		{"refund failed", true, "refund_required"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.failRefund {
+				fake.FailMethod("refundStarPayment", "Bad Request: CHARGE_ALREADY_REFUNDED")
+			}
+
+			telegramID := testTelegramID()
+			user := createTestUser(t, ctx, telegramID)
+			plan := createTestPlan(t, ctx, 1000, 199, 250)
+			invoice, err := service.CreateStarsInvoice(ctx, user.ID, plan.ID)
+			if err != nil {
+				t.Fatalf("CreateStarsInvoice: %v", err)
+			}
+
+			// Ответ с ошибкой заставил бы Telegram повторять update: обе доставки отвечают успехом
+			chargeID := fmt.Sprintf("charge-%d", telegramID)
+			for j := 0; j < 2; j++ {
+				update := telegramtest.SuccessfulPaymentUpdate(200+10*i+j, telegramID, invoice.Payload, invoice.Amount-1, chargeID)
+				if err := service.HandleTelegramUpdate(ctx, &update); err != nil {
+					t.Fatalf("delivery %d: %v", j+1, err)
+				}
+			}
+
+			if balance := userTokenBalance(t, ctx, user.ID); balance != 0 {
+				t.Errorf("token_balance = %d, want 0", balance)
+			}
+
+			var status string
+			var storedChargeID *string
+			err = database.Database.Pool.QueryRow(ctx, `
+				SELECT status, telegram_payment_charge_id FROM star_invoices WHERE id = $1
+			`, invoice.ID).Scan(&status, &storedChargeID)
+			if err != nil {
+				t.Fatalf("get invoice: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("invoice status = %q, want %q", status, tt.wantStatus)
+			}
+			if storedChargeID == nil || *storedChargeID != chargeID {
+				t.Errorf("telegram_payment_charge_id = %v, want %s", storedChargeID, chargeID)
+			}
+
+			var refunds int
+			for _, call := range fake.Calls("refundStarPayment") {
+				if call.Params["telegram_payment_charge_id"] == chargeID {
+					refunds++
+				}
+			}
+			if refunds != 1 {
+				t.Errorf("refundStarPayment calls = %d, want 1", refunds)
+			}
+		})
+	}
+}
+
+func TestGatewayWebhookInvalidSignature(t *testing.T) {
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	service := NewPaymentService(nil, fake.Gateway())
+
+	body, _ := fake.Notification(payments.EventPaymentSucceeded, "fake-payment-1")
+	header := http.Header{}
+	header.Set(payments.SignatureHeader, payments.Sign("other-secret", body))
+
+	// Подпись проверяется до обращения к базе
+	err := service.HandleGatewayWebhook(context.Background(), "yookassa", header, body)
+	if !errors.Is(err, payments.ErrInvalidSignature) {
+		t.Fatalf("HandleGatewayWebhook() error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+// paidTestCheckout создает заказ через фейковый API и подтверждает его webhook'ом payment.succeeded
+func paidTestCheckout(t *testing.T, ctx context.Context, service *PaymentService, fake *paymentstest.Server, userID, planID int) *models.Payment {
+	t.Helper()
+
+	payment, err := service.CreateCheckout(ctx, &models.CreateCheckoutRequest{
+		UserID: userID, PlanID: planID, Provider: "yookassa",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckout: %v", err)
+	}
+	deliverTestWebhook(t, ctx, service, fake, payments.EventPaymentSucceeded, *payment.ProviderPaymentID)
+	return payment
+}
+
+func deliverTestWebhook(t *testing.T, ctx context.Context, service *PaymentService, fake *paymentstest.Server, event string, providerPaymentID string) {
+	t.Helper()
+
+	body, signature := fake.Notification(event, providerPaymentID)
+	header := http.Header{}
+	header.Set(payments.SignatureHeader, signature)
+	if err := service.HandleGatewayWebhook(ctx, "yookassa", header, body); err != nil {
+		t.Fatalf("webhook %s: %v", event, err)
+	}
+}
+
+func TestGatewayWebhookDuplicateSucceeded(t *testing.T) {
+	ctx := testDB(t)
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	service := NewPaymentService(nil, fake.Gateway())
+
+	user := createTestUser(t, ctx, testTelegramID())
+	plan := createTestPlan(t, ctx, 1000, 199.9, 0)
+	payment := paidTestCheckout(t, ctx, service, fake, user.ID, plan.ID)
+
+	// Провайдер повторяет уведомление: токены не начисляются второй раз
+	deliverTestWebhook(t, ctx, service, fake, payments.EventPaymentSucceeded, *payment.ProviderPaymentID)
+
+	if balance := userTokenBalance(t, ctx, user.ID); balance != plan.TokenAmount {
+		t.Errorf("token_balance = %d, want %d", balance, plan.TokenAmount)
+	}
+
+	userPayments, err := service.GetUserPayments(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatalf("GetUserPayments: %v", err)
+	}
+	if len(userPayments) != 1 || userPayments[0].Status != payments.StatusPaid {
+		t.Fatalf("payments = %+v, want one paid", userPayments)
+	}
+	if userPayments[0].TokensGranted != plan.TokenAmount {
+		t.Errorf("tokens_granted = %d, want %d", userPayments[0].TokensGranted, plan.TokenAmount)
+	}
+}
+
+func TestRefundRevokesTokens(t *testing.T) {
+	ctx := testDB(t)
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	service := NewPaymentService(nil, fake.Gateway())
+
+	user := createTestUser(t, ctx, testTelegramID())
+	plan := createTestPlan(t, ctx, 1000, 199.9, 0)
+
+	t.Run("synchronous", func(t *testing.T) {
+		payment := paidTestCheckout(t, ctx, service, fake, user.ID, plan.ID)
+
+		refunded, err := service.RefundPayment(ctx, payment.ID, "test")
+		if err != nil {
+			t.Fatalf("RefundPayment: %v", err)
+		}
+		if refunded.Status != payments.StatusRefunded {
+			t.Errorf("status = %q, want refunded", refunded.Status)
+		}
+		if balance := userTokenBalance(t, ctx, user.ID); balance != 0 {
+			t.Errorf("token_balance = %d, want 0", balance)
+		}
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		fake.SetRefundPending(true)
+		defer fake.SetRefundPending(false)
+
+		payment := paidTestCheckout(t, ctx, service, fake, user.ID, plan.ID)
+		if _, err := service.RefundPayment(ctx, payment.ID, "test"); err != nil {
+			t.Fatalf("RefundPayment: %v", err)
+		}
+		if balance := userTokenBalance(t, ctx, user.ID); balance != plan.TokenAmount {
+			t.Errorf("token_balance before refund webhook = %d, want %d", balance, plan.TokenAmount)
+		}
+
+		deliverTestWebhook(t, ctx, service, fake, payments.EventRefundSucceeded, *payment.ProviderPaymentID)
+		if balance := userTokenBalance(t, ctx, user.ID); balance != 0 {
+			t.Errorf("token_balance = %d, want 0", balance)
+		}
+	})
+}
@@ -3,6 +3,9 @@ package services
 import (
 	"context"
 	"fmt"
+	"math"
+	"time"
+	"voice-ai-backend/internal/config"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
 
@@ -10,10 +13,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-type PlanService struct{}
+type PlanService struct {
+	tokenService *TokenService
+}
 
 func NewPlanService() *PlanService {
-	return &PlanService{}
+	return &PlanService{
+		tokenService: NewTokenService(),
+	}
 }
 
 // GetAllPlans получает все активные планы подписок
@@ -21,9 +28,9 @@ func (s *PlanService) GetAllPlans(ctx context.Context) ([]models.SubscriptionPla
 	conn := database.Database.Pool
 
 	rows, err := conn.Query(ctx, `
-		SELECT id, name, description, price, currency, token_amount, features, is_active, created_at
+		SELECT id, name, description, price, currency, token_amount, features, is_active, price_stars, created_at
 		FROM subscription_plans
-		WHERE is_active = true
+		WHERE is_active = true AND is_trial = false
 		ORDER BY price ASC
 	`)
 	if err != nil {
@@ -36,7 +43,7 @@ func (s *PlanService) GetAllPlans(ctx context.Context) ([]models.SubscriptionPla
 		var plan models.SubscriptionPlan
 		err := rows.Scan(
 			&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-			&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.CreatedAt,
+			&plan.TokenAmount, &plan.Features, &plan.IsActive, &plan.PriceStars, &plan.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan plan: %w", err)
@@ -118,36 +125,772 @@ func (s *PlanService) getUserPlansByStatus(ctx context.Context, userID int, stat
 	return plans, nil
 }
 
-// CreateSubscription создает новую подписку для пользователя
-func (s *PlanService) CreateSubscription(ctx context.Context, req *models.CreateSubscriptionRequest) (int, int, error) {
+// CreateSubscription создает новую подписку для пользователя.
+// Напрямую можно активировать только бесплатные планы: платные
+// активируются после подтверждения оплаты (см. PaymentService).
+func (s *PlanService) CreateSubscription(ctx context.Context, req *models.CreateSubscriptionRequest) (*models.PlanChangeResult, error) {
 	conn := database.Database.Pool
 
 	// Начинаем транзакцию
 	tx, err := conn.Begin(ctx)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var price float64
+	err = tx.QueryRow(ctx, `
+		SELECT price FROM subscription_plans WHERE id = $1 AND is_active = true AND is_trial = false
+	`, req.PlanID).Scan(&price)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("plan not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if price > 0 {
+		return nil, fmt.Errorf("payment required")
+	}
+
+	if err := checkNoPaidPlanChange(ctx, tx, req.UserID); err != nil {
+		return nil, err
+	}
+
+	result, err := s.ChangePlanTx(ctx, tx, req.UserID, req.PlanID, req.PaymentID, nil, req.ScheduleDowngrade)
+	if err != nil {
+		return nil, err
+	}
+
+	// Коммитим транзакцию
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return result, nil
+}
+
+// GrantPlanTx активирует план без оплаты (промокод, пробный период) в рамках
+// внешней транзакции. Если durationDays > 0, подписка ограничивается этим сроком.
+func (s *PlanService) GrantPlanTx(ctx context.Context, tx pgx.Tx, userID int, planID int, reference string, durationDays int) (int, int, error) {
+	subscriptionID, newTokenBalance, err := activateSubscription(ctx, tx, userID, planID, &reference)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if durationDays > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE user_subscriptions
+			SET end_date = start_date + make_interval(days => $1), updated_at = NOW()
+			WHERE id = $2
+		`, durationDays, subscriptionID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to set subscription duration: %w", err)
+		}
+
+		if config.AppConfig.PlanTokensExpire {
+			_, err = tx.Exec(ctx, `
+				UPDATE token_grants g
+				SET expires_at = us.end_date
+				FROM user_subscriptions us
+				WHERE us.id = $1 AND g.source = $2 AND g.reference_id = us.id AND g.status = 'active'
+			`, subscriptionID, TokenSourcePlan)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to update token grant expiry: %w", err)
+			}
+		}
+	}
+
+	log.Infof("🎁 Granted plan %d to user %d (%s, %d days)", planID, userID, reference, durationDays)
+
+	return subscriptionID, newTokenBalance, nil
+}
+
+// hasPaidSubscription проверяет, оплачена ли активная подписка пользователя (картой или в Stars)
+func hasPaidSubscription(ctx context.Context, tx pgx.Tx, userID int) (bool, error) {
+	var paid bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM user_subscriptions us
+			WHERE us.user_id = $1 AND us.status = 'active'
+			  AND (EXISTS(SELECT 1 FROM payments p WHERE p.subscription_id = us.id AND p.status = 'paid')
+			    OR EXISTS(SELECT 1 FROM star_invoices si WHERE si.subscription_id = us.id AND si.status = 'paid'))
+		)
+	`, userID).Scan(&paid)
+	if err != nil {
+		return false, fmt.Errorf("failed to check paid subscription: %w", err)
+	}
+	return paid, nil
+}
+
+// EnsureTrialPlan создает или обновляет служебный план пробного периода по конфигурации
+func (s *PlanService) EnsureTrialPlan(ctx context.Context) (int, error) {
+	conn := database.Database.Pool
+
+	var planID int
+	err := conn.QueryRow(ctx, `
+		UPDATE subscription_plans
+		SET name = $1, token_amount = $2, price = 0, is_active = true
+		WHERE is_trial = true
+		RETURNING id
+	`, config.AppConfig.TrialPlanName, config.AppConfig.TrialTokenAmount).Scan(&planID)
+
+	if err == pgx.ErrNoRows {
+		err = conn.QueryRow(ctx, `
+			INSERT INTO subscription_plans (name, description, price, currency, token_amount, features, is_active, is_trial, created_at)
+			VALUES ($1, $2, 0, 'RUB', $3, $4, true, true, CURRENT_TIMESTAMP)
+			RETURNING id
+		`, config.AppConfig.TrialPlanName, "Выдается один раз при регистрации",
+			config.AppConfig.TrialTokenAmount, []string{}).Scan(&planID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to ensure trial plan: %w", err)
+	}
+
+	return planID, nil
+}
+
+// GrantTrialTx выдает пробный план новому пользователю в рамках внешней транзакции.
+// Пробный период выдается не больше одного раза: повторный вызов ничего не делает.
+// Аккаунт, зарегистрированный заново после удаления, пробный период не получает.
+func (s *PlanService) GrantTrialTx(ctx context.Context, tx pgx.Tx, userID int) (*models.UserTrial, error) {
+	deleted, err := isDeletedAccount(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		log.Warnf("User %d was deleted before, trial is not granted", userID)
+		return nil, nil
+	}
+
+	var planID, tokenAmount int
+	err = tx.QueryRow(ctx, `
+		SELECT id, token_amount FROM subscription_plans WHERE is_trial = true AND is_active = true
+	`).Scan(&planID, &tokenAmount)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("trial plan not configured")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trial plan: %w", err)
+	}
+
+	// Сначала фиксируем факт выдачи: первичный ключ по user_id не даст выдать пробный период дважды
+	trial := models.UserTrial{UserID: userID, PlanID: planID, TokenAmount: tokenAmount}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO user_trials (user_id, plan_id, token_amount, status, granted_at, ends_at)
+		VALUES ($1, $2, $3, 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + make_interval(days => $4))
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING status, granted_at, ends_at
+	`, userID, planID, tokenAmount, config.AppConfig.TrialDurationDays).Scan(&trial.Status, &trial.GrantedAt, &trial.EndsAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record trial: %w", err)
+	}
+
+	subscriptionID, _, err := s.GrantPlanTx(ctx, tx, userID, planID, "trial", config.AppConfig.TrialDurationDays)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE user_trials SET subscription_id = $1 WHERE user_id = $2`, subscriptionID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link trial subscription: %w", err)
+	}
+	trial.SubscriptionID = &subscriptionID
+
+	return &trial, nil
+}
+
+// GetUserTrial возвращает сведения о пробном периоде пользователя (nil, если его не было)
+func (s *PlanService) GetUserTrial(ctx context.Context, userID int) (*models.UserTrial, error) {
+	var trial models.UserTrial
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT user_id, subscription_id, plan_id, token_amount, status, granted_at, ends_at, converted_at
+		FROM user_trials
+		WHERE user_id = $1
+	`, userID).Scan(
+		&trial.UserID, &trial.SubscriptionID, &trial.PlanID, &trial.TokenAmount,
+		&trial.Status, &trial.GrantedAt, &trial.EndsAt, &trial.ConvertedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trial: %w", err)
+	}
+
+	return &trial, nil
+}
+
+// ConvertExpiredTrials переводит пользователей с закончившимся пробным периодом
+// на бесплатный тариф: остаток токенов урезается до FreeTierTokenBalance.
+// Пользователи, купившие план во время пробного периода, помечаются как upgraded.
+func (s *PlanService) ConvertExpiredTrials(ctx context.Context) (int64, error) {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
+	upgraded, err := tx.Exec(ctx, `
+		UPDATE user_trials t
+		SET status = 'upgraded', converted_at = NOW()
+		WHERE t.status = 'active'
+		  AND EXISTS (
+			SELECT 1 FROM user_subscriptions us
+			WHERE us.user_id = t.user_id AND us.status = 'active'
+			  AND us.id IS DISTINCT FROM t.subscription_id
+		  )
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark upgraded trials: %w", err)
+	}
+
+	// Пробный период закончился по сроку или по токенам
+	_, err = tx.Exec(ctx, `
+		UPDATE user_subscriptions us
+		SET status = 'expired', updated_at = NOW()
+		FROM user_trials t
+		WHERE t.subscription_id = us.id AND t.status = 'active'
+		  AND us.status = 'active' AND t.ends_at <= NOW()
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire trial subscriptions: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		WITH ended AS (
+			UPDATE user_trials t
+			SET status = 'converted', converted_at = NOW()
+			WHERE t.status = 'active'
+			  AND NOT EXISTS (
+				SELECT 1 FROM user_subscriptions us
+				WHERE us.id = t.subscription_id AND us.status = 'active'
+			  )
+			RETURNING t.user_id
+		)
+		UPDATE users
+		SET token_balance = LEAST(token_balance, $1), updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (SELECT user_id FROM ended)
+		RETURNING id
+	`, config.AppConfig.FreeTierTokenBalance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert expired trials: %w", err)
+	}
+	var convertedUserIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan converted trial: %w", err)
+		}
+		convertedUserIDs = append(convertedUserIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to convert expired trials: %w", err)
+	}
+
+	for _, userID := range convertedUserIDs {
+		if err := reconcileTokenGrants(ctx, tx, userID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	converted := int64(len(convertedUserIDs))
+	if converted > 0 || upgraded.RowsAffected() > 0 {
+		log.Infof("🔚 Trials: %d converted to free tier, %d upgraded", converted, upgraded.RowsAffected())
+	}
+
+	return converted, nil
+}
+
+// activateSubscription закрывает старые подписки и активирует новую в рамках транзакции
+func activateSubscription(ctx context.Context, tx pgx.Tx, userID int, planID int, paymentID *string) (int, int, error) {
 	// Используем функцию из БД для закрытия старых подписок и установки нового баланса
 	var subscriptionID, newTokenBalance int
-	err = tx.QueryRow(ctx, `
+	err := tx.QueryRow(ctx, `
 		SELECT subscription_id, new_token_balance
 		FROM close_old_subscription_and_reset_tokens($1, $2, $3)
-	`, req.UserID, req.PlanID, req.PaymentID).Scan(&subscriptionID, &newTokenBalance)
+	`, userID, planID, paymentID).Scan(&subscriptionID, &newTokenBalance)
 
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to create subscription: %w", err)
 	}
 
-	// Коммитим транзакцию
-	if err = tx.Commit(ctx); err != nil {
-		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	// Баланс сброшен на токены плана: записываем их отдельной партией и урезаем
+	// старые партии, которых на балансе больше нет
+	var tokenAmount int
+	var expiresAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT sp.token_amount, CASE WHEN $2 THEN us.end_date END
+		FROM user_subscriptions us
+		JOIN subscription_plans sp ON sp.id = us.plan_id
+		WHERE us.id = $1
+	`, subscriptionID, config.AppConfig.PlanTokensExpire).Scan(&tokenAmount, &expiresAt)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get subscription tokens: %w", err)
+	}
+
+	if tokenAmount > 0 {
+		if err := insertTokenGrant(ctx, tx, userID, tokenAmount, TokenSourcePlan, &subscriptionID, expiresAt); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := reconcileTokenGrants(ctx, tx, userID); err != nil {
+		return 0, 0, err
 	}
 
 	return subscriptionID, newTokenBalance, nil
 }
 
+// minChargeAmount - минимальная сумма платежа после зачета остатка периода
+const minChargeAmount = 1.0
+
+// rowQuerier - общий интерфейс пула и транзакции для чтения одной строки
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// PreviewPlanChange рассчитывает результат перехода на другой план, ничего не меняя.
+// Пока ждет оплаченная смена плана, новая не предлагается: иначе она отменила бы оплаченную.
+func (s *PlanService) PreviewPlanChange(ctx context.Context, userID int, planID int, scheduleDowngrade bool) (*models.PlanChangePreview, error) {
+	conn := database.Database.Pool
+	if err := checkNoPaidPlanChange(ctx, conn, userID); err != nil {
+		return nil, err
+	}
+	preview, _, err := previewPlanChange(ctx, conn, userID, planID, scheduleDowngrade, false)
+	return preview, err
+}
+
+// checkNoPaidPlanChange возвращает ошибку, если у пользователя есть ожидающая оплаченная смена плана
+func checkNoPaidPlanChange(ctx context.Context, q rowQuerier, userID int) error {
+	var pending bool
+	err := q.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM scheduled_plan_changes
+			WHERE user_id = $1 AND status = 'pending' AND payment_id IS NOT NULL
+		)
+	`, userID).Scan(&pending)
+	if err != nil {
+		return fmt.Errorf("failed to check scheduled plan change: %w", err)
+	}
+	if pending {
+		return fmt.Errorf("paid plan change pending")
+	}
+	return nil
+}
+
+// previewPlanChange считает перерасчет при смене плана. При lock = true строка
+// пользователя блокируется, чтобы расчет совпал с применением в той же транзакции.
+// Вторым значением возвращается id текущей подписки (или nil).
+func previewPlanChange(ctx context.Context, q rowQuerier, userID int, planID int, scheduleDowngrade bool, lock bool) (*models.PlanChangePreview, *int, error) {
+	preview := &models.PlanChangePreview{
+		NewPlanID:     planID,
+		ProrationMode: config.AppConfig.PlanProrationMode,
+	}
+
+	var newTokenAmount int
+	err := q.QueryRow(ctx, `
+		SELECT name, price, currency, token_amount
+		FROM subscription_plans
+		WHERE id = $1 AND is_active = true AND is_trial = false
+	`, planID).Scan(&preview.NewPlanName, &preview.PlanPrice, &preview.Currency, &newTokenAmount)
+	if err == pgx.ErrNoRows {
+		return nil, nil, fmt.Errorf("plan not found")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	balanceQuery := `SELECT token_balance FROM users WHERE id = $1`
+	if lock {
+		balanceQuery += ` FOR UPDATE`
+	}
+	var tokenBalance int
+	err = q.QueryRow(ctx, balanceQuery, userID).Scan(&tokenBalance)
+	if err == pgx.ErrNoRows {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user balance: %w", err)
+	}
+
+	// paidAmount - сколько фактически заплачено за текущую подписку в валюте плана:
+	// у пробной, подаренной промокодом или бесплатной подписки это 0
+	var subscriptionID, currentPlanID, currentTokenAmount, tokensUsed int
+	var currentPlanName, currentCurrency string
+	var currentPrice, paidAmount float64
+	var startDate time.Time
+	var endDate *time.Time
+	err = q.QueryRow(ctx, `
+		SELECT us.id, us.plan_id, sp.name, sp.price, sp.currency, sp.token_amount, us.start_date, us.end_date,
+		       COALESCE((
+		           SELECT SUM(tu.cost_tokens) FROM token_usage tu
+		           WHERE tu.user_id = us.user_id AND tu.created_at >= us.start_date
+		       ), 0),
+		       COALESCE(
+		           (SELECT p.amount FROM payments p
+		            WHERE p.subscription_id = us.id AND p.status = 'paid' AND p.currency = sp.currency
+		            ORDER BY p.paid_at DESC LIMIT 1),
+		           (SELECT sp.price * LEAST(si.amount::numeric / sp.price_stars, 1) FROM star_invoices si
+		            WHERE si.subscription_id = us.id AND si.status = 'paid' AND sp.price_stars > 0
+		            ORDER BY si.paid_at DESC LIMIT 1),
+		           0)
+		FROM user_subscriptions us
+		JOIN subscription_plans sp ON us.plan_id = sp.id
+		WHERE us.user_id = $1 AND us.status = 'active' AND (us.end_date IS NULL OR us.end_date > NOW())
+		ORDER BY us.created_at DESC
+		LIMIT 1
+	`, userID).Scan(
+		&subscriptionID, &currentPlanID, &currentPlanName, &currentPrice, &currentCurrency,
+		&currentTokenAmount, &startDate, &endDate, &tokensUsed, &paidAmount,
+	)
+
+	if err == pgx.ErrNoRows {
+		preview.ChangeType = "new"
+		preview.AmountDue = preview.PlanPrice
+		preview.NewTokenBalance = newTokenAmount
+		return preview, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get current subscription: %w", err)
+	}
+
+	preview.CurrentPlanID = &currentPlanID
+	preview.CurrentPlanName = &currentPlanName
+
+	switch {
+	case currentPlanID == planID:
+		preview.ChangeType = "renewal"
+	case preview.PlanPrice > currentPrice,
+		preview.PlanPrice == currentPrice && newTokenAmount >= currentTokenAmount:
+		preview.ChangeType = "upgrade"
+	default:
+		preview.ChangeType = "downgrade"
+	}
+
+	// Неиспользованные токены плана, но не больше фактического баланса
+	unused := currentTokenAmount - tokensUsed
+	if unused < 0 {
+		unused = 0
+	}
+	if unused > tokenBalance {
+		unused = tokenBalance
+	}
+	preview.UnusedTokens = unused
+
+	if scheduleDowngrade && preview.ChangeType == "downgrade" {
+		// Текущий план доживает до конца периода, новый начинается после него
+		preview.Scheduled = true
+		preview.EffectiveAt = endDate
+		preview.AmountDue = preview.PlanPrice
+		preview.NewTokenBalance = tokenBalance
+		return preview, &subscriptionID, nil
+	}
+
+	preview.AmountDue = preview.PlanPrice
+	preview.NewTokenBalance = newTokenAmount
+
+	if preview.ProrationMode == "time" {
+		// Остаток периода засчитывается в цену нового плана, но не больше доли
+		// неиспользованных токенов: израсходованный план не дает зачета.
+		remaining := prorationRemaining(time.Now(), startDate, endDate, unused, currentTokenAmount)
+
+		if currentCurrency == preview.Currency && paidAmount > 0 {
+			preview.CreditAmount = math.Round(paidAmount*remaining*100) / 100
+		}
+		preview.AmountDue = math.Round((preview.PlanPrice-preview.CreditAmount)*100) / 100
+		if preview.PlanPrice > 0 && preview.AmountDue < minChargeAmount {
+			preview.AmountDue = minChargeAmount
+		}
+		if preview.PlanPrice == 0 {
+			preview.AmountDue = 0
+		}
+	} else {
+		preview.CarriedTokens = unused
+		preview.NewTokenBalance += unused
+	}
+
+	return preview, &subscriptionID, nil
+}
+
+// prorationRemaining возвращает засчитываемую долю текущего плана: меньшую из
+// оставшейся доли периода и доли неиспользованных токенов. Для подписок без
+// срока учитываются только токены.
+func prorationRemaining(now time.Time, startDate time.Time, endDate *time.Time, unusedTokens int, tokenAmount int) float64 {
+	remaining := 1.0
+	if tokenAmount > 0 {
+		remaining = float64(unusedTokens) / float64(tokenAmount)
+	}
+	if endDate != nil && endDate.After(startDate) {
+		remaining = math.Min(remaining, endDate.Sub(now).Seconds()/endDate.Sub(startDate).Seconds())
+	} else if tokenAmount <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, remaining))
+}
+
+// ChangePlanTx применяет смену плана в рамках внешней транзакции: переносит
+// неиспользованные токены (в режиме tokens) или откладывает понижение до конца периода.
+func (s *PlanService) ChangePlanTx(ctx context.Context, tx pgx.Tx, userID int, planID int, reference *string, paymentID *int, scheduleDowngrade bool) (*models.PlanChangeResult, error) {
+	preview, currentSubscriptionID, err := previewPlanChange(ctx, tx, userID, planID, scheduleDowngrade, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.PlanChangeResult{
+		ChangeType: preview.ChangeType,
+	}
+
+	// Новая смена плана заменяет ранее запланированную. Оплаченная отменяется, только
+	// если новый платеж прошел, пока она ждала: ее платеж возвращает вызывающий.
+	canceledPaymentID, err := cancelPendingPlanChange(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.CanceledPaymentID = canceledPaymentID
+
+	if preview.Scheduled {
+		var changeID int
+		err = tx.QueryRow(ctx, `
+			INSERT INTO scheduled_plan_changes (
+				user_id, from_subscription_id, to_plan_id, payment_id, reference, status, effective_at, created_at
+			)
+			VALUES ($1, $2, $3, $4, $5, 'pending', $6, CURRENT_TIMESTAMP)
+			RETURNING id
+		`, userID, currentSubscriptionID, planID, paymentID, reference, preview.EffectiveAt).Scan(&changeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule plan change: %w", err)
+		}
+
+		log.Infof("📅 Scheduled downgrade of user %d to plan %d (change %d)", userID, planID, changeID)
+
+		result.Scheduled = true
+		result.ScheduledChangeID = &changeID
+		result.EffectiveAt = preview.EffectiveAt
+		result.NewTokenBalance = preview.NewTokenBalance
+		return result, nil
+	}
+
+	subscriptionID, newBalance, err := activateSubscription(ctx, tx, userID, planID, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if preview.CarriedTokens > 0 {
+		// Перенесенный остаток живет столько же, сколько токены нового плана
+		var expiresAt *time.Time
+		if config.AppConfig.PlanTokensExpire {
+			err = tx.QueryRow(ctx, `SELECT end_date FROM user_subscriptions WHERE id = $1`, subscriptionID).Scan(&expiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get subscription end date: %w", err)
+			}
+		}
+
+		newBalance, err = s.tokenService.GrantTokensTx(ctx, tx, userID, preview.CarriedTokens, TokenSourcePlan, &subscriptionID, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("🔁 Plan change (%s) for user %d to plan %d, carried %d tokens",
+		preview.ChangeType, userID, planID, preview.CarriedTokens)
+
+	result.SubscriptionID = &subscriptionID
+	result.NewTokenBalance = newBalance
+	result.CarriedTokens = preview.CarriedTokens
+	return result, nil
+}
+
+// cancelPendingPlanChange отменяет ожидающую смену плана пользователя.
+// Возвращает id платежа, если отмененная смена была оплачена.
+func cancelPendingPlanChange(ctx context.Context, tx pgx.Tx, userID int) (*int, error) {
+	var changeID int
+	var paymentID *int
+	err := tx.QueryRow(ctx, `
+		UPDATE scheduled_plan_changes SET status = 'canceled'
+		WHERE user_id = $1 AND status = 'pending'
+		RETURNING id, payment_id
+	`, userID).Scan(&changeID, &paymentID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel scheduled plan change: %w", err)
+	}
+
+	if paymentID != nil {
+		log.Infof("Paid plan change %d of user %d replaced, payment %d will be refunded", changeID, userID, *paymentID)
+	}
+
+	return paymentID, nil
+}
+
+// CancelScheduledPlanChange отменяет запланированное пользователем понижение плана.
+// Оплаченное понижение отменяется только через возврат платежа.
+func (s *PlanService) CancelScheduledPlanChange(ctx context.Context, userID int) error {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var paymentID *int
+	err = tx.QueryRow(ctx, `
+		SELECT payment_id FROM scheduled_plan_changes WHERE user_id = $1 AND status = 'pending' FOR UPDATE
+	`, userID).Scan(&paymentID)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("scheduled plan change not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get scheduled plan change: %w", err)
+	}
+	if paymentID != nil {
+		return fmt.Errorf("paid plan change cannot be canceled")
+	}
+
+	if _, err := cancelPendingPlanChange(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetScheduledPlanChange возвращает ожидающую смену плана пользователя (nil, если ее нет)
+func (s *PlanService) GetScheduledPlanChange(ctx context.Context, userID int) (*models.ScheduledPlanChange, error) {
+	var change models.ScheduledPlanChange
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT c.id, c.user_id, c.from_subscription_id, c.to_plan_id, sp.name, c.payment_id,
+		       c.status, c.effective_at, c.subscription_id, c.created_at, c.applied_at
+		FROM scheduled_plan_changes c
+		JOIN subscription_plans sp ON sp.id = c.to_plan_id
+		WHERE c.user_id = $1 AND c.status = 'pending'
+	`, userID).Scan(
+		&change.ID, &change.UserID, &change.FromSubscriptionID, &change.ToPlanID, &change.ToPlanName,
+		&change.PaymentID, &change.Status, &change.EffectiveAt, &change.SubscriptionID,
+		&change.CreatedAt, &change.AppliedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled plan change: %w", err)
+	}
+
+	return &change, nil
+}
+
+// ApplyScheduledPlanChanges активирует отложенные понижения, у которых закончилась
+// текущая подписка (по сроку или по токенам). Возвращает количество примененных.
+func (s *PlanService) ApplyScheduledPlanChanges(ctx context.Context) (int, error) {
+	applied := 0
+
+	for {
+		ok, err := s.applyNextScheduledChange(ctx)
+		if err != nil {
+			return applied, err
+		}
+		if !ok {
+			break
+		}
+		applied++
+	}
+
+	if applied > 0 {
+		log.Infof("📅 Applied %d scheduled plan changes", applied)
+	}
+
+	return applied, nil
+}
+
+func (s *PlanService) applyNextScheduledChange(ctx context.Context) (bool, error) {
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var changeID, userID, planID int
+	var paymentID *int
+	var reference *string
+	err = tx.QueryRow(ctx, `
+		SELECT c.id, c.user_id, c.to_plan_id, c.payment_id, c.reference
+		FROM scheduled_plan_changes c
+		LEFT JOIN user_subscriptions us ON us.id = c.from_subscription_id
+		WHERE c.status = 'pending'
+		  AND (c.effective_at <= NOW() OR us.id IS NULL OR us.status != 'active'
+		       OR (us.end_date IS NOT NULL AND us.end_date <= NOW()))
+		ORDER BY c.effective_at NULLS FIRST, c.id
+		LIMIT 1
+		FOR UPDATE OF c SKIP LOCKED
+	`).Scan(&changeID, &userID, &planID, &paymentID, &reference)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get scheduled plan change: %w", err)
+	}
+
+	subscriptionID, newBalance, err := activateSubscription(ctx, tx, userID, planID, reference)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE scheduled_plan_changes
+		SET status = 'applied', subscription_id = $1, applied_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, subscriptionID, changeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark plan change applied: %w", err)
+	}
+
+	if paymentID != nil {
+		_, err = tx.Exec(ctx, `
+			UPDATE payments
+			SET subscription_id = $1,
+			    tokens_granted = (SELECT token_amount FROM subscription_plans WHERE id = $2),
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3
+		`, subscriptionID, planID, *paymentID)
+		if err != nil {
+			return false, fmt.Errorf("failed to link payment to subscription: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("📅 Plan change %d applied: user %d on plan %d, balance %d", changeID, userID, planID, newBalance)
+
+	return true, nil
+}
+
+// planTokenAllowanceSQL - сколько токенов выдано по подписке us: токены плана и остаток,
+// перенесенный при смене плана (партии token_grants с source = 'plan'). Подписки,
+// оформленные до появления партий, считаются по token_amount плана.
+const planTokenAllowanceSQL = `COALESCE((
+	SELECT SUM(g.amount) FROM token_grants g
+	WHERE g.user_id = us.user_id AND g.source = 'plan' AND g.reference_id = us.id
+), sp.token_amount)`
+
 // GetCurrentUserPlan получает текущий активный план пользователя с детализацией
 func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[string]interface{}, error) {
 	conn := database.Database.Pool
@@ -160,7 +903,9 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 			us.status,
 			sp.name as plan_name,
 			sp.token_amount,
+			` + planTokenAllowanceSQL + ` as token_allowance,
 			sp.features,
+			COALESCE(sp.is_trial, false) as is_trial,
 			u.token_balance,
 			COALESCE(SUM(tu.cost_tokens), 0) as tokens_used_in_plan
 		FROM users u
@@ -171,7 +916,7 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 			AND tu.created_at >= us.start_date
 			AND (us.end_date IS NULL OR tu.created_at <= us.end_date)
 		WHERE u.id = $1
-		GROUP BY us.id, us.start_date, us.end_date, us.status, sp.name, sp.token_amount, sp.features, u.token_balance
+		GROUP BY us.id, us.user_id, us.start_date, us.end_date, us.status, sp.name, sp.token_amount, sp.features, sp.is_trial, u.token_balance
 		ORDER BY us.created_at DESC
 		LIMIT 1
 	`
@@ -179,14 +924,15 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 	var subscriptionID *int
 	var startDate, endDate interface{}
 	var status, planName *string
-	var tokenAmount *int
+	var tokenAmount, tokenAllowance *int
 	var features []string
+	var isTrial bool
 	var tokenBalance int
 	var tokensUsedInPlan int
 
 	err := conn.QueryRow(ctx, query, userID).Scan(
 		&subscriptionID, &startDate, &endDate, &status,
-		&planName, &tokenAmount, &features, &tokenBalance, &tokensUsedInPlan,
+		&planName, &tokenAmount, &tokenAllowance, &features, &isTrial, &tokenBalance, &tokensUsedInPlan,
 	)
 
 	if err == pgx.ErrNoRows {
@@ -198,29 +944,19 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 
 	// Если у пользователя есть активная подписка
 	if subscriptionID != nil && status != nil && *status == "active" {
-		tokensRemainingInPlan := 0
-		if tokenAmount != nil {
-			tokensRemainingInPlan = *tokenAmount - tokensUsedInPlan
+		// В подписку входит и остаток, перенесенный с прошлого плана
+		tokensRemainingInPlan, carriedTokens := 0, 0
+		if tokenAmount != nil && tokenAllowance != nil {
+			carriedTokens = max(*tokenAllowance-*tokenAmount, 0)
+			tokensRemainingInPlan = *tokenAllowance - tokensUsedInPlan
 			if tokensRemainingInPlan < 0 {
 				tokensRemainingInPlan = 0
 			}
 		}
 
-		// Проверяем, не закончились ли токены в плане
-		shouldClosePlan := tokensRemainingInPlan <= 0
-
-		if shouldClosePlan {
-			// Автоматически закрываем план
-			_, err = conn.Exec(ctx, `
-				UPDATE user_subscriptions
-				SET status = 'expired', end_date = NOW(), updated_at = NOW()
-				WHERE id = $1
-			`, *subscriptionID)
-
-			if err != nil {
-				log.Errorf("Failed to close expired subscription: %v", err)
-			}
-
+		// Если токены в плане закончились, план считается истекшим.
+		// Сам статус подписки обновляет фоновая задача ExpireSubscriptions.
+		if tokensRemainingInPlan <= 0 {
 			return map[string]interface{}{
 				"success":                 true,
 				"has_active_subscription": false,
@@ -240,11 +976,20 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 			"current_plan_name":           *planName,
 			"subscription_id":             *subscriptionID,
 			"plan_token_amount":           *tokenAmount,
+			"carried_tokens":              carriedTokens,
 			"tokens_used_in_plan":         tokensUsedInPlan,
 			"tokens_remaining_in_plan":    tokensRemainingInPlan,
 			"start_date":                  startDate,
 			"end_date":                    endDate,
 			"features":                    features,
+			"is_trial":                    isTrial,
+		}
+
+		scheduledChange, err := s.GetScheduledPlanChange(ctx, userID)
+		if err != nil {
+			log.Warnf("Failed to get scheduled plan change for user %d: %v", userID, err)
+		} else if scheduledChange != nil {
+			result["scheduled_change"] = scheduledChange
 		}
 
 		return result, nil
@@ -257,3 +1002,57 @@ func (s *PlanService) GetCurrentUserPlan(ctx context.Context, userID int) (map[s
 		"current_plan_name":       "Бесплатный план",
 	}, nil
 }
+
+// ExpireSubscriptions закрывает активные подписки, у которых истек срок
+// или закончились токены плана (вместе с перенесенным остатком). Возвращает количество закрытых подписок.
+func (s *PlanService) ExpireSubscriptions(ctx context.Context) (int64, error) {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Подписки с истекшим сроком
+	byDate, err := tx.Exec(ctx, `
+		UPDATE user_subscriptions
+		SET status = 'expired', updated_at = NOW()
+		WHERE status = 'active' AND end_date IS NOT NULL AND end_date <= NOW()
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire subscriptions by date: %w", err)
+	}
+
+	// Подписки, в которых израсходованы все токены плана
+	byTokens, err := tx.Exec(ctx, `
+		UPDATE user_subscriptions
+		SET status = 'expired', end_date = NOW(), updated_at = NOW()
+		WHERE id IN (
+			SELECT us.id
+			FROM user_subscriptions us
+			JOIN subscription_plans sp ON us.plan_id = sp.id
+			LEFT JOIN token_usage tu ON tu.user_id = us.user_id
+				AND tu.created_at >= us.start_date
+				AND (us.end_date IS NULL OR tu.created_at <= us.end_date)
+			WHERE us.status = 'active'
+			GROUP BY us.id, us.user_id, sp.token_amount
+			HAVING COALESCE(SUM(tu.cost_tokens), 0) >= `+planTokenAllowanceSQL+`
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire exhausted subscriptions: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	expired := byDate.RowsAffected() + byTokens.RowsAffected()
+	if expired > 0 {
+		log.Infof("✅ Expired %d subscriptions (%d by date, %d by tokens)",
+			expired, byDate.RowsAffected(), byTokens.RowsAffected())
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/payments"
+	"voice-ai-backend/internal/payments/paymentstest"
+)
+
+func TestPaidScheduledDowngradeNotSilentlyCanceled(t *testing.T) {
+	ctx := testDB(t)
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	service := NewPaymentService(nil, fake.Gateway())
+
+	user := createTestUser(t, ctx, testTelegramID())
+	pro := createTestPlan(t, ctx, 5000, 999, 0)
+	basic := createTestPlan(t, ctx, 1000, 199, 0)
+	premium := createTestPlan(t, ctx, 3000, 499, 0)
+	paidTestCheckout(t, ctx, service, fake, user.ID, pro.ID)
+
+	checkout := func(planID int, schedule bool) (*models.Payment, error) {
+		return service.CreateCheckout(ctx, &models.CreateCheckoutRequest{
+			UserID: user.ID, PlanID: planID, Provider: "yookassa", ScheduleDowngrade: schedule,
+		})
+	}
+
+	// Обе оплаты начаты до подтверждения первой
+	downgrade, err := checkout(basic.ID, true)
+	if err != nil {
+		t.Fatalf("downgrade checkout: %v", err)
+	}
+	upgrade, err := checkout(premium.ID, false)
+	if err != nil {
+		t.Fatalf("second checkout: %v", err)
+	}
+
+	deliverTestWebhook(t, ctx, service, fake, payments.EventPaymentSucceeded, *downgrade.ProviderPaymentID)
+	change, err := NewPlanService().GetScheduledPlanChange(ctx, user.ID)
+	if err != nil || change == nil {
+		t.Fatalf("scheduled change = %v, %v; want pending change", change, err)
+	}
+
+	// Пока оплаченное понижение ждет, новую смену плана начать нельзя
+	if _, err := checkout(premium.ID, false); err == nil || err.Error() != "paid plan change pending" {
+		t.Fatalf("checkout with pending paid change: error = %v, want paid plan change pending", err)
+	}
+
+	// Вторая оплата все же прошла: понижение отменяется, а его платеж возвращается
+	deliverTestWebhook(t, ctx, service, fake, payments.EventPaymentSucceeded, *upgrade.ProviderPaymentID)
+
+	userPayments, err := service.GetUserPayments(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatalf("GetUserPayments: %v", err)
+	}
+	for _, payment := range userPayments {
+		if payment.ID == downgrade.ID && payment.Status != payments.StatusRefunded {
+			t.Errorf("replaced downgrade payment status = %q, want refunded", payment.Status)
+		}
+		if payment.ID == upgrade.ID && payment.Status != payments.StatusPaid {
+			t.Errorf("upgrade payment status = %q, want paid", payment.Status)
+		}
+	}
+	if refunds := fake.Refunds(); len(refunds) != 1 || refunds[0] != *downgrade.ProviderPaymentID {
+		t.Errorf("provider refunds = %v, want [%s]", refunds, *downgrade.ProviderPaymentID)
+	}
+}
+
+func TestProrationRemaining(t *testing.T) {
+	now := time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)
+	start := now.AddDate(0, 0, -10)
+	end := start.AddDate(0, 0, 40)
+	expired := start.AddDate(0, 0, 5)
+
+	tests := []struct {
+		name        string
+		endDate     *time.Time
+		unused      int
+		tokenAmount int
+		want        float64
+	}{
+		{"time is smaller", &end, 900, 1000, 0.75},
+		{"tokens are smaller", &end, 200, 1000, 0.2},
+		{"tokens burned early", &end, 0, 1000, 0},
+		{"period over", &expired, 1000, 1000, 0},
+		{"no end date uses tokens", nil, 300, 1000, 0.3},
+		{"no tokens uses time", &end, 0, 0, 0.75},
+		{"no end date and no tokens", nil, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prorationRemaining(now, start, tt.endDate, tt.unused, tt.tokenAmount)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("prorationRemaining() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeProrationCredit(t *testing.T) {
+	ctx := testDB(t)
+	mode := config.AppConfig.PlanProrationMode
+	config.AppConfig.PlanProrationMode = "time"
+	t.Cleanup(func() { config.AppConfig.PlanProrationMode = mode })
+
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	service := NewPaymentService(nil, fake.Gateway())
+	plans := NewPlanService()
+	plan := createTestPlan(t, ctx, 1000, 200, 0)
+
+	// Оплаченный и нетронутый план засчитывается почти целиком
+	paid := createTestUser(t, ctx, testTelegramID())
+	paidTestCheckout(t, ctx, service, fake, paid.ID, plan.ID)
+	preview, err := plans.PreviewPlanChange(ctx, paid.ID, plan.ID, false)
+	if err != nil {
+		t.Fatalf("PreviewPlanChange: %v", err)
+	}
+	if preview.CreditAmount < 190 {
+		t.Errorf("credit for unused paid plan = %.2f, want close to 200", preview.CreditAmount)
+	}
+
+	// Продление после того, как токены сожжены в первый же день, зачета не дает
+	_, err = database.Database.Pool.Exec(ctx, `
+		INSERT INTO token_usage (user_id, input_tokens, output_tokens, total_tokens, cost_tokens, created_at)
+		VALUES ($1, 0, 1000, 1000, 1000, CURRENT_TIMESTAMP)
+	`, paid.ID)
+	if err != nil {
+		t.Fatalf("insert token usage: %v", err)
+	}
+	_, err = database.Database.Pool.Exec(ctx, `UPDATE users SET token_balance = 0 WHERE id = $1`, paid.ID)
+	if err != nil {
+		t.Fatalf("burn tokens: %v", err)
+	}
+	preview, err = plans.PreviewPlanChange(ctx, paid.ID, plan.ID, false)
+	if err != nil {
+		t.Fatalf("PreviewPlanChange after burn: %v", err)
+	}
+	if preview.ChangeType != "renewal" || preview.CreditAmount != 0 || preview.AmountDue != plan.Price {
+		t.Errorf("renewal after burn: %s, credit %.2f, due %.2f; want renewal with no credit and full price",
+			preview.ChangeType, preview.CreditAmount, preview.AmountDue)
+	}
+
+	// Подписка без оплаты (промокод, пробный период) зачета не дает
+	granted := createTestUser(t, ctx, testTelegramID())
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, _, err := plans.GrantPlanTx(ctx, tx, granted.ID, plan.ID, "promo:TEST", 30); err != nil {
+		t.Fatalf("GrantPlanTx: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	upgrade := createTestPlan(t, ctx, 3000, 500, 0)
+	preview, err = plans.PreviewPlanChange(ctx, granted.ID, upgrade.ID, false)
+	if err != nil {
+		t.Fatalf("PreviewPlanChange for granted plan: %v", err)
+	}
+	if preview.CreditAmount != 0 || preview.AmountDue != upgrade.Price {
+		t.Errorf("upgrade from granted plan: credit %.2f, due %.2f; want no credit", preview.CreditAmount, preview.AmountDue)
+	}
+}
@@ -0,0 +1,879 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Действия в журнале privacy_audit_log
+const (
+	PrivacyActionExportRequested   = "data_export_requested"
+	PrivacyActionExportReady       = "data_export_ready"
+	PrivacyActionExportDownloaded  = "data_export_downloaded"
+	PrivacyActionDeletionRequested = "account_deletion_requested"
+	PrivacyActionDeletionCanceled  = "account_deletion_canceled"
+	PrivacyActionAccountDeleted    = "account_deleted"
+)
+
+// LedgerReasonAccountDeleted - списание остатка токенов при удалении аккаунта
+const LedgerReasonAccountDeleted = "account_deleted"
+
+// dataExportMaxAttempts - после стольких неудачных сборок выгрузка помечается failed
+const dataExportMaxAttempts = 3
+
+const dataExportColumns = `id, user_id, status, size_bytes, error, download_token, created_at, finished_at, expires_at`
+
+const accountDeletionColumns = `id, user_id, status, requested_at, scheduled_for, canceled_at, completed_at`
+
+// dataExportFiles - таблицы с персональными данными, которые попадают в архив.
+// Каждый запрос возвращает по одной JSON-строке на запись.
+var dataExportFiles = []struct {
+	name  string
+	query string
+}{
+	{"profile.json", `SELECT row_to_json(u) FROM users u WHERE u.id = $1`},
+	{"subscriptions.json", `SELECT row_to_json(t) FROM (SELECT * FROM user_subscriptions WHERE user_id = $1 ORDER BY created_at) t`},
+	{"payments.json", `SELECT row_to_json(t) FROM (SELECT * FROM payments WHERE user_id = $1 ORDER BY created_at) t`},
+	{"token_usage.json", `SELECT row_to_json(t) FROM (SELECT * FROM token_usage WHERE user_id = $1 ORDER BY created_at) t`},
+	{"token_ledger.json", `SELECT row_to_json(t) FROM (SELECT * FROM token_ledger WHERE user_id = $1 ORDER BY created_at) t`},
+	{"activity.json", `SELECT row_to_json(t) FROM (SELECT * FROM user_activity WHERE user_id = $1 ORDER BY created_at) t`},
+	{"voice_sessions.json", `SELECT row_to_json(t) FROM (SELECT * FROM voice_sessions WHERE user_id = $1 ORDER BY created_at) t`},
+	{"prompts.json", `SELECT row_to_json(t) FROM (SELECT * FROM voice_prompts WHERE user_id = $1 ORDER BY created_at) t`},
+	{"prompt_versions.json", `
+		SELECT row_to_json(t) FROM (
+			SELECT v.* FROM voice_prompt_versions v
+			JOIN voice_prompts vp ON vp.id = v.prompt_id
+			WHERE vp.user_id = $1 AND NOT vp.is_base ORDER BY v.prompt_id, v.version
+		) t`},
+	{"memory.json", `
+		SELECT row_to_json(t) FROM (
+			SELECT id, fact, fact_data_key_id, category, importance, source, session_id, mentions, created_at, updated_at, last_seen_at
+			FROM memory_facts WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at
+		) t`},
+	{"notifications.json", `SELECT row_to_json(t) FROM (SELECT * FROM notifications WHERE user_id = $1 ORDER BY created_at) t`},
+}
+
+// encryptedExportFiles - файлы архива с зашифрованным полем в строках
+var encryptedExportFiles = map[string]encryptedColumn{
+	"prompts.json":         {kind: contentTablePrompts, column: "content", keyColumn: "content_key_id"},
+	"prompt_versions.json": {kind: contentTablePrompts, column: "content", keyColumn: "content_key_id"},
+	"voice_sessions.json":  {kind: contentTableSessions, column: "context_summary", keyColumn: "summary_key_id"},
+	"memory.json":          {kind: contentTableMemory, column: "fact", keyColumn: "fact_data_key_id"},
+}
+
+// accountErasures - персональные данные, которые удаляются вместе с аккаунтом.
+// Платежи, подписки, журнал токенов и расход токенов остаются как учетные записи биллинга.
+var accountErasures = []struct {
+	table string
+	query string
+}{
+	{"conversation_messages", `DELETE FROM conversation_messages WHERE user_id = $1`},
+	{"voice_sessions", `DELETE FROM voice_sessions WHERE user_id = $1`},
+	{"memory_facts", `DELETE FROM memory_facts WHERE user_id = $1`},
+	{"voice_prompts", `DELETE FROM voice_prompts WHERE user_id = $1 AND NOT is_base`},
+	{"user_activity", `DELETE FROM user_activity WHERE user_id = $1`},
+	{"notifications", `DELETE FROM notifications WHERE user_id = $1`},
+	{"token_alerts", `DELETE FROM token_alerts WHERE user_id = $1`},
+	{"user_spending_limits", `DELETE FROM user_spending_limits WHERE user_id = $1`},
+	{"organizations", `DELETE FROM organizations WHERE owner_id = $1`},
+	// Крипто-удаление: без ключей данных копии истории и промптов в бэкапах не расшифровать
+	{"user_data_keys", `DELETE FROM user_data_keys WHERE user_id = $1`},
+}
+
+type PrivacyService struct {
+	bot           *telegram.Client
+	notifications *NotificationService
+	transcripts   *ExportService
+}
+
+func NewPrivacyService(bot *telegram.Client) *PrivacyService {
+	return &PrivacyService{
+		bot:           bot,
+		notifications: NewNotificationService(bot),
+		transcripts:   NewExportService(bot),
+	}
+}
+
+// writePrivacyAudit записывает действие с персональными данными в журнал
+func writePrivacyAudit(ctx context.Context, q execer, userID int, action string, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO privacy_audit_log (user_id, action, details, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+	`, userID, action, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to write privacy audit: %w", err)
+	}
+
+	log.Infof("🔏 Privacy: %s for user %d %v", action, userID, details)
+
+	return nil
+}
+
+func scanDataExport(row pgx.Row) (*models.DataExport, error) {
+	var e models.DataExport
+	err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.SizeBytes, &e.Error, &e.DownloadToken, &e.CreatedAt, &e.FinishedAt, &e.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func scanAccountDeletion(row pgx.Row) (*models.AccountDeletion, error) {
+	var d models.AccountDeletion
+	err := row.Scan(&d.ID, &d.UserID, &d.Status, &d.RequestedAt, &d.ScheduledFor, &d.CanceledAt, &d.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// checkActiveUser проверяет, что пользователь существует и не удален
+func checkActiveUser(ctx context.Context, userID int) error {
+	var exists bool
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)
+	`, userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check user: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// telegramIDHash возвращает ключевой хеш telegram_id (ключ - DELETED_ACCOUNT_SALT):
+// по нему можно узнать вернувшийся аккаунт, но нельзя перебором восстановить ID
+func telegramIDHash(telegramID string) string {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.DeletedAccountSalt))
+	mac.Write([]byte(telegramID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isDeletedAccount проверяет, принадлежит ли telegram_id пользователя ранее удаленному аккаунту
+func isDeletedAccount(ctx context.Context, q rowQuerier, userID int) (bool, error) {
+	var telegramID string
+	err := q.QueryRow(ctx, `SELECT telegram_id FROM users WHERE id = $1`, userID).Scan(&telegramID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var deleted bool
+	err = q.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM deleted_accounts WHERE telegram_hash = $1)
+	`, telegramIDHash(telegramID)).Scan(&deleted)
+	if err != nil {
+		return false, fmt.Errorf("failed to check deleted accounts: %w", err)
+	}
+	return deleted, nil
+}
+
+// generateDownloadToken возвращает случайный токен для скачивания архива
+func generateDownloadToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate download token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// RequestExport ставит сборку архива с данными пользователя в очередь.
+// Повторный запрос, пока архив собирается, возвращает ту же выгрузку.
+func (s *PrivacyService) RequestExport(ctx context.Context, userID int) (*models.DataExport, error) {
+	if err := checkActiveUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	conn := database.Database.Pool
+
+	export, err := scanDataExport(conn.QueryRow(ctx, `
+		INSERT INTO data_exports (user_id, status, created_at)
+		VALUES ($1, 'pending', CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'processing') DO NOTHING
+		RETURNING `+dataExportColumns, userID))
+	if err == pgx.ErrNoRows {
+		export, err = scanDataExport(conn.QueryRow(ctx, `
+			SELECT `+dataExportColumns+` FROM data_exports
+			WHERE user_id = $1 AND status IN ('pending', 'processing')
+		`, userID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data export: %w", err)
+		}
+		return export, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data export: %w", err)
+	}
+
+	if err := writePrivacyAudit(ctx, conn, userID, PrivacyActionExportRequested, map[string]interface{}{
+		"export_id": export.ID,
+	}); err != nil {
+		log.Warnf("Failed to audit data export %d: %v", export.ID, err)
+	}
+
+	return export, nil
+}
+
+// GetExports возвращает последние выгрузки пользователя
+func (s *PrivacyService) GetExports(ctx context.Context, userID int) ([]models.DataExport, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT `+dataExportColumns+` FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 10
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data export: %w", err)
+		}
+		exports = append(exports, *export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get data exports: %w", err)
+	}
+
+	return exports, nil
+}
+
+// OpenExport открывает готовый архив по токену скачивания и возвращает id выгрузки;
+// файл закрывает вызывающий
+func (s *PrivacyService) OpenExport(ctx context.Context, token string) (*os.File, int, error) {
+	var exportID, userID int
+	var status string
+	var filePath *string
+	var expired bool
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT id, user_id, status, file_path, COALESCE(expires_at <= CURRENT_TIMESTAMP, false)
+		FROM data_exports
+		WHERE download_token = $1
+	`, token).Scan(&exportID, &userID, &status, &filePath, &expired)
+	if err == pgx.ErrNoRows {
+		return nil, 0, fmt.Errorf("export not found")
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get data export: %w", err)
+	}
+
+	if status == "expired" || (status == "ready" && expired) {
+		return nil, 0, fmt.Errorf("export expired")
+	}
+	if status != "ready" || filePath == nil {
+		return nil, 0, fmt.Errorf("export is not ready")
+	}
+
+	file, err := os.Open(*filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open data export: %w", err)
+	}
+
+	if err := writePrivacyAudit(ctx, database.Database.Pool, userID, PrivacyActionExportDownloaded, map[string]interface{}{
+		"export_id": exportID,
+	}); err != nil {
+		log.Warnf("Failed to audit data export %d download: %v", exportID, err)
+	}
+
+	return file, exportID, nil
+}
+
+// DataExportFilename возвращает имя файла архива
+func DataExportFilename(exportID int) string {
+	return fmt.Sprintf("my-data-%d.zip", exportID)
+}
+
+// BuildPendingExports собирает архивы из очереди. Выгрузки, зависшие в processing
+// (например, после перезапуска), берутся повторно.
+func (s *PrivacyService) BuildPendingExports(ctx context.Context, batchSize int) (int, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		UPDATE data_exports
+		SET status = 'processing', started_at = CURRENT_TIMESTAMP, attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM data_exports
+			WHERE status = 'pending'
+			   OR (status = 'processing' AND started_at < CURRENT_TIMESTAMP - INTERVAL '30 minutes')
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, attempts
+	`, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim data exports: %w", err)
+	}
+	type claimedExport struct {
+		id       int
+		userID   int
+		attempts int
+	}
+	var claimed []claimedExport
+	for rows.Next() {
+		var e claimedExport
+		if err := rows.Scan(&e.id, &e.userID, &e.attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan data export: %w", err)
+		}
+		claimed = append(claimed, e)
+	}
+	rows.Close()
+
+	built := 0
+	for _, e := range claimed {
+		if err := s.buildExport(ctx, e.id, e.userID); err != nil {
+			if ctx.Err() != nil {
+				return built, err
+			}
+			log.Warnf("Failed to build data export %d: %v", e.id, err)
+
+			status := "pending"
+			if e.attempts >= dataExportMaxAttempts {
+				status = "failed"
+			}
+			if _, updateErr := database.Database.Pool.Exec(ctx, `
+				UPDATE data_exports SET status = $2, error = $3 WHERE id = $1
+			`, e.id, status, err.Error()); updateErr != nil {
+				log.Warnf("Failed to record data export %d failure: %v", e.id, updateErr)
+			}
+			continue
+		}
+		built++
+	}
+
+	if built > 0 {
+		log.Infof("📦 Built %d personal data exports", built)
+	}
+
+	return built, nil
+}
+
+// buildExport пишет архив во временный файл и публикует его переименованием,
+// чтобы недособранный архив нельзя было скачать
+func (s *PrivacyService) buildExport(ctx context.Context, exportID int, userID int) error {
+	if err := os.MkdirAll(config.AppConfig.DataExportDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create export dir: %w", err)
+	}
+
+	path := filepath.Join(config.AppConfig.DataExportDir, fmt.Sprintf("user-%d-export-%d.zip", userID, exportID))
+	tmp, err := os.CreateTemp(config.AppConfig.DataExportDir, "export-*.zip.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.writeArchive(ctx, userID, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to stat export file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to publish export file: %w", err)
+	}
+
+	token, err := generateDownloadToken()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	conn := database.Database.Pool
+	_, err = conn.Exec(ctx, `
+		UPDATE data_exports
+		SET status = 'ready', file_path = $2, size_bytes = $3, error = NULL, download_token = $5,
+		    finished_at = CURRENT_TIMESTAMP,
+		    expires_at = CURRENT_TIMESTAMP + make_interval(hours => $4)
+		WHERE id = $1
+	`, exportID, path, info.Size(), config.AppConfig.DataExportTTLHours, token)
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to save data export: %w", err)
+	}
+
+	if err := writePrivacyAudit(ctx, conn, userID, PrivacyActionExportReady, map[string]interface{}{
+		"export_id":  exportID,
+		"size_bytes": info.Size(),
+	}); err != nil {
+		log.Warnf("Failed to audit data export %d: %v", exportID, err)
+	}
+
+	message := fmt.Sprintf("📦 Архив с вашими данными готов. Скачать его можно в течение %d ч.", config.AppConfig.DataExportTTLHours)
+	if err := s.notifications.Notify(ctx, userID, NotificationDataExport, message); err != nil {
+		log.Warnf("Failed to notify user %d about data export %d: %v", userID, exportID, err)
+	}
+
+	return nil
+}
+
+// writeArchive записывает ZIP со всеми данными пользователя: таблицы в JSON
+// и историю разговоров в формате выгрузки conversation.json
+func (s *PrivacyService) writeArchive(ctx context.Context, userID int, w io.Writer) error {
+	buf := bufio.NewWriterSize(w, 64*1024)
+	archive := zip.NewWriter(buf)
+
+	for _, file := range dataExportFiles {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", file.name, err)
+		}
+		encrypted, ok := encryptedExportFiles[file.name]
+		var field *encryptedColumn
+		if ok {
+			field = &encrypted
+		}
+		if err := writeJSONRows(ctx, entry, file.query, userID, field); err != nil {
+			return fmt.Errorf("failed to export %s: %w", file.name, err)
+		}
+	}
+
+	entry, err := archive.Create("conversation.json")
+	if err != nil {
+		return fmt.Errorf("failed to add conversation.json: %w", err)
+	}
+	filter := &TranscriptExportFilter{UserID: userID, Format: "json"}
+	if err := s.transcripts.WriteTranscript(ctx, filter, entry); err != nil {
+		return fmt.Errorf("failed to export conversation.json: %w", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// writeJSONRows записывает результат запроса JSON-массивом, не собирая его в памяти.
+// Если задано encrypted, это поле каждой строки расшифровывается.
+func writeJSONRows(ctx context.Context, w io.Writer, query string, userID int, encrypted *encryptedColumn) error {
+	rows, err := database.Database.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	count := 0
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if encrypted != nil {
+			var err error
+			if row, err = decryptJSONRow(ctx, userID, *encrypted, row); err != nil {
+				return err
+			}
+		}
+		if count > 0 {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return err
+			}
+		}
+		count++
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// decryptJSONRow заменяет зашифрованное поле строки открытым текстом
+func decryptJSONRow(ctx context.Context, userID int, encrypted encryptedColumn, row []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(row, &fields); err != nil {
+		return nil, err
+	}
+
+	var keyID *int
+	if err := json.Unmarshal(fields[encrypted.keyColumn], &keyID); err != nil {
+		return nil, err
+	}
+	if keyID == nil {
+		return row, nil
+	}
+	var content string
+	if err := json.Unmarshal(fields[encrypted.column], &content); err != nil {
+		return nil, err
+	}
+
+	plain, err := openContent(ctx, userID, encrypted.kind, content, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if fields[encrypted.column], err = json.Marshal(plain); err != nil {
+		return nil, err
+	}
+	delete(fields, encrypted.keyColumn)
+	return json.Marshal(fields)
+}
+
+// PurgeExpiredExports удаляет файлы архивов с истекшим сроком хранения
+func (s *PrivacyService) PurgeExpiredExports(ctx context.Context) (int, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		WITH expired AS (
+			SELECT id, file_path FROM data_exports
+			WHERE status = 'ready' AND expires_at <= CURRENT_TIMESTAMP
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE data_exports d
+		SET status = 'expired', file_path = NULL, download_token = NULL
+		FROM expired
+		WHERE d.id = expired.id
+		RETURNING expired.file_path
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire data exports: %w", err)
+	}
+	var paths []string
+	for rows.Next() {
+		var path *string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan data export: %w", err)
+		}
+		if path != nil {
+			paths = append(paths, *path)
+		}
+	}
+	rows.Close()
+
+	removeExportFiles(paths)
+
+	if len(paths) > 0 {
+		log.Infof("🗑️ Removed %d expired data exports", len(paths))
+	}
+
+	return len(paths), nil
+}
+
+func removeExportFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove data export file %s: %v", path, err)
+		}
+	}
+}
+
+// RequestDeletion планирует удаление аккаунта через ACCOUNT_DELETION_GRACE_DAYS дней.
+// До этого срока запрос можно отменить. Повторный запрос возвращает уже запланированный.
+func (s *PrivacyService) RequestDeletion(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	if err := checkActiveUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	conn := database.Database.Pool
+
+	deletion, err := scanAccountDeletion(conn.QueryRow(ctx, `
+		INSERT INTO account_deletions (user_id, status, requested_at, scheduled_for)
+		VALUES ($1, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + make_interval(days => $2))
+		ON CONFLICT (user_id) WHERE status = 'pending' DO NOTHING
+		RETURNING `+accountDeletionColumns, userID, config.AppConfig.AccountDeletionGraceDays))
+	if err == pgx.ErrNoRows {
+		return s.GetDeletion(ctx, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account deletion: %w", err)
+	}
+
+	if err := writePrivacyAudit(ctx, conn, userID, PrivacyActionDeletionRequested, map[string]interface{}{
+		"deletion_id":   deletion.ID,
+		"scheduled_for": deletion.ScheduledFor,
+	}); err != nil {
+		log.Warnf("Failed to audit account deletion %d: %v", deletion.ID, err)
+	}
+
+	message := fmt.Sprintf("⚠️ Аккаунт и все данные будут удалены %s. До этого момента удаление можно отменить.",
+		deletion.ScheduledFor.Format("02.01.2006"))
+	if err := s.notifications.Notify(ctx, userID, NotificationAccountDelete, message); err != nil {
+		log.Warnf("Failed to notify user %d about account deletion: %v", userID, err)
+	}
+
+	return deletion, nil
+}
+
+// GetDeletion возвращает запланированное удаление аккаунта
+func (s *PrivacyService) GetDeletion(ctx context.Context, userID int) (*models.AccountDeletion, error) {
+	deletion, err := scanAccountDeletion(database.Database.Pool.QueryRow(ctx, `
+		SELECT `+accountDeletionColumns+` FROM account_deletions
+		WHERE user_id = $1 AND status = 'pending'
+	`, userID))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("deletion not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deletion: %w", err)
+	}
+	return deletion, nil
+}
+
+// CancelDeletion отменяет запланированное удаление аккаунта
+func (s *PrivacyService) CancelDeletion(ctx context.Context, userID int) error {
+	conn := database.Database.Pool
+
+	var deletionID int
+	err := conn.QueryRow(ctx, `
+		UPDATE account_deletions
+		SET status = 'canceled', canceled_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND status = 'pending'
+		RETURNING id
+	`, userID).Scan(&deletionID)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("deletion not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cancel account deletion: %w", err)
+	}
+
+	if err := writePrivacyAudit(ctx, conn, userID, PrivacyActionDeletionCanceled, map[string]interface{}{
+		"deletion_id": deletionID,
+	}); err != nil {
+		log.Warnf("Failed to audit account deletion %d: %v", deletionID, err)
+	}
+
+	return nil
+}
+
+// ProcessDueDeletions удаляет аккаунты, у которых истек период отмены
+func (s *PrivacyService) ProcessDueDeletions(ctx context.Context, batchSize int) (int, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id, user_id FROM account_deletions
+		WHERE status = 'pending' AND scheduled_for <= CURRENT_TIMESTAMP
+		ORDER BY scheduled_for
+		LIMIT $1
+	`, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due account deletions: %w", err)
+	}
+	type dueDeletion struct {
+		id     int
+		userID int
+	}
+	var due []dueDeletion
+	for rows.Next() {
+		var d dueDeletion
+		if err := rows.Scan(&d.id, &d.userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan account deletion: %w", err)
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	deleted := 0
+	for _, d := range due {
+		if err := s.eraseAccount(ctx, d.id, d.userID); err != nil {
+			if ctx.Err() != nil {
+				return deleted, err
+			}
+			log.Errorf("Failed to delete account of user %d: %v", d.userID, err)
+			continue
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// eraseAccount удаляет персональные данные и обезличивает строку users.
+// Строка остается, потому что на нее ссылаются платежи, подписки и журнал токенов.
+func (s *PrivacyService) eraseAccount(ctx context.Context, deletionID int, userID int) error {
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx, `
+		SELECT status FROM account_deletions WHERE id = $1 FOR UPDATE
+	`, deletionID).Scan(&status)
+	if err != nil {
+		return fmt.Errorf("failed to lock account deletion: %w", err)
+	}
+	if status != "pending" {
+		return nil
+	}
+
+	var telegramID string
+	var balance int
+	err = tx.QueryRow(ctx, `
+		SELECT telegram_id, token_balance FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&telegramID, &balance)
+	if err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	// Запоминаем аккаунт, чтобы повторная регистрация не получила бонусы нового пользователя
+	_, err = tx.Exec(ctx, `
+		INSERT INTO deleted_accounts (telegram_hash, deleted_at)
+		VALUES ($1, CURRENT_TIMESTAMP)
+		ON CONFLICT (telegram_hash) DO UPDATE SET deleted_at = EXCLUDED.deleted_at
+	`, telegramIDHash(telegramID))
+	if err != nil {
+		return fmt.Errorf("failed to record deleted account: %w", err)
+	}
+
+	var exportPaths []string
+	rows, err := tx.Query(ctx, `
+		DELETE FROM data_exports WHERE user_id = $1 RETURNING file_path
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete data exports: %w", err)
+	}
+	for rows.Next() {
+		var path *string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan data export: %w", err)
+		}
+		if path != nil {
+			exportPaths = append(exportPaths, *path)
+		}
+	}
+	rows.Close()
+
+	erased := map[string]interface{}{
+		"data_exports": len(exportPaths),
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET selected_prompt_id = NULL, selected_prompt_version = NULL WHERE id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to reset selected prompt: %w", err)
+	}
+	for _, erasure := range accountErasures {
+		result, err := tx.Exec(ctx, erasure.query, userID)
+		if err != nil {
+			return fmt.Errorf("failed to erase %s: %w", erasure.table, err)
+		}
+		erased[erasure.table] = result.RowsAffected()
+	}
+
+	// Подписки и токены прекращаются, записи о них остаются
+	if _, err := tx.Exec(ctx, `
+		UPDATE organization_members SET status = 'removed', removed_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND status = 'active'
+	`, userID); err != nil {
+		return fmt.Errorf("failed to leave organizations: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE scheduled_plan_changes SET status = 'canceled' WHERE user_id = $1 AND status = 'pending'
+	`, userID); err != nil {
+		return fmt.Errorf("failed to cancel plan changes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_subscriptions SET status = 'canceled', end_date = NOW(), updated_at = NOW()
+		WHERE user_id = $1 AND status = 'active'
+	`, userID); err != nil {
+		return fmt.Errorf("failed to cancel subscriptions: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE token_grants SET status = 'forfeited', closed_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND status = 'active'
+	`, userID); err != nil {
+		return fmt.Errorf("failed to close token grants: %w", err)
+	}
+	if balance != 0 {
+		if err := writeLedgerEntry(ctx, tx, userID, -balance, 0, LedgerReasonAccountDeleted, &deletionID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users
+		SET telegram_id = 'deleted:' || id::text,
+		    username = NULL,
+		    first_name = 'Deleted',
+		    last_name = NULL,
+		    language_code = NULL,
+		    is_premium = false,
+		    token_balance = 0,
+		    selected_model = NULL,
+		    selected_voice = NULL,
+		    referral_code = NULL,
+		    deleted_at = CURRENT_TIMESTAMP,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE promo_redemptions SET ip_address = NULL WHERE user_id = $1
+	`, userID); err != nil {
+		return fmt.Errorf("failed to anonymize promo redemptions: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE referrals SET start_param = NULL WHERE referrer_id = $1 OR referee_id = $1
+	`, userID); err != nil {
+		return fmt.Errorf("failed to anonymize referrals: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE account_deletions SET status = 'completed', completed_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, deletionID); err != nil {
+		return fmt.Errorf("failed to complete account deletion: %w", err)
+	}
+
+	erased["deletion_id"] = deletionID
+	erased["forfeited_tokens"] = balance
+	if err := writePrivacyAudit(ctx, tx, userID, PrivacyActionAccountDeleted, erased); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit account deletion: %w", err)
+	}
+
+	removeExportFiles(exportPaths)
+	forgetDataKeys(userID)
+
+	if s.bot.Enabled() {
+		if err := s.bot.SendMessage(ctx, telegramID, "Ваш аккаунт и персональные данные удалены."); err != nil {
+			log.Warnf("Failed to notify user %d about completed deletion: %v", userID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/telegram"
+)
+
+func TestTelegramIDHash(t *testing.T) {
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+
+	config.AppConfig = &config.Config{DeletedAccountSalt: "first"}
+	hash := telegramIDHash("123456789")
+	if len(hash) != 64 || hash == "123456789" {
+		t.Fatalf("telegramIDHash = %q, want hex HMAC-SHA256", hash)
+	}
+	if again := telegramIDHash("123456789"); again != hash {
+		t.Fatalf("telegramIDHash is not stable: %q != %q", again, hash)
+	}
+	if other := telegramIDHash("123456780"); other == hash {
+		t.Fatalf("different telegram IDs share hash %q", hash)
+	}
+
+	config.AppConfig = &config.Config{DeletedAccountSalt: "second"}
+	if salted := telegramIDHash("123456789"); salted == hash {
+		t.Fatalf("hash does not depend on DELETED_ACCOUNT_SALT")
+	}
+}
+
+func TestDeletedAccountSignupGetsNoBonuses(t *testing.T) {
+	ctx := testDB(t)
+	privacy := NewPrivacyService(telegram.NewClient("", ""))
+
+	telegramID := testTelegramID()
+	user := createTestUser(t, ctx, telegramID)
+	deletion, err := privacy.RequestDeletion(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("RequestDeletion: %v", err)
+	}
+	if err := privacy.eraseAccount(ctx, deletion.ID, user.ID); err != nil {
+		t.Fatalf("eraseAccount: %v", err)
+	}
+
+	referrer := createTestUser(t, ctx, testTelegramID())
+	code, err := NewReferralService().EnsureReferralCode(ctx, referrer.ID)
+	if err != nil {
+		t.Fatalf("EnsureReferralCode: %v", err)
+	}
+	startParam := referralStartPrefix + code
+	resp, err := NewUserService().CreateOrUpdateUser(ctx, &models.CreateUserRequest{
+		TelegramID: fmt.Sprint(telegramID),
+		FirstName:  "Returning",
+		StartParam: &startParam,
+	}, 0)
+	if err != nil {
+		t.Fatalf("sign up again: %v", err)
+	}
+	returning := resp.User
+	if returning.ID == user.ID {
+		t.Fatalf("deleted account was reused on sign up")
+	}
+
+	var status string
+	var reason *string
+	err = database.Database.Pool.QueryRow(ctx, `
+		SELECT status, reject_reason FROM referrals WHERE referee_id = $1
+	`, returning.ID).Scan(&status, &reason)
+	if err != nil {
+		t.Fatalf("get referral: %v", err)
+	}
+	if status != "rejected" || reason == nil || *reason != "returning_account" {
+		t.Fatalf("referral = %s %v, want rejected as returning_account", status, reason)
+	}
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	trial, err := NewPlanService().GrantTrialTx(ctx, tx, returning.ID)
+	if err != nil {
+		t.Fatalf("GrantTrialTx: %v", err)
+	}
+	if trial != nil {
+		t.Fatalf("returning account got trial %+v", trial)
+	}
+}
+
+func TestDataExportDownloadToken(t *testing.T) {
+	ctx := testDB(t)
+	privacy := NewPrivacyService(telegram.NewClient("", ""))
+
+	exportDir := config.AppConfig.DataExportDir
+	config.AppConfig.DataExportDir = t.TempDir()
+	t.Cleanup(func() { config.AppConfig.DataExportDir = exportDir })
+
+	user := createTestUser(t, ctx, testTelegramID())
+	export, err := privacy.RequestExport(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("RequestExport: %v", err)
+	}
+	if export.DownloadToken != nil {
+		t.Fatalf("pending export has download token")
+	}
+	if err := privacy.buildExport(ctx, export.ID, user.ID); err != nil {
+		t.Fatalf("buildExport: %v", err)
+	}
+
+	exports, err := privacy.GetExports(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetExports: %v", err)
+	}
+	if len(exports) == 0 || exports[0].ID != export.ID || exports[0].DownloadToken == nil {
+		t.Fatalf("GetExports = %+v, want ready export with download token", exports)
+	}
+	token := *exports[0].DownloadToken
+	if len(token) != 64 {
+		t.Fatalf("download token %q is not 32 random bytes", token)
+	}
+
+	file, exportID, err := privacy.OpenExport(ctx, token)
+	if err != nil {
+		t.Fatalf("OpenExport: %v", err)
+	}
+	file.Close()
+	if exportID != export.ID {
+		t.Fatalf("OpenExport returned export %d, want %d", exportID, export.ID)
+	}
+
+	if _, _, err := privacy.OpenExport(ctx, fmt.Sprint(export.ID)); err == nil || err.Error() != "export not found" {
+		t.Fatalf("OpenExport by export id: err = %v, want export not found", err)
+	}
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+type PromoService struct {
+	tokenService *TokenService
+	planService  *PlanService
+}
+
+func NewPromoService() *PromoService {
+	return &PromoService{
+		tokenService: NewTokenService(),
+		planService:  NewPlanService(),
+	}
+}
+
+// execer - общий интерфейс пула и транзакции для простых UPDATE
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+const promoCodeColumns = `
+	id, code, kind, token_amount, discount_percent, trial_days, plan_id,
+	max_uses, uses_count, expires_at, is_active, created_by, created_at
+`
+
+func scanPromoCode(row pgx.Row, promo *models.PromoCode) error {
+	return row.Scan(
+		&promo.ID, &promo.Code, &promo.Kind, &promo.TokenAmount, &promo.DiscountPercent,
+		&promo.TrialDays, &promo.PlanID, &promo.MaxUses, &promo.UsesCount, &promo.ExpiresAt,
+		&promo.IsActive, &promo.CreatedBy, &promo.CreatedAt,
+	)
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CreatePromoCode создает промокод
+func (s *PromoService) CreatePromoCode(ctx context.Context, req *models.CreatePromoCodeRequest) (*models.PromoCode, error) {
+	code := normalizePromoCode(req.Code)
+	if code == "" {
+		return nil, fmt.Errorf("invalid promo code: code is empty")
+	}
+
+	switch req.Kind {
+	case "tokens":
+		if req.TokenAmount <= 0 {
+			return nil, fmt.Errorf("invalid promo code: token_amount must be positive")
+		}
+	case "discount":
+		if req.DiscountPercent <= 0 || req.DiscountPercent >= 100 {
+			return nil, fmt.Errorf("invalid promo code: discount_percent must be between 1 and 99")
+		}
+	case "trial":
+		if req.TrialDays <= 0 || req.PlanID == nil {
+			return nil, fmt.Errorf("invalid promo code: trial requires plan_id and positive trial_days")
+		}
+	default:
+		return nil, fmt.Errorf("invalid promo code: kind must be tokens, discount or trial")
+	}
+
+	if req.MaxUses != nil && *req.MaxUses <= 0 {
+		return nil, fmt.Errorf("invalid promo code: max_uses must be positive")
+	}
+
+	conn := database.Database.Pool
+
+	var promo models.PromoCode
+	err := scanPromoCode(conn.QueryRow(ctx, `
+		INSERT INTO promo_codes (
+			code, kind, token_amount, discount_percent, trial_days, plan_id,
+			max_uses, expires_at, created_by, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING `+promoCodeColumns,
+		code, req.Kind, req.TokenAmount, req.DiscountPercent, req.TrialDays, req.PlanID,
+		req.MaxUses, req.ExpiresAt, req.CreatedBy), &promo)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("promo code already exists")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create promo code: %w", err)
+	}
+
+	log.Infof("✅ Created promo code %s (%s)", promo.Code, promo.Kind)
+
+	return &promo, nil
+}
+
+// GetPromoCodes получает все промокоды для админки
+func (s *PromoService) GetPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT `+promoCodeColumns+`
+		FROM promo_codes
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promo codes: %w", err)
+	}
+	defer rows.Close()
+
+	var promos []models.PromoCode
+	for rows.Next() {
+		var promo models.PromoCode
+		if err := scanPromoCode(rows, &promo); err != nil {
+			return nil, fmt.Errorf("failed to scan promo code: %w", err)
+		}
+		promos = append(promos, promo)
+	}
+
+	return promos, nil
+}
+
+// DeactivatePromoCode отключает промокод
+func (s *PromoService) DeactivatePromoCode(ctx context.Context, promoID int) error {
+	conn := database.Database.Pool
+
+	result, err := conn.Exec(ctx, `
+		UPDATE promo_codes SET is_active = false WHERE id = $1
+	`, promoID)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate promo code: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("promo code not found")
+	}
+
+	log.Infof("✅ Deactivated promo code ID: %d", promoID)
+
+	return nil
+}
+
+// GetRedemptions получает журнал активаций промокода
+func (s *PromoService) GetRedemptions(ctx context.Context, promoID int, limit int) ([]models.PromoRedemption, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT pr.id, pr.promo_code_id, pc.code, pr.user_id, pr.status, pr.tokens_granted,
+		       pr.subscription_id, pr.payment_id, pr.ip_address, pr.created_at
+		FROM promo_redemptions pr
+		JOIN promo_codes pc ON pc.id = pr.promo_code_id
+		WHERE pr.promo_code_id = $1
+		ORDER BY pr.created_at DESC
+		LIMIT $2
+	`, promoID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query redemptions: %w", err)
+	}
+	defer rows.Close()
+
+	var redemptions []models.PromoRedemption
+	for rows.Next() {
+		var r models.PromoRedemption
+		err := rows.Scan(
+			&r.ID, &r.PromoCodeID, &r.Code, &r.UserID, &r.Status, &r.TokensGranted,
+			&r.SubscriptionID, &r.PaymentID, &r.IPAddress, &r.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan redemption: %w", err)
+		}
+		redemptions = append(redemptions, r)
+	}
+
+	return redemptions, nil
+}
+
+// RedeemPromoCode активирует промокод. Проверка лимитов, начисление и запись
+// в журнал выполняются в одной транзакции.
+func (s *PromoService) RedeemPromoCode(ctx context.Context, userID int, code string, ipAddress *string) (*models.PromoRedemption, error) {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var promo models.PromoCode
+	err = scanPromoCode(tx.QueryRow(ctx, `
+		SELECT `+promoCodeColumns+`
+		FROM promo_codes
+		WHERE code = $1
+		FOR UPDATE
+	`, normalizePromoCode(code)), &promo)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("promo code not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get promo code: %w", err)
+	}
+
+	var expired bool
+	err = tx.QueryRow(ctx, `
+		SELECT expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP FROM promo_codes WHERE id = $1
+	`, promo.ID).Scan(&expired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check promo expiry: %w", err)
+	}
+
+	switch {
+	case !promo.IsActive:
+		return nil, fmt.Errorf("promo code not found")
+	case expired:
+		return nil, fmt.Errorf("promo code expired")
+	case promo.MaxUses != nil && promo.UsesCount >= *promo.MaxUses:
+		return nil, fmt.Errorf("promo code usage limit reached")
+	}
+
+	// Бонусные токены по коду с plan_id доступны только подписчикам этого плана
+	if promo.Kind == "tokens" && promo.PlanID != nil {
+		var onPlan bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM user_subscriptions
+				WHERE user_id = $1 AND plan_id = $2 AND status = 'active'
+			)
+		`, userID, *promo.PlanID).Scan(&onPlan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check user plan: %w", err)
+		}
+		if !onPlan {
+			return nil, fmt.Errorf("promo code not applicable to current plan")
+		}
+	}
+
+	// Пробный план активируется заменой текущей подписки: оплаченный план
+	// вместе с его токенами пропал бы
+	if promo.Kind == "trial" {
+		paid, err := hasPaidSubscription(ctx, tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if paid {
+			return nil, fmt.Errorf("promo code not available with paid subscription")
+		}
+	}
+
+	var redemption models.PromoRedemption
+	err = tx.QueryRow(ctx, `
+		INSERT INTO promo_redemptions (promo_code_id, user_id, status, ip_address, created_at, updated_at)
+		VALUES ($1, $2, 'pending', $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (promo_code_id, user_id) DO NOTHING
+		RETURNING id, created_at
+	`, promo.ID, userID, ipAddress).Scan(&redemption.ID, &redemption.CreatedAt)
+
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("promo code already redeemed")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record redemption: %w", err)
+	}
+
+	redemption.PromoCodeID = promo.ID
+	redemption.Code = promo.Code
+	redemption.UserID = userID
+	redemption.IPAddress = ipAddress
+	redemption.Status = "credited"
+
+	switch promo.Kind {
+	case "tokens":
+		expiresAt := TokenTTL(config.AppConfig.PromoTokenTTLDays)
+		if _, err := s.tokenService.GrantTokensTx(ctx, tx, userID, promo.TokenAmount, TokenSourcePromo, &redemption.ID, expiresAt); err != nil {
+			return nil, err
+		}
+		redemption.TokensGranted = promo.TokenAmount
+
+	case "trial":
+		subscriptionID, newBalance, err := s.planService.GrantPlanTx(ctx, tx, userID, *promo.PlanID, "promo:"+promo.Code, promo.TrialDays)
+		if err != nil {
+			return nil, err
+		}
+		redemption.SubscriptionID = &subscriptionID
+		redemption.TokensGranted = newBalance
+
+	case "discount":
+		// Скидка применяется при следующей оплате плана
+		redemption.Status = "pending"
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE promo_redemptions
+		SET status = $1, tokens_granted = $2, subscription_id = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`, redemption.Status, redemption.TokensGranted, redemption.SubscriptionID, redemption.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update redemption: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE promo_codes SET uses_count = uses_count + 1 WHERE id = $1
+	`, promo.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update promo usage: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("🎟️ User %d redeemed promo code %s (%s)", userID, promo.Code, promo.Kind)
+
+	return &redemption, nil
+}
+
+// reserveDiscount резервирует неиспользованную скидку пользователя на план.
+// Возвращает id активации и процент скидки (0, если скидки нет).
+func (s *PromoService) reserveDiscount(ctx context.Context, userID int, planID int) (*int, int, error) {
+	conn := database.Database.Pool
+
+	var redemptionID, percent int
+	err := conn.QueryRow(ctx, `
+		UPDATE promo_redemptions pr
+		SET status = 'reserved', updated_at = CURRENT_TIMESTAMP
+		FROM promo_codes pc
+		WHERE pc.id = pr.promo_code_id
+		  AND pr.id = (
+			SELECT pr2.id
+			FROM promo_redemptions pr2
+			JOIN promo_codes pc2 ON pc2.id = pr2.promo_code_id
+			WHERE pr2.user_id = $1 AND pr2.status = 'pending' AND pc2.kind = 'discount'
+			  AND (pc2.plan_id IS NULL OR pc2.plan_id = $2)
+			ORDER BY pc2.discount_percent DESC, pr2.created_at ASC
+			LIMIT 1
+			FOR UPDATE OF pr2 SKIP LOCKED
+		  )
+		RETURNING pr.id, pc.discount_percent
+	`, userID, planID).Scan(&redemptionID, &percent)
+
+	if err == pgx.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to reserve discount: %w", err)
+	}
+
+	return &redemptionID, percent, nil
+}
+
+// attachDiscount связывает зарезервированную скидку с заказом
+func (s *PromoService) attachDiscount(ctx context.Context, q execer, redemptionID int, paymentID int) error {
+	_, err := q.Exec(ctx, `
+		UPDATE promo_redemptions SET payment_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+	`, paymentID, redemptionID)
+	if err != nil {
+		return fmt.Errorf("failed to attach discount: %w", err)
+	}
+	return nil
+}
+
+// applyDiscount помечает скидку использованной после оплаты заказа
+func (s *PromoService) applyDiscount(ctx context.Context, q execer, paymentID int) error {
+	_, err := q.Exec(ctx, `
+		UPDATE promo_redemptions
+		SET status = 'applied', updated_at = CURRENT_TIMESTAMP
+		WHERE id = (SELECT promo_redemption_id FROM payments WHERE id = $1)
+	`, paymentID)
+	if err != nil {
+		return fmt.Errorf("failed to apply discount: %w", err)
+	}
+	return nil
+}
+
+// releaseDiscount возвращает скидку пользователю, если заказ не оплачен
+func (s *PromoService) releaseDiscount(ctx context.Context, q execer, redemptionID int) error {
+	_, err := q.Exec(ctx, `
+		UPDATE promo_redemptions
+		SET status = 'pending', payment_id = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'reserved'
+	`, redemptionID)
+	if err != nil {
+		return fmt.Errorf("failed to release discount: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/payments/paymentstest"
+)
+
+func TestTrialPromoRejectedWithPaidSubscription(t *testing.T) {
+	ctx := testDB(t)
+	fake := paymentstest.NewServer("shop", "secret", "webhook-secret")
+	defer fake.Close()
+	paymentService := NewPaymentService(nil, fake.Gateway())
+	promoService := NewPromoService()
+
+	user := createTestUser(t, ctx, testTelegramID())
+	paidPlan := createTestPlan(t, ctx, 1000, 199, 0)
+	trialPlan := createTestPlan(t, ctx, 5000, 499, 0)
+	paidTestCheckout(t, ctx, paymentService, fake, user.ID, paidPlan.ID)
+
+	promo, err := promoService.CreatePromoCode(ctx, &models.CreatePromoCodeRequest{
+		Code:      fmt.Sprintf("TRIAL%d", time.Now().UnixNano()),
+		Kind:      "trial",
+		TrialDays: 7,
+		PlanID:    &trialPlan.ID,
+	})
+	if err != nil {
+		t.Fatalf("CreatePromoCode: %v", err)
+	}
+
+	_, err = promoService.RedeemPromoCode(ctx, user.ID, promo.Code, nil)
+	if err == nil || err.Error() != "promo code not available with paid subscription" {
+		t.Fatalf("RedeemPromoCode() error = %v, want paid subscription rejection", err)
+	}
+
+	if balance := userTokenBalance(t, ctx, user.ID); balance != paidPlan.TokenAmount {
+		t.Errorf("token_balance = %d, want %d", balance, paidPlan.TokenAmount)
+	}
+
+	// Без оплаченной подписки код срабатывает
+	other := createTestUser(t, ctx, testTelegramID())
+	redemption, err := promoService.RedeemPromoCode(ctx, other.ID, promo.Code, nil)
+	if err != nil {
+		t.Fatalf("RedeemPromoCode() for unpaid user: %v", err)
+	}
+	if redemption.SubscriptionID == nil {
+		t.Error("trial redemption has no subscription")
+	}
+}
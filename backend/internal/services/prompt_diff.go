@@ -0,0 +1,116 @@
+package services
+
+import (
+	"strings"
+	"voice-ai-backend/internal/models"
+)
+
+// Операции построчного сравнения
+const (
+	DiffOpEqual  = "equal"
+	DiffOpAdd    = "add"
+	DiffOpRemove = "remove"
+)
+
+// diffMaxCells - ограничение на размер таблицы LCS; для больших текстов
+// сравнение вырождается в «удалено все старое, добавлено все новое»
+const diffMaxCells = 4_000_000
+
+// diffLines сравнивает тексты построчно по наибольшей общей подпоследовательности
+func diffLines(from string, to string) []models.PromptDiffLine {
+	a := strings.Split(from, "\n")
+	b := strings.Split(to, "\n")
+
+	// Общие начало и конец не участвуют в LCS
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]models.PromptDiffLine, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		lines = append(lines, models.PromptDiffLine{Op: DiffOpEqual, Text: line})
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, models.PromptDiffLine{Op: DiffOpEqual, Text: line})
+	}
+	return lines
+}
+
+func diffMiddle(a []string, b []string) []models.PromptDiffLine {
+	var lines []models.PromptDiffLine
+	if len(a)*len(b) > diffMaxCells {
+		for _, line := range a {
+			lines = append(lines, models.PromptDiffLine{Op: DiffOpRemove, Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, models.PromptDiffLine{Op: DiffOpAdd, Text: line})
+		}
+		return lines
+	}
+
+	// lcs[i][j] - длина общей подпоследовательности a[i:] и b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, models.PromptDiffLine{Op: DiffOpEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, models.PromptDiffLine{Op: DiffOpRemove, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, models.PromptDiffLine{Op: DiffOpAdd, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, models.PromptDiffLine{Op: DiffOpRemove, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, models.PromptDiffLine{Op: DiffOpAdd, Text: b[j]})
+	}
+	return lines
+}
+
+// unifiedDiff форматирует построчное сравнение как unified diff без разбивки на блоки
+func unifiedDiff(fromName string, toName string, lines []models.PromptDiffLine) string {
+	var b strings.Builder
+	b.WriteString("--- " + fromName + "\n")
+	b.WriteString("+++ " + toName + "\n")
+	for _, line := range lines {
+		switch line.Op {
+		case DiffOpAdd:
+			b.WriteString("+")
+		case DiffOpRemove:
+			b.WriteString("-")
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(line.Text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
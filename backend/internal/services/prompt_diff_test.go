@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/models"
+)
+
+func TestDiffLines(t *testing.T) {
+	eq := func(text string) models.PromptDiffLine { return models.PromptDiffLine{Op: DiffOpEqual, Text: text} }
+	add := func(text string) models.PromptDiffLine { return models.PromptDiffLine{Op: DiffOpAdd, Text: text} }
+	del := func(text string) models.PromptDiffLine { return models.PromptDiffLine{Op: DiffOpRemove, Text: text} }
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     []models.PromptDiffLine
+	}{
+		{"identical", "a\nb\nc", "a\nb\nc", []models.PromptDiffLine{eq("a"), eq("b"), eq("c")}},
+		{"both empty", "", "", []models.PromptDiffLine{eq("")}},
+		{"insert in the middle", "a\nc", "a\nb\nc", []models.PromptDiffLine{eq("a"), add("b"), eq("c")}},
+		{"insert at the start", "b\nc", "a\nb\nc", []models.PromptDiffLine{add("a"), eq("b"), eq("c")}},
+		{"insert at the end", "a\nb", "a\nb\nc", []models.PromptDiffLine{eq("a"), eq("b"), add("c")}},
+		{"delete in the middle", "a\nb\nc", "a\nc", []models.PromptDiffLine{eq("a"), del("b"), eq("c")}},
+		{"delete at the end", "a\nb\nc", "a\nb", []models.PromptDiffLine{eq("a"), eq("b"), del("c")}},
+		{"replace", "a\nb\nc", "a\nB\nc", []models.PromptDiffLine{eq("a"), del("b"), add("B"), eq("c")}},
+		{"replace everything", "a\nb", "c\nd", []models.PromptDiffLine{del("a"), del("b"), add("c"), add("d")}},
+		{"from empty", "", "a", []models.PromptDiffLine{del(""), add("a")}},
+		{
+			"common lines between changes", "a\nx\nb\ny\nc", "a\nb\nz\nc",
+			[]models.PromptDiffLine{eq("a"), del("x"), eq("b"), del("y"), add("z"), eq("c")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLargeInputFallsBack(t *testing.T) {
+	var from, to []string
+	for i := 0; i < 2100; i++ {
+		from = append(from, fmt.Sprintf("old %d", i))
+		to = append(to, fmt.Sprintf("new %d", i))
+	}
+
+	lines := diffLines(strings.Join(from, "\n"), strings.Join(to, "\n"))
+	if len(lines) != len(from)+len(to) {
+		t.Fatalf("diffLines() returned %d lines, want %d", len(lines), len(from)+len(to))
+	}
+	for i, line := range lines {
+		want := DiffOpRemove
+		if i >= len(from) {
+			want = DiffOpAdd
+		}
+		if line.Op != want {
+			t.Fatalf("line %d op = %s, want %s", i, line.Op, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got := unifiedDiff("v1", "v2", diffLines("a\nb\nc", "a\nB\nc"))
+	want := "--- v1\n+++ v2\n a\n-b\n+B\n c\n"
+	if got != want {
+		t.Errorf("unifiedDiff() = %q, want %q", got, want)
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	ctx := testDB(t)
+	basePrompts := NewBasePromptService()
+	prompts := NewPromptService()
+
+	prompt, err := basePrompts.CreateBasePrompt(ctx, &models.CreateBasePromptRequest{
+		BasePromptData: models.BasePromptData{
+			Slug:    fmt.Sprintf("diff-test-%d", time.Now().UnixNano()),
+			Title:   "Учитель",
+			Content: "Ты учитель.\nГовори медленно.\nИсправляй ошибки.",
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateBasePrompt: %v", err)
+	}
+	title, content := "Учитель английского", "Ты учитель.\nГовори медленно и четко.\nИсправляй ошибки.\nХвали за успехи."
+	if _, err := basePrompts.UpdateBasePrompt(ctx, &models.UpdateBasePromptRequest{
+		PromptID: prompt.ID, Title: &title, Content: &content,
+	}); err != nil {
+		t.Fatalf("UpdateBasePrompt: %v", err)
+	}
+
+	diff, err := prompts.DiffVersions(ctx, prompt.ID, 1, 0)
+	if err != nil {
+		t.Fatalf("DiffVersions: %v", err)
+	}
+	if diff.From != 1 || diff.To != 2 {
+		t.Fatalf("DiffVersions() compares %d..%d, want 1..2", diff.From, diff.To)
+	}
+	wantContent := []models.PromptDiffLine{
+		{Op: DiffOpEqual, Text: "Ты учитель."},
+		{Op: DiffOpRemove, Text: "Говори медленно."},
+		{Op: DiffOpAdd, Text: "Говори медленно и четко."},
+		{Op: DiffOpEqual, Text: "Исправляй ошибки."},
+		{Op: DiffOpAdd, Text: "Хвали за успехи."},
+	}
+	if !reflect.DeepEqual(diff.Content, wantContent) {
+		t.Errorf("content diff = %v, want %v", diff.Content, wantContent)
+	}
+	if len(diff.Fields) != 1 || diff.Fields[0].Field != "title" || *diff.Fields[0].To != title {
+		t.Errorf("field changes = %+v, want the title", diff.Fields)
+	}
+
+	same, err := prompts.DiffVersions(ctx, prompt.ID, 2, 2)
+	if err != nil {
+		t.Fatalf("DiffVersions of the same version: %v", err)
+	}
+	if len(same.Fields) != 0 {
+		t.Errorf("identical versions have field changes %+v", same.Fields)
+	}
+	for _, line := range same.Content {
+		if line.Op != DiffOpEqual {
+			t.Fatalf("identical versions have content change %+v", line)
+		}
+	}
+
+	if _, err := prompts.DiffVersions(ctx, prompt.ID, 1, 99); err == nil {
+		t.Errorf("DiffVersions with a missing version succeeded")
+	}
+}
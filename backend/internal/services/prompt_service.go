@@ -2,9 +2,14 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"strconv"
+	"strings"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/prompttmpl"
 
 	"github.com/jackc/pgx/v5"
 	log "github.com/sirupsen/logrus"
@@ -20,55 +25,45 @@ func NewPromptService() *PromptService {
 func (s *PromptService) GetUserPrompts(ctx context.Context, userID int) (*models.PromptsResponse, error) {
 	conn := database.Database.Pool
 
-	// Получаем уровень плана пользователя
-	var planName string
-	var planLevel int
+	// Получаем план и язык пользователя
+	var languageCode string
+	planName := freePlanName
 	err := conn.QueryRow(ctx, `
-		SELECT
-			COALESCE(sp.name, 'Бесплатный план') as plan_name,
-			CASE
-				WHEN sp.name = 'Базовый' THEN 1
-				WHEN sp.name = 'Премиум' THEN 2
-				WHEN sp.name = 'Про' THEN 3
-				ELSE 1
-			END as plan_level
+		SELECT COALESCE(u.language_code, ''), COALESCE(sp.name, $2)
 		FROM users u
 		LEFT JOIN user_subscriptions us ON u.id = us.user_id AND us.status = 'active'
 		LEFT JOIN subscription_plans sp ON us.plan_id = sp.id
 		WHERE u.id = $1
-	`, userID).Scan(&planName, &planLevel)
+	`, userID, freePlanName).Scan(&languageCode, &planName)
 
 	if err != nil && err != pgx.ErrNoRows {
 		return nil, fmt.Errorf("failed to get user plan: %w", err)
 	}
 
-	if err == pgx.ErrNoRows {
-		planName = "Бесплатный план"
-		planLevel = 1
-	}
+	planLevel, maxUserPrompts, allBasePrompts := subscriptionPromptTier(planName)
 
-	// Получаем базовые промпты в зависимости от уровня плана
+	// Получаем базовые промпты в зависимости от уровня плана, с названием на языке пользователя
 	var basePrompts []models.VoicePrompt
 	var baseQuery string
-	var baseArgs []interface{}
+	baseArgs := []interface{}{languageBase(languageCode)}
 
-	if planLevel == 3 {
-		// Для Про плана - все базовые промпты
+	if allBasePrompts {
+		// Для старшего плана - все базовые промпты
 		baseQuery = `
-			SELECT id, title, description, content, plan_required, category, voice_gender, is_active, created_at
+			SELECT id, COALESCE(NULLIF(titles->>$1, ''), title), description, content, plan_required, category, voice_gender, is_active, current_version, created_at
 			FROM voice_prompts
 			WHERE is_base = true AND is_active = true
-			ORDER BY plan_required ASC, title ASC
+			ORDER BY sort_order ASC, plan_required ASC, title ASC
 		`
 	} else {
 		// Для остальных планов - по уровню доступа
 		baseQuery = `
-			SELECT id, title, description, content, plan_required, category, voice_gender, is_active, created_at
+			SELECT id, COALESCE(NULLIF(titles->>$1, ''), title), description, content, plan_required, category, voice_gender, is_active, current_version, created_at
 			FROM voice_prompts
-			WHERE is_base = true AND plan_required <= $1 AND is_active = true
-			ORDER BY plan_required ASC, title ASC
+			WHERE is_base = true AND plan_required <= $2 AND is_active = true
+			ORDER BY sort_order ASC, plan_required ASC, title ASC
 		`
-		baseArgs = []interface{}{planLevel}
+		baseArgs = append(baseArgs, planLevel)
 	}
 
 	rows, err := conn.Query(ctx, baseQuery, baseArgs...)
@@ -80,7 +75,7 @@ func (s *PromptService) GetUserPrompts(ctx context.Context, userID int) (*models
 	for rows.Next() {
 		var prompt models.VoicePrompt
 		err := rows.Scan(&prompt.ID, &prompt.Title, &prompt.Description, &prompt.Content,
-			&prompt.PlanRequired, &prompt.Category, &prompt.VoiceGender, &prompt.IsActive, &prompt.CreatedAt)
+			&prompt.PlanRequired, &prompt.Category, &prompt.VoiceGender, &prompt.IsActive, &prompt.Version, &prompt.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan base prompt: %w", err)
 		}
@@ -90,7 +85,7 @@ func (s *PromptService) GetUserPrompts(ctx context.Context, userID int) (*models
 	// Получаем пользовательские промпты
 	var userPrompts []models.VoicePrompt
 	userRows, err := conn.Query(ctx, `
-		SELECT id, title, description, content, category, voice_gender, is_active, created_at
+		SELECT id, title, description, content, content_key_id, category, voice_gender, is_active, current_version, created_at
 		FROM voice_prompts
 		WHERE user_id = $1 AND is_base = false AND is_active = true
 		ORDER BY created_at DESC
@@ -103,42 +98,37 @@ func (s *PromptService) GetUserPrompts(ctx context.Context, userID int) (*models
 
 	for userRows.Next() {
 		var prompt models.VoicePrompt
+		var keyID *int
 		prompt.UserID = &userID
-		err := rows.Scan(&prompt.ID, &prompt.Title, &prompt.Description, &prompt.Content,
-			&prompt.Category, &prompt.VoiceGender, &prompt.IsActive, &prompt.CreatedAt)
+		err := userRows.Scan(&prompt.ID, &prompt.Title, &prompt.Description, &prompt.Content, &keyID,
+			&prompt.Category, &prompt.VoiceGender, &prompt.IsActive, &prompt.Version, &prompt.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user prompt: %w", err)
 		}
+		if prompt.Content, err = openContent(ctx, userID, contentTablePrompts, prompt.Content, keyID); err != nil {
+			return nil, err
+		}
 		userPrompts = append(userPrompts, prompt)
 	}
 
-	// Получаем выбранный промпт пользователя
-	var selectedPromptID *int
+	// Получаем выбранный промпт пользователя и закрепленную версию
+	var selectedPromptID, selectedPromptVersion *int
 	err = conn.QueryRow(ctx, `
-		SELECT selected_prompt_id FROM users WHERE id = $1
-	`, userID).Scan(&selectedPromptID)
+		SELECT selected_prompt_id, selected_prompt_version FROM users WHERE id = $1
+	`, userID).Scan(&selectedPromptID, &selectedPromptVersion)
 
 	// Подсчитываем лимиты
 	userPromptCount := len(userPrompts)
-	var maxUserPrompts int
-
-	switch planLevel {
-	case 1:
-		maxUserPrompts = 0
-	case 2:
-		maxUserPrompts = 3
-	case 3:
-		maxUserPrompts = -1 // неограниченно
-	}
 
 	return &models.PromptsResponse{
 		UserPlan: models.PlanLevel{
 			PlanName:  planName,
 			PlanLevel: planLevel,
 		},
-		BasePrompts:      basePrompts,
-		UserPrompts:      userPrompts,
-		SelectedPromptID: selectedPromptID,
+		BasePrompts:           basePrompts,
+		UserPrompts:           userPrompts,
+		SelectedPromptID:      selectedPromptID,
+		SelectedPromptVersion: selectedPromptVersion,
 		PromptLimits: models.PromptLimits{
 			Current:       userPromptCount,
 			Max:           maxUserPrompts,
@@ -149,31 +139,18 @@ func (s *PromptService) GetUserPrompts(ctx context.Context, userID int) (*models
 
 // CreatePrompt создает новый пользовательский промпт
 func (s *PromptService) CreatePrompt(ctx context.Context, req *models.CreatePromptRequest) (*models.VoicePrompt, error) {
+	if err := prompttmpl.Validate(req.Content); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
 	conn := database.Database.Pool
 
 	// Проверяем лимиты пользователя
-	var planLevel int
-	err := conn.QueryRow(ctx, `
-		SELECT
-			CASE
-				WHEN sp.name = 'Базовый' THEN 1
-				WHEN sp.name = 'Премиум' THEN 2
-				WHEN sp.name = 'Про' THEN 3
-				ELSE 1
-			END as plan_level
-		FROM users u
-		LEFT JOIN user_subscriptions us ON u.id = us.user_id AND us.status = 'active'
-		LEFT JOIN subscription_plans sp ON us.plan_id = sp.id
-		WHERE u.id = $1
-	`, req.UserID).Scan(&planLevel)
-
-	if err != nil && err != pgx.ErrNoRows {
-		return nil, fmt.Errorf("failed to get user plan level: %w", err)
-	}
-
-	if err == pgx.ErrNoRows {
-		planLevel = 1
+	planName, err := userPlanName(ctx, req.UserID)
+	if err != nil {
+		return nil, err
 	}
+	_, maxPrompts, _ := subscriptionPromptTier(planName)
 
 	// Подсчитываем существующие промпты
 	var currentCount int
@@ -187,84 +164,124 @@ func (s *PromptService) CreatePrompt(ctx context.Context, req *models.CreateProm
 	}
 
 	// Проверяем лимит
-	var maxPrompts int
-	switch planLevel {
-	case 1:
-		maxPrompts = 0
-	case 2:
-		maxPrompts = 3
-	case 3:
-		maxPrompts = -1
-	}
-
 	if maxPrompts != -1 && currentCount >= maxPrompts {
 		return nil, fmt.Errorf("prompt limit reached: %d/%d", currentCount, maxPrompts)
 	}
 
-	// Создаем промпт
+	sealed, keyID, err := sealContent(ctx, req.UserID, contentTablePrompts, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Создаем промпт и его первую версию
 	var prompt models.VoicePrompt
-	err = conn.QueryRow(ctx, `
-		INSERT INTO voice_prompts (user_id, title, description, content, category, voice_gender, is_base, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, false, CURRENT_TIMESTAMP)
-		RETURNING id, user_id, title, description, content, category, voice_gender, is_base, is_active, created_at
-	`, req.UserID, req.Title, req.Description, req.Content, req.Category, req.VoiceGender).Scan(
-		&prompt.ID, &prompt.UserID, &prompt.Title, &prompt.Description, &prompt.Content,
-		&prompt.Category, &prompt.VoiceGender, &prompt.IsBase, &prompt.IsActive, &prompt.CreatedAt,
+	err = tx.QueryRow(ctx, `
+		INSERT INTO voice_prompts (user_id, title, description, content, content_key_id, category, voice_gender, is_base, current_version, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, false, 1, CURRENT_TIMESTAMP)
+		RETURNING id, user_id, title, description, category, voice_gender, is_base, is_active, current_version, created_at
+	`, req.UserID, req.Title, req.Description, sealed, keyID, req.Category, req.VoiceGender).Scan(
+		&prompt.ID, &prompt.UserID, &prompt.Title, &prompt.Description,
+		&prompt.Category, &prompt.VoiceGender, &prompt.IsBase, &prompt.IsActive, &prompt.Version, &prompt.CreatedAt,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create prompt: %w", err)
 	}
+	if err := insertPromptVersion(ctx, tx, prompt.ID, promptAuthorUser(req.UserID), nil); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	prompt.Content = req.Content
 
 	return &prompt, nil
 }
 
-// SelectPrompt выбирает промпт для пользователя
-func (s *PromptService) SelectPrompt(ctx context.Context, userID int, promptID int) error {
-	conn := database.Database.Pool
+// userPlanName возвращает название плана активной подписки пользователя или freePlanName
+func userPlanName(ctx context.Context, userID int) (string, error) {
+	planName := freePlanName
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT COALESCE(sp.name, $2)
+		FROM users u
+		LEFT JOIN user_subscriptions us ON u.id = us.user_id AND us.status = 'active'
+		LEFT JOIN subscription_plans sp ON us.plan_id = sp.id
+		WHERE u.id = $1
+	`, userID, freePlanName).Scan(&planName)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", fmt.Errorf("failed to get user plan: %w", err)
+	}
+	return planName, nil
+}
+
+// promptAccessible проверяет, что промпт активен и доступен пользователю:
+// свой промпт или базовый промпт его плана
+func promptAccessible(ctx context.Context, userID int, promptID int) (bool, error) {
+	planName, err := userPlanName(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	planLevel, _, allBasePrompts := subscriptionPromptTier(planName)
 
-	// Проверяем доступность промпта для пользователя
 	var exists bool
-	err := conn.QueryRow(ctx, `
+	err = database.Database.Pool.QueryRow(ctx, `
 		SELECT EXISTS(
 			SELECT 1
 			FROM voice_prompts vp
-			LEFT JOIN users u ON u.id = $1
-			LEFT JOIN user_subscriptions us ON u.id = us.user_id AND us.status = 'active'
-			LEFT JOIN subscription_plans sp ON us.plan_id = sp.id
 			WHERE vp.id = $2
 				AND vp.is_active = true
 				AND (
 					vp.user_id = $1 OR
-					(vp.is_base = true AND (
-						COALESCE(sp.name, 'Бесплатный план') = 'Про' OR
-						vp.plan_required <= COALESCE(
-							CASE
-								WHEN sp.name = 'Базовый' THEN 1
-								WHEN sp.name = 'Премиум' THEN 2
-								WHEN sp.name = 'Про' THEN 3
-								ELSE 1
-							END, 1
-						)
-					))
+					(vp.is_base = true AND ($4::boolean OR vp.plan_required <= $3))
 				)
 		)
-	`, userID, promptID).Scan(&exists)
+	`, userID, promptID, planLevel, allBasePrompts).Scan(&exists)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check prompt availability: %w", err)
+	}
+	return exists, nil
+}
 
+// SelectPrompt выбирает промпт для пользователя. version закрепляет версию промпта;
+// nil - всегда использовать последнюю.
+func (s *PromptService) SelectPrompt(ctx context.Context, userID int, promptID int, version *int) error {
+	conn := database.Database.Pool
+
+	// Проверяем доступность промпта для пользователя
+	exists, err := promptAccessible(ctx, userID, promptID)
 	if err != nil {
-		return fmt.Errorf("failed to check prompt availability: %w", err)
+		return err
 	}
 
 	if !exists {
 		return fmt.Errorf("prompt not found or not accessible")
 	}
 
+	if version != nil {
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM voice_prompt_versions WHERE prompt_id = $1 AND version = $2)
+		`, promptID, *version).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check prompt version: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("prompt version not found")
+		}
+	}
+
 	// Обновляем выбранный промпт
 	result, err := conn.Exec(ctx, `
 		UPDATE users
-		SET selected_prompt_id = $1, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $2
-	`, promptID, userID)
+		SET selected_prompt_id = $1, selected_prompt_version = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, promptID, version, userID)
 
 	if err != nil {
 		return fmt.Errorf("failed to select prompt: %w", err)
@@ -274,7 +291,11 @@ func (s *PromptService) SelectPrompt(ctx context.Context, userID int, promptID i
 		return fmt.Errorf("user not found")
 	}
 
-	log.Infof("✅ User %d selected prompt %d", userID, promptID)
+	if version != nil {
+		log.Infof("✅ User %d selected prompt %d pinned to version %d", userID, promptID, *version)
+	} else {
+		log.Infof("✅ User %d selected prompt %d", userID, promptID)
+	}
 
 	return nil
 }
@@ -331,7 +352,7 @@ func (s *PromptService) DeletePrompt(ctx context.Context, userID int, promptID i
 		if err == nil && defaultPromptID != nil {
 			_, err = tx.Exec(ctx, `
 				UPDATE users
-				SET selected_prompt_id = $1, updated_at = CURRENT_TIMESTAMP
+				SET selected_prompt_id = $1, selected_prompt_version = NULL, updated_at = CURRENT_TIMESTAMP
 				WHERE id = $2
 			`, *defaultPromptID, userID)
 
@@ -361,3 +382,307 @@ func (s *PromptService) DeletePrompt(ctx context.Context, userID int, promptID i
 
 	return isSelected, nil
 }
+
+// promptAuthorUser - автор версии, созданной пользователем
+func promptAuthorUser(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// promptAuthorAdmin - автор версии, созданной из админки; по умолчанию "admin"
+func promptAuthorAdmin(author string) string {
+	author = strings.TrimSpace(author)
+	if author == "" {
+		return "admin"
+	}
+	return author
+}
+
+// insertPromptVersion сохраняет текущее состояние промпта как версию current_version.
+// Содержимое копируется как есть: версии шифруются тем же ключом, что и промпт.
+func insertPromptVersion(ctx context.Context, q execer, promptID int, author string, note *string) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO voice_prompt_versions (prompt_id, version, user_id, title, titles, description, content, content_key_id,
+		                                   category, voice_gender, author, change_note, created_at)
+		SELECT id, current_version, CASE WHEN is_base THEN NULL ELSE user_id END, title, titles, description, content,
+		       content_key_id, category, voice_gender, $2, $3, CURRENT_TIMESTAMP
+		FROM voice_prompts
+		WHERE id = $1
+	`, promptID, author, note)
+	if err != nil {
+		return fmt.Errorf("failed to save prompt version: %w", err)
+	}
+	return nil
+}
+
+// UpdatePrompt изменяет пользовательский промпт, создавая новую версию
+func (s *PromptService) UpdatePrompt(ctx context.Context, req *models.UpdatePromptRequest) (*models.VoicePrompt, error) {
+	if req.Title == nil && req.Description == nil && req.Content == nil && req.Category == nil && req.VoiceGender == nil {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	if (req.Title != nil && strings.TrimSpace(*req.Title) == "") || (req.Content != nil && strings.TrimSpace(*req.Content) == "") {
+		return nil, fmt.Errorf("title and content cannot be empty")
+	}
+	if req.Content != nil {
+		if err := prompttmpl.Validate(*req.Content); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var promptID int
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM voice_prompts
+		WHERE id = $1 AND user_id = $2 AND is_base = false AND is_active = true
+		FOR UPDATE
+	`, req.PromptID, req.UserID).Scan(&promptID)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("prompt not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check prompt ownership: %w", err)
+	}
+
+	var sealed *string
+	var keyID *int
+	if req.Content != nil {
+		content, contentKeyID, err := sealContent(ctx, req.UserID, contentTablePrompts, *req.Content)
+		if err != nil {
+			return nil, err
+		}
+		sealed, keyID = &content, contentKeyID
+	}
+
+	var prompt models.VoicePrompt
+	var storedContent string
+	var storedKeyID *int
+	err = tx.QueryRow(ctx, `
+		UPDATE voice_prompts
+		SET title = COALESCE($2, title),
+		    description = COALESCE($3, description),
+		    content = COALESCE($4, content),
+		    content_key_id = CASE WHEN $4::text IS NULL THEN content_key_id ELSE $5 END,
+		    category = COALESCE($6, category),
+		    voice_gender = COALESCE($7, voice_gender),
+		    current_version = current_version + 1
+		WHERE id = $1
+		RETURNING id, user_id, title, description, content, content_key_id, category, voice_gender,
+		          is_base, is_active, current_version, created_at
+	`, req.PromptID, req.Title, req.Description, sealed, keyID, req.Category, req.VoiceGender).Scan(
+		&prompt.ID, &prompt.UserID, &prompt.Title, &prompt.Description, &storedContent, &storedKeyID,
+		&prompt.Category, &prompt.VoiceGender, &prompt.IsBase, &prompt.IsActive, &prompt.Version, &prompt.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update prompt: %w", err)
+	}
+
+	if err := insertPromptVersion(ctx, tx, req.PromptID, promptAuthorUser(req.UserID), req.ChangeNote); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if prompt.Content, err = openContent(ctx, req.UserID, contentTablePrompts, storedContent, storedKeyID); err != nil {
+		return nil, err
+	}
+
+	log.Infof("✏️ User %d updated prompt %d to version %d", req.UserID, prompt.ID, prompt.Version)
+
+	return &prompt, nil
+}
+
+// GetPromptVersions возвращает историю версий промпта, начиная с последней.
+// userID ограничивает выборку промптами, доступными пользователю; nil - без ограничений.
+func (s *PromptService) GetPromptVersions(ctx context.Context, promptID int, userID *int) ([]models.VoicePromptVersion, error) {
+	if userID != nil {
+		accessible, err := promptAccessible(ctx, *userID, promptID)
+		if err != nil {
+			return nil, err
+		}
+		if !accessible {
+			return nil, fmt.Errorf("prompt not found or not accessible")
+		}
+	}
+
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT v.id, v.prompt_id, v.version, v.user_id, v.title, v.titles, v.description, v.content, v.content_key_id,
+		       v.category, v.voice_gender, v.author, v.change_note, v.version = vp.current_version, v.created_at
+		FROM voice_prompt_versions v
+		JOIN voice_prompts vp ON vp.id = v.prompt_id
+		WHERE v.prompt_id = $1
+		ORDER BY v.version DESC
+	`, promptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.VoicePromptVersion{}
+	for rows.Next() {
+		v, err := scanPromptVersion(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get prompt versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("prompt not found")
+	}
+
+	return versions, nil
+}
+
+func scanPromptVersion(ctx context.Context, row pgx.Row) (*models.VoicePromptVersion, error) {
+	var v models.VoicePromptVersion
+	var ownerID, keyID *int
+	err := row.Scan(&v.ID, &v.PromptID, &v.Version, &ownerID, &v.Title, &v.Titles, &v.Description, &v.Content, &keyID,
+		&v.Category, &v.VoiceGender, &v.Author, &v.ChangeNote, &v.IsCurrent, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != nil {
+		if v.Content, err = openContent(ctx, *ownerID, contentTablePrompts, v.Content, keyID); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+// getPromptVersion возвращает версию промпта; version 0 - текущая
+func getPromptVersion(ctx context.Context, promptID int, version int) (*models.VoicePromptVersion, error) {
+	v, err := scanPromptVersion(ctx, database.Database.Pool.QueryRow(ctx, `
+		SELECT v.id, v.prompt_id, v.version, v.user_id, v.title, v.titles, v.description, v.content, v.content_key_id,
+		       v.category, v.voice_gender, v.author, v.change_note, v.version = vp.current_version, v.created_at
+		FROM voice_prompt_versions v
+		JOIN voice_prompts vp ON vp.id = v.prompt_id
+		WHERE v.prompt_id = $1 AND v.version = CASE WHEN $2 = 0 THEN vp.current_version ELSE $2 END
+	`, promptID, version))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("prompt version not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt version: %w", err)
+	}
+	return v, nil
+}
+
+// DiffVersions сравнивает две версии промпта: поля и построчно содержимое. to 0 - текущая версия.
+func (s *PromptService) DiffVersions(ctx context.Context, promptID int, from int, to int) (*models.PromptDiff, error) {
+	fromVersion, err := getPromptVersion(ctx, promptID, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := getPromptVersion(ctx, promptID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &models.PromptDiff{
+		PromptID: promptID,
+		From:     fromVersion.Version,
+		To:       toVersion.Version,
+		Fields:   []models.PromptFieldChange{},
+		Content:  diffLines(fromVersion.Content, toVersion.Content),
+	}
+
+	fields := []struct {
+		name     string
+		from, to *string
+	}{
+		{"title", &fromVersion.Title, &toVersion.Title},
+		{"description", fromVersion.Description, toVersion.Description},
+		{"category", fromVersion.Category, toVersion.Category},
+		{"voice_gender", fromVersion.VoiceGender, toVersion.VoiceGender},
+	}
+	for _, f := range fields {
+		if (f.from == nil) != (f.to == nil) || (f.from != nil && *f.from != *f.to) {
+			diff.Fields = append(diff.Fields, models.PromptFieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	if !maps.Equal(fromVersion.Titles, toVersion.Titles) {
+		fromTitles, _ := json.Marshal(fromVersion.Titles)
+		toTitles, _ := json.Marshal(toVersion.Titles)
+		from, to := string(fromTitles), string(toTitles)
+		diff.Fields = append(diff.Fields, models.PromptFieldChange{Field: "titles", From: &from, To: &to})
+	}
+
+	diff.Unified = unifiedDiff(
+		fmt.Sprintf("prompt %d version %d", promptID, diff.From),
+		fmt.Sprintf("prompt %d version %d", promptID, diff.To),
+		diff.Content,
+	)
+
+	return diff, nil
+}
+
+// RollbackPrompt возвращает промпт к прежней версии. История не переписывается:
+// содержимое выбранной версии сохраняется как новая версия.
+func (s *PromptService) RollbackPrompt(ctx context.Context, req *models.RollbackPromptRequest) (*models.VoicePromptVersion, error) {
+	author := promptAuthorAdmin(req.Author)
+	note := req.ChangeNote
+	if note == nil {
+		text := fmt.Sprintf("Rollback to version %d", req.Version)
+		note = &text
+	}
+
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var currentVersion int
+	err = tx.QueryRow(ctx, `
+		SELECT current_version FROM voice_prompts WHERE id = $1 FOR UPDATE
+	`, req.PromptID).Scan(&currentVersion)
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("prompt not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock prompt: %w", err)
+	}
+	if req.Version == currentVersion {
+		return nil, fmt.Errorf("version is already current")
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE voice_prompts vp
+		SET title = v.title,
+		    titles = v.titles,
+		    description = v.description,
+		    content = v.content,
+		    content_key_id = v.content_key_id,
+		    category = v.category,
+		    voice_gender = v.voice_gender,
+		    current_version = vp.current_version + 1
+		FROM voice_prompt_versions v
+		WHERE vp.id = $1 AND v.prompt_id = vp.id AND v.version = $2
+	`, req.PromptID, req.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back prompt: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, fmt.Errorf("prompt version not found")
+	}
+
+	if err := insertPromptVersion(ctx, tx, req.PromptID, author, note); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("⏪ Prompt %d rolled back to version %d by %s", req.PromptID, req.Version, author)
+
+	return getPromptVersion(ctx, req.PromptID, 0)
+}
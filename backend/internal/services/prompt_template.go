@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/prompttmpl"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// promptSource - текст промпта (шаблон) и род голоса, для которого он написан
+type promptSource struct {
+	content     string
+	voiceGender *string
+}
+
+// loadSelectedPrompt возвращает выбранный пользователем промпт (или закрепленную версию).
+// nil - промпт не выбран, недоступен или не расшифровался.
+func loadSelectedPrompt(ctx context.Context, userID int) *promptSource {
+	var content, voiceGender *string
+	var promptOwnerID, keyID *int
+	// Если пользователь закрепил версию, берется она, иначе текущая версия промпта
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT COALESCE(pv.content, vp.content),
+		       CASE WHEN pv.id IS NOT NULL THEN pv.voice_gender ELSE vp.voice_gender END,
+		       CASE WHEN vp.is_base THEN NULL ELSE vp.user_id END,
+		       CASE WHEN pv.id IS NOT NULL THEN pv.content_key_id ELSE vp.content_key_id END
+		FROM users u
+		LEFT JOIN voice_prompts vp ON u.selected_prompt_id = vp.id
+		LEFT JOIN voice_prompt_versions pv ON pv.prompt_id = vp.id AND pv.version = u.selected_prompt_version
+		WHERE u.id = $1 AND (vp.is_active = true OR vp.id IS NULL)
+	`, userID).Scan(&content, &voiceGender, &promptOwnerID, &keyID)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Warnf("Failed to get selected prompt for user %d: %v", userID, err)
+		}
+		return nil
+	}
+	if content == nil || strings.TrimSpace(*content) == "" {
+		return nil
+	}
+
+	plain := *content
+	if keyID != nil && promptOwnerID != nil {
+		plain, err = openContent(ctx, *promptOwnerID, contentTablePrompts, *content, keyID)
+		if err != nil {
+			log.Warnf("Failed to decrypt prompt for user %d: %v", userID, err)
+			return nil
+		}
+	}
+	return &promptSource{content: plain, voiceGender: voiceGender}
+}
+
+// loadPrompt возвращает текущую версию промпта; доступность проверяет вызывающий
+func loadPrompt(ctx context.Context, promptID int) (*promptSource, error) {
+	version, err := getPromptVersion(ctx, promptID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &promptSource{content: version.Content, voiceGender: version.VoiceGender}, nil
+}
+
+// loadPromptValues собирает значения переменных шаблона промпта. Без пользователя
+// доступны только голос и время; память подставляет вызывающий.
+func loadPromptValues(ctx context.Context, userID *int, voice string, female bool) prompttmpl.Values {
+	values := prompttmpl.Values{
+		"voice":      voice,
+		"local_time": promptLocalTime(time.Now()),
+	}
+	if female {
+		values["female"] = "true"
+	} else {
+		values["male"] = "true"
+	}
+	if userID == nil {
+		return values
+	}
+
+	var firstName, languageCode, planName string
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT COALESCE(u.first_name, ''), COALESCE(u.language_code, ''), COALESCE(sp.name, 'Бесплатный план')
+		FROM users u
+		LEFT JOIN user_subscriptions us ON u.id = us.user_id AND us.status = 'active'
+		LEFT JOIN subscription_plans sp ON us.plan_id = sp.id
+		WHERE u.id = $1
+	`, *userID).Scan(&firstName, &languageCode, &planName)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Warnf("Failed to get prompt variables for user %d: %v", *userID, err)
+		}
+		return values
+	}
+
+	values["first_name"] = firstName
+	values["language"] = languageName(languageCode)
+	values["plan_name"] = planName
+	return values
+}
+
+// renderMemory укладывает факты о пользователе в бюджет памяти для подстановки в {{memory}}
+func renderMemory(facts []string) string {
+	if len(facts) == 0 {
+		return ""
+	}
+	budget := config.AppConfig.MemoryTokenBudget
+	text, _ := fitSection(ContextSection{Name: "memory", Items: facts, MaxTokens: budget}, budget)
+	return text
+}
+
+// languageNames - названия языков по коду Telegram (language_code)
+var languageNames = map[string]string{
+	"ru": "русский",
+	"en": "английский",
+	"uk": "украинский",
+	"be": "белорусский",
+	"kk": "казахский",
+	"uz": "узбекский",
+	"de": "немецкий",
+	"fr": "французский",
+	"es": "испанский",
+	"it": "итальянский",
+	"pt": "португальский",
+	"tr": "турецкий",
+	"zh": "китайский",
+	"ja": "японский",
+}
+
+// languageName возвращает название языка; неизвестный код возвращается как есть
+func languageName(code string) string {
+	if name, ok := languageNames[languageBase(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// languageBase отбрасывает регион из кода языка: "en-US" -> "en"
+func languageBase(code string) string {
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	return base
+}
+
+var weekdayNames = [...]string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"}
+
+var (
+	promptLocationOnce sync.Once
+	promptLocation     *time.Location
+)
+
+// promptLocalTime форматирует время в часовом поясе PROMPT_TIMEZONE: «среда, 14.05.2025 18:30»
+func promptLocalTime(now time.Time) string {
+	promptLocationOnce.Do(func() {
+		loc, err := time.LoadLocation(config.AppConfig.PromptTimezone)
+		if err != nil {
+			log.Warnf("Unknown PROMPT_TIMEZONE %q, using UTC: %v", config.AppConfig.PromptTimezone, err)
+			loc = time.UTC
+		}
+		promptLocation = loc
+	})
+
+	local := now.In(promptLocation)
+	return weekdayNames[local.Weekday()] + ", " + local.Format("02.01.2006 15:04")
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// Причины, по которым награда за приглашение выдается
+const (
+	ReferralTriggerVoiceSession = "voice_session"
+	ReferralTriggerPaidPlan     = "paid_plan"
+)
+
+// referralStartPrefix - префикс start_param в ссылке приглашения (t.me/bot/app?startapp=ref_CODE)
+const referralStartPrefix = "ref_"
+
+// Алфавит кодов приглашений без похожих символов (0/O, 1/I)
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const referralCodeLength = 8
+
+type ReferralService struct {
+	tokenService *TokenService
+}
+
+func NewReferralService() *ReferralService {
+	return &ReferralService{
+		tokenService: NewTokenService(),
+	}
+}
+
+// generateInviteCode генерирует случайный код из inviteCodeAlphabet
+func generateInviteCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i := range buf {
+		buf[i] = inviteCodeAlphabet[int(buf[i])%len(inviteCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// parseReferralStartParam извлекает код приглашения из start_param Mini App
+func parseReferralStartParam(startParam string) string {
+	code := strings.TrimSpace(startParam)
+	if len(code) > len(referralStartPrefix) && strings.EqualFold(code[:len(referralStartPrefix)], referralStartPrefix) {
+		code = code[len(referralStartPrefix):]
+	}
+	return strings.ToUpper(code)
+}
+
+// EnsureReferralCode возвращает код приглашения пользователя, создавая его при необходимости
+func (s *ReferralService) EnsureReferralCode(ctx context.Context, userID int) (string, error) {
+	conn := database.Database.Pool
+
+	var code *string
+	err := conn.QueryRow(ctx, `SELECT referral_code FROM users WHERE id = $1`, userID).Scan(&code)
+	if err == pgx.ErrNoRows {
+		return "", fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get referral code: %w", err)
+	}
+	if code != nil {
+		return *code, nil
+	}
+
+	// Коллизия маловероятна, но уникальный индекс может ее отклонить - пробуем еще раз
+	for attempt := 0; attempt < 5; attempt++ {
+		newCode, err := generateInviteCode(referralCodeLength)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate referral code: %w", err)
+		}
+
+		var stored string
+		err = conn.QueryRow(ctx, `
+			UPDATE users
+			SET referral_code = COALESCE(referral_code, $1)
+			WHERE id = $2
+			RETURNING referral_code
+		`, newCode, userID).Scan(&stored)
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to save referral code: %w", err)
+		}
+
+		return stored, nil
+	}
+
+	return "", fmt.Errorf("failed to generate unique referral code")
+}
+
+// AttachReferral связывает только что созданного пользователя с пригласившим по start_param.
+// Превышение дневного лимита приглашений и повторная регистрация удаленного аккаунта
+// сохраняются как отклоненное приглашение.
+func (s *ReferralService) AttachReferral(ctx context.Context, refereeID int, startParam string) error {
+	code := parseReferralStartParam(startParam)
+	if code == "" {
+		return nil
+	}
+
+	conn := database.Database.Pool
+
+	var referrerID int
+	err := conn.QueryRow(ctx, `SELECT id FROM users WHERE referral_code = $1`, code).Scan(&referrerID)
+	if err == pgx.ErrNoRows {
+		// start_param может использоваться и для других целей - это не ошибка
+		log.Debugf("Start param %q does not match any referral code", startParam)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find referrer: %w", err)
+	}
+
+	var rejectReason *string
+	var todayCount int
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM referrals
+		WHERE referrer_id = $1 AND created_at > CURRENT_TIMESTAMP - INTERVAL '1 day'
+	`, referrerID).Scan(&todayCount)
+	if err != nil {
+		return fmt.Errorf("failed to count referrals: %w", err)
+	}
+	if todayCount >= config.AppConfig.ReferralDailyLimit {
+		reason := "daily_limit"
+		rejectReason = &reason
+	}
+
+	deleted, err := isDeletedAccount(ctx, conn, refereeID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		reason := "returning_account"
+		rejectReason = &reason
+	}
+
+	status := "pending"
+	if rejectReason != nil {
+		status = "rejected"
+	}
+
+	// referee_id уникален: повторная привязка того же пользователя игнорируется
+	_, err = conn.Exec(ctx, `
+		INSERT INTO referrals (referrer_id, referee_id, start_param, status, reject_reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (referee_id) DO NOTHING
+	`, referrerID, refereeID, startParam, status, rejectReason)
+	if err != nil {
+		return fmt.Errorf("failed to create referral: %w", err)
+	}
+
+	if rejectReason != nil {
+		log.Warnf("⚠️ Referral of user %d by user %d rejected: %s", refereeID, referrerID, *rejectReason)
+	} else {
+		log.Infof("🤝 User %d joined by referral of user %d", refereeID, referrerID)
+	}
+
+	return nil
+}
+
+// RewardReferral начисляет бонусы обоим участникам, когда приглашенный впервые
+// завершает голосовую сессию с разговором или оплачивает план. Награда выдается один раз.
+func (s *ReferralService) RewardReferral(ctx context.Context, refereeID int, trigger string) error {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var referralID, referrerID int
+	err = tx.QueryRow(ctx, `
+		SELECT id, referrer_id FROM referrals
+		WHERE referee_id = $1 AND status = 'pending'
+		FOR UPDATE
+	`, refereeID).Scan(&referralID, &referrerID)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get referral: %w", err)
+	}
+
+	referrerBonus := config.AppConfig.ReferralReferrerBonus
+	refereeBonus := config.AppConfig.ReferralRefereeBonus
+
+	// Пригласивший получает бонус только до достижения лимита наград
+	var rewardedCount int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM referrals
+		WHERE referrer_id = $1 AND status = 'rewarded' AND referrer_reward > 0
+	`, referrerID).Scan(&rewardedCount)
+	if err != nil {
+		return fmt.Errorf("failed to count rewarded referrals: %w", err)
+	}
+	if rewardedCount >= config.AppConfig.ReferralMaxRewards {
+		log.Warnf("⚠️ Referrer %d reached reward limit, skipping referrer bonus", referrerID)
+		referrerBonus = 0
+	}
+
+	expiresAt := TokenTTL(config.AppConfig.ReferralTokenTTLDays)
+	if referrerBonus > 0 {
+		if _, err := s.tokenService.GrantTokensTx(ctx, tx, referrerID, referrerBonus, TokenSourceReferral, &referralID, expiresAt); err != nil {
+			return err
+		}
+	}
+	if refereeBonus > 0 {
+		if _, err := s.tokenService.GrantTokensTx(ctx, tx, refereeID, refereeBonus, TokenSourceReferral, &referralID, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE referrals
+		SET status = 'rewarded', reward_trigger = $1, referrer_reward = $2, referee_reward = $3,
+		    rewarded_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`, trigger, referrerBonus, refereeBonus, referralID)
+	if err != nil {
+		return fmt.Errorf("failed to mark referral rewarded: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("🎁 Referral %d rewarded on %s: referrer %d +%d, referee %d +%d",
+		referralID, trigger, referrerID, referrerBonus, refereeID, refereeBonus)
+
+	return nil
+}
+
+// GetUserReferralInfo возвращает код приглашения и статистику пользователя
+func (s *ReferralService) GetUserReferralInfo(ctx context.Context, userID int) (*models.ReferralInfoResponse, error) {
+	code, err := s.EnsureReferralCode(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &models.ReferralInfoResponse{
+		ReferralCode:  code,
+		StartParam:    referralStartPrefix + code,
+		ReferrerBonus: config.AppConfig.ReferralReferrerBonus,
+		RefereeBonus:  config.AppConfig.ReferralRefereeBonus,
+	}
+
+	err = database.Database.Pool.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE status != 'rejected'),
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'rewarded'),
+			COALESCE(SUM(referrer_reward), 0)
+		FROM referrals
+		WHERE referrer_id = $1
+	`, userID).Scan(&info.TotalReferred, &info.PendingCount, &info.RewardedCount, &info.TokensEarned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referral stats: %w", err)
+	}
+
+	return info, nil
+}
+
+// GetReferralStats возвращает общую статистику реферальной программы для админки
+func (s *ReferralService) GetReferralStats(ctx context.Context, limit int) (*models.ReferralStatsResponse, error) {
+	conn := database.Database.Pool
+
+	stats := &models.ReferralStatsResponse{
+		RejectReasons: make(map[string]int),
+		TopReferrers:  []models.ReferrerStatsEntry{},
+	}
+
+	err := conn.QueryRow(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'rewarded'),
+			COUNT(*) FILTER (WHERE status = 'rejected'),
+			COALESCE(SUM(referrer_reward + referee_reward), 0)
+		FROM referrals
+	`).Scan(&stats.TotalReferrals, &stats.PendingReferrals, &stats.RewardedReferrals,
+		&stats.RejectedReferrals, &stats.TokensAwarded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referral totals: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT reject_reason, COUNT(*)
+		FROM referrals
+		WHERE status = 'rejected' AND reject_reason IS NOT NULL
+		GROUP BY reject_reason
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reject reasons: %w", err)
+	}
+	for rows.Next() {
+		var reason string
+		var count int
+		if err := rows.Scan(&reason, &count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan reject reason: %w", err)
+		}
+		stats.RejectReasons[reason] = count
+	}
+	rows.Close()
+
+	rows, err = conn.Query(ctx, `
+		SELECT r.referrer_id, u.username,
+		       COUNT(*) FILTER (WHERE r.status != 'rejected') as total_referred,
+		       COUNT(*) FILTER (WHERE r.status = 'rewarded') as rewarded_count
+		FROM referrals r
+		JOIN users u ON u.id = r.referrer_id
+		GROUP BY r.referrer_id, u.username
+		ORDER BY total_referred DESC, rewarded_count DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.ReferrerStatsEntry
+		if err := rows.Scan(&entry.UserID, &entry.Username, &entry.TotalReferred, &entry.RewardedCount); err != nil {
+			return nil, fmt.Errorf("failed to scan referrer stats: %w", err)
+		}
+		stats.TopReferrers = append(stats.TopReferrers, entry)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Тарифы для сроков хранения
+const (
+	RetentionTierFree = "free"
+	RetentionTierPaid = "paid"
+)
+
+// retentionTables - таблицы, для которых можно задать срок хранения
+var retentionTables = map[string]bool{
+	"conversation_messages": true,
+	"user_activity":         true,
+}
+
+// retentionPaidCondition - у пользователя строки есть действующая платная подписка (пробный план не считается)
+const retentionPaidCondition = `EXISTS (
+	SELECT 1 FROM user_subscriptions us
+	JOIN subscription_plans sp ON sp.id = us.plan_id
+	WHERE us.user_id = t.user_id AND us.status = 'active' AND sp.price > 0 AND NOT sp.is_trial
+)`
+
+type RetentionService struct{}
+
+func NewRetentionService() *RetentionService {
+	return &RetentionService{}
+}
+
+// retentionScope возвращает условие на строки таблицы, подпадающие под политику.
+// Имя таблицы подставляется только из retentionTables; $1 - срок хранения в днях.
+func retentionScope(table string, tier string) string {
+	tierCondition := retentionPaidCondition
+	if tier == RetentionTierFree {
+		tierCondition = "NOT " + retentionPaidCondition
+	}
+	return fmt.Sprintf(`FROM %s t
+		WHERE t.created_at < CURRENT_TIMESTAMP - make_interval(days => $1)
+		  AND %s`, table, tierCondition)
+}
+
+// GetPolicies возвращает сроки хранения по таблицам и тарифам
+func (s *RetentionService) GetPolicies(ctx context.Context) ([]models.RetentionPolicy, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT table_name, tier, retention_days, updated_at
+		FROM retention_policies
+		ORDER BY table_name, tier
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []models.RetentionPolicy{}
+	for rows.Next() {
+		var p models.RetentionPolicy
+		if err := rows.Scan(&p.Table, &p.Tier, &p.RetentionDays, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get retention policies: %w", err)
+	}
+
+	return policies, nil
+}
+
+// UpdatePolicy задает срок хранения; nil - хранить бессрочно
+func (s *RetentionService) UpdatePolicy(ctx context.Context, req *models.UpdateRetentionPolicyRequest) (*models.RetentionPolicy, error) {
+	if !retentionTables[req.Table] {
+		return nil, fmt.Errorf("invalid table")
+	}
+	if req.Tier != RetentionTierFree && req.Tier != RetentionTierPaid {
+		return nil, fmt.Errorf("invalid tier")
+	}
+	if req.RetentionDays != nil && *req.RetentionDays < 1 {
+		return nil, fmt.Errorf("invalid retention days")
+	}
+
+	var p models.RetentionPolicy
+	err := database.Database.Pool.QueryRow(ctx, `
+		INSERT INTO retention_policies (table_name, tier, retention_days, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (table_name, tier) DO UPDATE
+		SET retention_days = EXCLUDED.retention_days, updated_at = EXCLUDED.updated_at
+		RETURNING table_name, tier, retention_days, updated_at
+	`, req.Table, req.Tier, req.RetentionDays).Scan(&p.Table, &p.Tier, &p.RetentionDays, &p.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update retention policy: %w", err)
+	}
+
+	if p.RetentionDays != nil {
+		log.Infof("🗄️ Retention policy %s/%s set to %d days", p.Table, p.Tier, *p.RetentionDays)
+	} else {
+		log.Infof("🗄️ Retention policy %s/%s set to unlimited", p.Table, p.Tier)
+	}
+
+	return &p, nil
+}
+
+// activePolicies возвращает политики с ограниченным сроком для известных таблиц
+func (s *RetentionService) activePolicies(ctx context.Context) ([]models.RetentionPolicy, error) {
+	policies, err := s.GetPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []models.RetentionPolicy
+	for _, p := range policies {
+		if p.RetentionDays != nil && retentionTables[p.Table] {
+			active = append(active, p)
+		}
+	}
+	return active, nil
+}
+
+// Preview считает, сколько строк удалила бы каждая политика, ничего не удаляя
+func (s *RetentionService) Preview(ctx context.Context) ([]models.RetentionPreviewEntry, error) {
+	policies, err := s.activePolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []models.RetentionPreviewEntry{}
+	for _, p := range policies {
+		entry := models.RetentionPreviewEntry{
+			Table:         p.Table,
+			Tier:          p.Tier,
+			RetentionDays: *p.RetentionDays,
+		}
+		err := database.Database.Pool.QueryRow(ctx, `
+			SELECT CURRENT_TIMESTAMP - make_interval(days => $1), COUNT(*), MIN(t.created_at)
+			`+retentionScope(p.Table, p.Tier), *p.RetentionDays).Scan(&entry.Cutoff, &entry.Rows, &entry.OldestAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preview retention for %s/%s: %w", p.Table, p.Tier, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// ApplyPolicies удаляет строки старше срока хранения. Удаление идет пачками по batchSize,
+// чтобы не держать долгие блокировки. Результат каждой политики записывается в retention_purges;
+// ошибка одной политики не останавливает остальные.
+func (s *RetentionService) ApplyPolicies(ctx context.Context, batchSize int) (int64, error) {
+	policies, err := s.activePolicies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	var failed int
+	for _, p := range policies {
+		started := time.Now()
+		deleted, err := purgeByPolicy(ctx, p, batchSize)
+		total += deleted
+
+		var errText *string
+		if err != nil {
+			if ctx.Err() != nil {
+				return total, err
+			}
+			text := err.Error()
+			errText = &text
+			failed++
+			log.Errorf("Failed to apply retention policy %s/%s: %v", p.Table, p.Tier, err)
+		}
+
+		if _, recordErr := database.Database.Pool.Exec(ctx, `
+			INSERT INTO retention_purges (table_name, tier, retention_days, rows_deleted, duration_ms, error, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		`, p.Table, p.Tier, *p.RetentionDays, deleted, time.Since(started).Milliseconds(), errText); recordErr != nil {
+			log.Warnf("Failed to record retention purge %s/%s: %v", p.Table, p.Tier, recordErr)
+		}
+
+		if deleted > 0 {
+			log.Infof("✅ Retention: purged %d %s rows of %s users older than %d days", deleted, p.Table, p.Tier, *p.RetentionDays)
+		}
+	}
+
+	if failed > 0 {
+		return total, fmt.Errorf("%d retention policies failed", failed)
+	}
+	return total, nil
+}
+
+func purgeByPolicy(ctx context.Context, p models.RetentionPolicy, batchSize int) (int64, error) {
+	query := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE id IN (SELECT t.id %s LIMIT $2)
+	`, p.Table, retentionScope(p.Table, p.Tier))
+
+	var total int64
+	for {
+		result, err := database.Database.Pool.Exec(ctx, query, *p.RetentionDays, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge %s: %w", p.Table, err)
+		}
+
+		total += result.RowsAffected()
+		if result.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// GetPurgeStats возвращает статистику удалений по политикам за последние days дней
+func (s *RetentionService) GetPurgeStats(ctx context.Context, days int) ([]models.RetentionPurgeStats, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT table_name, tier, COUNT(*), COUNT(error), COALESCE(SUM(rows_deleted), 0),
+		       COALESCE(SUM(duration_ms), 0), MAX(created_at),
+		       (ARRAY_AGG(error ORDER BY created_at DESC))[1]
+		FROM retention_purges
+		WHERE created_at > CURRENT_TIMESTAMP - make_interval(days => $1)
+		GROUP BY table_name, tier
+		ORDER BY table_name, tier
+	`, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []models.RetentionPurgeStats{}
+	for rows.Next() {
+		var st models.RetentionPurgeStats
+		err := rows.Scan(&st.Table, &st.Tier, &st.Runs, &st.Failures, &st.RowsDeleted,
+			&st.DurationMs, &st.LastRunAt, &st.LastError)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan retention stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get retention stats: %w", err)
+	}
+
+	return stats, nil
+}
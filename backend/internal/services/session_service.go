@@ -5,28 +5,45 @@ import (
 	"fmt"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
 )
 
-type SessionService struct{}
+type SessionService struct {
+	referralService *ReferralService
+}
 
 func NewSessionService() *SessionService {
-	return &SessionService{}
+	return &SessionService{
+		referralService: NewReferralService(),
+	}
 }
 
 // CreateVoiceSession создает запись о голосовой сессии
 func (s *SessionService) CreateVoiceSession(ctx context.Context, req *models.CreateVoiceSessionRequest) (int, error) {
 	conn := database.Database.Pool
 
+	// Сводка шифруется ключом данных пользователя; сессия без пользователя хранит ее открытой
+	summary := req.ContextSummary
+	var summaryKeyID *int
+	if req.UserID != nil {
+		var err error
+		if summary, summaryKeyID, err = sealOptionalContent(ctx, *req.UserID, contentTableSessions, req.ContextSummary); err != nil {
+			return 0, err
+		}
+	}
+
 	var sessionID int
 	err := conn.QueryRow(ctx, `
 		INSERT INTO voice_sessions (
 			user_id, words_spoken, ai_responses, session_quality,
-			context_summary, last_conversation_topic, created_at
+			context_summary, summary_key_id, last_conversation_topic, created_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
 		RETURNING id
 	`, req.UserID, req.WordsSpoken, req.AIResponses, req.SessionQuality,
-		req.ContextSummary, req.LastConversationTopic).Scan(&sessionID)
+		summary, summaryKeyID, req.LastConversationTopic).Scan(&sessionID)
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to create voice session: %w", err)
@@ -35,13 +52,40 @@ func (s *SessionService) CreateVoiceSession(ctx context.Context, req *models.Cre
 	return sessionID, nil
 }
 
+// EndVoiceSession отмечает сессию завершенной; сводку по ней составит фоновая задача.
+// Первая сессия приглашенного пользователя, в которой был разговор, открывает бонус по приглашению.
+func (s *SessionService) EndVoiceSession(ctx context.Context, userID int, sessionID int) error {
+	var active bool
+	err := database.Database.Pool.QueryRow(ctx, `
+		UPDATE voice_sessions vs SET ended_at = COALESCE(vs.ended_at, CURRENT_TIMESTAMP)
+		WHERE vs.id = $1 AND vs.user_id = $2
+		RETURNING COALESCE(vs.words_spoken, 0) > 0 OR EXISTS(
+			SELECT 1 FROM conversation_messages cm WHERE cm.session_id = vs.id AND cm.user_id = vs.user_id
+		)
+	`, sessionID, userID).Scan(&active)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("session not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to end voice session: %w", err)
+	}
+
+	if active {
+		if err := s.referralService.RewardReferral(ctx, userID, ReferralTriggerVoiceSession); err != nil {
+			log.Warnf("Failed to reward referral for user %d: %v", userID, err)
+		}
+	}
+
+	return nil
+}
+
 // GetUserVoiceSessions получает сессии пользователя
 func (s *SessionService) GetUserVoiceSessions(ctx context.Context, userID int, limit int) ([]models.VoiceSession, error) {
 	conn := database.Database.Pool
 
 	rows, err := conn.Query(ctx, `
 		SELECT id, user_id, words_spoken, ai_responses, session_quality,
-		       created_at, context_summary, last_conversation_topic
+		       created_at, context_summary, summary_key_id, last_conversation_topic, ended_at, summarized_at
 		FROM voice_sessions
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -56,14 +100,18 @@ func (s *SessionService) GetUserVoiceSessions(ctx context.Context, userID int, l
 	var sessions []models.VoiceSession
 	for rows.Next() {
 		var session models.VoiceSession
+		var summaryKeyID *int
 		err := rows.Scan(
 			&session.ID, &session.UserID, &session.WordsSpoken, &session.AIResponses,
-			&session.SessionQuality, &session.CreatedAt, &session.ContextSummary,
-			&session.LastConversationTopic,
+			&session.SessionQuality, &session.CreatedAt, &session.ContextSummary, &summaryKeyID,
+			&session.LastConversationTopic, &session.EndedAt, &session.SummarizedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan voice session: %w", err)
 		}
+		if session.ContextSummary, err = openOptionalContent(ctx, userID, contentTableSessions, session.ContextSummary, summaryKeyID); err != nil {
+			return nil, err
+		}
 
 		sessions = append(sessions, session)
 	}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+)
+
+func TestReferralRewardedOnActiveSessionEnd(t *testing.T) {
+	ctx := testDB(t)
+	sessionService := NewSessionService()
+
+	referrer := createTestUser(t, ctx, testTelegramID())
+	code, err := NewReferralService().EnsureReferralCode(ctx, referrer.ID)
+	if err != nil {
+		t.Fatalf("EnsureReferralCode: %v", err)
+	}
+	startParam := referralStartPrefix + code
+	resp, err := NewUserService().CreateOrUpdateUser(ctx, &models.CreateUserRequest{
+		TelegramID: fmt.Sprint(testTelegramID()),
+		FirstName:  "Referee",
+		StartParam: &startParam,
+	}, 0)
+	if err != nil {
+		t.Fatalf("create referee: %v", err)
+	}
+	referee := resp.User
+
+	referralStatus := func() string {
+		t.Helper()
+		var status string
+		err := database.Database.Pool.QueryRow(ctx, `SELECT status FROM referrals WHERE referee_id = $1`, referee.ID).Scan(&status)
+		if err != nil {
+			t.Fatalf("get referral: %v", err)
+		}
+		return status
+	}
+
+	endSession := func(wordsSpoken int) {
+		t.Helper()
+		sessionID, err := sessionService.CreateVoiceSession(ctx, &models.CreateVoiceSessionRequest{
+			UserID: &referee.ID, WordsSpoken: wordsSpoken,
+		})
+		if err != nil {
+			t.Fatalf("CreateVoiceSession: %v", err)
+		}
+		if status := referralStatus(); status != "pending" {
+			t.Fatalf("referral status after session start = %q, want pending", status)
+		}
+		if err := sessionService.EndVoiceSession(ctx, referee.ID, sessionID); err != nil {
+			t.Fatalf("EndVoiceSession: %v", err)
+		}
+	}
+
+	// Пустая сессия не считается использованием сервиса
+	endSession(0)
+	if status := referralStatus(); status != "pending" {
+		t.Fatalf("referral status after empty session = %q, want pending", status)
+	}
+
+	endSession(42)
+	if status := referralStatus(); status != "rewarded" {
+		t.Fatalf("referral status after active session = %q, want rewarded", status)
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/llm"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Ограничения суммаризации сессий
+const (
+	summaryMaxTranscriptTokens = 6000
+	summaryMaxReplyTokens      = 400
+	summaryMaxAttempts         = 3
+)
+
+const summarySystemPrompt = `Ты ведешь память голосового ассистента. По предыдущей сводке (если она есть)
+и расшифровке последнего разговора составь обновленную сводку для продолжения общения.
+
+Правила:
+- Сводка - до 8 предложений, на языке разговора
+- Сохрани важные факты о пользователе, договоренности и незавершенные вопросы из предыдущей сводки
+- Не выдумывай того, чего не было в разговоре
+- Тема - 2-6 слов о главном предмете последнего разговора
+
+Верни только JSON: {"topic": "...", "summary": "..."}`
+
+type SummaryService struct {
+	completer llm.Completer
+}
+
+func NewSummaryService(completer llm.Completer) *SummaryService {
+	return &SummaryService{
+		completer: completer,
+	}
+}
+
+type sessionSummary struct {
+	Topic   string `json:"topic"`
+	Summary string `json:"summary"`
+}
+
+type transcriptMessage struct {
+	role      string
+	content   string
+	createdAt time.Time
+}
+
+// estimateTokens грубо оценивает число токенов текста (в среднем ~4 символа на токен)
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// truncateToTokens обрезает текст до оценки в maxTokens токенов
+func truncateToTokens(text string, maxTokens int) string {
+	if estimateTokens(text) <= maxTokens {
+		return text
+	}
+	runes := []rune(text)
+	limit := maxTokens * 4
+	if limit > len(runes) {
+		limit = len(runes)
+	}
+	return strings.TrimSpace(string(runes[:limit])) + "…"
+}
+
+func transcriptRoleName(role string) string {
+	if role == "assistant" {
+		return "Ассистент"
+	}
+	return "Пользователь"
+}
+
+// loadSessionTranscript возвращает реплики сессии в хронологическом порядке
+func loadSessionTranscript(ctx context.Context, sessionID int, userID int) ([]transcriptMessage, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT message_type, content, content_key_id, created_at
+		FROM conversation_messages
+		WHERE session_id = $1 AND user_id = $2 AND message_type IN ('user', 'assistant')
+		ORDER BY created_at, id
+	`, sessionID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []transcriptMessage
+	for rows.Next() {
+		var m transcriptMessage
+		var keyID *int
+		if err := rows.Scan(&m.role, &m.content, &keyID, &m.createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session message: %w", err)
+		}
+		content, err := openContent(ctx, userID, contentTableMessages, m.content, keyID)
+		if err != nil {
+			return nil, err
+		}
+		m.content = content
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query session messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// formatTranscript записывает реплики построчно в пределах maxTokens.
+// Длинный разговор обрезается с начала: последние реплики важнее.
+func formatTranscript(messages []transcriptMessage, maxTokens int) string {
+	var lines []string
+	budget := maxTokens
+	for i := len(messages) - 1; i >= 0; i-- {
+		line := transcriptRoleName(messages[i].role) + ": " + messages[i].content
+		cost := estimateTokens(line)
+		if cost > budget {
+			if len(lines) == 0 {
+				lines = append(lines, truncateToTokens(line, budget))
+			}
+			break
+		}
+		budget -= cost
+		lines = append([]string{line}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimJSONReply убирает markdown-обрамление, которое модели иногда добавляют к JSON
+func trimJSONReply(reply string) string {
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(reply, "```json")
+	reply = strings.TrimSuffix(strings.TrimPrefix(reply, "```"), "```")
+	return strings.TrimSpace(reply)
+}
+
+// parseSessionSummary разбирает ответ модели. Если модель не вернула JSON,
+// весь ответ считается сводкой без темы.
+func parseSessionSummary(reply string) (*sessionSummary, error) {
+	reply = trimJSONReply(reply)
+
+	var summary sessionSummary
+	if err := json.Unmarshal([]byte(reply), &summary); err != nil {
+		summary = sessionSummary{Summary: reply}
+	}
+	summary.Topic = strings.TrimSpace(summary.Topic)
+	summary.Summary = strings.TrimSpace(summary.Summary)
+
+	if summary.Summary == "" {
+		return nil, fmt.Errorf("empty summary")
+	}
+	return &summary, nil
+}
+
+// SummarizeEndedSessions составляет сводки завершенных сессий: закрытых клиентом
+// или без новых сообщений дольше SESSION_IDLE_MINUTES. Сессии старше недели
+// (например, созданные до появления суммаризации) не обрабатываются.
+// Ошибка модели по одной сессии не останавливает пакет - сессия будет повторена в следующий запуск.
+func (s *SummaryService) SummarizeEndedSessions(ctx context.Context, batchSize int) (int, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT vs.id FROM voice_sessions vs
+		WHERE vs.summarized_at IS NULL
+		  AND vs.user_id IS NOT NULL
+		  AND vs.summary_attempts < $1
+		  AND vs.created_at > CURRENT_TIMESTAMP - INTERVAL '7 days'
+		  AND (vs.ended_at IS NOT NULL OR vs.created_at < CURRENT_TIMESTAMP - make_interval(mins => $2))
+		  AND NOT EXISTS (
+			SELECT 1 FROM conversation_messages cm
+			WHERE cm.session_id = vs.id AND cm.created_at > CURRENT_TIMESTAMP - make_interval(mins => $2)
+		  )
+		ORDER BY vs.created_at
+		LIMIT $3
+	`, summaryMaxAttempts, config.AppConfig.SessionIdleMinutes, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find sessions to summarize: %w", err)
+	}
+	var sessionIDs []int
+	for rows.Next() {
+		var sessionID int
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan session id: %w", err)
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	rows.Close()
+
+	summarized := 0
+	for _, sessionID := range sessionIDs {
+		if err := s.SummarizeSession(ctx, sessionID); err != nil {
+			if ctx.Err() != nil {
+				return summarized, err
+			}
+			log.Warnf("Failed to summarize voice session %d: %v", sessionID, err)
+			continue
+		}
+		summarized++
+	}
+
+	if summarized > 0 {
+		log.Infof("📝 Summarized %d voice sessions", summarized)
+	}
+
+	return summarized, nil
+}
+
+// SummarizeSession записывает сводку и тему сессии в voice_sessions.
+// Сводка «накопительная»: в запрос передается последняя сводка пользователя.
+// Если клиент уже прислал сводку, она сохраняется без обращения к модели.
+func (s *SummaryService) SummarizeSession(ctx context.Context, sessionID int) error {
+	conn := database.Database.Pool
+
+	var userID int
+	var clientSummary *string
+	err := conn.QueryRow(ctx, `
+		SELECT user_id, context_summary FROM voice_sessions
+		WHERE id = $1 AND user_id IS NOT NULL AND summarized_at IS NULL
+	`, sessionID).Scan(&userID, &clientSummary)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get voice session: %w", err)
+	}
+
+	messages, err := loadSessionTranscript(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	var through *time.Time
+	if len(messages) > 0 {
+		through = &messages[len(messages)-1].createdAt
+	}
+
+	if len(messages) == 0 || clientSummary != nil {
+		return markSessionSummarized(ctx, sessionID, userID, nil, through)
+	}
+
+	var previousSummary *string
+	var previousKeyID *int
+	err = conn.QueryRow(ctx, `
+		SELECT context_summary, summary_key_id FROM voice_sessions
+		WHERE user_id = $1 AND id != $2 AND summarized_at IS NOT NULL AND context_summary IS NOT NULL
+		ORDER BY summarized_at DESC, id DESC
+		LIMIT 1
+	`, userID, sessionID).Scan(&previousSummary, &previousKeyID)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to get previous summary: %w", err)
+	}
+	if previousSummary, err = openOptionalContent(ctx, userID, contentTableSessions, previousSummary, previousKeyID); err != nil {
+		return err
+	}
+
+	var prompt strings.Builder
+	if previousSummary != nil {
+		prompt.WriteString("ПРЕДЫДУЩАЯ СВОДКА:\n")
+		prompt.WriteString(*previousSummary)
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("ПОСЛЕДНИЙ РАЗГОВОР:\n")
+	prompt.WriteString(formatTranscript(messages, summaryMaxTranscriptTokens))
+
+	reply, err := s.completer.Complete(ctx, &llm.CompletionRequest{
+		Model:     config.AppConfig.SummaryModel,
+		System:    summarySystemPrompt,
+		Prompt:    prompt.String(),
+		MaxTokens: summaryMaxReplyTokens,
+		JSON:      true,
+	})
+	if err == nil {
+		var summary *sessionSummary
+		if summary, err = parseSessionSummary(reply); err == nil {
+			return markSessionSummarized(ctx, sessionID, userID, summary, through)
+		}
+	}
+
+	if _, updateErr := conn.Exec(ctx, `
+		UPDATE voice_sessions SET summary_attempts = summary_attempts + 1 WHERE id = $1
+	`, sessionID); updateErr != nil {
+		log.Warnf("Failed to record summary attempt for session %d: %v", sessionID, updateErr)
+	}
+	return fmt.Errorf("failed to summarize session %d: %w", sessionID, err)
+}
+
+// markSessionSummarized сохраняет сводку (если есть) зашифрованной и отмечает сессию обработанной
+func markSessionSummarized(ctx context.Context, sessionID int, userID int, summary *sessionSummary, through *time.Time) error {
+	var text, topic *string
+	if summary != nil {
+		text = &summary.Summary
+		if summary.Topic != "" {
+			topic = &summary.Topic
+		}
+	}
+
+	sealed, keyID, err := sealOptionalContent(ctx, userID, contentTableSessions, text)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Database.Pool.Exec(ctx, `
+		UPDATE voice_sessions
+		SET context_summary = COALESCE($2, context_summary),
+		    summary_key_id = CASE WHEN $2::text IS NULL THEN summary_key_id ELSE $5 END,
+		    last_conversation_topic = COALESCE($3, last_conversation_topic),
+		    summary_through = $4,
+		    summarized_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND summarized_at IS NULL
+	`, sessionID, sealed, topic, through, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to save session summary: %w", err)
+	}
+	return nil
+}
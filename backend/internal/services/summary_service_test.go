@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/llm/llmtest"
+	"voice-ai-backend/internal/models"
+)
+
+func TestParseSessionSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		topic   string
+		summary string
+		wantErr bool
+	}{
+		{"json", `{"topic": " Путешествия ", "summary": "Обсудили поездку."}`, "Путешествия", "Обсудили поездку.", false},
+		{"markdown fence", "```json\n{\"topic\": \"Работа\", \"summary\": \"Про отпуск.\"}\n```", "Работа", "Про отпуск.", false},
+		{"plain text", "  Пользователь рассказал о поездке.  ", "", "Пользователь рассказал о поездке.", false},
+		{"empty json summary", `{"topic": "Работа", "summary": ""}`, "", "", true},
+		{"empty reply", "   ", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := parseSessionSummary(tt.reply)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSessionSummary(%q) = %+v, want error", tt.reply, summary)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSessionSummary(%q): %v", tt.reply, err)
+			}
+			if summary.Topic != tt.topic || summary.Summary != tt.summary {
+				t.Fatalf("parseSessionSummary(%q) = %+v, want topic %q summary %q", tt.reply, summary, tt.topic, tt.summary)
+			}
+		})
+	}
+}
+
+// createTestSession создает сессию пользователя с парой реплик
+func createTestSession(t *testing.T, ctx context.Context, userID int) int {
+	t.Helper()
+
+	sessionID, err := NewSessionService().CreateVoiceSession(ctx, &models.CreateVoiceSessionRequest{UserID: &userID})
+	if err != nil {
+		t.Fatalf("CreateVoiceSession: %v", err)
+	}
+	conversations := NewConversationService()
+	for _, message := range []struct{ kind, content string }{
+		{"user", "Я собираюсь в отпуск в Казань"},
+		{"assistant", "Отличный выбор! Когда планируете поездку?"},
+	} {
+		_, err := conversations.SaveMessage(ctx, &models.SaveConversationRequest{
+			UserID: userID, SessionID: &sessionID, MessageType: message.kind, Content: message.content,
+		})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	return sessionID
+}
+
+type testSessionSummaryState struct {
+	summary    *string
+	topic      *string
+	attempts   int
+	summarized bool
+}
+
+func testSessionSummary(t *testing.T, ctx context.Context, sessionID int) testSessionSummaryState {
+	t.Helper()
+
+	var state testSessionSummaryState
+	err := database.Database.Pool.QueryRow(ctx, `
+		SELECT context_summary, last_conversation_topic, summary_attempts, summarized_at IS NOT NULL
+		FROM voice_sessions WHERE id = $1
+	`, sessionID).Scan(&state.summary, &state.topic, &state.attempts, &state.summarized)
+	if err != nil {
+		t.Fatalf("get session summary: %v", err)
+	}
+	return state
+}
+
+func TestSummarizeSession(t *testing.T) {
+	ctx := testDB(t)
+
+	t.Run("json reply", func(t *testing.T) {
+		fake := llmtest.NewServer("test-key")
+		defer fake.Close()
+		fake.SetReply(func(req llmtest.Request) string {
+			return `{"topic": "Отпуск в Казани", "summary": "Пользователь планирует отпуск в Казани."}`
+		})
+
+		user := createTestUser(t, ctx, testTelegramID())
+		sessionID := createTestSession(t, ctx, user.ID)
+		if err := NewSummaryService(fake.Client()).SummarizeSession(ctx, sessionID); err != nil {
+			t.Fatalf("SummarizeSession: %v", err)
+		}
+
+		state := testSessionSummary(t, ctx, sessionID)
+		if !state.summarized || state.summary == nil || *state.summary != "Пользователь планирует отпуск в Казани." {
+			t.Fatalf("session summary = %+v, want JSON summary", state)
+		}
+		if state.topic == nil || *state.topic != "Отпуск в Казани" {
+			t.Fatalf("session topic = %v, want %q", state.topic, "Отпуск в Казани")
+		}
+
+		requests := fake.Requests()
+		if len(requests) != 1 || !requests[0].JSON {
+			t.Fatalf("model requests = %+v, want one JSON request", requests)
+		}
+	})
+
+	t.Run("non-JSON reply", func(t *testing.T) {
+		fake := llmtest.NewServer("test-key")
+		defer fake.Close()
+		fake.SetReply(func(req llmtest.Request) string {
+			return "Пользователь собирается в Казань."
+		})
+
+		user := createTestUser(t, ctx, testTelegramID())
+		sessionID := createTestSession(t, ctx, user.ID)
+		if err := NewSummaryService(fake.Client()).SummarizeSession(ctx, sessionID); err != nil {
+			t.Fatalf("SummarizeSession: %v", err)
+		}
+
+		state := testSessionSummary(t, ctx, sessionID)
+		if !state.summarized || state.summary == nil || *state.summary != "Пользователь собирается в Казань." {
+			t.Fatalf("session summary = %+v, want whole reply as summary", state)
+		}
+		if state.topic != nil {
+			t.Fatalf("session topic = %q, want none", *state.topic)
+		}
+	})
+
+	t.Run("API failure", func(t *testing.T) {
+		fake := llmtest.NewServer("test-key")
+		defer fake.Close()
+		fake.Fail(http.StatusInternalServerError, "The server had an error")
+
+		user := createTestUser(t, ctx, testTelegramID())
+		sessionID := createTestSession(t, ctx, user.ID)
+		service := NewSummaryService(fake.Client())
+
+		for attempt := 1; attempt <= summaryMaxAttempts; attempt++ {
+			if err := service.SummarizeSession(ctx, sessionID); err == nil {
+				t.Fatalf("SummarizeSession attempt %d succeeded, want error", attempt)
+			}
+			if state := testSessionSummary(t, ctx, sessionID); state.attempts != attempt || state.summarized {
+				t.Fatalf("after attempt %d session = %+v, want %d attempts and no summary", attempt, state, attempt)
+			}
+		}
+
+		// Сессия с исчерпанными попытками больше не выбирается, даже если модель снова отвечает
+		fake.Fail(0, "")
+		_, err := database.Database.Pool.Exec(ctx, `
+			UPDATE conversation_messages SET created_at = CURRENT_TIMESTAMP - INTERVAL '1 day' WHERE session_id = $1
+		`, sessionID)
+		if err != nil {
+			t.Fatalf("backdate messages: %v", err)
+		}
+		_, err = database.Database.Pool.Exec(ctx, `UPDATE voice_sessions SET ended_at = CURRENT_TIMESTAMP WHERE id = $1`, sessionID)
+		if err != nil {
+			t.Fatalf("end session: %v", err)
+		}
+		if _, err := service.SummarizeEndedSessions(ctx, 1000); err != nil {
+			t.Fatalf("SummarizeEndedSessions: %v", err)
+		}
+		if state := testSessionSummary(t, ctx, sessionID); state.summarized || state.attempts != summaryMaxAttempts {
+			t.Fatalf("session after %d failed attempts = %+v, want it skipped", summaryMaxAttempts, state)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"time"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
 
@@ -10,20 +11,85 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Источники начисления токенов (token_grants.source)
+const (
+	TokenSourcePlan     = "plan"
+	TokenSourcePromo    = "promo"
+	TokenSourceReferral = "referral"
+	TokenSourceManual   = "manual"
+	TokenSourceTransfer = "transfer"
+)
+
+const LedgerReasonExpired = "expired"
+
 type TokenService struct{}
 
 func NewTokenService() *TokenService {
 	return &TokenService{}
 }
 
-// GetTokenBalance получает баланс токенов пользователя
+// tokenAccount - счет, с которого пользователь тратит токены. Для участника
+// организации это баланс владельца (общий пул) с учетом месячного лимита.
+type tokenAccount struct {
+	payerID        int
+	organizationID *int
+	monthlyCap     *int
+	usedThisMonth  int
+}
+
+// spendable возвращает, сколько токенов пользователь может потратить при балансе пула
+func (a *tokenAccount) spendable(balance int) int {
+	if a.monthlyCap != nil {
+		remaining := *a.monthlyCap - a.usedThisMonth
+		if remaining < 0 {
+			remaining = 0
+		}
+		if remaining < balance {
+			return remaining
+		}
+	}
+	return balance
+}
+
+func resolveTokenAccount(ctx context.Context, q rowQuerier, userID int) (*tokenAccount, error) {
+	account := &tokenAccount{payerID: userID}
+
+	err := q.QueryRow(ctx, `
+		SELECT o.id, o.owner_id, m.monthly_cap,
+		       COALESCE((
+		           SELECT SUM(tu.cost_tokens) FROM token_usage tu
+		           WHERE tu.organization_id = o.id AND tu.actor_user_id = m.user_id
+		             AND tu.created_at >= date_trunc('month', CURRENT_TIMESTAMP)
+		       ), 0)
+		FROM organization_members m
+		JOIN organizations o ON o.id = m.organization_id
+		WHERE m.user_id = $1 AND m.status = 'active'
+	`, userID).Scan(&account.organizationID, &account.payerID, &account.monthlyCap, &account.usedThisMonth)
+
+	if err == pgx.ErrNoRows {
+		return &tokenAccount{payerID: userID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve token account: %w", err)
+	}
+
+	return account, nil
+}
+
+// GetTokenBalance получает баланс токенов пользователя. Для участника организации
+// возвращается доступный ему остаток общего пула с учетом месячного лимита.
 func (s *TokenService) GetTokenBalance(ctx context.Context, userID int) (int, error) {
 	conn := database.Database.Pool
 
+	account, err := resolveTokenAccount(ctx, conn, userID)
+	if err != nil {
+		return 0, err
+	}
+
 	var tokenBalance int
-	err := conn.QueryRow(ctx, `
+	err = conn.QueryRow(ctx, `
 		SELECT token_balance FROM users WHERE id = $1
-	`, userID).Scan(&tokenBalance)
+	`, account.payerID).Scan(&tokenBalance)
 
 	if err == pgx.ErrNoRows {
 		return 0, fmt.Errorf("user not found")
@@ -32,7 +98,7 @@ func (s *TokenService) GetTokenBalance(ctx context.Context, userID int) (int, er
 		return 0, fmt.Errorf("failed to get token balance: %w", err)
 	}
 
-	return tokenBalance, nil
+	return account.spendable(tokenBalance), nil
 }
 
 // DeductTokens списывает токены с баланса пользователя
@@ -70,11 +136,17 @@ func (s *TokenService) DeductTokens(ctx context.Context, req *models.TokenUsageR
 	}
 	defer tx.Rollback(ctx)
 
+	// Участники организации тратят общий пул владельца
+	account, err := resolveTokenAccount(ctx, tx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Проверяем текущий баланс
 	var currentBalance int
 	err = tx.QueryRow(ctx, `
-		SELECT token_balance FROM users WHERE id = $1
-	`, req.UserID).Scan(&currentBalance)
+		SELECT token_balance FROM users WHERE id = $1 FOR UPDATE
+	`, account.payerID).Scan(&currentBalance)
 
 	if err == pgx.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
@@ -83,6 +155,23 @@ func (s *TokenService) DeductTokens(ctx context.Context, req *models.TokenUsageR
 		return nil, fmt.Errorf("failed to get token balance: %w", err)
 	}
 
+	// Проверяем месячный лимит участника
+	if account.monthlyCap != nil && account.usedThisMonth+totalTokens > *account.monthlyCap {
+		return nil, fmt.Errorf("member spending cap exceeded")
+	}
+
+	// Проверяем лимиты расхода, которые пользователь задал себе сам
+	limits, err := getSpendingLimits(ctx, tx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if limits.DailyLimit != nil && limits.UsedToday+totalTokens > *limits.DailyLimit {
+		return nil, fmt.Errorf("daily spending limit exceeded")
+	}
+	if limits.MonthlyLimit != nil && limits.UsedThisMonth+totalTokens > *limits.MonthlyLimit {
+		return nil, fmt.Errorf("monthly spending limit exceeded")
+	}
+
 	// Проверяем достаточность токенов
 	if currentBalance < totalTokens {
 		return nil, fmt.Errorf("insufficient tokens: have %d, need %d", currentBalance, totalTokens)
@@ -92,7 +181,7 @@ func (s *TokenService) DeductTokens(ctx context.Context, req *models.TokenUsageR
 	if req.CheckOnly {
 		return &models.TokenUsageResponse{
 			TokensUsed: 0,
-			NewBalance: currentBalance,
+			NewBalance: account.spendable(currentBalance),
 		}, nil
 	}
 
@@ -102,38 +191,81 @@ func (s *TokenService) DeductTokens(ctx context.Context, req *models.TokenUsageR
 		UPDATE users
 		SET token_balance = $1, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $2
-	`, newBalance, req.UserID)
+	`, newBalance, account.payerID)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to deduct tokens: %w", err)
 	}
 
-	// Логируем использование токенов
+	if err := consumeTokenGrants(ctx, tx, account.payerID, totalTokens); err != nil {
+		return nil, err
+	}
+
+	// Логируем использование токенов. user_id - владелец счета (расход засчитывается
+	// в его подписку), actor_user_id - участник организации, который потратил токены.
+	var actorUserID *int
+	if account.organizationID != nil {
+		actorUserID = &req.UserID
+	}
+
+	// Модель берем из запроса, иначе - выбранную пользователем
+	var model string
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE($2, selected_model, $3) FROM users WHERE id = $1
+	`, req.UserID, req.Model, defaultRealtimeModel).Scan(&model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user model: %w", err)
+	}
+
 	_, err = tx.Exec(ctx, `
 		INSERT INTO token_usage (
 			user_id, session_id, input_tokens, output_tokens, total_tokens, cost_tokens,
 			input_text_tokens, input_audio_tokens, input_image_tokens, cached_tokens,
-			output_text_tokens, output_audio_tokens, created_at
+			output_text_tokens, output_audio_tokens, organization_id, actor_user_id, model, created_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, CURRENT_TIMESTAMP)
-	`, req.UserID, req.SessionID, inputTokens, outputTokens, totalTokens, totalTokens,
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, CURRENT_TIMESTAMP)
+	`, account.payerID, req.SessionID, inputTokens, outputTokens, totalTokens, totalTokens,
 		inputTextTokens, inputAudioTokens, inputImageTokens, cachedTokens,
-		outputTextTokens, outputAudioTokens)
+		outputTextTokens, outputAudioTokens, account.organizationID, actorUserID, model)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to log token usage: %w", err)
 	}
 
+	// Почасовой агрегат ведется по тому, кто потратил токены (COALESCE(actor_user_id, user_id))
+	err = recordUsageRollup(ctx, tx, req.UserID, model, req.SessionID, &models.UsageTotals{
+		InputTokens:       inputTokens,
+		OutputTokens:      outputTokens,
+		TotalTokens:       totalTokens,
+		InputTextTokens:   inputTextTokens,
+		InputAudioTokens:  inputAudioTokens,
+		InputImageTokens:  inputImageTokens,
+		CachedTokens:      cachedTokens,
+		OutputTextTokens:  outputTextTokens,
+		OutputAudioTokens: outputAudioTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Коммитим транзакцию
 	if err = tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	log.Infof("✅ Deducted %d tokens from user %d. New balance: %d", totalTokens, req.UserID, newBalance)
+	log.Infof("✅ Deducted %d tokens from user %d (account %d). New balance: %d", totalTokens, req.UserID, account.payerID, newBalance)
+
+	account.usedThisMonth += totalTokens
+
+	// Пороги проверяются по зафиксированному балансу; предупреждения только ставятся
+	// в ленту, в Telegram их отправит задача deliver_notifications
+	if err := checkTokenAlerts(ctx, req.UserID); err != nil {
+		log.Warnf("Failed to check token alerts for user %d: %v", req.UserID, err)
+	}
 
 	return &models.TokenUsageResponse{
 		TokensUsed: totalTokens,
-		NewBalance: newBalance,
+		NewBalance: account.spendable(newBalance),
 		UsageBreakdown: &models.UsageBreakdown{
 			Input: models.TokenBreakdown{
 				Total:  inputTokens,
@@ -152,12 +284,92 @@ func (s *TokenService) DeductTokens(ctx context.Context, req *models.TokenUsageR
 	}, nil
 }
 
-// AddTokens пополняет баланс токенов
-func (s *TokenService) AddTokens(ctx context.Context, userID int, tokensToAdd int) (int, error) {
+// getSpendingLimits возвращает лимиты расхода пользователя и его расход за сегодня и за месяц.
+// Расход считается по почасовому агрегату, где пользователь - тот, кто потратил токены.
+func getSpendingLimits(ctx context.Context, q rowQuerier, userID int) (*models.SpendingLimits, error) {
+	limits := &models.SpendingLimits{AlertsEnabled: true}
+
+	err := q.QueryRow(ctx, `
+		SELECT daily_limit, monthly_limit, alerts_enabled FROM user_spending_limits WHERE user_id = $1
+	`, userID).Scan(&limits.DailyLimit, &limits.MonthlyLimit, &limits.AlertsEnabled)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get spending limits: %w", err)
+	}
+
+	err = q.QueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(total_tokens) FILTER (WHERE bucket_start >= date_trunc('day', CURRENT_TIMESTAMP::timestamp)), 0),
+			COALESCE(SUM(total_tokens), 0)
+		FROM token_usage_hourly
+		WHERE user_id = $1 AND bucket_start >= date_trunc('month', CURRENT_TIMESTAMP::timestamp)
+	`, userID).Scan(&limits.UsedToday, &limits.UsedThisMonth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spending usage: %w", err)
+	}
+
+	return limits, nil
+}
+
+// GetSpendingLimits возвращает лимиты расхода пользователя вместе с текущим расходом
+func (s *TokenService) GetSpendingLimits(ctx context.Context, userID int) (*models.SpendingLimits, error) {
+	return getSpendingLimits(ctx, database.Database.Pool, userID)
+}
+
+// UpdateSpendingLimits задает дневной и месячный лимиты расхода (nil - без лимита)
+func (s *TokenService) UpdateSpendingLimits(ctx context.Context, req *models.UpdateSpendingLimitsRequest) (*models.SpendingLimits, error) {
+	if (req.DailyLimit != nil && *req.DailyLimit <= 0) || (req.MonthlyLimit != nil && *req.MonthlyLimit <= 0) {
+		return nil, fmt.Errorf("invalid limit")
+	}
+
 	conn := database.Database.Pool
 
+	_, err := conn.Exec(ctx, `
+		INSERT INTO user_spending_limits (user_id, daily_limit, monthly_limit, alerts_enabled, updated_at)
+		VALUES ($1, $2, $3, COALESCE($4, true), CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			daily_limit = EXCLUDED.daily_limit,
+			monthly_limit = EXCLUDED.monthly_limit,
+			alerts_enabled = COALESCE($4, user_spending_limits.alerts_enabled),
+			updated_at = CURRENT_TIMESTAMP
+	`, req.UserID, req.DailyLimit, req.MonthlyLimit, req.AlertsEnabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update spending limits: %w", err)
+	}
+
+	return getSpendingLimits(ctx, conn, req.UserID)
+}
+
+// AddTokens пополняет баланс токенов. Если задан expiresAt, начисленные токены сгорят в этот момент.
+func (s *TokenService) AddTokens(ctx context.Context, userID int, tokensToAdd int, expiresAt *time.Time) (int, error) {
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var newBalance int
+	if expiresAt != nil {
+		newBalance, err = s.GrantTokensTx(ctx, tx, userID, tokensToAdd, TokenSourceManual, nil, expiresAt)
+	} else {
+		newBalance, err = s.AddTokensTx(ctx, tx, userID, tokensToAdd)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return newBalance, nil
+}
+
+// AddTokensTx пополняет баланс в рамках внешней транзакции
+func (s *TokenService) AddTokensTx(ctx context.Context, tx pgx.Tx, userID int, tokensToAdd int) (int, error) {
 	var newBalance int
-	err := conn.QueryRow(ctx, `
+	err := tx.QueryRow(ctx, `
 		UPDATE users
 		SET token_balance = token_balance + $1, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $2
@@ -175,3 +387,304 @@ func (s *TokenService) AddTokens(ctx context.Context, userID int, tokensToAdd in
 
 	return newBalance, nil
 }
+
+// RevokeTokens списывает ранее начисленные токены (например, при возврате платежа).
+// Баланс не уходит ниже нуля. Возвращает фактически списанное количество и новый баланс.
+func (s *TokenService) RevokeTokens(ctx context.Context, tx pgx.Tx, userID int, tokens int) (int, int, error) {
+	var revoked, newBalance int
+	err := tx.QueryRow(ctx, `
+		WITH current AS (
+			SELECT token_balance FROM users WHERE id = $2 FOR UPDATE
+		)
+		UPDATE users u
+		SET token_balance = GREATEST(current.token_balance - $1, 0), updated_at = CURRENT_TIMESTAMP
+		FROM current
+		WHERE u.id = $2
+		RETURNING LEAST(current.token_balance, $1), u.token_balance
+	`, tokens, userID).Scan(&revoked, &newBalance)
+
+	if err == pgx.ErrNoRows {
+		return 0, 0, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	if err := consumeTokenGrants(ctx, tx, userID, revoked); err != nil {
+		return 0, 0, err
+	}
+
+	log.Infof("↩️ Revoked %d of %d tokens from user %d. New balance: %d", revoked, tokens, userID, newBalance)
+
+	return revoked, newBalance, nil
+}
+
+// TokenTTL возвращает момент сгорания токенов, начисленных сейчас со сроком days дней.
+// При days <= 0 токены бессрочные.
+func TokenTTL(days int) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+	return &expiresAt
+}
+
+// GrantTokensTx пополняет баланс и запоминает начисление как партию токенов
+// со сроком действия (expiresAt == nil - бессрочно)
+func (s *TokenService) GrantTokensTx(ctx context.Context, tx pgx.Tx, userID int, amount int, source string, referenceID *int, expiresAt *time.Time) (int, error) {
+	newBalance, err := s.AddTokensTx(ctx, tx, userID, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	if amount > 0 {
+		if err := insertTokenGrant(ctx, tx, userID, amount, source, referenceID, expiresAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return newBalance, nil
+}
+
+func insertTokenGrant(ctx context.Context, q execer, userID int, amount int, source string, referenceID *int, expiresAt *time.Time) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO token_grants (user_id, source, reference_id, amount, remaining, status, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $4, 'active', $5, CURRENT_TIMESTAMP)
+	`, userID, source, referenceID, amount, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to record token grant: %w", err)
+	}
+	return nil
+}
+
+// consumeTokenGrants уменьшает остатки активных партий пользователя на amount,
+// начиная с тех, что сгорят раньше. Бессрочные партии расходуются последними,
+// а то, что не покрыто партиями, списывается с бессрочной части баланса.
+// Вызывается в транзакции, где строка пользователя уже заблокирована.
+func consumeTokenGrants(ctx context.Context, tx pgx.Tx, userID int, amount int) error {
+	_, err := takeTokenGrants(ctx, tx, userID, amount)
+	return err
+}
+
+// grantPortion - токены, взятые из партий с одним сроком сгорания
+type grantPortion struct {
+	amount    int
+	expiresAt *time.Time
+}
+
+// takeTokenGrants работает как consumeTokenGrants и возвращает, сколько токенов взято
+// из партий с каждым сроком сгорания (в порядке списания). Часть, не покрытая партиями,
+// в результат не входит.
+func takeTokenGrants(ctx context.Context, tx pgx.Tx, userID int, amount int) ([]grantPortion, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, remaining, expires_at FROM token_grants
+		WHERE user_id = $1 AND status = 'active'
+		ORDER BY expires_at ASC NULLS LAST, id
+		FOR UPDATE
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token grants: %w", err)
+	}
+
+	type grantRemaining struct {
+		id        int
+		remaining int
+		expiresAt *time.Time
+	}
+	var grants []grantRemaining
+	for rows.Next() {
+		var g grantRemaining
+		if err := rows.Scan(&g.id, &g.remaining, &g.expiresAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan token grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get token grants: %w", err)
+	}
+
+	var portions []grantPortion
+	for _, g := range grants {
+		if amount <= 0 {
+			break
+		}
+		take := g.remaining
+		if take > amount {
+			take = amount
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE token_grants
+			SET remaining = remaining - $1,
+			    status = CASE WHEN remaining - $1 = 0 THEN 'consumed' ELSE status END,
+			    closed_at = CASE WHEN remaining - $1 = 0 THEN CURRENT_TIMESTAMP ELSE closed_at END
+			WHERE id = $2
+		`, take, g.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume token grant: %w", err)
+		}
+		amount -= take
+
+		// Партии идут по сроку, поэтому одинаковые сроки стоят подряд
+		if n := len(portions); n > 0 && sameExpiry(portions[n-1].expiresAt, g.expiresAt) {
+			portions[n-1].amount += take
+		} else {
+			portions = append(portions, grantPortion{amount: take, expiresAt: g.expiresAt})
+		}
+	}
+
+	return portions, nil
+}
+
+func sameExpiry(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// reconcileTokenGrants урезает партии, если баланс был уменьшен или сброшен
+// в обход consumeTokenGrants и сумма остатков партий стала больше баланса
+func reconcileTokenGrants(ctx context.Context, tx pgx.Tx, userID int) error {
+	var excess int
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE((
+			SELECT SUM(remaining) FROM token_grants WHERE user_id = $1 AND status = 'active'
+		), 0) - token_balance
+		FROM users WHERE id = $1
+		FOR UPDATE
+	`, userID).Scan(&excess)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reconcile token grants: %w", err)
+	}
+
+	return consumeTokenGrants(ctx, tx, userID, excess)
+}
+
+// GetTokenExpirations возвращает ближайшие сгорания токенов пользователя
+func (s *TokenService) GetTokenExpirations(ctx context.Context, userID int) ([]models.TokenExpiration, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT remaining, source, expires_at
+		FROM token_grants
+		WHERE user_id = $1 AND status = 'active' AND remaining > 0 AND expires_at IS NOT NULL
+		ORDER BY expires_at, id
+		LIMIT 20
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token expirations: %w", err)
+	}
+	defer rows.Close()
+
+	expirations := []models.TokenExpiration{}
+	for rows.Next() {
+		var e models.TokenExpiration
+		if err := rows.Scan(&e.Amount, &e.Source, &e.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan token expiration: %w", err)
+		}
+		expirations = append(expirations, e)
+	}
+
+	return expirations, nil
+}
+
+// ExpireTokenGrants списывает с баланса несгоревшие остатки просроченных партий.
+// Каждый пользователь обрабатывается в отдельной транзакции; за запуск - не больше batchSize пользователей.
+func (s *TokenService) ExpireTokenGrants(ctx context.Context, batchSize int) (int, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT DISTINCT user_id FROM token_grants
+		WHERE status = 'active' AND expires_at <= CURRENT_TIMESTAMP
+		LIMIT $1
+	`, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired token grants: %w", err)
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan user id: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	totalExpired := 0
+	for _, userID := range userIDs {
+		expired, err := s.expireUserTokenGrants(ctx, userID)
+		if err != nil {
+			return totalExpired, err
+		}
+		totalExpired += expired
+	}
+
+	if totalExpired > 0 {
+		log.Infof("⌛ Expired %d tokens for %d users", totalExpired, len(userIDs))
+	}
+
+	return totalExpired, nil
+}
+
+func (s *TokenService) expireUserTokenGrants(ctx context.Context, userID int) (int, error) {
+	tx, err := database.Database.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокируем пользователя раньше партий - в том же порядке, что и DeductTokens
+	var balance int
+	err = tx.QueryRow(ctx, `SELECT token_balance FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock user balance: %w", err)
+	}
+
+	var expired int
+	err = tx.QueryRow(ctx, `
+		WITH closed AS (
+			UPDATE token_grants
+			SET status = 'expired', closed_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND status = 'active' AND expires_at <= CURRENT_TIMESTAMP
+			RETURNING remaining
+		)
+		SELECT COALESCE(SUM(remaining), 0) FROM closed
+	`, userID).Scan(&expired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire token grants: %w", err)
+	}
+
+	if expired > balance {
+		expired = balance
+	}
+
+	if expired > 0 {
+		newBalance := balance - expired
+		_, err = tx.Exec(ctx, `
+			UPDATE users SET token_balance = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+		`, newBalance, userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to deduct expired tokens: %w", err)
+		}
+
+		if err := writeLedgerEntry(ctx, tx, userID, -expired, newBalance, LedgerReasonExpired, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"voice-ai-backend/internal/config"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+	"voice-ai-backend/internal/telegram"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Причины изменения баланса в token_ledger
+const (
+	LedgerReasonTransferOut = "transfer_out"
+	LedgerReasonTransferIn  = "transfer_in"
+)
+
+type TransferService struct {
+	bot          *telegram.Client
+	tokenService *TokenService
+}
+
+func NewTransferService(bot *telegram.Client) *TransferService {
+	return &TransferService{
+		bot:          bot,
+		tokenService: NewTokenService(),
+	}
+}
+
+// writeLedgerEntry записывает изменение баланса в журнал в рамках транзакции
+func writeLedgerEntry(ctx context.Context, tx pgx.Tx, userID int, delta int, balanceAfter int, reason string, referenceID *int) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO token_ledger (user_id, delta, balance_after, reason, reference_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+	`, userID, delta, balanceAfter, reason, referenceID)
+	if err != nil {
+		return fmt.Errorf("failed to write ledger entry: %w", err)
+	}
+	return nil
+}
+
+// findRecipient ищет получателя по Telegram username (@name) или коду приглашения
+func findRecipient(ctx context.Context, tx pgx.Tx, recipient string) (int, string, error) {
+	recipient = strings.TrimSpace(recipient)
+	username := strings.TrimPrefix(recipient, "@")
+
+	var userID int
+	var telegramID string
+	err := tx.QueryRow(ctx, `
+		SELECT id, telegram_id FROM users
+		WHERE lower(username) = lower($1) OR referral_code = $2
+		ORDER BY (lower(username) = lower($1)) DESC
+		LIMIT 1
+	`, username, parseReferralStartParam(recipient)).Scan(&userID, &telegramID)
+
+	if err == pgx.ErrNoRows {
+		return 0, "", fmt.Errorf("recipient not found")
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to find recipient: %w", err)
+	}
+
+	return userID, telegramID, nil
+}
+
+// TransferTokens атомарно переводит токены с баланса отправителя получателю.
+// Проверяются минимальная сумма, дневной лимит и неснижаемый остаток отправителя.
+// Повторный запрос с тем же idempotency_key возвращает уже выполненный перевод.
+func (s *TransferService) TransferTokens(ctx context.Context, req *models.TransferTokensRequest) (*models.TokenTransfer, error) {
+	if req.Amount < config.AppConfig.TransferMinAmount {
+		return nil, fmt.Errorf("invalid amount")
+	}
+	if req.Note != nil && len([]rune(*req.Note)) > 200 {
+		return nil, fmt.Errorf("note is too long")
+	}
+
+	conn := database.Database.Pool
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if existing, err := findTransferByKey(ctx, tx, req); existing != nil || err != nil {
+		return existing, err
+	}
+
+	recipientID, recipientTelegramID, err := findRecipient(ctx, tx, req.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	if recipientID == req.UserID {
+		return nil, fmt.Errorf("cannot transfer to yourself")
+	}
+
+	// Блокируем обе строки в порядке id, чтобы встречные переводы не вызвали дедлок
+	balances := make(map[int]int, 2)
+	rows, err := tx.Query(ctx, `
+		SELECT id, token_balance FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE
+	`, []int{req.UserID, recipientID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock balances: %w", err)
+	}
+	for rows.Next() {
+		var id, balance int
+		if err := rows.Scan(&id, &balance); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		balances[id] = balance
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to lock balances: %w", err)
+	}
+
+	senderBalance, ok := balances[req.UserID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	// Одновременный повтор ждал блокировки отправителя: перевод с тем же ключом
+	// мог появиться, пока ждали
+	if existing, err := findTransferByKey(ctx, tx, req); existing != nil || err != nil {
+		return existing, err
+	}
+
+	if senderBalance < req.Amount {
+		return nil, fmt.Errorf("insufficient tokens")
+	}
+	if senderBalance-req.Amount < config.AppConfig.TransferMinRemainingBalance {
+		return nil, fmt.Errorf("minimum remaining balance not met")
+	}
+
+	var sentToday int
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM token_transfers
+		WHERE sender_id = $1 AND created_at > CURRENT_TIMESTAMP - INTERVAL '1 day'
+	`, req.UserID).Scan(&sentToday)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily transfers: %w", err)
+	}
+	if sentToday+req.Amount > config.AppConfig.TransferDailyLimit {
+		return nil, fmt.Errorf("daily transfer limit exceeded")
+	}
+
+	var transfer models.TokenTransfer
+	err = tx.QueryRow(ctx, `
+		INSERT INTO token_transfers (sender_id, recipient_id, amount, note, idempotency_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, sender_id, recipient_id, amount, note, created_at
+	`, req.UserID, recipientID, req.Amount, req.Note, req.IdempotencyKey).Scan(
+		&transfer.ID, &transfer.SenderID, &transfer.RecipientID, &transfer.Amount, &transfer.Note, &transfer.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+
+	var senderNewBalance, recipientNewBalance int
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET token_balance = token_balance - $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING token_balance
+	`, req.Amount, req.UserID).Scan(&senderNewBalance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to debit sender: %w", err)
+	}
+	portions, err := takeTokenGrants(ctx, tx, req.UserID, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Получатель получает токены с теми же сроками сгорания, что были у отправителя:
+	// перевод не должен делать сгорающие токены бессрочными
+	recipientNewBalance, err = s.creditRecipient(ctx, tx, recipientID, req.Amount, portions, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeLedgerEntry(ctx, tx, req.UserID, -req.Amount, senderNewBalance, LedgerReasonTransferOut, &transfer.ID); err != nil {
+		return nil, err
+	}
+	if err := writeLedgerEntry(ctx, tx, recipientID, req.Amount, recipientNewBalance, LedgerReasonTransferIn, &transfer.ID); err != nil {
+		return nil, err
+	}
+
+	var senderName string
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE('@' || username, first_name) FROM users WHERE id = $1
+	`, req.UserID).Scan(&senderName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender name: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Infof("💸 Transfer %d: user %d sent %d tokens to user %d", transfer.ID, req.UserID, req.Amount, recipientID)
+
+	s.notifyRecipient(ctx, recipientTelegramID, senderName, &transfer, recipientNewBalance)
+
+	transfer.Direction = "out"
+	transfer.SenderNewBalance = &senderNewBalance
+
+	return &transfer, nil
+}
+
+// findTransferByKey возвращает перевод отправителя с idempotency_key запроса или nil
+func findTransferByKey(ctx context.Context, tx pgx.Tx, req *models.TransferTokensRequest) (*models.TokenTransfer, error) {
+	if req.IdempotencyKey == nil {
+		return nil, nil
+	}
+
+	var transfer models.TokenTransfer
+	err := tx.QueryRow(ctx, `
+		SELECT id, sender_id, recipient_id, amount, note, created_at
+		FROM token_transfers
+		WHERE sender_id = $1 AND idempotency_key = $2
+	`, req.UserID, *req.IdempotencyKey).Scan(
+		&transfer.ID, &transfer.SenderID, &transfer.RecipientID, &transfer.Amount, &transfer.Note, &transfer.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check idempotency key: %w", err)
+	}
+
+	transfer.Direction = "out"
+	return &transfer, nil
+}
+
+// creditRecipient зачисляет перевод получателю партиями с теми же сроками, из которых
+// токены взяты у отправителя. Часть, не покрытая партиями, зачисляется бессрочно.
+func (s *TransferService) creditRecipient(ctx context.Context, tx pgx.Tx, recipientID int, amount int, portions []grantPortion, transferID int) (int, error) {
+	var newBalance int
+	var err error
+	for _, portion := range portions {
+		newBalance, err = s.tokenService.GrantTokensTx(ctx, tx, recipientID, portion.amount, TokenSourceTransfer, &transferID, portion.expiresAt)
+		if err != nil {
+			return 0, err
+		}
+		amount -= portion.amount
+	}
+
+	if amount > 0 || len(portions) == 0 {
+		newBalance, err = s.tokenService.AddTokensTx(ctx, tx, recipientID, amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newBalance, nil
+}
+
+// notifyRecipient сообщает получателю о переводе через бота. Ошибка доставки не отменяет перевод.
+func (s *TransferService) notifyRecipient(ctx context.Context, telegramID string, senderName string, transfer *models.TokenTransfer, newBalance int) {
+	if !s.bot.Enabled() {
+		return
+	}
+
+	text := fmt.Sprintf("🎁 %s отправил(а) вам %d токенов. Баланс: %d.", senderName, transfer.Amount, newBalance)
+	if transfer.Note != nil && *transfer.Note != "" {
+		text += "\n\n«" + *transfer.Note + "»"
+	}
+
+	if err := s.bot.SendMessage(ctx, telegramID, text); err != nil {
+		log.Warnf("Failed to notify user %s about transfer %d: %v", telegramID, transfer.ID, err)
+	}
+}
+
+// GetUserTransfers возвращает входящие и исходящие переводы пользователя
+func (s *TransferService) GetUserTransfers(ctx context.Context, userID int, limit int) ([]models.TokenTransfer, error) {
+	conn := database.Database.Pool
+
+	rows, err := conn.Query(ctx, `
+		SELECT t.id, t.sender_id, t.recipient_id, t.amount, t.note, t.created_at,
+		       COALESCE('@' || u.username, u.first_name)
+		FROM token_transfers t
+		JOIN users u ON u.id = CASE WHEN t.sender_id = $1 THEN t.recipient_id ELSE t.sender_id END
+		WHERE t.sender_id = $1 OR t.recipient_id = $1
+		ORDER BY t.created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %w", err)
+	}
+	defer rows.Close()
+
+	transfers := []models.TokenTransfer{}
+	for rows.Next() {
+		var transfer models.TokenTransfer
+		err := rows.Scan(
+			&transfer.ID, &transfer.SenderID, &transfer.RecipientID, &transfer.Amount,
+			&transfer.Note, &transfer.CreatedAt, &transfer.CounterpartyName,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transfer: %w", err)
+		}
+
+		transfer.Direction = "in"
+		if transfer.SenderID == userID {
+			transfer.Direction = "out"
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+// GetLedger возвращает журнал изменений баланса пользователя
+func (s *TransferService) GetLedger(ctx context.Context, userID int, limit int) ([]models.TokenLedgerEntry, error) {
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT id, user_id, delta, balance_after, reason, reference_id, created_at
+		FROM token_ledger
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.TokenLedgerEntry{}
+	for rows.Next() {
+		var entry models.TokenLedgerEntry
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Delta, &entry.BalanceAfter, &entry.Reason, &entry.ReferenceID, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ledger entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
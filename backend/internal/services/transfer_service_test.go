@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+)
+
+func TestTransferKeepsTokenExpiry(t *testing.T) {
+	ctx := testDB(t)
+	tokenService := NewTokenService()
+	transferService := NewTransferService(nil)
+
+	sender := createTestUser(t, ctx, testTelegramID())
+	recipient := createTestUser(t, ctx, testTelegramID())
+	code, err := NewReferralService().EnsureReferralCode(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("EnsureReferralCode: %v", err)
+	}
+
+	// У отправителя сгорающие бонусные токены и бессрочные
+	soon := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Second)
+	if _, err := tokenService.AddTokens(ctx, sender.ID, 300, &soon); err != nil {
+		t.Fatalf("AddTokens: %v", err)
+	}
+	if _, err := tokenService.AddTokens(ctx, sender.ID, 1000, nil); err != nil {
+		t.Fatalf("AddTokens: %v", err)
+	}
+
+	transfer, err := transferService.TransferTokens(ctx, &models.TransferTokensRequest{
+		UserID: sender.ID, Recipient: referralStartPrefix + code, Amount: 500,
+	})
+	if err != nil {
+		t.Fatalf("TransferTokens: %v", err)
+	}
+
+	if balance := userTokenBalance(t, ctx, recipient.ID); balance != 500 {
+		t.Errorf("recipient balance = %d, want 500", balance)
+	}
+
+	// Сгорающие 300 ушли первыми и сохранили срок; остальные 200 - бессрочные
+	rows, err := database.Database.Pool.Query(ctx, `
+		SELECT remaining, expires_at FROM token_grants
+		WHERE user_id = $1 AND source = $2 AND reference_id = $3 AND status = 'active'
+	`, recipient.ID, TokenSourceTransfer, transfer.ID)
+	if err != nil {
+		t.Fatalf("query grants: %v", err)
+	}
+	defer rows.Close()
+
+	var expiring, permanent int
+	for rows.Next() {
+		var remaining int
+		var expiresAt *time.Time
+		if err := rows.Scan(&remaining, &expiresAt); err != nil {
+			t.Fatalf("scan grant: %v", err)
+		}
+		if expiresAt == nil {
+			permanent += remaining
+			continue
+		}
+		if !expiresAt.Equal(soon) {
+			t.Errorf("grant expires_at = %v, want %v", expiresAt, soon)
+		}
+		expiring += remaining
+	}
+	if expiring != 300 {
+		t.Errorf("expiring tokens = %d, want 300", expiring)
+	}
+	if permanent != 200 {
+		t.Errorf("permanent tokens = %d, want 200", permanent)
+	}
+}
+
+func TestTransferConcurrentDuplicateKey(t *testing.T) {
+	ctx := testDB(t)
+	transferService := NewTransferService(nil)
+
+	sender := createTestUser(t, ctx, testTelegramID())
+	recipient := createTestUser(t, ctx, testTelegramID())
+	code, err := NewReferralService().EnsureReferralCode(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("EnsureReferralCode: %v", err)
+	}
+	if _, err := NewTokenService().AddTokens(ctx, sender.ID, 2000, nil); err != nil {
+		t.Fatalf("AddTokens: %v", err)
+	}
+
+	// Клиент повторил запрос, не дождавшись ответа: оба запроса выполняются одновременно
+	key := fmt.Sprintf("transfer-%d", sender.ID)
+	const attempts = 4
+	transfers := make([]*models.TokenTransfer, attempts)
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			transfers[i], errs[i] = transferService.TransferTokens(ctx, &models.TransferTokensRequest{
+				UserID: sender.ID, Recipient: referralStartPrefix + code, Amount: 500, IdempotencyKey: &key,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < attempts; i++ {
+		if errs[i] != nil {
+			t.Fatalf("attempt %d: %v", i+1, errs[i])
+		}
+		if transfers[i].ID != transfers[0].ID {
+			t.Errorf("attempt %d returned transfer %d, want %d", i+1, transfers[i].ID, transfers[0].ID)
+		}
+	}
+
+	if balance := userTokenBalance(t, ctx, sender.ID); balance != 1500 {
+		t.Errorf("sender balance = %d, want 1500", balance)
+	}
+	if balance := userTokenBalance(t, ctx, recipient.ID); balance != 500 {
+		t.Errorf("recipient balance = %d, want 500", balance)
+	}
+
+	var count int
+	err = database.Database.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM token_transfers WHERE sender_id = $1
+	`, sender.ID).Scan(&count)
+	if err != nil {
+		t.Fatalf("count transfers: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("transfers = %d, want 1", count)
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"voice-ai-backend/internal/database"
+	"voice-ai-backend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Шаги агрегации статистики расхода и максимальный период для каждого из них
+var usageBucketMaxRange = map[string]time.Duration{
+	"hour": 31 * 24 * time.Hour,
+	"day":  366 * 24 * time.Hour,
+	"week": 5 * 366 * 24 * time.Hour,
+}
+
+const usageTopSessionsLimit = 50
+
+// usageTotalsColumns - суммы по колонкам token_usage_hourly в порядке полей models.UsageTotals
+const usageTotalsColumns = `
+	COALESCE(SUM(requests), 0), COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0),
+	COALESCE(SUM(total_tokens), 0), COALESCE(SUM(input_text_tokens), 0), COALESCE(SUM(input_audio_tokens), 0),
+	COALESCE(SUM(input_image_tokens), 0), COALESCE(SUM(cached_tokens), 0),
+	COALESCE(SUM(output_text_tokens), 0), COALESCE(SUM(output_audio_tokens), 0)`
+
+func usageTotalsDest(t *models.UsageTotals) []any {
+	return []any{
+		&t.Requests, &t.InputTokens, &t.OutputTokens, &t.TotalTokens, &t.InputTextTokens, &t.InputAudioTokens,
+		&t.InputImageTokens, &t.CachedTokens, &t.OutputTextTokens, &t.OutputAudioTokens,
+	}
+}
+
+type UsageService struct{}
+
+func NewUsageService() *UsageService {
+	return &UsageService{}
+}
+
+// recordUsageRollup добавляет запись расхода в почасовой агрегат в той же транзакции,
+// что и вставка в token_usage, поэтому агрегат всегда совпадает с сырыми данными
+func recordUsageRollup(ctx context.Context, tx pgx.Tx, actorID int, model string, sessionID string, u *models.UsageTotals) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO token_usage_hourly (
+			user_id, bucket_start, model, session_id, requests, input_tokens, output_tokens, total_tokens,
+			input_text_tokens, input_audio_tokens, input_image_tokens, cached_tokens,
+			output_text_tokens, output_audio_tokens
+		)
+		VALUES ($1, date_trunc('hour', CURRENT_TIMESTAMP::timestamp), $2, $3, 1, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (user_id, bucket_start, model, session_id) DO UPDATE SET
+			requests = token_usage_hourly.requests + 1,
+			input_tokens = token_usage_hourly.input_tokens + EXCLUDED.input_tokens,
+			output_tokens = token_usage_hourly.output_tokens + EXCLUDED.output_tokens,
+			total_tokens = token_usage_hourly.total_tokens + EXCLUDED.total_tokens,
+			input_text_tokens = token_usage_hourly.input_text_tokens + EXCLUDED.input_text_tokens,
+			input_audio_tokens = token_usage_hourly.input_audio_tokens + EXCLUDED.input_audio_tokens,
+			input_image_tokens = token_usage_hourly.input_image_tokens + EXCLUDED.input_image_tokens,
+			cached_tokens = token_usage_hourly.cached_tokens + EXCLUDED.cached_tokens,
+			output_text_tokens = token_usage_hourly.output_text_tokens + EXCLUDED.output_text_tokens,
+			output_audio_tokens = token_usage_hourly.output_audio_tokens + EXCLUDED.output_audio_tokens
+	`, actorID, model, sessionID, u.InputTokens, u.OutputTokens, u.TotalTokens,
+		u.InputTextTokens, u.InputAudioTokens, u.InputImageTokens, u.CachedTokens,
+		u.OutputTextTokens, u.OutputAudioTokens)
+	if err != nil {
+		return fmt.Errorf("failed to update usage rollup: %w", err)
+	}
+	return nil
+}
+
+// GetUsage возвращает расход токенов пользователя за период [from, to) по интервалам,
+// моделям и сессиям. Участнику организации показывается только его собственный расход.
+func (s *UsageService) GetUsage(ctx context.Context, userID int, from time.Time, to time.Time, bucket string) (*models.UsageAnalyticsResponse, error) {
+	maxRange, ok := usageBucketMaxRange[bucket]
+	if !ok {
+		return nil, fmt.Errorf("invalid bucket")
+	}
+	if to.Sub(from) > maxRange {
+		return nil, fmt.Errorf("date range too large for bucket")
+	}
+
+	conn := database.Database.Pool
+
+	usage := &models.UsageAnalyticsResponse{
+		From:      from,
+		To:        to,
+		Bucket:    bucket,
+		Buckets:   []models.UsageBucket{},
+		ByModel:   []models.ModelUsage{},
+		BySession: []models.SessionUsage{},
+	}
+
+	err := conn.QueryRow(ctx, `
+		SELECT `+usageTotalsColumns+`
+		FROM token_usage_hourly
+		WHERE user_id = $1 AND bucket_start >= $2 AND bucket_start < $3
+	`, userID, from, to).Scan(usageTotalsDest(&usage.Totals)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage totals: %w", err)
+	}
+
+	// Пустые интервалы тоже возвращаем, чтобы клиенту не нужно было дополнять ряд
+	rows, err := conn.Query(ctx, `
+		WITH series AS (
+			SELECT generate_series(
+				date_trunc($4, $2::timestamp),
+				$3::timestamp - INTERVAL '1 microsecond',
+				('1 ' || $4)::interval
+			) AS bucket_start
+		)
+		SELECT s.bucket_start, `+usageTotalsColumns+`
+		FROM series s
+		LEFT JOIN token_usage_hourly h ON h.user_id = $1
+			AND h.bucket_start >= GREATEST(s.bucket_start, $2::timestamp)
+			AND h.bucket_start < LEAST(s.bucket_start + ('1 ' || $4)::interval, $3::timestamp)
+		GROUP BY s.bucket_start
+		ORDER BY s.bucket_start
+	`, userID, from, to, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage buckets: %w", err)
+	}
+	for rows.Next() {
+		var b models.UsageBucket
+		if err := rows.Scan(append([]any{&b.BucketStart}, usageTotalsDest(&b.UsageTotals)...)...); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan usage bucket: %w", err)
+		}
+		usage.Buckets = append(usage.Buckets, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get usage buckets: %w", err)
+	}
+
+	rows, err = conn.Query(ctx, `
+		SELECT model, `+usageTotalsColumns+`
+		FROM token_usage_hourly
+		WHERE user_id = $1 AND bucket_start >= $2 AND bucket_start < $3
+		GROUP BY model
+		ORDER BY SUM(total_tokens) DESC
+	`, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage by model: %w", err)
+	}
+	for rows.Next() {
+		var m models.ModelUsage
+		if err := rows.Scan(append([]any{&m.Model}, usageTotalsDest(&m.UsageTotals)...)...); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan model usage: %w", err)
+		}
+		usage.ByModel = append(usage.ByModel, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get usage by model: %w", err)
+	}
+
+	rows, err = conn.Query(ctx, `
+		SELECT session_id, MIN(bucket_start), MAX(bucket_start), `+usageTotalsColumns+`
+		FROM token_usage_hourly
+		WHERE user_id = $1 AND bucket_start >= $2 AND bucket_start < $3 AND session_id != ''
+		GROUP BY session_id
+		ORDER BY SUM(total_tokens) DESC
+		LIMIT $4
+	`, userID, from, to, usageTopSessionsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage by session: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var su models.SessionUsage
+		dest := append([]any{&su.SessionID, &su.FirstUsedAt, &su.LastUsedAt}, usageTotalsDest(&su.UsageTotals)...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan session usage: %w", err)
+		}
+		usage.BySession = append(usage.BySession, su)
+	}
+
+	return usage, nil
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"voice-ai-backend/internal/config"
 	"voice-ai-backend/internal/database"
 	"voice-ai-backend/internal/models"
 
@@ -10,10 +11,18 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-type UserService struct{}
+type UserService struct {
+	planService     *PlanService
+	referralService *ReferralService
+	orgService      *OrganizationService
+}
 
 func NewUserService() *UserService {
-	return &UserService{}
+	return &UserService{
+		planService:     NewPlanService(),
+		referralService: NewReferralService(),
+		orgService:      NewOrganizationService(),
+	}
 }
 
 // CreateOrUpdateUser создает нового пользователя или обновляет существующего
@@ -34,15 +43,28 @@ func (s *UserService) CreateOrUpdateUser(ctx context.Context, req *models.Create
 		&user.SelectedVoice, &user.SelectedPromptID, &user.CreatedAt, &user.UpdatedAt, &user.LastActive,
 	)
 
+	var trial *models.UserTrial
 	if err == pgx.ErrNoRows {
-		// Создаем нового пользователя
-		err = conn.QueryRow(ctx, `
+		// Создаем нового пользователя. Если включен пробный период, стартовый
+		// баланс выдается вместе с пробным планом в той же транзакции.
+		startBalance := defaultTokenBalance
+		if config.AppConfig.TrialEnabled {
+			startBalance = 0
+		}
+
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback(ctx)
+
+		err = tx.QueryRow(ctx, `
 			INSERT INTO users (telegram_id, username, first_name, last_name, language_code, token_balance, created_at, updated_at, last_active)
 			VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 			RETURNING id, telegram_id, username, first_name, last_name, language_code,
 			          is_premium, token_balance, selected_model, selected_voice, selected_prompt_id,
 			          created_at, updated_at, last_active
-		`, req.TelegramID, req.Username, req.FirstName, req.LastName, req.LanguageCode, defaultTokenBalance).Scan(
+		`, req.TelegramID, req.Username, req.FirstName, req.LastName, req.LanguageCode, startBalance).Scan(
 			&user.ID, &user.TelegramID, &user.Username, &user.FirstName, &user.LastName,
 			&user.LanguageCode, &user.IsPremium, &user.TokenBalance, &user.SelectedModel,
 			&user.SelectedVoice, &user.SelectedPromptID, &user.CreatedAt, &user.UpdatedAt, &user.LastActive,
@@ -51,6 +73,34 @@ func (s *UserService) CreateOrUpdateUser(ctx context.Context, req *models.Create
 		if err != nil {
 			return nil, fmt.Errorf("failed to create user: %w", err)
 		}
+
+		if config.AppConfig.TrialEnabled {
+			trial, err = s.planService.GrantTrialTx(ctx, tx, user.ID)
+			if err != nil && err.Error() == "trial plan not configured" {
+				// Пробный план отключен в админке - выдаем обычный стартовый баланс
+				log.Warnf("Trial plan is not available, granting default balance to user %d", user.ID)
+				_, err = tx.Exec(ctx, `UPDATE users SET token_balance = $1 WHERE id = $2`, defaultTokenBalance, user.ID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to set default balance: %w", err)
+				}
+				user.TokenBalance = defaultTokenBalance
+			} else if err != nil {
+				return nil, err
+			} else if trial != nil {
+				user.TokenBalance = trial.TokenAmount
+			}
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+
+		// Приглашение учитываем только при первом входе пользователя
+		if req.StartParam != nil && *req.StartParam != "" && !IsOrganizationStartParam(*req.StartParam) {
+			if err := s.referralService.AttachReferral(ctx, user.ID, *req.StartParam); err != nil {
+				log.Warnf("Failed to attach referral for user %d: %v", user.ID, err)
+			}
+		}
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to query user: %w", err)
 	} else {
@@ -73,18 +123,25 @@ func (s *UserService) CreateOrUpdateUser(ctx context.Context, req *models.Create
 			return nil, fmt.Errorf("failed to update user: %w", err)
 		}
 
-		// Если у пользователя нет token_balance, устанавливаем дефолтное значение
-		if user.TokenBalance == 0 {
-			_, err = conn.Exec(ctx, `
-				UPDATE users SET token_balance = $1 WHERE telegram_id = $2
-			`, defaultTokenBalance, req.TelegramID)
+		trial, err = s.planService.GetUserTrial(ctx, user.ID)
+		if err != nil {
+			log.Warnf("Failed to get trial for user %d: %v", user.ID, err)
+		}
+	}
 
-			if err == nil {
-				user.TokenBalance = defaultTokenBalance
-			}
+	// Ссылка-приглашение в организацию работает и для существующих пользователей
+	if req.StartParam != nil && IsOrganizationStartParam(*req.StartParam) {
+		if _, err := s.orgService.JoinOrganization(ctx, user.ID, *req.StartParam); err != nil {
+			log.Warnf("Failed to join organization by start param for user %d: %v", user.ID, err)
 		}
 	}
 
+	if code, err := s.referralService.EnsureReferralCode(ctx, user.ID); err != nil {
+		log.Warnf("Failed to ensure referral code for user %d: %v", user.ID, err)
+	} else {
+		user.ReferralCode = &code
+	}
+
 	// Проверяем активную подписку
 	var planName *string
 	err = conn.QueryRow(ctx, `
@@ -102,6 +159,7 @@ func (s *UserService) CreateOrUpdateUser(ctx context.Context, req *models.Create
 		User:                  &user,
 		HasActiveSubscription: hasActiveSubscription,
 		CurrentPlanName:       planName,
+		Trial:                 trial,
 	}, nil
 }
 
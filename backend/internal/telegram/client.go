@@ -0,0 +1,196 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client - минимальный клиент Telegram Bot API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+}
+
+func NewClient(token string, baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// Enabled сообщает, настроен ли токен бота
+func (c *Client) Enabled() bool {
+	return c != nil && c.token != ""
+}
+
+// CreateInvoiceLink создает ссылку на оплату счета
+func (c *Client) CreateInvoiceLink(ctx context.Context, params *CreateInvoiceLinkParams) (string, error) {
+	var link string
+	if err := c.call(ctx, "createInvoiceLink", params, &link); err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+// AnswerPreCheckoutQuery подтверждает или отклоняет pre_checkout_query
+func (c *Client) AnswerPreCheckoutQuery(ctx context.Context, queryID string, ok bool, errorMessage string) error {
+	params := map[string]interface{}{
+		"pre_checkout_query_id": queryID,
+		"ok":                    ok,
+	}
+	if !ok {
+		params["error_message"] = errorMessage
+	}
+
+	var result bool
+	return c.call(ctx, "answerPreCheckoutQuery", params, &result)
+}
+
+// RefundStarPayment возвращает пользователю оплату в Telegram Stars
+func (c *Client) RefundStarPayment(ctx context.Context, userID int64, chargeID string) error {
+	params := map[string]interface{}{
+		"user_id":                    userID,
+		"telegram_payment_charge_id": chargeID,
+	}
+
+	var result bool
+	return c.call(ctx, "refundStarPayment", params, &result)
+}
+
+// SendMessage отправляет текстовое сообщение в чат
+func (c *Client) SendMessage(ctx context.Context, chatID string, text string) error {
+	params := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}
+
+	var result Message
+	return c.call(ctx, "sendMessage", params, &result)
+}
+
+// SendDocument отправляет файл в чат. Содержимое читается из r по мере загрузки,
+// поэтому файл не нужно целиком держать в памяти.
+func (c *Client) SendDocument(ctx context.Context, chatID string, filename string, r io.Reader, caption string) error {
+	params := map[string]string{
+		"chat_id": chatID,
+	}
+	if caption != "" {
+		params["caption"] = caption
+	}
+
+	var result Message
+	return c.upload(ctx, "sendDocument", params, "document", filename, r, &result)
+}
+
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	if !c.Enabled() {
+		return fmt.Errorf("telegram bot token is not configured")
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s params: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.methodURL(method), bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(c.httpClient, req, method, result)
+}
+
+// upload вызывает метод с multipart/form-data телом, в котором передается файл
+func (c *Client) upload(ctx context.Context, method string, params map[string]string, field string, filename string, r io.Reader, result interface{}) error {
+	if !c.Enabled() {
+		return fmt.Errorf("telegram bot token is not configured")
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		for key, value := range params {
+			if err := form.WriteField(key, value); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile(field, filename)
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, r); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		writer.CloseWithError(form.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.methodURL(method), body)
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	// Загрузка большого файла может идти дольше обычного таймаута клиента
+	uploadClient := *c.httpClient
+	uploadClient.Timeout = 5 * time.Minute
+
+	return c.do(&uploadClient, req, method, result)
+}
+
+func (c *Client) do(httpClient *http.Client, req *http.Request, method string, result interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+
+	var apiResp apiResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return fmt.Errorf("failed to decode %s response (status %d): %w", method, resp.StatusCode, err)
+	}
+
+	if !apiResp.OK {
+		return fmt.Errorf("telegram %s failed: %d %s", method, apiResp.ErrorCode, apiResp.Description)
+	}
+
+	if result != nil && len(apiResp.Result) > 0 {
+		if err := json.Unmarshal(apiResp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) methodURL(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+}
@@ -0,0 +1,196 @@
+// Package telegramtest предоставляет локальный фейк Telegram Bot API
+// для тестирования платежей и уведомлений без обращения к Telegram.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"voice-ai-backend/internal/telegram"
+)
+
+// Call - зафиксированный вызов метода Bot API
+type Call struct {
+	Method string
+	Params map[string]interface{}
+	// Files - файлы из multipart-запроса (sendDocument и т.п.) по имени поля
+	Files map[string]File
+}
+
+// File - загруженный файл
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Server - фейковый Bot API поверх httptest.Server
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu        sync.Mutex
+	calls     []Call
+	failures  map[string]string
+	messageID int
+}
+
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:    token,
+		failures: make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// FailMethod заставляет метод возвращать ошибку Bot API с указанным описанием
+func (s *Server) FailMethod(method string, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = description
+}
+
+// Calls возвращает вызовы указанного метода (или все вызовы, если method пустой)
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Call
+	for _, call := range s.calls {
+		if method == "" || call.Method == method {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + s.Token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"ok": false, "error_code": 401, "description": "Unauthorized",
+		})
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+
+	call := Call{Method: method, Params: map[string]interface{}{}}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := readMultipart(r, &call); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"ok": false, "error_code": 400, "description": "Bad Request: " + err.Error(),
+			})
+			return
+		}
+	} else {
+		_ = json.NewDecoder(r.Body).Decode(&call.Params)
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	failure, shouldFail := s.failures[method]
+	s.messageID++
+	messageID := s.messageID
+	s.mu.Unlock()
+
+	if shouldFail {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"ok": false, "error_code": 400, "description": failure,
+		})
+		return
+	}
+
+	var result interface{}
+	switch method {
+	case "createInvoiceLink":
+		result = fmt.Sprintf("https://t.me/$fake-invoice-%d", messageID)
+	case "answerPreCheckoutQuery", "refundStarPayment":
+		result = true
+	case "sendMessage", "sendDocument":
+		result = map[string]interface{}{
+			"message_id": messageID,
+			"date":       time.Now().Unix(),
+			"chat":       map[string]interface{}{"id": call.Params["chat_id"], "type": "private"},
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"ok": false, "error_code": 404, "description": "Not Found: method not found",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "result": result})
+}
+
+func readMultipart(r *http.Request, call *Call) error {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	for key, values := range r.MultipartForm.Value {
+		if len(values) > 0 {
+			call.Params[key] = values[0]
+		}
+	}
+
+	call.Files = make(map[string]File)
+	for key, headers := range r.MultipartForm.File {
+		if len(headers) == 0 {
+			continue
+		}
+		f, err := headers[0].Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		call.Files[key] = File{Name: headers[0].Filename, Data: data}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// PreCheckoutUpdate формирует update с pre_checkout_query, как его присылает Telegram
+func PreCheckoutUpdate(updateID int, fromID int64, payload string, amount int) telegram.Update {
+	return telegram.Update{
+		UpdateID: updateID,
+		PreCheckoutQuery: &telegram.PreCheckoutQuery{
+			ID:             fmt.Sprintf("pcq-%d", updateID),
+			From:           telegram.User{ID: fromID, FirstName: "Test"},
+			Currency:       telegram.StarsCurrency,
+			TotalAmount:    amount,
+			InvoicePayload: payload,
+		},
+	}
+}
+
+// SuccessfulPaymentUpdate формирует update с сообщением об успешной оплате
+func SuccessfulPaymentUpdate(updateID int, fromID int64, payload string, amount int, chargeID string) telegram.Update {
+	return telegram.Update{
+		UpdateID: updateID,
+		Message: &telegram.Message{
+			MessageID: updateID,
+			From:      &telegram.User{ID: fromID, FirstName: "Test"},
+			Chat:      telegram.Chat{ID: fromID, Type: "private"},
+			Date:      time.Now().Unix(),
+			SuccessfulPayment: &telegram.SuccessfulPayment{
+				Currency:                telegram.StarsCurrency,
+				TotalAmount:             amount,
+				InvoicePayload:          payload,
+				TelegramPaymentChargeID: chargeID,
+			},
+		},
+	}
+}